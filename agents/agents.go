@@ -0,0 +1,57 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Agents translating switch specific protocols into CGRateS charging
+package agents
+
+import (
+	"fmt"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/sessionmanager"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Saves the costs of the session the way the SessionManager does, so the mediator finds them for prepaid CDRs,
+// and hands them over to the notifier if one is set, rating the CDRs which arrived before the costs.
+func logCallCosts(loggerDb engine.LogStorage, notifier sessionmanager.CostNotifier, uuid string, callCosts []*engine.CallCost) {
+	if loggerDb == nil {
+		engine.Logger.Err("<Agents> Error: no connection to logger database, cannot save costs")
+		return
+	}
+	var firstCC *engine.CallCost
+	for _, cc := range callCosts {
+		if len(cc.Timespans) == 0 { // Fully refunded
+			continue
+		}
+		if firstCC == nil {
+			firstCC = cc
+		} else {
+			firstCC.Merge(cc)
+		}
+	}
+	if firstCC == nil {
+		return
+	}
+	if err := loggerDb.LogCallCost(uuid, engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID, firstCC); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Agents> Error saving costs for session %s: %v", uuid, err))
+	}
+	if notifier != nil {
+		notifier(utils.FSCgrId(uuid), firstCC)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+)
+
+// Connector stand-in debiting one second increments out of a balance expressed in seconds
+type testConnector struct {
+	balance time.Duration
+}
+
+func (self *testConnector) MaxDebit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	debited := cd.TimeEnd.Sub(cd.TimeStart)
+	if debited > self.balance {
+		debited = self.balance
+	}
+	if debited == 0 {
+		return errors.New(NO_CREDIT_ERR)
+	}
+	self.balance -= debited
+	ts := &engine.TimeSpan{TimeStart: cd.TimeStart, TimeEnd: cd.TimeStart.Add(debited)}
+	for i := time.Duration(0); i < debited; i += time.Second {
+		ts.Increments = append(ts.Increments, &engine.Increment{Duration: time.Second, Cost: 0.01})
+	}
+	*cc = engine.CallCost{Direction: cd.Direction, Tenant: cd.Tenant, TOR: cd.TOR, Subject: cd.Subject, Account: cd.Account,
+		Destination: cd.Destination, Cost: float64(len(ts.Increments)) * 0.01, Timespans: engine.TimeSpans{ts}}
+	return nil
+}
+
+func (self *testConnector) RefundIncrements(cd engine.CallDescriptor, reply *float64) error {
+	for _, inc := range cd.Increments {
+		self.balance += inc.Duration
+	}
+	return nil
+}
+
+// Usage being already consumed, the balance can go negative
+func (self *testConnector) Debit(cd engine.CallDescriptor, cc *engine.CallCost) error {
+	balance := self.balance
+	self.balance = cd.TimeEnd.Sub(cd.TimeStart)
+	err := self.MaxDebit(cd, cc)
+	self.balance = balance - cd.TimeEnd.Sub(cd.TimeStart)
+	return err
+}
+
+func (self *testConnector) GetMaxSessionTime(cd engine.CallDescriptor, reply *float64) error {
+	*reply = float64(self.balance)
+	return nil
+}
+
+func (self *testConnector) GetCost(engine.CallDescriptor, *engine.CallCost) error { return nil }
+func (self *testConnector) DebitCents(engine.CallDescriptor, *float64) error      { return nil }
+func (self *testConnector) DebitSeconds(engine.CallDescriptor, *float64) error    { return nil }
@@ -0,0 +1,429 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/sessionmanager"
+)
+
+const (
+	DATA_TOR      = "data"
+	SMS_TOR       = "sms"
+	NO_CREDIT_ERR = "no more credit" // Error returned by MaxDebit when not even one unit can be granted
+)
+
+// Most units a session can be charged for, each one being mapped on one second of the CallDescriptor durations
+const MAX_SESSION_UNITS = time.Duration(math.MaxInt64/int64(time.Second)) * time.Second
+
+var ErrUnitsOutOfRange = errors.New("Units out of range")
+
+// Credit control session, holding the costs reserved so far
+type dmtSession struct {
+	callDescriptor *engine.CallDescriptor
+	callCosts      []*engine.CallCost
+	granted        time.Duration // Units granted on the last reservation
+	used           time.Duration // Units consumed so far
+	terminated     bool          // Set once terminated, for the requests which found the session before
+	mux            sync.Mutex    // Serializes the requests of the session, each settling and reserving out of the previous state
+}
+
+// Units requested or reported in a CCR, out of the first Multiple-Services-Credit-Control or the command level ones
+type ccrUnits struct {
+	inMSCC       bool
+	msccIds      []*AVP // Rating-Group and Service-Identifier to be echoed back in the answer
+	unitType     uint32 // AVP code of the units: CC-Time, CC-Total-Octets or CC-Service-Specific-Units
+	requested    time.Duration
+	hasRequested bool
+	used         time.Duration
+	hasUsed      bool
+}
+
+// Diameter Gy/Ro online charging agent. Units are mapped on CallDescriptor durations,
+// time being charged as such and octets or service specific units as one second each, up to MAX_SESSION_UNITS per session.
+type DiameterAgent struct {
+	cgrCfg       *config.CGRConfig
+	connector    engine.Connector
+	loggerDb     engine.LogStorage
+	costNotifier sessionmanager.CostNotifier // Receives the final session costs, the mediator waiting on them
+	sessions     map[string]*dmtSession
+	sessionsMux  sync.Mutex
+}
+
+func NewDiameterAgent(cgrCfg *config.CGRConfig, connector engine.Connector, loggerDb engine.LogStorage) *DiameterAgent {
+	return &DiameterAgent{cgrCfg: cgrCfg, connector: connector, loggerDb: loggerDb, sessions: make(map[string]*dmtSession)}
+}
+
+// Hands over the final session costs directly instead of leaving them only in logDb, to be set before ListenAndServe
+func (da *DiameterAgent) SetCostNotifier(notifier sessionmanager.CostNotifier) {
+	da.costNotifier = notifier
+}
+
+// Listens for Diameter peers, serving each connection on its own goroutine
+func (da *DiameterAgent) ListenAndServe() error {
+	listener, err := net.Listen("tcp", da.cgrCfg.DAListen)
+	if err != nil {
+		return err
+	}
+	engine.Logger.Info(fmt.Sprintf("<DiameterAgent> Listening for connections on %s", da.cgrCfg.DAListen))
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go da.handleConnection(conn)
+	}
+}
+
+// Reads requests out of the connection and writes back the answers
+func (da *DiameterAgent) handleConnection(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	for {
+		msg, err := ReadDiameterMessage(conn)
+		if err != nil {
+			if err != io.EOF {
+				engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Error reading message: %v", err))
+			}
+			return
+		}
+		if !msg.IsRequest() { // We do not originate requests so ignore the answers
+			continue
+		}
+		if _, err := conn.Write(da.processRequest(msg).Marshal()); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Error writing answer: %v", err))
+			return
+		}
+		if msg.CommandCode == DMT_CMD_DP {
+			return
+		}
+	}
+}
+
+// Dispatches the request based on command code, returning the answer to be sent
+func (da *DiameterAgent) processRequest(req *DiameterMessage) *DiameterMessage {
+	switch req.CommandCode {
+	case DMT_CMD_CE:
+		return req.Answer().AddAVP(da.baseAVPs(DIAMETER_SUCCESS)...).AddAVP(NewAVPUint32(AVP_VENDOR_ID, uint32(da.cgrCfg.DAVendorId)),
+			NewAVPString(AVP_PRODUCT_NAME, da.cgrCfg.DAProductName), NewAVPUint32(AVP_AUTH_APPLICATION_ID, DMT_APP_CC))
+	case DMT_CMD_DW, DMT_CMD_DP:
+		return req.Answer().AddAVP(da.baseAVPs(DIAMETER_SUCCESS)...)
+	case DMT_CMD_CC:
+		if req.ApplicationId != DMT_APP_CC {
+			return da.answerError(req, DIAMETER_APPLICATION_UNSUPPORTED)
+		}
+		return da.processCCR(req)
+	}
+	return da.answerError(req, DIAMETER_COMMAND_UNSUPPORTED)
+}
+
+func (da *DiameterAgent) baseAVPs(resultCode uint32) []*AVP {
+	return []*AVP{NewAVPUint32(AVP_RESULT_CODE, resultCode),
+		NewAVPString(AVP_ORIGIN_HOST, da.cgrCfg.DAOriginHost), NewAVPString(AVP_ORIGIN_REALM, da.cgrCfg.DAOriginRealm)}
+}
+
+// Protocol errors are signaled with the E bit set
+func (da *DiameterAgent) answerError(req *DiameterMessage, resultCode uint32) *DiameterMessage {
+	ans := req.Answer().AddAVP(da.baseAVPs(resultCode)...)
+	ans.Flags |= DMT_FLAG_ERROR
+	return ans
+}
+
+// Handles the Credit-Control-Request, answering with the units granted
+func (da *DiameterAgent) processCCR(ccr *DiameterMessage) *DiameterMessage {
+	sessionId := ccr.AVPString(AVP_SESSION_ID)
+	reqTypeAvp := ccr.FindAVP(AVP_CC_REQUEST_TYPE)
+	if sessionId == "" || reqTypeAvp == nil || ccr.FindAVP(AVP_CC_REQUEST_NUMBER) == nil {
+		return da.answerCCA(ccr, DIAMETER_MISSING_AVP, nil, 0, false)
+	}
+	units, err := parseCCRUnits(ccr)
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Error parsing units for session %s: %v", sessionId, err))
+		if err == ErrUnitsOutOfRange {
+			return da.answerCCA(ccr, DIAMETER_INVALID_AVP_VALUE, nil, 0, false)
+		}
+		return da.answerCCA(ccr, DIAMETER_UNABLE_TO_COMPLY, nil, 0, false)
+	}
+	reqType, _ := reqTypeAvp.AsUint32()
+	var resultCode uint32
+	var granted time.Duration
+	switch reqType {
+	case CC_REQUEST_INITIAL, CC_REQUEST_EVENT:
+		cd, err := da.ccrCallDescriptor(ccr, units)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Session %s: %v", sessionId, err))
+			return da.answerCCA(ccr, DIAMETER_MISSING_AVP, units, 0, false)
+		}
+		if reqType == CC_REQUEST_INITIAL {
+			resultCode, granted = da.initSession(sessionId, cd, units)
+		} else {
+			resultCode, granted = da.directDebit(sessionId, cd, units)
+		}
+	case CC_REQUEST_UPDATE:
+		resultCode, granted = da.updateSession(sessionId, units)
+	case CC_REQUEST_TERMINATE:
+		resultCode = da.terminateSession(sessionId, units)
+	default:
+		resultCode = DIAMETER_INVALID_AVP_VALUE
+	}
+	return da.answerCCA(ccr, resultCode, units, granted, units != nil && units.hasRequested && granted < units.requested)
+}
+
+// Builds the Credit-Control-Answer, Session-Id being the first AVP as required by RFC 4006
+func (da *DiameterAgent) answerCCA(ccr *DiameterMessage, resultCode uint32, units *ccrUnits, granted time.Duration, finalUnit bool) *DiameterMessage {
+	cca := ccr.Answer().AddAVP(NewAVPString(AVP_SESSION_ID, ccr.AVPString(AVP_SESSION_ID))).AddAVP(da.baseAVPs(resultCode)...)
+	cca.AddAVP(NewAVPUint32(AVP_AUTH_APPLICATION_ID, DMT_APP_CC))
+	for _, code := range []uint32{AVP_CC_REQUEST_TYPE, AVP_CC_REQUEST_NUMBER} {
+		if avp := ccr.FindAVP(code); avp != nil {
+			cca.AddAVP(avp)
+		}
+	}
+	if units == nil || granted == 0 {
+		return cca
+	}
+	grantedAvps := []*AVP{NewAVPGrouped(AVP_GRANTED_SERVICE_UNIT, unitsAVP(units.unitType, granted))}
+	if finalUnit {
+		grantedAvps = append(grantedAvps, NewAVPGrouped(AVP_FINAL_UNIT_INDICATION, NewAVPUint32(AVP_FINAL_UNIT_ACTION, FINAL_UNIT_TERMINATE)))
+	}
+	if !units.inMSCC {
+		return cca.AddAVP(grantedAvps...)
+	}
+	msccAvps := append(append(units.msccIds, grantedAvps...), NewAVPUint32(AVP_RESULT_CODE, resultCode))
+	return cca.AddAVP(NewAVPGrouped(AVP_MULTIPLE_SERVICES_CC, msccAvps...))
+}
+
+// Reserves the units requested on a new session
+func (da *DiameterAgent) initSession(sessionId string, cd *engine.CallDescriptor, units *ccrUnits) (uint32, time.Duration) {
+	dSess := &dmtSession{callDescriptor: cd}
+	resultCode, granted := da.reserve(dSess, da.requestedUnits(units))
+	if resultCode == DIAMETER_SUCCESS {
+		da.sessionsMux.Lock()
+		da.sessions[sessionId] = dSess
+		da.sessionsMux.Unlock()
+	}
+	return resultCode, granted
+}
+
+// Settles the units used out of the last reservation and reserves the next ones
+func (da *DiameterAgent) updateSession(sessionId string, units *ccrUnits) (uint32, time.Duration) {
+	da.sessionsMux.Lock()
+	dSess, hasIt := da.sessions[sessionId]
+	da.sessionsMux.Unlock()
+	if !hasIt {
+		return DIAMETER_UNKNOWN_SESSION_ID, 0
+	}
+	dSess.mux.Lock()
+	defer dSess.mux.Unlock()
+	if dSess.terminated {
+		return DIAMETER_UNKNOWN_SESSION_ID, 0
+	}
+	da.settle(dSess, units)
+	return da.reserve(dSess, da.requestedUnits(units))
+}
+
+// Settles the units used out of the last reservation and logs the costs of the session
+func (da *DiameterAgent) terminateSession(sessionId string, units *ccrUnits) uint32 {
+	da.sessionsMux.Lock()
+	dSess, hasIt := da.sessions[sessionId]
+	delete(da.sessions, sessionId)
+	da.sessionsMux.Unlock()
+	if !hasIt {
+		return DIAMETER_UNKNOWN_SESSION_ID
+	}
+	dSess.mux.Lock()
+	defer dSess.mux.Unlock()
+	if dSess.terminated {
+		return DIAMETER_UNKNOWN_SESSION_ID
+	}
+	dSess.terminated = true
+	da.settle(dSess, units)
+	logCallCosts(da.loggerDb, da.costNotifier, sessionId, dSess.callCosts)
+	return DIAMETER_SUCCESS
+}
+
+// One time charging for events like SMS, all requested units must be covered by the balance
+func (da *DiameterAgent) directDebit(sessionId string, cd *engine.CallDescriptor, units *ccrUnits) (uint32, time.Duration) {
+	requested := time.Second // One event if not specified otherwise
+	if units.hasRequested && units.requested != 0 {
+		requested = units.requested
+	}
+	dSess := &dmtSession{callDescriptor: cd}
+	resultCode, granted := da.reserve(dSess, requested)
+	if resultCode != DIAMETER_SUCCESS {
+		return resultCode, 0
+	}
+	if granted < requested {
+		sessionmanager.RefundCallCost(da.connector, dSess.callCosts[0], granted)
+		return DIAMETER_CREDIT_LIMIT_REACHED, 0
+	}
+	logCallCosts(da.loggerDb, da.costNotifier, sessionId, dSess.callCosts)
+	return DIAMETER_SUCCESS, granted
+}
+
+// Units to be reserved, the configured debit interval if not requested explicitly
+func (da *DiameterAgent) requestedUnits(units *ccrUnits) time.Duration {
+	if units.hasRequested && units.requested != 0 {
+		return units.requested
+	}
+	return da.cgrCfg.DADebitInterval
+}
+
+// Debits in advance the units, continuing after the ones used so far
+func (da *DiameterAgent) reserve(dSess *dmtSession, requested time.Duration) (uint32, time.Duration) {
+	if requested > MAX_SESSION_UNITS-dSess.used { // Total of the session would overflow
+		requested = MAX_SESSION_UNITS - dSess.used
+	}
+	if requested == 0 {
+		return DIAMETER_CREDIT_LIMIT_REACHED, 0
+	}
+	cd := *dSess.callDescriptor
+	cd.TimeStart = dSess.callDescriptor.TimeStart.Add(dSess.used)
+	cd.TimeEnd = cd.TimeStart.Add(requested)
+	cd.CallDuration = dSess.used + requested
+	cd.LoopIndex = float64(len(dSess.callCosts))
+	cc := new(engine.CallCost)
+	if err := da.connector.MaxDebit(cd, cc); err != nil && err.Error() != NO_CREDIT_ERR {
+		engine.Logger.Err(fmt.Sprintf("<DiameterAgent> Could not complete debit operation: %v", err))
+		return DIAMETER_UNABLE_TO_COMPLY, 0
+	}
+	granted := cc.GetDuration()
+	if granted == 0 {
+		return DIAMETER_CREDIT_LIMIT_REACHED, 0
+	}
+	dSess.callCosts = append(dSess.callCosts, cc)
+	dSess.granted = granted
+	return DIAMETER_SUCCESS, granted
+}
+
+// Accounts the used units, refunding the ones granted but not consumed
+func (da *DiameterAgent) settle(dSess *dmtSession, units *ccrUnits) {
+	used := dSess.granted // Without report we consider all granted units as used
+	if units.hasUsed && units.used < dSess.granted {
+		used = units.used
+		sessionmanager.RefundCallCost(da.connector, dSess.callCosts[len(dSess.callCosts)-1], dSess.granted-units.used)
+	}
+	dSess.used += used
+	dSess.granted = 0
+}
+
+// Maps the CCR on the CallDescriptor to be charged, subscriber being used as both account and subject
+func (da *DiameterAgent) ccrCallDescriptor(ccr *DiameterMessage, units *ccrUnits) (*engine.CallDescriptor, error) {
+	account := ccr.AVPString(AVP_SUBSCRIPTION_ID, AVP_SUBSCRIPTION_ID_DATA)
+	if account == "" {
+		return nil, errors.New("Missing Subscription-Id")
+	}
+	destination := addressNumber(ccr.AVPString(AVP_SERVICE_INFORMATION, AVP_IMS_INFORMATION, AVP_CALLED_PARTY_ADDRESS))
+	if destination == "" {
+		destination = addressNumber(ccr.AVPString(AVP_SERVICE_INFORMATION, AVP_SMS_INFORMATION, AVP_RECIPIENT_INFO, AVP_RECIPIENT_ADDRESS, AVP_ADDRESS_DATA))
+	}
+	if destination == "" { // APN for data sessions
+		destination = ccr.AVPString(AVP_CALLED_STATION_ID)
+	}
+	timeStart := time.Now()
+	if avp := ccr.FindAVP(AVP_EVENT_TIMESTAMP); avp != nil {
+		if evTime, err := avp.AsTime(); err == nil {
+			timeStart = evTime
+		}
+	}
+	tor := da.cgrCfg.DefaultTOR
+	switch units.unitType {
+	case AVP_CC_TOTAL_OCTETS:
+		tor = DATA_TOR
+	case AVP_CC_SERVICE_SPECIFIC_UNITS:
+		tor = SMS_TOR
+	}
+	return &engine.CallDescriptor{Direction: engine.OUTBOUND, Tenant: da.cgrCfg.DefaultTenant, TOR: tor,
+		Subject: account, Account: account, Destination: destination, TimeStart: timeStart}, nil
+}
+
+func parseCCRUnits(ccr *DiameterMessage) (*ccrUnits, error) {
+	units := new(ccrUnits)
+	avps := ccr.AVPs
+	if mscc := ccr.FindAVP(AVP_MULTIPLE_SERVICES_CC); mscc != nil {
+		var err error
+		if avps, err = mscc.AsGrouped(); err != nil {
+			return nil, err
+		}
+		units.inMSCC = true
+		for _, code := range []uint32{AVP_SERVICE_IDENTIFIER, AVP_RATING_GROUP} {
+			if avp := FindAVP(avps, code); avp != nil {
+				units.msccIds = append(units.msccIds, avp)
+			}
+		}
+	}
+	var err error
+	if rsu := FindAVP(avps, AVP_REQUESTED_SERVICE_UNIT); rsu != nil {
+		units.hasRequested = true
+		if units.requested, err = units.parseUnits(rsu); err != nil {
+			return nil, err
+		}
+	}
+	if usu := FindAVP(avps, AVP_USED_SERVICE_UNIT); usu != nil {
+		units.hasUsed = true
+		if units.used, err = units.parseUnits(usu); err != nil {
+			return nil, err
+		}
+	}
+	if units.unitType == 0 {
+		if reqType, _ := ccr.FindAVP(AVP_CC_REQUEST_TYPE).AsUint32(); reqType == CC_REQUEST_EVENT {
+			units.unitType = AVP_CC_SERVICE_SPECIFIC_UNITS
+		} else {
+			units.unitType = AVP_CC_TIME
+		}
+	}
+	return units, nil
+}
+
+// Decodes the units out of a service unit grouped AVP, remembering their type
+func (units *ccrUnits) parseUnits(serviceUnit *AVP) (time.Duration, error) {
+	avps, err := serviceUnit.AsGrouped()
+	if err != nil {
+		return 0, err
+	}
+	for _, code := range []uint32{AVP_CC_TIME, AVP_CC_TOTAL_OCTETS, AVP_CC_SERVICE_SPECIFIC_UNITS} {
+		if avp := FindAVP(avps, code); avp != nil {
+			val, err := avp.AsUint64()
+			if err != nil {
+				return 0, err
+			}
+			if val > uint64(MAX_SESSION_UNITS/time.Second) { // Would overflow once mapped on seconds, eg: octets of large data volumes
+				return 0, ErrUnitsOutOfRange
+			}
+			units.unitType = code
+			return time.Duration(val) * time.Second, nil
+		}
+	}
+	return 0, nil // Empty service unit, let the server decide
+}
+
+// Encodes the units into the AVP corresponding to their type
+func unitsAVP(unitType uint32, units time.Duration) *AVP {
+	if unitType == AVP_CC_TIME {
+		return NewAVPUint32(AVP_CC_TIME, uint32(units/time.Second))
+	}
+	return NewAVPUint64(unitType, uint64(units/time.Second))
+}
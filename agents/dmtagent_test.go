@@ -0,0 +1,229 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Local Diameter client stand-in, talking to the agent over an in-memory connection
+type dmtTestClient struct {
+	conn  net.Conn
+	hopId uint32
+}
+
+func newDmtTestClient(da *DiameterAgent) *dmtTestClient {
+	clntConn, srvConn := net.Pipe()
+	go da.handleConnection(srvConn)
+	return &dmtTestClient{conn: clntConn}
+}
+
+func (self *dmtTestClient) request(t *testing.T, cmdCode, appId uint32, avps ...*AVP) *DiameterMessage {
+	self.hopId += 1
+	req := &DiameterMessage{Flags: DMT_FLAG_REQUEST, CommandCode: cmdCode, ApplicationId: appId, HopByHopId: self.hopId, EndToEndId: self.hopId}
+	if _, err := self.conn.Write(req.AddAVP(avps...).Marshal()); err != nil {
+		t.Fatal("Error sending request: ", err)
+	}
+	ans, err := ReadDiameterMessage(self.conn)
+	if err != nil {
+		t.Fatal("Error reading answer: ", err)
+	}
+	if ans.IsRequest() || ans.HopByHopId != self.hopId {
+		t.Fatalf("Unexpected answer: %+v", ans)
+	}
+	return ans
+}
+
+func (self *dmtTestClient) ccr(t *testing.T, reqType, reqNr uint32, avps ...*AVP) *DiameterMessage {
+	return self.request(t, DMT_CMD_CC, DMT_APP_CC, append([]*AVP{NewAVPString(AVP_SESSION_ID, "cgrates;1386405744;1"),
+		NewAVPUint32(AVP_CC_REQUEST_TYPE, reqType), NewAVPUint32(AVP_CC_REQUEST_NUMBER, reqNr),
+		NewAVPGrouped(AVP_SUBSCRIPTION_ID, NewAVPUint32(AVP_SUBSCRIPTION_ID_TYPE, 0), NewAVPString(AVP_SUBSCRIPTION_ID_DATA, "1001"))}, avps...)...)
+}
+
+func dmtResultCode(t *testing.T, avps []*AVP, path ...uint32) uint32 {
+	avp := FindAVP(avps, append(path, AVP_RESULT_CODE)...)
+	if avp == nil {
+		t.Fatal("No Result-Code in answer")
+	}
+	resultCode, _ := avp.AsUint32()
+	return resultCode
+}
+
+func dmtUnits(avps []*AVP, path ...uint32) uint64 {
+	if avp := FindAVP(avps, path...); avp != nil {
+		units, _ := avp.AsUint64()
+		return units
+	}
+	return 0
+}
+
+func newDmtTestAgent(t *testing.T, balance time.Duration) (*DiameterAgent, *testConnector, engine.LogStorage) {
+	cgrCfg, err := config.NewDefaultCGRConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logDb, _ := engine.NewMapStorage()
+	connector := &testConnector{balance: balance}
+	return NewDiameterAgent(cgrCfg, connector, logDb), connector, logDb
+}
+
+func TestDmtAgentBaseCommands(t *testing.T) {
+	da, _, _ := newDmtTestAgent(t, 0)
+	clnt := newDmtTestClient(da)
+	if cea := clnt.request(t, DMT_CMD_CE, 0, NewAVPString(AVP_ORIGIN_HOST, "client")); dmtResultCode(t, cea.AVPs) != DIAMETER_SUCCESS ||
+		cea.AVPString(AVP_ORIGIN_HOST) != "CGR-DA" || cea.AVPString(AVP_PRODUCT_NAME) != "CGRateS" {
+		t.Errorf("Unexpected CEA: %+v", cea)
+	}
+	if dwa := clnt.request(t, DMT_CMD_DW, 0); dmtResultCode(t, dwa.AVPs) != DIAMETER_SUCCESS {
+		t.Errorf("Unexpected DWA: %+v", dwa)
+	}
+	if ans := clnt.request(t, 999, 0); dmtResultCode(t, ans.AVPs) != DIAMETER_COMMAND_UNSUPPORTED || ans.Flags&DMT_FLAG_ERROR == 0 {
+		t.Errorf("Unexpected answer: %+v", ans)
+	}
+	if cca := clnt.request(t, DMT_CMD_CC, DMT_APP_CC, NewAVPString(AVP_SESSION_ID, "session1")); dmtResultCode(t, cca.AVPs) != DIAMETER_MISSING_AVP {
+		t.Errorf("Unexpected CCA: %+v", cca)
+	}
+}
+
+func TestDmtAgentSession(t *testing.T) {
+	da, connector, logDb := newDmtTestAgent(t, 100*time.Second)
+	var notifiedCgrId string
+	var notifiedCost *engine.CallCost
+	da.SetCostNotifier(func(cgrId string, cc *engine.CallCost) { notifiedCgrId, notifiedCost = cgrId, cc })
+	clnt := newDmtTestClient(da)
+	cldAddr := NewAVPGrouped(AVP_SERVICE_INFORMATION, NewAVPGrouped(AVP_IMS_INFORMATION,
+		NewAVPString(AVP_CALLED_PARTY_ADDRESS, "tel:1002").WithVendor(VENDOR_3GPP)).WithVendor(VENDOR_3GPP)).WithVendor(VENDOR_3GPP)
+	mscc := func(avps ...*AVP) *AVP {
+		return NewAVPGrouped(AVP_MULTIPLE_SERVICES_CC, append([]*AVP{NewAVPUint32(AVP_RATING_GROUP, 1)}, avps...)...)
+	}
+	cca := clnt.ccr(t, CC_REQUEST_INITIAL, 0, cldAddr, mscc(NewAVPGrouped(AVP_REQUESTED_SERVICE_UNIT, NewAVPUint32(AVP_CC_TIME, 60))))
+	if dmtResultCode(t, cca.AVPs) != DIAMETER_SUCCESS || dmtResultCode(t, cca.AVPs, AVP_MULTIPLE_SERVICES_CC) != DIAMETER_SUCCESS {
+		t.Fatalf("Unexpected CCA: %+v", cca)
+	}
+	if granted := dmtUnits(cca.AVPs, AVP_MULTIPLE_SERVICES_CC, AVP_GRANTED_SERVICE_UNIT, AVP_CC_TIME); granted != 60 {
+		t.Error("Unexpected granted units: ", granted)
+	}
+	if cca.AVPString(AVP_SESSION_ID) != "cgrates;1386405744;1" || cca.AVPs[0].Code != AVP_SESSION_ID || cca.FindAVP(AVP_MULTIPLE_SERVICES_CC, AVP_RATING_GROUP) == nil {
+		t.Errorf("Unexpected CCA: %+v", cca)
+	}
+	if dSess := da.sessions["cgrates;1386405744;1"]; dSess == nil || dSess.callDescriptor.Account != "1001" || dSess.callDescriptor.Destination != "1002" ||
+		dSess.callDescriptor.TOR != "call" {
+		t.Errorf("Unexpected session: %+v", dSess)
+	}
+	// Used 50 out of 60, request another 60 but only 50 left on balance
+	cca = clnt.ccr(t, CC_REQUEST_UPDATE, 1, mscc(NewAVPGrouped(AVP_REQUESTED_SERVICE_UNIT, NewAVPUint32(AVP_CC_TIME, 60)),
+		NewAVPGrouped(AVP_USED_SERVICE_UNIT, NewAVPUint32(AVP_CC_TIME, 50))))
+	if dmtResultCode(t, cca.AVPs) != DIAMETER_SUCCESS {
+		t.Fatalf("Unexpected CCA: %+v", cca)
+	}
+	if granted := dmtUnits(cca.AVPs, AVP_MULTIPLE_SERVICES_CC, AVP_GRANTED_SERVICE_UNIT, AVP_CC_TIME); granted != 50 {
+		t.Error("Unexpected granted units: ", granted)
+	}
+	if cca.FindAVP(AVP_MULTIPLE_SERVICES_CC, AVP_FINAL_UNIT_INDICATION) == nil {
+		t.Error("Final-Unit-Indication missing")
+	}
+	cca = clnt.ccr(t, CC_REQUEST_TERMINATE, 2, mscc(NewAVPGrouped(AVP_USED_SERVICE_UNIT, NewAVPUint32(AVP_CC_TIME, 20))))
+	if dmtResultCode(t, cca.AVPs) != DIAMETER_SUCCESS {
+		t.Fatalf("Unexpected CCA: %+v", cca)
+	}
+	if connector.balance != 30*time.Second {
+		t.Error("Unexpected balance: ", connector.balance)
+	}
+	if cc, err := logDb.GetCallCostLog("cgrates;1386405744;1", engine.SESSION_MANAGER_SOURCE, "default"); err != nil {
+		t.Error(err)
+	} else if cc.GetDuration() != 70*time.Second || cc.Cost != 0.7 {
+		t.Errorf("Unexpected cost logged: %+v", cc)
+	}
+	if notifiedCgrId != utils.FSCgrId("cgrates;1386405744;1") || notifiedCost == nil || notifiedCost.Cost != 0.7 {
+		t.Errorf("Unexpected cost notified for %s: %+v", notifiedCgrId, notifiedCost)
+	}
+	if cca = clnt.ccr(t, CC_REQUEST_UPDATE, 3); dmtResultCode(t, cca.AVPs) != DIAMETER_UNKNOWN_SESSION_ID {
+		t.Errorf("Unexpected CCA: %+v", cca)
+	}
+}
+
+func TestDmtAgentEvent(t *testing.T) {
+	da, connector, _ := newDmtTestAgent(t, time.Second)
+	clnt := newDmtTestClient(da)
+	cca := clnt.ccr(t, CC_REQUEST_EVENT, 0, NewAVPGrouped(AVP_REQUESTED_SERVICE_UNIT, NewAVPUint64(AVP_CC_SERVICE_SPECIFIC_UNITS, 1)))
+	if dmtResultCode(t, cca.AVPs) != DIAMETER_SUCCESS || dmtUnits(cca.AVPs, AVP_GRANTED_SERVICE_UNIT, AVP_CC_SERVICE_SPECIFIC_UNITS) != 1 {
+		t.Errorf("Unexpected CCA: %+v", cca)
+	}
+	if connector.balance != 0 {
+		t.Error("Unexpected balance: ", connector.balance)
+	}
+	if cca = clnt.ccr(t, CC_REQUEST_EVENT, 0); dmtResultCode(t, cca.AVPs) != DIAMETER_CREDIT_LIMIT_REACHED {
+		t.Errorf("Unexpected CCA: %+v", cca)
+	}
+}
+
+func TestDmtAgentConcurrentRequests(t *testing.T) {
+	da, connector, _ := newDmtTestAgent(t, 1000*time.Second)
+	cd := &engine.CallDescriptor{Direction: "*out", Tenant: "cgrates.org", TOR: "call", Subject: "1001", Account: "1001", Destination: "1002",
+		TimeStart: time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)}
+	requested := &ccrUnits{requested: 10 * time.Second, hasRequested: true}
+	if resultCode, _ := da.initSession("session1", cd, requested); resultCode != DIAMETER_SUCCESS {
+		t.Fatal("Unexpected result code: ", resultCode)
+	}
+	dSess := da.sessions["session1"]
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 10 {
+				da.terminateSession("session1", &ccrUnits{used: 5 * time.Second, hasUsed: true})
+				return
+			}
+			da.updateSession("session1", &ccrUnits{requested: 10 * time.Second, hasRequested: true, used: 5 * time.Second, hasUsed: true})
+		}(i)
+	}
+	wg.Wait()
+	// Every reservation settled once, unused units refunded
+	if connector.balance+dSess.used != 1000*time.Second || dSess.used != time.Duration(len(dSess.callCosts))*5*time.Second {
+		t.Errorf("Unexpected balance: %v, used: %v, reservations: %d", connector.balance, dSess.used, len(dSess.callCosts))
+	}
+	if resultCode, _ := da.updateSession("session1", requested); resultCode != DIAMETER_UNKNOWN_SESSION_ID {
+		t.Error("Unexpected result code: ", resultCode)
+	}
+}
+
+func TestDmtAgentUnitsOutOfRange(t *testing.T) {
+	da, _, _ := newDmtTestAgent(t, time.Hour)
+	clnt := newDmtTestClient(da)
+	cca := clnt.ccr(t, CC_REQUEST_INITIAL, 0, NewAVPGrouped(AVP_REQUESTED_SERVICE_UNIT, NewAVPUint64(AVP_CC_TOTAL_OCTETS, 1<<40)))
+	if dmtResultCode(t, cca.AVPs) != DIAMETER_INVALID_AVP_VALUE {
+		t.Errorf("Unexpected CCA: %+v", cca)
+	}
+	units := new(ccrUnits)
+	maxUnits := uint64(MAX_SESSION_UNITS / time.Second)
+	if used, err := units.parseUnits(NewAVPGrouped(AVP_USED_SERVICE_UNIT, NewAVPUint64(AVP_CC_TOTAL_OCTETS, maxUnits))); err != nil || used != MAX_SESSION_UNITS {
+		t.Error("Unexpected units: ", used, err)
+	}
+	if _, err := units.parseUnits(NewAVPGrouped(AVP_USED_SERVICE_UNIT, NewAVPUint64(AVP_CC_TOTAL_OCTETS, maxUnits+1))); err != ErrUnitsOutOfRange {
+		t.Error("Expecting out of range error, received: ", err)
+	}
+}
@@ -0,0 +1,343 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	DIAMETER_VERSION = 1
+	// Message flags
+	DMT_FLAG_REQUEST   = 0x80
+	DMT_FLAG_PROXIABLE = 0x40
+	DMT_FLAG_ERROR     = 0x20
+	// AVP flags
+	AVP_FLAG_VENDOR    = 0x80
+	AVP_FLAG_MANDATORY = 0x40
+	// Command codes
+	DMT_CMD_CE = 257 // Capabilities-Exchange
+	DMT_CMD_CC = 272 // Credit-Control
+	DMT_CMD_DW = 280 // Device-Watchdog
+	DMT_CMD_DP = 282 // Disconnect-Peer
+	// Application ids
+	DMT_APP_CC  = 4          // Diameter Credit-Control Application
+	VENDOR_3GPP = 10415      // 3GPP vendor id, used on Ro service information AVPs
+	NTP_OFFSET  = 2208988800 // Seconds between 1900 (Diameter Time base) and 1970
+	// Base and credit control AVP codes
+	AVP_CALLED_STATION_ID         = 30
+	AVP_EVENT_TIMESTAMP           = 55
+	AVP_AUTH_APPLICATION_ID       = 258
+	AVP_SESSION_ID                = 263
+	AVP_ORIGIN_HOST               = 264
+	AVP_VENDOR_ID                 = 266
+	AVP_RESULT_CODE               = 268
+	AVP_PRODUCT_NAME              = 269
+	AVP_ORIGIN_REALM              = 296
+	AVP_CC_REQUEST_NUMBER         = 415
+	AVP_CC_REQUEST_TYPE           = 416
+	AVP_CC_SERVICE_SPECIFIC_UNITS = 417
+	AVP_CC_TIME                   = 420
+	AVP_CC_TOTAL_OCTETS           = 421
+	AVP_FINAL_UNIT_INDICATION     = 430
+	AVP_GRANTED_SERVICE_UNIT      = 431
+	AVP_RATING_GROUP              = 432
+	AVP_REQUESTED_SERVICE_UNIT    = 437
+	AVP_SERVICE_IDENTIFIER        = 439
+	AVP_SUBSCRIPTION_ID           = 443
+	AVP_SUBSCRIPTION_ID_DATA      = 444
+	AVP_USED_SERVICE_UNIT         = 446
+	AVP_FINAL_UNIT_ACTION         = 449
+	AVP_SUBSCRIPTION_ID_TYPE      = 450
+	AVP_MULTIPLE_SERVICES_CC      = 456
+	AVP_CALLED_PARTY_ADDRESS      = 832  // 3GPP
+	AVP_SERVICE_INFORMATION       = 873  // 3GPP
+	AVP_IMS_INFORMATION           = 876  // 3GPP
+	AVP_ADDRESS_DATA              = 897  // 3GPP
+	AVP_RECIPIENT_ADDRESS         = 1201 // 3GPP
+	AVP_SMS_INFORMATION           = 2000 // 3GPP
+	AVP_RECIPIENT_INFO            = 2026 // 3GPP
+	// CC-Request-Type values
+	CC_REQUEST_INITIAL   = 1
+	CC_REQUEST_UPDATE    = 2
+	CC_REQUEST_TERMINATE = 3
+	CC_REQUEST_EVENT     = 4
+	// Final-Unit-Action values
+	FINAL_UNIT_TERMINATE = 0
+	// Result-Code values
+	DIAMETER_SUCCESS                 = 2001
+	DIAMETER_COMMAND_UNSUPPORTED     = 3001
+	DIAMETER_APPLICATION_UNSUPPORTED = 3007
+	DIAMETER_CREDIT_LIMIT_REACHED    = 4012
+	DIAMETER_UNKNOWN_SESSION_ID      = 5002
+	DIAMETER_INVALID_AVP_VALUE       = 5004
+	DIAMETER_MISSING_AVP             = 5005
+	DIAMETER_UNABLE_TO_COMPLY        = 5012
+)
+
+// Diameter Attribute-Value Pair, grouped AVPs keep their encoded children in Data
+type AVP struct {
+	Code     uint32
+	Flags    uint8
+	VendorId uint32
+	Data     []byte
+}
+
+func NewAVPString(code uint32, val string) *AVP {
+	return &AVP{Code: code, Flags: AVP_FLAG_MANDATORY, Data: []byte(val)}
+}
+
+func NewAVPUint32(code uint32, val uint32) *AVP {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, val)
+	return &AVP{Code: code, Flags: AVP_FLAG_MANDATORY, Data: data}
+}
+
+func NewAVPUint64(code uint32, val uint64) *AVP {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, val)
+	return &AVP{Code: code, Flags: AVP_FLAG_MANDATORY, Data: data}
+}
+
+func NewAVPTime(code uint32, val time.Time) *AVP {
+	return NewAVPUint32(code, uint32(val.Unix()+NTP_OFFSET))
+}
+
+func NewAVPGrouped(code uint32, avps ...*AVP) *AVP {
+	var buf bytes.Buffer
+	for _, avp := range avps {
+		avp.writeTo(&buf)
+	}
+	return &AVP{Code: code, Flags: AVP_FLAG_MANDATORY, Data: buf.Bytes()}
+}
+
+// Marks the AVP as vendor specific
+func (avp *AVP) WithVendor(vendorId uint32) *AVP {
+	avp.Flags |= AVP_FLAG_VENDOR
+	avp.VendorId = vendorId
+	return avp
+}
+
+func (avp *AVP) AsString() string {
+	return string(avp.Data)
+}
+
+// Decodes Unsigned32, Integer32 and Enumerated AVPs
+func (avp *AVP) AsUint32() (uint32, error) {
+	if len(avp.Data) != 4 {
+		return 0, fmt.Errorf("Invalid length %d for 32 bits AVP %d", len(avp.Data), avp.Code)
+	}
+	return binary.BigEndian.Uint32(avp.Data), nil
+}
+
+// Decodes Unsigned64 and Integer64 AVPs, accepting also 32 bits encoded values
+func (avp *AVP) AsUint64() (uint64, error) {
+	switch len(avp.Data) {
+	case 4:
+		return uint64(binary.BigEndian.Uint32(avp.Data)), nil
+	case 8:
+		return binary.BigEndian.Uint64(avp.Data), nil
+	}
+	return 0, fmt.Errorf("Invalid length %d for 64 bits AVP %d", len(avp.Data), avp.Code)
+}
+
+func (avp *AVP) AsTime() (time.Time, error) {
+	secs, err := avp.AsUint32()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(secs)-NTP_OFFSET, 0), nil
+}
+
+func (avp *AVP) AsGrouped() ([]*AVP, error) {
+	return decodeAVPs(avp.Data)
+}
+
+// Length of the AVP on the wire, without padding
+func (avp *AVP) length() int {
+	if avp.Flags&AVP_FLAG_VENDOR != 0 {
+		return 12 + len(avp.Data)
+	}
+	return 8 + len(avp.Data)
+}
+
+func (avp *AVP) writeTo(buf *bytes.Buffer) {
+	binary.Write(buf, binary.BigEndian, avp.Code)
+	avpLen := avp.length()
+	buf.Write([]byte{avp.Flags, byte(avpLen >> 16), byte(avpLen >> 8), byte(avpLen)})
+	if avp.Flags&AVP_FLAG_VENDOR != 0 {
+		binary.Write(buf, binary.BigEndian, avp.VendorId)
+	}
+	buf.Write(avp.Data)
+	if pad := (4 - avpLen%4) % 4; pad != 0 {
+		buf.Write(make([]byte, pad))
+	}
+}
+
+func decodeAVPs(data []byte) (avps []*AVP, err error) {
+	for len(data) != 0 {
+		if len(data) < 8 {
+			return nil, errors.New("Truncated AVP header")
+		}
+		avp := &AVP{Code: binary.BigEndian.Uint32(data[0:4]), Flags: data[4]}
+		avpLen := int(data[5])<<16 | int(data[6])<<8 | int(data[7])
+		hdrLen := 8
+		if avp.Flags&AVP_FLAG_VENDOR != 0 {
+			if len(data) < 12 {
+				return nil, errors.New("Truncated AVP header")
+			}
+			avp.VendorId = binary.BigEndian.Uint32(data[8:12])
+			hdrLen = 12
+		}
+		if avpLen < hdrLen || avpLen > len(data) {
+			return nil, fmt.Errorf("Invalid length %d for AVP %d", avpLen, avp.Code)
+		}
+		avp.Data = data[hdrLen:avpLen]
+		avps = append(avps, avp)
+		if padded := avpLen + (4-avpLen%4)%4; padded < len(data) {
+			data = data[padded:]
+		} else {
+			data = nil
+		}
+	}
+	return avps, nil
+}
+
+// Returns the first AVP with the code, descending into the grouped AVPs for each additional code in the path
+func FindAVP(avps []*AVP, path ...uint32) *AVP {
+	for idx, code := range path {
+		var found *AVP
+		for _, avp := range avps {
+			if avp.Code == code {
+				found = avp
+				break
+			}
+		}
+		if found == nil || idx == len(path)-1 {
+			return found
+		}
+		var err error
+		if avps, err = found.AsGrouped(); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Diameter message as defined in RFC 3588
+type DiameterMessage struct {
+	Flags         uint8
+	CommandCode   uint32
+	ApplicationId uint32
+	HopByHopId    uint32
+	EndToEndId    uint32
+	AVPs          []*AVP
+}
+
+func (msg *DiameterMessage) IsRequest() bool {
+	return msg.Flags&DMT_FLAG_REQUEST != 0
+}
+
+func (msg *DiameterMessage) AddAVP(avps ...*AVP) *DiameterMessage {
+	msg.AVPs = append(msg.AVPs, avps...)
+	return msg
+}
+
+func (msg *DiameterMessage) FindAVP(path ...uint32) *AVP {
+	return FindAVP(msg.AVPs, path...)
+}
+
+// Returns the string value of the AVP found at path, empty if not present
+func (msg *DiameterMessage) AVPString(path ...uint32) string {
+	if avp := msg.FindAVP(path...); avp != nil {
+		return avp.AsString()
+	}
+	return ""
+}
+
+// Creates the answer for a request, keeping the command and the identifiers
+func (msg *DiameterMessage) Answer() *DiameterMessage {
+	return &DiameterMessage{Flags: msg.Flags &^ (DMT_FLAG_REQUEST | DMT_FLAG_ERROR), CommandCode: msg.CommandCode,
+		ApplicationId: msg.ApplicationId, HopByHopId: msg.HopByHopId, EndToEndId: msg.EndToEndId}
+}
+
+func (msg *DiameterMessage) Marshal() []byte {
+	var avpsBuf bytes.Buffer
+	for _, avp := range msg.AVPs {
+		avp.writeTo(&avpsBuf)
+	}
+	msgLen := 20 + avpsBuf.Len()
+	var buf bytes.Buffer
+	buf.Write([]byte{DIAMETER_VERSION, byte(msgLen >> 16), byte(msgLen >> 8), byte(msgLen)})
+	buf.Write([]byte{msg.Flags, byte(msg.CommandCode >> 16), byte(msg.CommandCode >> 8), byte(msg.CommandCode)})
+	binary.Write(&buf, binary.BigEndian, msg.ApplicationId)
+	binary.Write(&buf, binary.BigEndian, msg.HopByHopId)
+	binary.Write(&buf, binary.BigEndian, msg.EndToEndId)
+	buf.Write(avpsBuf.Bytes())
+	return buf.Bytes()
+}
+
+// Reads one message out of the stream
+func ReadDiameterMessage(rdr io.Reader) (*DiameterMessage, error) {
+	hdr := make([]byte, 20)
+	if _, err := io.ReadFull(rdr, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != DIAMETER_VERSION {
+		return nil, fmt.Errorf("Unsupported Diameter version: %d", hdr[0])
+	}
+	msgLen := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+	if msgLen < 20 {
+		return nil, fmt.Errorf("Invalid message length: %d", msgLen)
+	}
+	body := make([]byte, msgLen-20)
+	if _, err := io.ReadFull(rdr, body); err != nil {
+		return nil, err
+	}
+	avps, err := decodeAVPs(body)
+	if err != nil {
+		return nil, err
+	}
+	return &DiameterMessage{Flags: hdr[4],
+		CommandCode:   uint32(hdr[5])<<16 | uint32(hdr[6])<<8 | uint32(hdr[7]),
+		ApplicationId: binary.BigEndian.Uint32(hdr[8:12]),
+		HopByHopId:    binary.BigEndian.Uint32(hdr[12:16]),
+		EndToEndId:    binary.BigEndian.Uint32(hdr[16:20]),
+		AVPs:          avps}, nil
+}
+
+// Strips the URI scheme and domain out of addresses like tel:+4986517174963 or sip:1001@cgrates.org
+func addressNumber(addr string) string {
+	for _, scheme := range []string{"tel:", "sip:", "sips:"} {
+		addr = strings.TrimPrefix(addr, scheme)
+	}
+	if idx := strings.Index(addr, "@"); idx != -1 {
+		addr = addr[:idx]
+	}
+	if idx := strings.Index(addr, ";"); idx != -1 {
+		addr = addr[:idx]
+	}
+	return addr
+}
@@ -0,0 +1,67 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDmtAVPGroupedPadding(t *testing.T) {
+	grouped := NewAVPGrouped(AVP_SUBSCRIPTION_ID, NewAVPUint32(AVP_SUBSCRIPTION_ID_TYPE, 0), NewAVPString(AVP_SUBSCRIPTION_ID_DATA, "4986517174963"))
+	if len(grouped.Data) != 12+24 { // 13 bytes of data padded to 16
+		t.Errorf("Unexpected grouped data length: %d", len(grouped.Data))
+	}
+	if avps, err := grouped.AsGrouped(); err != nil {
+		t.Error(err)
+	} else if len(avps) != 2 || avps[1].AsString() != "4986517174963" {
+		t.Errorf("Unexpected grouped AVPs: %+v", avps)
+	}
+}
+
+func TestDmtMessageMarshalRead(t *testing.T) {
+	evTime := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	msg := &DiameterMessage{Flags: DMT_FLAG_REQUEST | DMT_FLAG_PROXIABLE, CommandCode: DMT_CMD_CC, ApplicationId: DMT_APP_CC,
+		HopByHopId: 1, EndToEndId: 2}
+	msg.AddAVP(NewAVPString(AVP_SESSION_ID, "session1"), NewAVPUint32(AVP_CC_REQUEST_TYPE, CC_REQUEST_INITIAL), NewAVPTime(AVP_EVENT_TIMESTAMP, evTime),
+		NewAVPGrouped(AVP_SERVICE_INFORMATION,
+			NewAVPGrouped(AVP_IMS_INFORMATION, NewAVPString(AVP_CALLED_PARTY_ADDRESS, "tel:+4986517174964").WithVendor(VENDOR_3GPP)).WithVendor(VENDOR_3GPP)).WithVendor(VENDOR_3GPP),
+		NewAVPGrouped(AVP_REQUESTED_SERVICE_UNIT, NewAVPUint64(AVP_CC_TOTAL_OCTETS, 1048576)))
+	rcvMsg, err := ReadDiameterMessage(bytes.NewBuffer(msg.Marshal()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, rcvMsg) {
+		t.Errorf("Expecting: %+v, received: %+v", msg, rcvMsg)
+	}
+	if !rcvMsg.IsRequest() || rcvMsg.Answer().IsRequest() {
+		t.Error("Wrong request flag")
+	}
+	if cldAddr := rcvMsg.AVPString(AVP_SERVICE_INFORMATION, AVP_IMS_INFORMATION, AVP_CALLED_PARTY_ADDRESS); addressNumber(cldAddr) != "+4986517174964" {
+		t.Error("Unexpected called party address: ", cldAddr)
+	}
+	if rcvTime, err := rcvMsg.FindAVP(AVP_EVENT_TIMESTAMP).AsTime(); err != nil || !rcvTime.Equal(evTime) {
+		t.Error("Unexpected event timestamp: ", rcvTime, err)
+	}
+	if octets, err := rcvMsg.FindAVP(AVP_REQUESTED_SERVICE_UNIT, AVP_CC_TOTAL_OCTETS).AsUint64(); err != nil || octets != 1048576 {
+		t.Error("Unexpected octets: ", octets, err)
+	}
+}
@@ -0,0 +1,435 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/cdrs"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/sessionmanager"
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	RAD_INSUFFICIENT_FUNDS = "INSUFFICIENT_FUNDS"
+	RAD_SYSTEM_ERROR       = "SYSTEM_ERROR"
+	RAD_DISCONNECT_TIMEOUT = time.Duration(3) * time.Second // Time to wait for the NAS to acknowledge a Disconnect-Request
+)
+
+// Accounting session, holding the costs debited so far
+type radSession struct {
+	callDescriptor *engine.CallDescriptor
+	callCosts      []*engine.CallCost
+	debited        time.Duration // Session time covered by debits
+	nasAddr        string        // NAS handling the session, receiving the Disconnect-Requests
+	acctSessionId  string
+	userName       string
+}
+
+// RADIUS server authorizing calls based on the balance and turning accounting into debits and CDRs
+type RadiusAgent struct {
+	cgrCfg       *config.CGRConfig
+	connector    engine.Connector
+	cdrServer    *cdrs.CDRS
+	loggerDb     engine.LogStorage
+	costNotifier sessionmanager.CostNotifier // Receives the final session costs, the mediator waiting on them
+	dict         *RadDictionary
+	cfgCdrFields map[string]string // Attribute names indexed on CDR field name
+	httpClient   *http.Client
+	sessions     map[string]*radSession
+	sessionsMux  sync.Mutex
+	disconnectId uint8 // Identifier of the last Disconnect-Request sent
+}
+
+func NewRadiusAgent(cgrCfg *config.CGRConfig, connector engine.Connector, cdrServer *cdrs.CDRS, loggerDb engine.LogStorage) (*RadiusAgent, error) {
+	ra := &RadiusAgent{cgrCfg: cgrCfg, connector: connector, cdrServer: cdrServer, loggerDb: loggerDb, dict: NewRadDictionary(),
+		httpClient: new(http.Client), sessions: make(map[string]*radSession)}
+	if cgrCfg.RADictionariesDir != "" {
+		if err := ra.dict.LoadDir(cgrCfg.RADictionariesDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := ra.parseFieldsConfig(); err != nil {
+		return nil, err
+	}
+	return ra, nil
+}
+
+// Hands over the final session costs directly instead of leaving them only in logDb, to be set before ListenAndServe
+func (ra *RadiusAgent) SetCostNotifier(notifier sessionmanager.CostNotifier) {
+	ra.costNotifier = notifier
+}
+
+// Loads the attributes mapped on CDR fields, making sure they are known to the dictionary
+func (ra *RadiusAgent) parseFieldsConfig() error {
+	ra.cfgCdrFields = map[string]string{
+		utils.ACCID:       ra.cgrCfg.RAAccIdField,
+		utils.REQTYPE:     ra.cgrCfg.RAReqTypeField,
+		utils.DIRECTION:   ra.cgrCfg.RADirectionField,
+		utils.TENANT:      ra.cgrCfg.RATenantField,
+		utils.TOR:         ra.cgrCfg.RATorField,
+		utils.ACCOUNT:     ra.cgrCfg.RAAccountField,
+		utils.SUBJECT:     ra.cgrCfg.RASubjectField,
+		utils.DESTINATION: ra.cgrCfg.RADestinationField,
+		utils.DURATION:    ra.cgrCfg.RADurationField,
+	}
+	for _, fieldWithAttr := range ra.cgrCfg.RAExtraFields {
+		splt := strings.SplitN(fieldWithAttr, ":", 2)
+		if len(splt) != 2 {
+			return errors.New("Cannot parse radius_agent.extra_fields")
+		}
+		if utils.IsSliceMember(utils.PrimaryCdrFields, splt[0]) {
+			return errors.New("Extra radius_agent.extra_fields overwriting primary fields")
+		}
+		ra.cfgCdrFields[splt[0]] = splt[1]
+	}
+	for cdrField, cfgVal := range ra.cfgCdrFields {
+		if !strings.HasPrefix(cfgVal, utils.STATIC_VALUE_PREFIX) && ra.dict.AttributeByName(cfgVal) == nil {
+			return fmt.Errorf("Unknown attribute %s configured for field %s", cfgVal, cdrField)
+		}
+	}
+	return nil
+}
+
+// Listens for authorization and accounting requests, returns on the first listener error
+func (ra *RadiusAgent) ListenAndServe() error {
+	errChan := make(chan error, 2)
+	for _, lstn := range []struct {
+		addr    string
+		process func(*RadiusPacket, string) *RadiusPacket
+	}{{ra.cgrCfg.RAListenAuth, ra.processAuth}, {ra.cgrCfg.RAListenAcct, ra.processAcct}} {
+		conn, err := net.ListenPacket("udp", lstn.addr)
+		if err != nil {
+			return err
+		}
+		engine.Logger.Info(fmt.Sprintf("<RadiusAgent> Listening for requests on %s", lstn.addr))
+		go func(conn net.PacketConn, process func(*RadiusPacket, string) *RadiusPacket) {
+			errChan <- ra.serve(conn, process)
+		}(conn, lstn.process)
+	}
+	return <-errChan
+}
+
+// Reads the requests out of the connection, processing them in order of arrival
+func (ra *RadiusAgent) serve(conn net.PacketConn, process func(*RadiusPacket, string) *RadiusPacket) error {
+	buf := make([]byte, 4096) // Maximum RADIUS packet length
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		pktData := make([]byte, n) // Parsed attributes point inside
+		copy(pktData, buf[:n])
+		req, err := ParseRadiusPacket(pktData)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error parsing packet from %s: %v", addr, err))
+			continue
+		}
+		if !req.IsAuthentic(ra.cgrCfg.RASecret) {
+			engine.Logger.Warning(fmt.Sprintf("<RadiusAgent> Dropping packet from %s with invalid authenticator", addr))
+			continue
+		}
+		host, _, _ := net.SplitHostPort(addr.String())
+		if reply := process(req, host); reply != nil {
+			replyData, err := reply.MarshalReply(ra.cgrCfg.RASecret)
+			if err != nil {
+				engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error encoding reply to %s: %v", addr, err))
+				continue
+			}
+			if _, err := conn.WriteTo(replyData, addr); err != nil {
+				engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error sending reply to %s: %v", addr, err))
+			}
+		}
+	}
+}
+
+// Authorizes prepaid calls, limiting their duration to the one covered by the balance
+func (ra *RadiusAgent) processAuth(req *RadiusPacket, cdrHost string) *RadiusPacket {
+	if req.Code != RAD_ACCESS_REQUEST {
+		return nil
+	}
+	cdr, err := ra.packetAsStoredCdr(req, cdrHost)
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error parsing Access-Request: %v", err))
+		return ra.reject(req, RAD_SYSTEM_ERROR)
+	}
+	if cdr.ReqType != utils.PREPAID {
+		return req.Reply(RAD_ACCESS_ACCEPT)
+	}
+	cd := storedCdrCallDescriptor(cdr)
+	cd.TimeStart = time.Now()
+	cd.TimeEnd = cd.TimeStart.Add(ra.cgrCfg.RAMaxCallDuration)
+	var maxSessionTime float64
+	if err := ra.connector.GetMaxSessionTime(*cd, &maxSessionTime); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Could not get max session time for %s: %v", cdr.AccId, err))
+		return ra.reject(req, RAD_SYSTEM_ERROR)
+	}
+	sessionTimeout := int(time.Duration(maxSessionTime).Seconds())
+	if sessionTimeout <= 0 {
+		return ra.reject(req, RAD_INSUFFICIENT_FUNDS)
+	}
+	reply := req.Reply(RAD_ACCESS_ACCEPT)
+	if attr, err := ra.dict.NewAttribute("Session-Timeout", strconv.Itoa(sessionTimeout)); err == nil {
+		reply.Attributes = append(reply.Attributes, attr)
+	}
+	return reply
+}
+
+func (ra *RadiusAgent) reject(req *RadiusPacket, reason string) *RadiusPacket {
+	reply := req.Reply(RAD_ACCESS_REJECT)
+	if attr, err := ra.dict.NewAttribute("Reply-Message", reason); err == nil {
+		reply.Attributes = append(reply.Attributes, attr)
+	}
+	return reply
+}
+
+// Debits the sessions out of accounting requests and posts the CDR when they stop
+func (ra *RadiusAgent) processAcct(req *RadiusPacket, cdrHost string) *RadiusPacket {
+	if req.Code != RAD_ACCOUNTING_REQUEST {
+		return nil
+	}
+	reply := req.Reply(RAD_ACCOUNTING_RESPONSE) // Acknowledge even on errors, retransmits would fail the same way
+	cdr, err := ra.packetAsStoredCdr(req, cdrHost)
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error parsing Accounting-Request: %v", err))
+		return reply
+	}
+	if cdr.AccId == "" {
+		engine.Logger.Err("<RadiusAgent> Accounting-Request without accounting id")
+		return reply
+	}
+	eventTime := time.Now()
+	if evTime, err := utils.ParseTimeDetectLayout(ra.dict.PacketValue(req, "Event-Timestamp")); err == nil && !evTime.IsZero() {
+		eventTime = evTime
+	}
+	if delay, err := strconv.Atoi(ra.dict.PacketValue(req, "Acct-Delay-Time")); err == nil {
+		eventTime = eventTime.Add(-time.Duration(delay) * time.Second)
+	}
+	switch ra.dict.PacketValue(req, "Acct-Status-Type") {
+	case "Start":
+		ra.sessionsMux.Lock()
+		ra.sessions[cdr.AccId] = ra.newSession(req, cdr, cdrHost, eventTime)
+		ra.sessionsMux.Unlock()
+	case "Interim-Update":
+		if cdr.ReqType == utils.PREPAID {
+			rSess := ra.getSession(req, cdr, cdrHost, eventTime)
+			if notDebited, err := ra.debitSession(rSess, cdr.Duration, true); err != nil {
+				engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error debiting session %s: %v", cdr.AccId, err))
+			} else if notDebited != 0 { // Out of balance, the session would go on for free otherwise
+				engine.Logger.Warning(fmt.Sprintf("<RadiusAgent> Disconnecting session %s, time not covered by the balance: %v", cdr.AccId, notDebited))
+				go func() {
+					if err := ra.disconnectSession(rSess, RAD_INSUFFICIENT_FUNDS); err != nil {
+						engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Could not disconnect session %s: %v", cdr.AccId, err))
+					}
+				}()
+			}
+		}
+	case "Stop":
+		rSess := ra.getSession(req, cdr, cdrHost, eventTime)
+		ra.sessionsMux.Lock()
+		delete(ra.sessions, cdr.AccId)
+		ra.sessionsMux.Unlock()
+		if cdr.ReqType == utils.PREPAID || cdr.ReqType == utils.POSTPAID {
+			if notDebited, err := ra.debitSession(rSess, cdr.Duration, cdr.ReqType == utils.PREPAID); err != nil {
+				engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Error debiting session %s: %v", cdr.AccId, err))
+			} else if notDebited != 0 {
+				engine.Logger.Warning(fmt.Sprintf("<RadiusAgent> Session %s ended with time not covered by the balance: %v", cdr.AccId, notDebited))
+			}
+			logCallCosts(ra.loggerDb, ra.costNotifier, cdr.AccId, rSess.callCosts)
+		}
+		cdr.AnswerTime = rSess.callDescriptor.TimeStart
+		if err := ra.postCdr(cdr); err == engine.ErrDuplicateCdr {
+			engine.Logger.Warning(fmt.Sprintf("<RadiusAgent> Duplicate CDR, accid: %s", cdr.AccId))
+		} else if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<RadiusAgent> Failed posting CDR, error: %s", err.Error()))
+		}
+	}
+	return reply
+}
+
+// Session started at timeStart out of the accounting request, remembering where to send its Disconnect-Request
+func (ra *RadiusAgent) newSession(req *RadiusPacket, cdr *utils.StoredCdr, cdrHost string, timeStart time.Time) *radSession {
+	rSess := &radSession{callDescriptor: storedCdrCallDescriptor(cdr), nasAddr: ra.dict.PacketValue(req, "NAS-IP-Address"),
+		acctSessionId: ra.dict.PacketValue(req, "Acct-Session-Id"), userName: ra.dict.PacketValue(req, "User-Name")}
+	if rSess.nasAddr == "" {
+		rSess.nasAddr = cdrHost
+	}
+	rSess.callDescriptor.TimeStart = timeStart
+	return rSess
+}
+
+// Returns the session for the CDR, recreating it if we missed its start
+func (ra *RadiusAgent) getSession(req *RadiusPacket, cdr *utils.StoredCdr, cdrHost string, eventTime time.Time) *radSession {
+	ra.sessionsMux.Lock()
+	defer ra.sessionsMux.Unlock()
+	rSess, hasIt := ra.sessions[cdr.AccId]
+	if !hasIt {
+		rSess = ra.newSession(req, cdr, cdrHost, eventTime.Add(-cdr.Duration))
+		ra.sessions[cdr.AccId] = rSess
+	}
+	return rSess
+}
+
+// Debits the session time not covered by previous debits, prepaid sessions only as far as the balance allows.
+// Returns the session time left without debit.
+func (ra *RadiusAgent) debitSession(rSess *radSession, sessionTime time.Duration, prepaid bool) (time.Duration, error) {
+	if sessionTime <= rSess.debited {
+		return 0, nil
+	}
+	cd := *rSess.callDescriptor
+	cd.TimeStart = rSess.callDescriptor.TimeStart.Add(rSess.debited)
+	cd.TimeEnd = rSess.callDescriptor.TimeStart.Add(sessionTime)
+	cd.CallDuration = sessionTime
+	cd.LoopIndex = float64(len(rSess.callCosts))
+	cc := new(engine.CallCost)
+	if !prepaid {
+		if err := ra.connector.Debit(cd, cc); err != nil {
+			return 0, err
+		}
+		rSess.callCosts = append(rSess.callCosts, cc)
+		rSess.debited = sessionTime
+		return 0, nil
+	}
+	if err := ra.connector.MaxDebit(cd, cc); err != nil && err.Error() != NO_CREDIT_ERR {
+		return 0, err
+	}
+	if granted := cc.GetDuration(); granted != 0 {
+		rSess.callCosts = append(rSess.callCosts, cc)
+		rSess.debited += granted
+	}
+	return sessionTime - rSess.debited, nil
+}
+
+// Asks the NAS to end the session with a Disconnect-Request (RFC 5176), its accounting Stop closing the session afterwards
+func (ra *RadiusAgent) disconnectSession(rSess *radSession, reason string) error {
+	ra.sessionsMux.Lock()
+	ra.disconnectId += 1
+	req := &RadiusPacket{Code: RAD_DISCONNECT_REQUEST, Identifier: ra.disconnectId}
+	ra.sessionsMux.Unlock()
+	for _, attrVal := range [][]string{{"Acct-Session-Id", rSess.acctSessionId}, {"User-Name", rSess.userName}, {"Reply-Message", reason}} {
+		if attrVal[1] == "" {
+			continue
+		}
+		attr, err := ra.dict.NewAttribute(attrVal[0], attrVal[1])
+		if err != nil {
+			return err
+		}
+		req.Attributes = append(req.Attributes, attr)
+	}
+	if err := req.SignAccountingRequest(ra.cgrCfg.RASecret); err != nil {
+		return err
+	}
+	reqData, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(rSess.nasAddr, strconv.Itoa(ra.cgrCfg.RADisconnectPort)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(RAD_DISCONNECT_TIMEOUT))
+	if _, err := conn.Write(reqData); err != nil {
+		return err
+	}
+	buf := make([]byte, RAD_MAX_PACKET_LEN)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	reply, err := ParseRadiusPacket(buf[:n])
+	if err != nil {
+		return err
+	}
+	if !reply.IsAuthenticReply(req, ra.cgrCfg.RASecret) {
+		return errors.New("Invalid reply authenticator")
+	}
+	if reply.Code != RAD_DISCONNECT_ACK {
+		return fmt.Errorf("Disconnect refused by NAS %s, Error-Cause: %s", rSess.nasAddr, ra.dict.PacketValue(reply, "Error-Cause"))
+	}
+	return nil
+}
+
+func (ra *RadiusAgent) postCdr(cdr *utils.StoredCdr) error {
+	if ra.cgrCfg.RACdrs == utils.INTERNAL {
+		return ra.cdrServer.ProcessRawCdr(cdr)
+	}
+	resp, err := ra.httpClient.PostForm(fmt.Sprintf("http://%s/cgr", ra.cgrCfg.RACdrs), cdr.AsRawCdrHttpForm())
+	if err != nil {
+		return err
+	}
+	return cdrs.ReplyError(resp)
+}
+
+// Maps the packet attributes on CDR fields based on configuration
+func (ra *RadiusAgent) packetAsStoredCdr(pkt *RadiusPacket, cdrHost string) (*utils.StoredCdr, error) {
+	storedCdr := &utils.StoredCdr{CdrHost: cdrHost, CdrSource: ra.cgrCfg.RASourceId, ExtraFields: map[string]string{}, Cost: -1}
+	var err error
+	for cfgFieldName, cfgFieldVal := range ra.cfgCdrFields {
+		var fieldVal string
+		if strings.HasPrefix(cfgFieldVal, utils.STATIC_VALUE_PREFIX) {
+			fieldVal = cfgFieldVal[1:]
+		} else {
+			fieldVal = ra.dict.PacketValue(pkt, cfgFieldVal)
+		}
+		switch cfgFieldName {
+		case utils.ACCID:
+			storedCdr.CgrId = utils.FSCgrId(fieldVal)
+			storedCdr.AccId = fieldVal
+		case utils.REQTYPE:
+			storedCdr.ReqType = fieldVal
+		case utils.DIRECTION:
+			storedCdr.Direction = fieldVal
+		case utils.TENANT:
+			storedCdr.Tenant = fieldVal
+		case utils.TOR:
+			storedCdr.TOR = fieldVal
+		case utils.ACCOUNT:
+			storedCdr.Account = fieldVal
+		case utils.SUBJECT:
+			storedCdr.Subject = fieldVal
+		case utils.DESTINATION:
+			storedCdr.Destination = fieldVal
+		case utils.DURATION:
+			if fieldVal == "" { // Not present on authorization and accounting start
+				continue
+			}
+			if storedCdr.Duration, err = utils.ParseDurationWithSecs(fieldVal); err != nil {
+				return nil, fmt.Errorf("Cannot parse duration field, err: %s", err.Error())
+			}
+		default: // Extra fields will not match predefined so they all show up here
+			storedCdr.ExtraFields[cfgFieldName] = fieldVal
+		}
+	}
+	return storedCdr, nil
+}
+
+func storedCdrCallDescriptor(cdr *utils.StoredCdr) *engine.CallDescriptor {
+	return &engine.CallDescriptor{Direction: cdr.Direction, Tenant: cdr.Tenant, TOR: cdr.TOR,
+		Subject: cdr.Subject, Account: cdr.Account, Destination: cdr.Destination}
+}
@@ -0,0 +1,183 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Builds requests the way a NAS would
+func radTestRequest(t *testing.T, ra *RadiusAgent, code uint8, attrVals ...string) *RadiusPacket {
+	pkt := &RadiusPacket{Code: code, Identifier: 1}
+	for i := 0; i < len(attrVals); i += 2 {
+		attr, err := ra.dict.NewAttribute(attrVals[i], attrVals[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt.Attributes = append(pkt.Attributes, attr)
+	}
+	if code == RAD_ACCESS_REQUEST {
+		if err := pkt.SignAccessRequest(ra.cgrCfg.RASecret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pkt
+}
+
+func TestRadAgentFieldsConfig(t *testing.T) {
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	cgrCfg.RAExtraFields = []string{"nas_ip:NAS-IP-Address"}
+	if _, err := NewRadiusAgent(cgrCfg, nil, nil, nil); err != nil {
+		t.Error(err)
+	}
+	cgrCfg.RAExtraFields = []string{"nas_ip:Unknown-Attribute"}
+	if _, err := NewRadiusAgent(cgrCfg, nil, nil, nil); err == nil {
+		t.Error("Should not accept unknown attributes")
+	}
+	cgrCfg.RAExtraFields = []string{utils.ACCOUNT + ":User-Name"}
+	if _, err := NewRadiusAgent(cgrCfg, nil, nil, nil); err == nil {
+		t.Error("Should not accept overwriting primary fields")
+	}
+}
+
+func TestRadAgentAuthAcct(t *testing.T) {
+	var cdrForms []url.Values
+	cdrsSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cgr" {
+			r.ParseForm()
+			cdrForms = append(cdrForms, r.Form)
+		}
+	}))
+	defer cdrsSrv.Close()
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	cgrCfg.RACdrs = strings.TrimPrefix(cdrsSrv.URL, "http://")
+	cgrCfg.RAExtraFields = []string{"nas_ip:NAS-IP-Address"}
+	connector := &testConnector{balance: 100 * time.Second}
+	logDb, _ := engine.NewMapStorage()
+	ra, err := NewRadiusAgent(cgrCfg, connector, nil, logDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := ra.processAuth(radTestRequest(t, ra, RAD_ACCESS_REQUEST, "User-Name", "1001", "Called-Station-Id", "1002"), "127.0.0.1")
+	if reply.Code != RAD_ACCESS_ACCEPT || ra.dict.PacketValue(reply, "Session-Timeout") != "100" {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+	reply = ra.processAcct(radTestRequest(t, ra, RAD_ACCOUNTING_REQUEST, "Acct-Status-Type", "Start", "Acct-Session-Id", "session1",
+		"User-Name", "1001", "Called-Station-Id", "1002", "Event-Timestamp", "2013-12-07T08:42:24Z"), "127.0.0.1")
+	if reply.Code != RAD_ACCOUNTING_RESPONSE {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+	ra.processAcct(radTestRequest(t, ra, RAD_ACCOUNTING_REQUEST, "Acct-Status-Type", "Interim-Update", "Acct-Session-Id", "session1",
+		"User-Name", "1001", "Called-Station-Id", "1002", "Acct-Session-Time", "60"), "127.0.0.1")
+	if connector.balance != 40*time.Second {
+		t.Error("Unexpected balance: ", connector.balance)
+	}
+	ra.processAcct(radTestRequest(t, ra, RAD_ACCOUNTING_REQUEST, "Acct-Status-Type", "Stop", "Acct-Session-Id", "session1",
+		"User-Name", "1001", "Called-Station-Id", "1002", "Acct-Session-Time", "90", "NAS-IP-Address", "10.0.0.1"), "127.0.0.1")
+	if connector.balance != 10*time.Second {
+		t.Error("Unexpected balance: ", connector.balance)
+	}
+	if len(ra.sessions) != 0 {
+		t.Error("Session not removed: ", ra.sessions)
+	}
+	if cc, err := logDb.GetCallCostLog("session1", engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID); err != nil {
+		t.Error(err)
+	} else if cc.GetDuration() != 90*time.Second {
+		t.Errorf("Unexpected cost logged: %+v", cc)
+	}
+	if len(cdrForms) != 1 {
+		t.Fatal("Unexpected CDRs posted: ", cdrForms)
+	}
+	for fld, eVal := range map[string]string{utils.ACCID: "session1", utils.REQTYPE: utils.PREPAID, utils.ACCOUNT: "1001", utils.DESTINATION: "1002",
+		utils.ANSWER_TIME: "2013-12-07 08:42:24 +0000 UTC", utils.DURATION: "90", utils.CDRSOURCE: "radius", "nas_ip": "10.0.0.1"} {
+		if val := cdrForms[0].Get(fld); val != eVal {
+			t.Errorf("Field %s, expecting: %s, received: %s", fld, eVal, val)
+		}
+	}
+	connector.balance = 0
+	reply = ra.processAuth(radTestRequest(t, ra, RAD_ACCESS_REQUEST, "User-Name", "1001", "Called-Station-Id", "1002"), "127.0.0.1")
+	if reply.Code != RAD_ACCESS_REJECT || ra.dict.PacketValue(reply, "Reply-Message") != RAD_INSUFFICIENT_FUNDS {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+}
+
+func TestRadAgentDisconnect(t *testing.T) {
+	nas, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nas.Close()
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	cgrCfg.RADisconnectPort = nas.LocalAddr().(*net.UDPAddr).Port
+	connector := &testConnector{balance: 30 * time.Second}
+	logDb, _ := engine.NewMapStorage()
+	ra, err := NewRadiusAgent(cgrCfg, connector, nil, logDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra.processAcct(radTestRequest(t, ra, RAD_ACCOUNTING_REQUEST, "Acct-Status-Type", "Start", "Acct-Session-Id", "session1",
+		"User-Name", "1001", "Called-Station-Id", "1002", "NAS-IP-Address", "127.0.0.1"), "10.0.0.1")
+	ra.processAcct(radTestRequest(t, ra, RAD_ACCOUNTING_REQUEST, "Acct-Status-Type", "Interim-Update", "Acct-Session-Id", "session1",
+		"User-Name", "1001", "Called-Station-Id", "1002", "Acct-Session-Time", "20"), "10.0.0.1")
+	if connector.balance != 10*time.Second {
+		t.Error("Unexpected balance: ", connector.balance)
+	}
+	ra.processAcct(radTestRequest(t, ra, RAD_ACCOUNTING_REQUEST, "Acct-Status-Type", "Interim-Update", "Acct-Session-Id", "session1",
+		"User-Name", "1001", "Called-Station-Id", "1002", "Acct-Session-Time", "40"), "10.0.0.1")
+	if connector.balance != 0 || ra.sessions["session1"].debited != 30*time.Second {
+		t.Error("Unexpected balance: ", connector.balance)
+	}
+	nas.SetDeadline(time.Now().Add(RAD_DISCONNECT_TIMEOUT))
+	buf := make([]byte, RAD_MAX_PACKET_LEN)
+	n, addr, err := nas.ReadFrom(buf)
+	if err != nil {
+		t.Fatal("No Disconnect-Request received: ", err)
+	}
+	req, err := ParseRadiusPacket(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Code != RAD_DISCONNECT_REQUEST || ra.dict.PacketValue(req, "Acct-Session-Id") != "session1" ||
+		ra.dict.PacketValue(req, "User-Name") != "1001" || ra.dict.PacketValue(req, "Reply-Message") != RAD_INSUFFICIENT_FUNDS {
+		t.Errorf("Unexpected request: %+v", req)
+	}
+	signed := &RadiusPacket{Code: req.Code, Identifier: req.Identifier, Attributes: req.Attributes}
+	if signed.SignAccountingRequest(cgrCfg.RASecret); signed.Authenticator != req.Authenticator {
+		t.Error("Unexpected authenticator: ", req.Authenticator)
+	}
+	replyData, err := req.Reply(RAD_DISCONNECT_ACK).MarshalReply(cgrCfg.RASecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nas.WriteTo(replyData, addr)
+	// Postpaid debits go beyond the balance
+	if notDebited, err := ra.debitSession(ra.sessions["session1"], 50*time.Second, false); err != nil || notDebited != 0 {
+		t.Error("Unexpected debit: ", notDebited, err)
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RAD_TYPE_STRING  = "string"
+	RAD_TYPE_OCTETS  = "octets"
+	RAD_TYPE_IPADDR  = "ipaddr"
+	RAD_TYPE_INTEGER = "integer"
+	RAD_TYPE_DATE    = "date"
+)
+
+// Attributes out of RFC 2865, RFC 2866 and RFC 5176 used in authorization, accounting and disconnects, always loaded
+var radBuiltinDictionary = `
+ATTRIBUTE	User-Name		1	string
+ATTRIBUTE	User-Password		2	octets
+ATTRIBUTE	NAS-IP-Address		4	ipaddr
+ATTRIBUTE	NAS-Port		5	integer
+ATTRIBUTE	Service-Type		6	integer
+ATTRIBUTE	Framed-IP-Address	8	ipaddr
+ATTRIBUTE	Reply-Message		18	string
+ATTRIBUTE	Class			25	octets
+ATTRIBUTE	Session-Timeout		27	integer
+ATTRIBUTE	Called-Station-Id	30	string
+ATTRIBUTE	Calling-Station-Id	31	string
+ATTRIBUTE	NAS-Identifier		32	string
+ATTRIBUTE	Acct-Status-Type	40	integer
+ATTRIBUTE	Acct-Delay-Time		41	integer
+ATTRIBUTE	Acct-Input-Octets	42	integer
+ATTRIBUTE	Acct-Output-Octets	43	integer
+ATTRIBUTE	Acct-Session-Id		44	string
+ATTRIBUTE	Acct-Session-Time	46	integer
+ATTRIBUTE	Acct-Input-Packets	47	integer
+ATTRIBUTE	Acct-Output-Packets	48	integer
+ATTRIBUTE	Acct-Terminate-Cause	49	integer
+ATTRIBUTE	Event-Timestamp		55	date
+ATTRIBUTE	NAS-Port-Type		61	integer
+ATTRIBUTE	Error-Cause		101	integer
+
+VALUE	Acct-Status-Type	Start			1
+VALUE	Acct-Status-Type	Stop			2
+VALUE	Acct-Status-Type	Interim-Update		3
+`
+
+type RadDictAttribute struct {
+	Name     string
+	Type     uint8
+	VendorId uint32
+	DataType string
+}
+
+// RADIUS dictionary, loaded out of FreeRADIUS format files
+type RadDictionary struct {
+	vendors     map[string]uint32
+	attrsByName map[string]*RadDictAttribute
+	attrsByType map[uint32]map[uint8]*RadDictAttribute // Indexed on vendor id and attribute type
+	values      map[string]map[uint32]string           // Value names indexed on attribute name and value
+}
+
+// Returns a dictionary with the built-in attributes loaded
+func NewRadDictionary() *RadDictionary {
+	dict := &RadDictionary{vendors: make(map[string]uint32), attrsByName: make(map[string]*RadDictAttribute),
+		attrsByType: make(map[uint32]map[uint8]*RadDictAttribute), values: make(map[string]map[uint32]string)}
+	if err := dict.Parse(strings.NewReader(radBuiltinDictionary)); err != nil {
+		panic(err) // Should never happen, the built-in dictionary is tested
+	}
+	return dict
+}
+
+// Loads all the dictionary files out of the folder
+func (dict *RadDictionary) LoadDir(dirPath string) error {
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range files {
+		if fileInfo.IsDir() {
+			continue
+		}
+		file, err := os.Open(path.Join(dirPath, fileInfo.Name()))
+		if err != nil {
+			return err
+		}
+		err = dict.Parse(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", fileInfo.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Parses VENDOR, BEGIN-VENDOR/END-VENDOR, ATTRIBUTE and VALUE definitions, ignoring the rest
+func (dict *RadDictionary) Parse(rdr io.Reader) error {
+	var crrVendor uint32
+	scanner := bufio.NewScanner(rdr)
+	for lineNr := 1; scanner.Scan(); lineNr++ {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "VENDOR":
+			if len(fields) < 3 {
+				return fmt.Errorf("Line %d: invalid VENDOR definition", lineNr)
+			}
+			vendorId, err := strconv.ParseUint(fields[2], 10, 32)
+			if err != nil {
+				return fmt.Errorf("Line %d: %v", lineNr, err)
+			}
+			dict.vendors[fields[1]] = uint32(vendorId)
+		case "BEGIN-VENDOR":
+			if len(fields) < 2 {
+				return fmt.Errorf("Line %d: invalid BEGIN-VENDOR definition", lineNr)
+			}
+			vendorId, hasIt := dict.vendors[fields[1]]
+			if !hasIt {
+				return fmt.Errorf("Line %d: unknown vendor %s", lineNr, fields[1])
+			}
+			crrVendor = vendorId
+		case "END-VENDOR":
+			crrVendor = 0
+		case "ATTRIBUTE":
+			if len(fields) < 4 {
+				return fmt.Errorf("Line %d: invalid ATTRIBUTE definition", lineNr)
+			}
+			attrType, err := strconv.ParseUint(fields[2], 10, 8)
+			if err != nil {
+				return fmt.Errorf("Line %d: %v", lineNr, err)
+			}
+			attr := &RadDictAttribute{Name: fields[1], Type: uint8(attrType), VendorId: crrVendor, DataType: fields[3]}
+			if len(fields) > 4 { // Old format, vendor name following the data type
+				if vendorId, hasIt := dict.vendors[fields[4]]; hasIt {
+					attr.VendorId = vendorId
+				}
+			}
+			dict.attrsByName[attr.Name] = attr
+			if _, hasIt := dict.attrsByType[attr.VendorId]; !hasIt {
+				dict.attrsByType[attr.VendorId] = make(map[uint8]*RadDictAttribute)
+			}
+			dict.attrsByType[attr.VendorId][attr.Type] = attr
+		case "VALUE":
+			if len(fields) < 4 {
+				return fmt.Errorf("Line %d: invalid VALUE definition", lineNr)
+			}
+			val, err := strconv.ParseUint(fields[3], 0, 32)
+			if err != nil {
+				return fmt.Errorf("Line %d: %v", lineNr, err)
+			}
+			if _, hasIt := dict.values[fields[1]]; !hasIt {
+				dict.values[fields[1]] = make(map[uint32]string)
+			}
+			dict.values[fields[1]][uint32(val)] = fields[2]
+		}
+	}
+	return scanner.Err()
+}
+
+func (dict *RadDictionary) AttributeByName(name string) *RadDictAttribute {
+	return dict.attrsByName[name]
+}
+
+func (dict *RadDictionary) AttributeByType(vendorId uint32, attrType uint8) *RadDictAttribute {
+	return dict.attrsByType[vendorId][attrType]
+}
+
+// Returns the string representation of the attribute value, based on its data type. Unknown attributes are considered strings.
+func (dict *RadDictionary) ValueAsString(attr *RadAttribute) string {
+	dictAttr := dict.AttributeByType(attr.VendorId, attr.Type)
+	if dictAttr == nil {
+		return string(attr.Value)
+	}
+	switch dictAttr.DataType {
+	case RAD_TYPE_INTEGER:
+		if len(attr.Value) != 4 {
+			break
+		}
+		val := binary.BigEndian.Uint32(attr.Value)
+		if valName, hasIt := dict.values[dictAttr.Name][val]; hasIt {
+			return valName
+		}
+		return strconv.FormatUint(uint64(val), 10)
+	case RAD_TYPE_DATE:
+		if len(attr.Value) != 4 {
+			break
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(attr.Value)), 0).UTC().Format(time.RFC3339)
+	case RAD_TYPE_IPADDR:
+		if len(attr.Value) != 4 {
+			break
+		}
+		return net.IP(attr.Value).String()
+	}
+	return string(attr.Value)
+}
+
+// Returns the value of the attribute with the name out of the packet, empty if not present
+func (dict *RadDictionary) PacketValue(pkt *RadiusPacket, name string) string {
+	dictAttr := dict.AttributeByName(name)
+	if dictAttr == nil {
+		return ""
+	}
+	if attr := pkt.Attribute(dictAttr.VendorId, dictAttr.Type); attr != nil {
+		return dict.ValueAsString(attr)
+	}
+	return ""
+}
+
+// Creates the attribute with the name, encoding the value based on its data type
+func (dict *RadDictionary) NewAttribute(name, value string) (*RadAttribute, error) {
+	dictAttr := dict.AttributeByName(name)
+	if dictAttr == nil {
+		return nil, fmt.Errorf("Unknown attribute: %s", name)
+	}
+	attr := &RadAttribute{Type: dictAttr.Type, VendorId: dictAttr.VendorId}
+	switch dictAttr.DataType {
+	case RAD_TYPE_INTEGER, RAD_TYPE_DATE:
+		var val uint32
+		if dictAttr.DataType == RAD_TYPE_DATE {
+			tm, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, err
+			}
+			val = uint32(tm.Unix())
+		} else if intVal, err := strconv.ParseUint(value, 10, 32); err == nil {
+			val = uint32(intVal)
+		} else if val, err = dict.valueByName(name, value); err != nil {
+			return nil, err
+		}
+		attr.Value = make([]byte, 4)
+		binary.BigEndian.PutUint32(attr.Value, val)
+	case RAD_TYPE_IPADDR:
+		ip := net.ParseIP(value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid IPv4 address: %s", value)
+		}
+		attr.Value = []byte(ip)
+	default:
+		attr.Value = []byte(value)
+	}
+	return attr, nil
+}
+
+func (dict *RadDictionary) valueByName(attrName, valName string) (uint32, error) {
+	for val, name := range dict.values[attrName] {
+		if name == valName {
+			return val, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown value %s for attribute %s", valName, attrName)
+}
@@ -0,0 +1,251 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Packet codes
+	RAD_ACCESS_REQUEST      = 1
+	RAD_ACCESS_ACCEPT       = 2
+	RAD_ACCESS_REJECT       = 3
+	RAD_ACCOUNTING_REQUEST  = 4
+	RAD_ACCOUNTING_RESPONSE = 5
+	RAD_DISCONNECT_REQUEST  = 40
+	RAD_DISCONNECT_ACK      = 41
+	RAD_DISCONNECT_NAK      = 42
+	// Attribute types used by the agent
+	RAD_ATTR_VENDOR_SPECIFIC       = 26
+	RAD_ATTR_MESSAGE_AUTHENTICATOR = 80
+	// Encoding limits
+	RAD_MAX_PACKET_LEN = 4096
+	RAD_MAX_VALUE_LEN  = 253 // Attribute length is one byte, including type and length
+	RAD_MAX_VSA_LEN    = 247 // Vendor specific ones carry also the vendor id, sub-attribute type and length
+	// Acct-Status-Type values
+	RAD_ACCT_START   = 1
+	RAD_ACCT_STOP    = 2
+	RAD_ACCT_INTERIM = 3
+)
+
+// RADIUS attribute, vendor specific ones having VendorId set
+type RadAttribute struct {
+	Type     uint8
+	VendorId uint32
+	Value    []byte
+}
+
+// RADIUS packet as defined in RFC 2865
+type RadiusPacket struct {
+	Code          uint8
+	Identifier    uint8
+	Authenticator [16]byte
+	Attributes    []*RadAttribute
+}
+
+func ParseRadiusPacket(data []byte) (*RadiusPacket, error) {
+	if len(data) < 20 {
+		return nil, errors.New("Packet too short")
+	}
+	pktLen := int(binary.BigEndian.Uint16(data[2:4]))
+	if pktLen < 20 || pktLen > len(data) {
+		return nil, fmt.Errorf("Invalid packet length: %d", pktLen)
+	}
+	pkt := &RadiusPacket{Code: data[0], Identifier: data[1]}
+	copy(pkt.Authenticator[:], data[4:20])
+	for attrs := data[20:pktLen]; len(attrs) != 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, errors.New("Malformed attribute")
+		}
+		attrType, value := attrs[0], attrs[2:attrs[1]]
+		attrs = attrs[attrs[1]:]
+		if attrType != RAD_ATTR_VENDOR_SPECIFIC {
+			pkt.Attributes = append(pkt.Attributes, &RadAttribute{Type: attrType, Value: value})
+			continue
+		}
+		if len(value) < 4 {
+			return nil, errors.New("Malformed vendor specific attribute")
+		}
+		vendorId := binary.BigEndian.Uint32(value[0:4])
+		for vsas := value[4:]; len(vsas) != 0; { // Multiple sub-attributes can be packed in one VSA
+			if len(vsas) < 2 || vsas[1] < 2 || int(vsas[1]) > len(vsas) {
+				return nil, errors.New("Malformed vendor specific attribute")
+			}
+			pkt.Attributes = append(pkt.Attributes, &RadAttribute{Type: vsas[0], VendorId: vendorId, Value: vsas[2:vsas[1]]})
+			vsas = vsas[vsas[1]:]
+		}
+	}
+	return pkt, nil
+}
+
+func (pkt *RadiusPacket) AddAttribute(attrType uint8, value []byte) *RadiusPacket {
+	pkt.Attributes = append(pkt.Attributes, &RadAttribute{Type: attrType, Value: value})
+	return pkt
+}
+
+// Returns the first attribute with the type, 0 vendor for standard attributes
+func (pkt *RadiusPacket) Attribute(vendorId uint32, attrType uint8) *RadAttribute {
+	for _, attr := range pkt.Attributes {
+		if attr.VendorId == vendorId && attr.Type == attrType {
+			return attr
+		}
+	}
+	return nil
+}
+
+// Encodes the packet with the given authenticator, failing on values too long to fit their attribute
+func (pkt *RadiusPacket) encode(authenticator []byte) ([]byte, error) {
+	var attrsBuf bytes.Buffer
+	for _, attr := range pkt.Attributes {
+		if attr.VendorId == 0 {
+			if len(attr.Value) > RAD_MAX_VALUE_LEN {
+				return nil, fmt.Errorf("Value of attribute %d too long: %d bytes", attr.Type, len(attr.Value))
+			}
+			attrsBuf.Write([]byte{attr.Type, byte(len(attr.Value) + 2)})
+		} else {
+			if len(attr.Value) > RAD_MAX_VSA_LEN {
+				return nil, fmt.Errorf("Value of vendor %d attribute %d too long: %d bytes", attr.VendorId, attr.Type, len(attr.Value))
+			}
+			attrsBuf.Write([]byte{RAD_ATTR_VENDOR_SPECIFIC, byte(len(attr.Value) + 8)})
+			binary.Write(&attrsBuf, binary.BigEndian, attr.VendorId)
+			attrsBuf.Write([]byte{attr.Type, byte(len(attr.Value) + 2)})
+		}
+		attrsBuf.Write(attr.Value)
+	}
+	if 20+attrsBuf.Len() > RAD_MAX_PACKET_LEN {
+		return nil, fmt.Errorf("Packet too long: %d bytes", 20+attrsBuf.Len())
+	}
+	var buf bytes.Buffer
+	buf.Write([]byte{pkt.Code, pkt.Identifier})
+	binary.Write(&buf, binary.BigEndian, uint16(20+attrsBuf.Len()))
+	buf.Write(authenticator)
+	buf.Write(attrsBuf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// Encodes the packet as it is, used for requests
+func (pkt *RadiusPacket) Marshal() ([]byte, error) {
+	return pkt.encode(pkt.Authenticator[:])
+}
+
+// Computes the MD5 authenticator over the packet encoded with the given authenticator followed by the secret
+func (pkt *RadiusPacket) computeAuthenticator(authenticator []byte, secret string) (auth [16]byte, err error) {
+	encoded, err := pkt.encode(authenticator)
+	if err != nil {
+		return auth, err
+	}
+	hash := md5.New()
+	hash.Write(encoded)
+	hash.Write([]byte(secret))
+	copy(auth[:], hash.Sum(nil))
+	return auth, nil
+}
+
+// Computes the Message-Authenticator (RFC 3579), HMAC-MD5 keyed with the secret over the packet encoded with the
+// given authenticator and the Message-Authenticator attribute zeroed. The attribute is added if the packet has none.
+func (pkt *RadiusPacket) computeMessageAuthenticator(authenticator []byte, secret string) ([]byte, error) {
+	msgAuth := pkt.Attribute(0, RAD_ATTR_MESSAGE_AUTHENTICATOR)
+	if msgAuth == nil {
+		msgAuth = &RadAttribute{Type: RAD_ATTR_MESSAGE_AUTHENTICATOR}
+		pkt.Attributes = append(pkt.Attributes, msgAuth)
+	}
+	received := msgAuth.Value
+	msgAuth.Value = make([]byte, md5.Size)
+	encoded, err := pkt.encode(authenticator)
+	msgAuth.Value = received
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(encoded)
+	return mac.Sum(nil), nil
+}
+
+// Sets the Message-Authenticator of an Access-Request, as the clients do
+func (pkt *RadiusPacket) SignAccessRequest(secret string) error {
+	msgAuth, err := pkt.computeMessageAuthenticator(pkt.Authenticator[:], secret)
+	if err != nil {
+		return err
+	}
+	pkt.Attribute(0, RAD_ATTR_MESSAGE_AUTHENTICATOR).Value = msgAuth
+	return nil
+}
+
+// Sets the Accounting-Request authenticator, as the clients do. Disconnect-Requests are signed the same way.
+func (pkt *RadiusPacket) SignAccountingRequest(secret string) error {
+	var err error
+	pkt.Authenticator, err = pkt.computeAuthenticator(make([]byte, 16), secret)
+	return err
+}
+
+// Checks the request against the shared secret. Access-Request authenticator being random, these need a valid Message-Authenticator.
+func (pkt *RadiusPacket) IsAuthentic(secret string) bool {
+	msgAuth := pkt.Attribute(0, RAD_ATTR_MESSAGE_AUTHENTICATOR)
+	if msgAuth != nil {
+		authenticator := pkt.Authenticator[:]
+		if pkt.Code == RAD_ACCOUNTING_REQUEST {
+			authenticator = make([]byte, 16)
+		}
+		if expected, err := pkt.computeMessageAuthenticator(authenticator, secret); err != nil || !hmac.Equal(expected, msgAuth.Value) {
+			return false
+		}
+	}
+	switch pkt.Code {
+	case RAD_ACCESS_REQUEST:
+		return msgAuth != nil
+	case RAD_ACCOUNTING_REQUEST:
+		auth, err := pkt.computeAuthenticator(make([]byte, 16), secret)
+		return err == nil && auth == pkt.Authenticator
+	}
+	return false
+}
+
+// Checks the reply against the request it answers and the shared secret
+func (pkt *RadiusPacket) IsAuthenticReply(req *RadiusPacket, secret string) bool {
+	auth, err := pkt.computeAuthenticator(req.Authenticator[:], secret)
+	return err == nil && pkt.Identifier == req.Identifier && auth == pkt.Authenticator
+}
+
+// Creates the reply to a request, to be encoded with MarshalReply
+func (pkt *RadiusPacket) Reply(code uint8) *RadiusPacket {
+	return &RadiusPacket{Code: code, Identifier: pkt.Identifier, Authenticator: pkt.Authenticator}
+}
+
+// Encodes a reply, authenticator being computed out of the request one and the shared secret.
+// Replies to Access-Requests carry their own Message-Authenticator.
+func (pkt *RadiusPacket) MarshalReply(secret string) ([]byte, error) {
+	if pkt.Code == RAD_ACCESS_ACCEPT || pkt.Code == RAD_ACCESS_REJECT {
+		msgAuth, err := pkt.computeMessageAuthenticator(pkt.Authenticator[:], secret)
+		if err != nil {
+			return nil, err
+		}
+		pkt.Attribute(0, RAD_ATTR_MESSAGE_AUTHENTICATOR).Value = msgAuth
+	}
+	respAuth, err := pkt.computeAuthenticator(pkt.Authenticator[:], secret)
+	if err != nil {
+		return nil, err
+	}
+	return pkt.encode(respAuth[:])
+}
@@ -0,0 +1,162 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package agents
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var radTestDictionary = `
+# Sample vendor dictionary
+VENDOR		Cisco		9
+
+BEGIN-VENDOR	Cisco
+ATTRIBUTE	Cisco-AVPair		1	string
+ATTRIBUTE	h323-call-origin	26	string
+END-VENDOR	Cisco
+
+ATTRIBUTE	Cisco-Disconnect-Cause	195	integer	Cisco
+VALUE	Cisco-Disconnect-Cause	No-Carrier		10
+`
+
+func TestRadDictionaryParse(t *testing.T) {
+	dict := NewRadDictionary()
+	if err := dict.Parse(strings.NewReader(radTestDictionary)); err != nil {
+		t.Fatal(err)
+	}
+	if attr := dict.AttributeByName("Cisco-AVPair"); attr == nil || attr.VendorId != 9 || attr.Type != 1 || attr.DataType != RAD_TYPE_STRING {
+		t.Errorf("Unexpected attribute: %+v", attr)
+	}
+	if attr := dict.AttributeByType(9, 195); attr == nil || attr.Name != "Cisco-Disconnect-Cause" {
+		t.Errorf("Unexpected attribute: %+v", attr)
+	}
+	if attr := dict.AttributeByType(0, 44); attr == nil || attr.Name != "Acct-Session-Id" {
+		t.Errorf("Unexpected attribute: %+v", attr)
+	}
+	if err := dict.Parse(strings.NewReader("BEGIN-VENDOR Unknown\n")); err == nil {
+		t.Error("Should not accept unknown vendors")
+	}
+}
+
+func TestRadPacketMarshalParse(t *testing.T) {
+	dict := NewRadDictionary()
+	if err := dict.Parse(strings.NewReader(radTestDictionary)); err != nil {
+		t.Fatal(err)
+	}
+	pkt := &RadiusPacket{Code: RAD_ACCOUNTING_REQUEST, Identifier: 7}
+	for _, attrVal := range [][]string{[]string{"Acct-Status-Type", "Stop"}, []string{"Acct-Session-Time", "125"},
+		[]string{"Event-Timestamp", "2013-12-07T08:42:24Z"}, []string{"NAS-IP-Address", "10.0.0.1"},
+		[]string{"Cisco-AVPair", "h323-conf-id=1234"}, []string{"Cisco-Disconnect-Cause", "No-Carrier"}} {
+		attr, err := dict.NewAttribute(attrVal[0], attrVal[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkt.Attributes = append(pkt.Attributes, attr)
+	}
+	if err := pkt.SignAccountingRequest("CGRateS.org"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcvPkt, err := ParseRadiusPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pkt, rcvPkt) {
+		t.Errorf("Expecting: %+v, received: %+v", pkt, rcvPkt)
+	}
+	if !rcvPkt.IsAuthentic("CGRateS.org") || rcvPkt.IsAuthentic("wrong") {
+		t.Error("Wrong authenticator check")
+	}
+	for name, eVal := range map[string]string{"Acct-Status-Type": "Stop", "Acct-Session-Time": "125", "Event-Timestamp": "2013-12-07T08:42:24Z",
+		"NAS-IP-Address": "10.0.0.1", "Cisco-AVPair": "h323-conf-id=1234", "Cisco-Disconnect-Cause": "No-Carrier", "User-Name": ""} {
+		if val := dict.PacketValue(rcvPkt, name); val != eVal {
+			t.Errorf("Attribute %s, expecting: %s, received: %s", name, eVal, val)
+		}
+	}
+	if data, err = rcvPkt.Reply(RAD_ACCOUNTING_RESPONSE).MarshalReply("CGRateS.org"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := ParseRadiusPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Code != RAD_ACCOUNTING_RESPONSE || reply.Identifier != 7 || reply.Authenticator == pkt.Authenticator {
+		t.Errorf("Unexpected reply: %+v", reply)
+	}
+}
+
+func TestRadPacketMessageAuthenticator(t *testing.T) {
+	pkt := &RadiusPacket{Code: RAD_ACCESS_REQUEST, Identifier: 3, Authenticator: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}}
+	pkt.AddAttribute(1, []byte("dan"))
+	if pkt.IsAuthentic("CGRateS.org") {
+		t.Error("Should not accept Access-Request without Message-Authenticator")
+	}
+	if err := pkt.SignAccessRequest("CGRateS.org"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcvPkt, err := ParseRadiusPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rcvPkt.IsAuthentic("CGRateS.org") || rcvPkt.IsAuthentic("wrong") {
+		t.Error("Wrong Message-Authenticator check")
+	}
+	rcvPkt.Attribute(0, 1).Value = []byte("bob")
+	if rcvPkt.IsAuthentic("CGRateS.org") {
+		t.Error("Should not accept altered Access-Request")
+	}
+	if data, err = pkt.Reply(RAD_ACCESS_ACCEPT).MarshalReply("CGRateS.org"); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := ParseRadiusPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgAuth := reply.Attribute(0, RAD_ATTR_MESSAGE_AUTHENTICATOR); msgAuth == nil {
+		t.Error("Access-Accept without Message-Authenticator")
+	} else if expected, err := reply.computeMessageAuthenticator(pkt.Authenticator[:], "CGRateS.org"); err != nil || !reflect.DeepEqual(expected, msgAuth.Value) {
+		t.Errorf("Wrong Message-Authenticator in reply: %v", msgAuth.Value)
+	}
+}
+
+func TestRadPacketLongAttribute(t *testing.T) {
+	pkt := &RadiusPacket{Code: RAD_ACCOUNTING_REQUEST, Identifier: 1}
+	pkt.AddAttribute(1, make([]byte, RAD_MAX_VALUE_LEN))
+	if _, err := pkt.Marshal(); err != nil {
+		t.Error(err)
+	}
+	pkt.AddAttribute(1, make([]byte, RAD_MAX_VALUE_LEN+1))
+	if _, err := pkt.Marshal(); err == nil {
+		t.Error("Should not encode values over 253 bytes")
+	}
+	pkt = &RadiusPacket{Code: RAD_ACCOUNTING_REQUEST, Identifier: 1}
+	pkt.Attributes = append(pkt.Attributes, &RadAttribute{VendorId: 9, Type: 1, Value: make([]byte, RAD_MAX_VSA_LEN+1)})
+	if err := pkt.SignAccountingRequest("CGRateS.org"); err == nil {
+		t.Error("Should not encode vendor values over 247 bytes")
+	}
+}
@@ -22,11 +22,18 @@ import (
 	"errors"
 	"fmt"
 	"path"
+	"sync"
 
 	"github.com/cgrates/cgrates/cache2go"
+	"github.com/cgrates/cgrates/cdrc"
+	"github.com/cgrates/cgrates/cdrexporter"
+	"github.com/cgrates/cgrates/cdrs"
 	"github.com/cgrates/cgrates/config"
 	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/invoices"
+	"github.com/cgrates/cgrates/mediator"
 	"github.com/cgrates/cgrates/scheduler"
+	"github.com/cgrates/cgrates/sessionmanager"
 	"github.com/cgrates/cgrates/utils"
 )
 
@@ -35,12 +42,22 @@ const (
 )
 
 type ApierV1 struct {
-	StorDb    engine.LoadStorage
-	RatingDb  engine.RatingStorage
-	AccountDb engine.AccountingStorage
-	CdrDb     engine.CdrStorage
-	Sched     *scheduler.Scheduler
-	Config    *config.CGRConfig
+	StorDb         engine.LoadStorage
+	RatingDb       engine.RatingStorage
+	AccountDb      engine.AccountingStorage
+	CdrDb          engine.CdrStorage
+	Sched          *scheduler.Scheduler
+	sessionManager sessionmanager.SessionManager // Set once the SessionManager started, possibly after the API is served
+	smMux          sync.RWMutex
+	CdrStats       *engine.CdrStats
+	FraudDetector  *engine.FraudDetector
+	CdrServer      *cdrs.CDRS
+	Mediator       *mediator.Mediator
+	CdrExportJobs  []*cdrexporter.CdrExportJob
+	Cdrcs          []*cdrc.Cdrc
+	BillingCycles  []*invoices.BillingCycle
+	InvoiceStorage *invoices.InvoiceStorage
+	Config         *config.CGRConfig
 }
 
 func (self *ApierV1) GetDestination(dstId string, reply *engine.Destination) error {
@@ -547,7 +564,8 @@ func (self *ApierV1) LoadTariffPlanFromFolder(attrs AttrLoadTPFromFolder, reply
 		path.Join(attrs.FolderPath, utils.ACTIONS_CSV),
 		path.Join(attrs.FolderPath, utils.ACTION_PLANS_CSV),
 		path.Join(attrs.FolderPath, utils.ACTION_TRIGGERS_CSV),
-		path.Join(attrs.FolderPath, utils.ACCOUNT_ACTIONS_CSV))
+		path.Join(attrs.FolderPath, utils.ACCOUNT_ACTIONS_CSV),
+		path.Join(attrs.FolderPath, utils.DERIVED_CHARGING_CSV))
 	if err := loader.LoadAll(); err != nil {
 		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
 	}
@@ -589,6 +607,9 @@ func (self *ApierV1) LoadTariffPlanFromFolder(attrs AttrLoadTPFromFolder, reply
 		self.Sched.LoadActionTimings(self.AccountDb)
 		self.Sched.Restart()
 	}
+	if err := self.reloadDerivedCharging(); err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
 	*reply = "OK"
 	return nil
 }
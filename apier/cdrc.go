@@ -0,0 +1,65 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cgrates/cgrates/cdrc"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Returns the processing statistics of each running cdrc, in configuration order
+func (self *ApierV1) GetCdrcStats(ignored string, reply *[]*cdrc.CdrcStats) error {
+	if len(self.Cdrcs) == 0 {
+		return errors.New("CDRC_NOT_ENABLED")
+	}
+	stats := make([]*cdrc.CdrcStats, len(self.Cdrcs))
+	for idx, cdrClient := range self.Cdrcs {
+		stats[idx] = cdrClient.Stats()
+	}
+	*reply = stats
+	return nil
+}
+
+type AttrGetCdrcFileSummaries struct {
+	CdrcId   string // Profile of the cdrc, empty for all
+	FileName string // Name of the processed file, empty for all
+}
+
+// Returns the summaries of the files processed by the cdrcs
+func (self *ApierV1) GetCdrcFileSummaries(attrs AttrGetCdrcFileSummaries, reply *[]*cdrc.CdrcFileSummary) error {
+	if len(self.Cdrcs) == 0 {
+		return errors.New("CDRC_NOT_ENABLED")
+	}
+	summaries := make([]*cdrc.CdrcFileSummary, 0)
+	for _, cdrClient := range self.Cdrcs {
+		if len(attrs.CdrcId) != 0 && cdrClient.Id() != attrs.CdrcId {
+			continue
+		}
+		cdrcSummaries, err := cdrClient.FileSummaries(attrs.FileName)
+		if err != nil {
+			return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+		}
+		summaries = append(summaries, cdrcSummaries...)
+	}
+	*reply = summaries
+	return nil
+}
@@ -19,10 +19,12 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package apier
 
 import (
+	"errors"
 	"fmt"
 	"github.com/cgrates/cgrates/cdrexporter"
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
 	"github.com/cgrates/cgrates/utils"
-	"os"
 	"path"
 	"strings"
 	"time"
@@ -32,7 +34,13 @@ func (self *ApierV1) ExportCdrsToFile(attr utils.AttrExpFileCdrs, reply *utils.E
 	var tStart, tEnd time.Time
 	var err error
 	cdrFormat := strings.ToLower(attr.CdrFormat)
-	if !utils.IsSliceMember(utils.CdreCdrFormats, cdrFormat) {
+	var tpl *config.CdreTemplateConfig
+	if len(attr.ExportTemplate) != 0 {
+		if tpl = self.Config.CdreTemplates[attr.ExportTemplate]; tpl == nil {
+			return fmt.Errorf("%s:%s", utils.ERR_NOT_FOUND, "ExportTemplate")
+		}
+		cdrFormat = tpl.CdrFormat
+	} else if !utils.IsSliceMember(utils.CdreCdrFormats, cdrFormat) {
 		return fmt.Errorf("%s:%s", utils.ERR_MANDATORY_IE_MISSING, "CdrFormat")
 	}
 	if len(attr.TimeStart) != 0 {
@@ -50,22 +58,11 @@ func (self *ApierV1) ExportCdrsToFile(attr utils.AttrExpFileCdrs, reply *utils.E
 		return err
 	}
 	var fileName string
-	if cdrFormat == utils.CDRE_CSV && len(cdrs) != 0 {
-		fileName = path.Join(self.Config.CdreDir, fmt.Sprintf("cdrs_%d.csv", time.Now().Unix()))
-		fileOut, err := os.Create(fileName)
-		if err != nil {
+	if cdrFormat != utils.CDRE_DRYRUN && len(cdrs) != 0 {
+		fileName = path.Join(self.Config.CdreDir, fmt.Sprintf("cdrs_%d.%s", time.Now().Unix(), cdrexporter.FileExtension(cdrFormat)))
+		if err := cdrexporter.ExportCdrsToFile(fileName, cdrs, tpl, self.Config.RoundingDecimals, self.Config.CdreExtraFields); err != nil {
 			return err
-		} else {
-			defer fileOut.Close()
-		}
-		csvWriter := cdrexporter.NewCsvCdrWriter(fileOut, self.Config.RoundingDecimals, self.Config.CdreExtraFields)
-		for _, cdr := range cdrs {
-			if err := csvWriter.Write(cdr); err != nil {
-				os.Remove(fileName)
-				return err
-			}
 		}
-		csvWriter.Close()
 		if attr.RemoveFromDb {
 			cgrIds := make([]string, len(cdrs))
 			for idx, cdr := range cdrs {
@@ -79,3 +76,86 @@ func (self *ApierV1) ExportCdrsToFile(attr utils.AttrExpFileCdrs, reply *utils.E
 	*reply = utils.ExportedFileCdrs{fileName, len(cdrs)}
 	return nil
 }
+
+// Queries the stored CDRs, one for each mediation run, or only their number in count mode
+func (self *ApierV1) GetCdrs(attrs utils.AttrGetCdrs, reply *utils.GetCdrsReply) error {
+	fltr, err := attrs.AsCdrsFilter()
+	if err != nil {
+		return err
+	}
+	cdrs, count, err := self.CdrDb.GetCdrs(fltr)
+	if err != nil {
+		return err
+	}
+	*reply = utils.GetCdrsReply{Count: count, Cdrs: cdrs}
+	return nil
+}
+
+type AttrRerateCdrs struct {
+	TPid             string            // Tariff plan in storDb the CDRs are re-rated with
+	CdrsFilter       utils.AttrGetCdrs // Selects the rated CDRs to re-rate, ordering and pagination are ignored
+	RunId            string            // Run id to store the new costs under, empty to overwrite the original costs
+	ApplyAdjustments bool              // Debit or topup the accounts with the cost differences of their prepaid CDRs
+}
+
+// Re-rates stored CDRs with the rating data of a tariff plan out of storDb and reports the cost differences per account
+func (self *ApierV1) RerateCdrs(attrs AttrRerateCdrs, reply *engine.RerateReport) error {
+	if len(attrs.TPid) == 0 {
+		return fmt.Errorf("%s:%s", utils.ERR_MANDATORY_IE_MISSING, "TPid")
+	}
+	fltr, err := attrs.CdrsFilter.AsCdrsFilter()
+	if err != nil {
+		return err
+	}
+	fltr.Count, fltr.Limit, fltr.Offset = false, 0, 0
+	cdrs, _, err := self.CdrDb.GetCdrs(fltr)
+	if err != nil {
+		return err
+	}
+	rd, err := engine.LoadTPRatingData(self.StorDb, attrs.TPid)
+	if err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	rpt, err := engine.RerateCdrs(self.CdrDb, rd, cdrs, attrs.RunId)
+	if err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	if attrs.ApplyAdjustments {
+		if err := rpt.ApplyAdjustments(); err != nil { // CDRs are already re-rated, report which accounts were adjusted
+			engine.Logger.Err(fmt.Sprintf("<Rerate> %s", err.Error()))
+			rpt.AdjustErr = err.Error()
+		}
+	}
+	*reply = *rpt
+	return nil
+}
+
+// Returns the result of the last run of each export job
+func (self *ApierV1) GetCdrExportJobs(ignored string, reply *[]*cdrexporter.CdrExportJobStatus) error {
+	statuses := make([]*cdrexporter.CdrExportJobStatus, len(self.CdrExportJobs))
+	for idx, job := range self.CdrExportJobs {
+		statuses[idx] = job.Status()
+	}
+	*reply = statuses
+	return nil
+}
+
+type AttrRunCdrExportJob struct {
+	Id string // Export job to run now, outside of its schedule
+}
+
+func (self *ApierV1) RunCdrExportJob(attrs AttrRunCdrExportJob, reply *cdrexporter.CdrExportJobStatus) error {
+	for _, job := range self.CdrExportJobs {
+		if job.Id() != attrs.Id {
+			continue
+		}
+		if err := job.TryRun(time.Now()); err == cdrexporter.ErrJobRunning {
+			return fmt.Errorf("%s:%s", utils.ERR_JOB_RUNNING, attrs.Id)
+		} else if err != nil {
+			return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+		}
+		*reply = *job.Status()
+		return nil
+	}
+	return errors.New(utils.ERR_NOT_FOUND)
+}
@@ -0,0 +1,34 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"errors"
+
+	"github.com/cgrates/cgrates/cdrs"
+)
+
+// Returns the status of the CDR replication targets configured on the local CDRS
+func (self *ApierV1) GetCdrReplicationStatus(ignored string, reply *[]*cdrs.CdrReplicationStatus) error {
+	if self.CdrServer == nil {
+		return errors.New("CDRS_NOT_ENABLED")
+	}
+	*reply = self.CdrServer.GetReplicationStatus()
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Lists the ids of the configured stats queues
+func (self *ApierV1) GetCdrStatsQueueIds(ignored string, reply *[]string) error {
+	if self.CdrStats == nil {
+		return errors.New("CDRSTATS_NOT_ENABLED")
+	}
+	*reply = self.CdrStats.GetQueueIds()
+	return nil
+}
+
+type AttrGetCdrStatsMetrics struct {
+	QueueId string // Id of the stats queue to query
+}
+
+// Returns the metrics of one stats queue, indexed on metric name (ASR, ACD, ACC, TCC, TCD)
+func (self *ApierV1) GetCdrStatsMetrics(attrs AttrGetCdrStatsMetrics, reply *map[string]float64) error {
+	if len(attrs.QueueId) == 0 {
+		return fmt.Errorf("%s:%s", utils.ERR_MANDATORY_IE_MISSING, "QueueId")
+	}
+	if self.CdrStats == nil {
+		return errors.New("CDRSTATS_NOT_ENABLED")
+	}
+	metrics, err := self.CdrStats.GetMetrics(attrs.QueueId)
+	if err != nil {
+		return err
+	}
+	*reply = metrics
+	return nil
+}
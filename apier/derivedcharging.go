@@ -0,0 +1,86 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"fmt"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Returns the derived charging rules stored in the accounting db
+func (self *ApierV1) GetDerivedChargingRules(ignored string, reply *[]*utils.DerivedChargingRule) error {
+	dcrs, err := self.AccountDb.GetDerivedChargingRules()
+	if err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	*reply = dcrs
+	return nil
+}
+
+// Adds or replaces a derived charging rule, applied by the local mediator on the CDRs mediated from now on
+func (self *ApierV1) SetDerivedChargingRule(attrs utils.DerivedChargingRule, reply *string) error {
+	if missing := utils.MissingStructFields(&attrs, []string{"Id", "RunId"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	if err := attrs.Compile(); err != nil {
+		return err
+	}
+	if err := self.AccountDb.SetDerivedChargingRule(&attrs); err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	if err := self.reloadDerivedCharging(); err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	*reply = OK
+	return nil
+}
+
+type AttrRemDerivedChargingRule struct {
+	Id string // Identifier of the rule to remove
+}
+
+func (self *ApierV1) RemDerivedChargingRule(attrs AttrRemDerivedChargingRule, reply *string) error {
+	if len(attrs.Id) == 0 {
+		return fmt.Errorf("%s:Id", utils.ERR_MANDATORY_IE_MISSING)
+	}
+	if err := self.AccountDb.RemDerivedChargingRule(attrs.Id); err != nil {
+		if err.Error() == utils.ERR_NOT_FOUND {
+			return err
+		}
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	if err := self.reloadDerivedCharging(); err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	*reply = OK
+	return nil
+}
+
+// Passes the stored rules to the local mediator, if one is running
+func (self *ApierV1) reloadDerivedCharging() error {
+	if self.Mediator == nil {
+		return nil
+	}
+	dcrs, err := self.AccountDb.GetDerivedChargingRules()
+	if err != nil {
+		return err
+	}
+	return self.Mediator.SetDerivedChargingRules(dcrs)
+}
@@ -0,0 +1,75 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+type AttrGetFraudIncidents struct {
+	Tenant    string // If provided, will filter the incidents on tenant
+	Account   string // If provided, will filter the incidents on account
+	RuleId    string // If provided, will filter the incidents on the rule matched
+	TimeStart string // If provided, will return only the incidents recorded starting with this time
+	TimeEnd   string // If provided, will return only the incidents recorded before this time
+}
+
+// Lists the incidents recorded by the fraud detection
+func (self *ApierV1) GetFraudIncidents(attrs AttrGetFraudIncidents, reply *[]*engine.FraudIncident) error {
+	if self.FraudDetector == nil {
+		return errors.New("FRAUD_DETECTION_NOT_ENABLED")
+	}
+	var tStart, tEnd time.Time
+	var err error
+	if len(attrs.TimeStart) != 0 {
+		if tStart, err = utils.ParseTimeDetectLayout(attrs.TimeStart); err != nil {
+			return err
+		}
+	}
+	if len(attrs.TimeEnd) != 0 {
+		if tEnd, err = utils.ParseTimeDetectLayout(attrs.TimeEnd); err != nil {
+			return err
+		}
+	}
+	incidents := make([]*engine.FraudIncident, 0)
+	for _, incident := range self.FraudDetector.GetIncidents() {
+		if len(attrs.Tenant) != 0 && incident.Tenant != attrs.Tenant {
+			continue
+		}
+		if len(attrs.Account) != 0 && incident.Account != attrs.Account {
+			continue
+		}
+		if len(attrs.RuleId) != 0 && incident.RuleId != attrs.RuleId {
+			continue
+		}
+		if !tStart.IsZero() && incident.Time.Before(tStart) {
+			continue
+		}
+		if !tEnd.IsZero() && !incident.Time.Before(tEnd) {
+			continue
+		}
+		incidents = append(incidents, incident)
+	}
+	*reply = incidents
+	return nil
+}
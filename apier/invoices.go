@@ -0,0 +1,145 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package apier
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cgrates/cgrates/invoices"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Returns the result of the last run of each billing cycle
+func (self *ApierV1) GetBillingCycles(ignored string, reply *[]*invoices.BillingCycleStatus) error {
+	if self.InvoiceStorage == nil {
+		return errors.New("INVOICES_NOT_ENABLED")
+	}
+	statuses := make([]*invoices.BillingCycleStatus, len(self.BillingCycles))
+	for idx, bc := range self.BillingCycles {
+		statuses[idx] = bc.Status()
+	}
+	*reply = statuses
+	return nil
+}
+
+type AttrGenerateInvoices struct {
+	CycleId  string   // Billing cycle to run now, outside of its schedule
+	Accounts []string // Accounts to invoice, empty for the ones of the billing cycle
+	RunTime  string   // Invoices the period ending before this time, empty for now
+}
+
+// Runs a billing cycle, returning the numbers of the invoices issued
+func (self *ApierV1) GenerateInvoices(attrs AttrGenerateInvoices, reply *[]string) error {
+	if self.InvoiceStorage == nil {
+		return errors.New("INVOICES_NOT_ENABLED")
+	}
+	if missing := utils.MissingStructFields(&attrs, []string{"CycleId"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	runTime := time.Now()
+	if len(attrs.RunTime) != 0 {
+		var err error
+		if runTime, err = utils.ParseTimeDetectLayout(attrs.RunTime); err != nil {
+			return fmt.Errorf("%s:%s", utils.ERR_MANDATORY_IE_MISSING, "RunTime")
+		}
+	}
+	for _, bc := range self.BillingCycles {
+		if bc.Id() != attrs.CycleId {
+			continue
+		}
+		numbers, err := bc.Run(runTime, attrs.Accounts)
+		if err != nil {
+			return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+		}
+		*reply = numbers
+		return nil
+	}
+	return fmt.Errorf("%s:%s", utils.ERR_NOT_FOUND, attrs.CycleId)
+}
+
+type AttrGetInvoices struct {
+	Tenant  string // Filters on tenant when not empty
+	Account string // Filters on account when not empty
+	CycleId string // Filters on billing cycle when not empty
+}
+
+// Lists the stored invoices, sorted on number
+func (self *ApierV1) GetInvoices(attrs AttrGetInvoices, reply *[]*invoices.InvoiceSummary) error {
+	if self.InvoiceStorage == nil {
+		return errors.New("INVOICES_NOT_ENABLED")
+	}
+	summaries, err := self.InvoiceStorage.GetInvoices(attrs.Tenant, attrs.Account, attrs.CycleId)
+	if err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	*reply = summaries
+	return nil
+}
+
+type AttrGetInvoice struct {
+	Number string
+}
+
+func (self *ApierV1) GetInvoice(attrs AttrGetInvoice, reply *invoices.Invoice) error {
+	if self.InvoiceStorage == nil {
+		return errors.New("INVOICES_NOT_ENABLED")
+	}
+	inv, err := self.InvoiceStorage.GetInvoice(attrs.Number)
+	if err != nil {
+		return err
+	}
+	*reply = *inv
+	return nil
+}
+
+// Returns the invoice as rendered by the template of its billing cycle
+func (self *ApierV1) GetRenderedInvoice(attrs AttrGetInvoice, reply *string) error {
+	if self.InvoiceStorage == nil {
+		return errors.New("INVOICES_NOT_ENABLED")
+	}
+	rendered, err := self.InvoiceStorage.GetRenderedInvoice(attrs.Number)
+	if err != nil {
+		return err
+	}
+	*reply = rendered
+	return nil
+}
+
+type AttrAddInvoiceAdjustment struct {
+	Tenant      string
+	Account     string
+	Description string
+	Amount      float64 // Negative for credits
+}
+
+// Adds a one-off charge or credit to the next invoice of the account
+func (self *ApierV1) AddInvoiceAdjustment(attrs AttrAddInvoiceAdjustment, reply *string) error {
+	if self.InvoiceStorage == nil {
+		return errors.New("INVOICES_NOT_ENABLED")
+	}
+	if missing := utils.MissingStructFields(&attrs, []string{"Tenant", "Account", "Description"}); len(missing) != 0 {
+		return fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	if err := self.InvoiceStorage.AddAdjustment(attrs.Tenant, attrs.Account, &invoices.InvoiceFee{Description: attrs.Description, Amount: attrs.Amount}); err != nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	*reply = OK
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cgrates/cgrates/sessionmanager"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Exposes the sessions of the SessionManager over the API
+func (self *ApierV1) SetSessionManager(sm sessionmanager.SessionManager) {
+	self.smMux.Lock()
+	defer self.smMux.Unlock()
+	self.sessionManager = sm
+}
+
+func (self *ApierV1) getSessionManager() sessionmanager.SessionManager {
+	self.smMux.RLock()
+	defer self.smMux.RUnlock()
+	return self.sessionManager
+}
+
+type AttrGetActiveSessions struct {
+	Tenant  string // If provided, will filter the sessions on tenant
+	Account string // If provided, will filter the sessions on account
+}
+
+// Lists the sessions currently handled by the SessionManager
+func (self *ApierV1) GetActiveSessions(attrs AttrGetActiveSessions, reply *[]*sessionmanager.ActiveSession) error {
+	sm := self.getSessionManager()
+	if sm == nil {
+		return errors.New("SESSION_MANAGER_NOT_ENABLED")
+	}
+	actvSessions := make([]*sessionmanager.ActiveSession, 0)
+	for _, s := range sm.GetSessions() {
+		actvSession := s.AsActiveSession()
+		if len(attrs.Tenant) != 0 && actvSession.Tenant != attrs.Tenant {
+			continue
+		}
+		if len(attrs.Account) != 0 && actvSession.Account != attrs.Account {
+			continue
+		}
+		actvSessions = append(actvSessions, actvSession)
+	}
+	*reply = actvSessions
+	return nil
+}
+
+type AttrDisconnectSessions struct {
+	Uuid    string // Disconnect the session with this uuid
+	Tenant  string // Used together with Account, restricts the account sessions to one tenant
+	Account string // Disconnect all the sessions of this account
+}
+
+// Forces disconnect of the session identified by uuid or of all sessions belonging to one account, returns the disconnected uuids
+func (self *ApierV1) DisconnectSessions(attrs AttrDisconnectSessions, reply *[]string) error {
+	if len(attrs.Uuid) == 0 && len(attrs.Account) == 0 {
+		return fmt.Errorf("%s:%s", utils.ERR_MANDATORY_IE_MISSING, "Uuid|Account")
+	}
+	sm := self.getSessionManager()
+	if sm == nil {
+		return errors.New("SESSION_MANAGER_NOT_ENABLED")
+	}
+	disconnected := make([]string, 0)
+	for _, s := range sm.GetSessions() {
+		actvSession := s.AsActiveSession()
+		if len(attrs.Uuid) != 0 && actvSession.Uuid != attrs.Uuid {
+			continue
+		}
+		if len(attrs.Tenant) != 0 && actvSession.Tenant != attrs.Tenant {
+			continue
+		}
+		if len(attrs.Account) != 0 && actvSession.Account != attrs.Account {
+			continue
+		}
+		sm.DisconnectSession(s, sessionmanager.ADMIN_DISCONNECT)
+		disconnected = append(disconnected, actvSession.Uuid)
+	}
+	if len(disconnected) == 0 {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	*reply = disconnected
+	return nil
+}
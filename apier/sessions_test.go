@@ -0,0 +1,113 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package apier
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/cgrates/cgrates/sessionmanager"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// FreeSWITCH event not depending on the configuration of the SessionManager
+type testSessionEvent struct {
+	sessionmanager.FSEvent
+}
+
+func (ev testSessionEvent) GetTOR() string         { return "call" }
+func (ev testSessionEvent) GetTenant() string      { return ev.FSEvent[sessionmanager.CSTMID] }
+func (ev testSessionEvent) GetReqType() string     { return utils.POSTPAID } // No debit loop started
+func (ev testSessionEvent) MissingParameter() bool { return false }
+
+// SessionManager listing fixed sessions and recording the disconnects
+type testSessionManager struct {
+	sessionmanager.SessionManager
+	sessions     []*sessionmanager.Session
+	disconnected []string
+}
+
+func (sm *testSessionManager) GetSessions() []*sessionmanager.Session { return sm.sessions }
+
+func (sm *testSessionManager) DisconnectSession(s *sessionmanager.Session, notify string) {
+	sm.disconnected = append(sm.disconnected, s.AsActiveSession().Uuid)
+}
+
+func newTestSessionManager() *testSessionManager {
+	sm := new(testSessionManager)
+	for _, ev := range []sessionmanager.FSEvent{
+		sessionmanager.FSEvent{sessionmanager.UUID: "uuid1", sessionmanager.CSTMID: "cgrates.org", sessionmanager.ACCOUNT: "1001"},
+		sessionmanager.FSEvent{sessionmanager.UUID: "uuid2", sessionmanager.CSTMID: "cgrates.org", sessionmanager.ACCOUNT: "1002"},
+		sessionmanager.FSEvent{sessionmanager.UUID: "uuid3", sessionmanager.CSTMID: "itsyscom.com", sessionmanager.ACCOUNT: "1001"},
+	} {
+		ev[sessionmanager.START_TIME] = "1386405744000000" // FreeSWITCH microseconds timestamp
+		sm.sessions = append(sm.sessions, sessionmanager.NewSession(testSessionEvent{ev}, sm))
+	}
+	return sm
+}
+
+func TestApierGetActiveSessions(t *testing.T) {
+	apierSm := new(ApierV1)
+	var reply []*sessionmanager.ActiveSession
+	if err := apierSm.GetActiveSessions(AttrGetActiveSessions{}, &reply); err == nil {
+		t.Error("Should fail without SessionManager")
+	}
+	apierSm.SetSessionManager(newTestSessionManager())
+	for _, tst := range []struct {
+		attrs  AttrGetActiveSessions
+		eUuids []string
+	}{
+		{AttrGetActiveSessions{}, []string{"uuid1", "uuid2", "uuid3"}},
+		{AttrGetActiveSessions{Tenant: "cgrates.org"}, []string{"uuid1", "uuid2"}},
+		{AttrGetActiveSessions{Account: "1001"}, []string{"uuid1", "uuid3"}},
+		{AttrGetActiveSessions{Tenant: "cgrates.org", Account: "1001"}, []string{"uuid1"}},
+		{AttrGetActiveSessions{Tenant: "itsyscom.com", Account: "1002"}, []string{}},
+	} {
+		if err := apierSm.GetActiveSessions(tst.attrs, &reply); err != nil {
+			t.Fatal(err)
+		}
+		uuids := make([]string, len(reply))
+		for idx, actvSession := range reply {
+			uuids[idx] = actvSession.Uuid
+		}
+		sort.Strings(uuids)
+		if !reflect.DeepEqual(tst.eUuids, uuids) {
+			t.Errorf("Filter: %+v, expecting: %v, received: %v", tst.attrs, tst.eUuids, uuids)
+		}
+	}
+}
+
+func TestApierDisconnectSessions(t *testing.T) {
+	apierSm := new(ApierV1)
+	sm := newTestSessionManager()
+	apierSm.SetSessionManager(sm)
+	var reply []string
+	if err := apierSm.DisconnectSessions(AttrDisconnectSessions{Tenant: "cgrates.org"}, &reply); err == nil {
+		t.Error("Should require uuid or account")
+	}
+	if err := apierSm.DisconnectSessions(AttrDisconnectSessions{Tenant: "itsyscom.com", Account: "1002"}, &reply); err == nil || err.Error() != utils.ERR_NOT_FOUND {
+		t.Error("Unexpected error: ", err)
+	}
+	if err := apierSm.DisconnectSessions(AttrDisconnectSessions{Tenant: "cgrates.org", Account: "1001"}, &reply); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual([]string{"uuid1"}, reply) || !reflect.DeepEqual([]string{"uuid1"}, sm.disconnected) {
+		t.Errorf("Unexpected disconnects: %v, %v", reply, sm.disconnected)
+	}
+}
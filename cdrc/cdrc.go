@@ -19,8 +19,8 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package cdrc
 
 import (
-	"bufio"
 	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -28,8 +28,11 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cgrates/cgrates/cdrs"
@@ -40,14 +43,81 @@ import (
 )
 
 const (
-	CSV    = "csv"
-	FS_CSV = "freeswitch_csv"
+	CSV         = "csv"
+	FS_CSV      = "freeswitch_csv"
+	FIXED_WIDTH = "fixed_width"
+	JSON_LINES  = "json_lines"
+	XML         = "xml"
 )
 
-func NewCdrc(config *config.CGRConfig, cdrServer *cdrs.CDRS) (*Cdrc, error) {
-	cdrc := &Cdrc{cgrCfg: config, cdrServer: cdrServer}
+const (
+	FAILED_FILE_SUFFIX  = ".failed"  // Appended to the name of the processed file to get the one holding its failed records
+	SUMMARY_FILE_SUFFIX = ".summary" // Appended to the name of the processed file to get the one holding its json encoded summary
+)
+
+var cdrTypes = []string{CSV, FS_CSV, FIXED_WIDTH, JSON_LINES, XML}
+
+// Reads the CDR records out of a file, returns io.EOF once there are no more records.
+// Other errors invalidate only the current record, returned along with the error when its content is known.
+// Readers which cannot recover return io.EOF on the next read.
+type recordReader interface {
+	Read() (cdrRecord, error)
+}
+
+// Implemented by the readers stopping at their first error, so the rest of the file is known to be left unread
+type failingReader interface {
+	Failure() (int, error) // Records left unread, -1 if they cannot be counted, and the error which stopped the reading, nil if none
+}
+
+// One CDR record read out of a file
+type cdrRecord interface {
+	FieldValue(fieldId string) (string, error) // Value of the field identified as in cdrc configuration
+	Raw() string                               // Record as found in the file
+}
+
+// Record which could not be decoded, only its content is known
+type invalidRecord string
+
+func (self invalidRecord) FieldValue(fieldId string) (string, error) {
+	return "", errors.New("Invalid record")
+}
+
+func (self invalidRecord) Raw() string {
+	return string(self)
+}
+
+// Processing statistics of one cdrc instance
+type CdrcStats struct {
+	Id           string    // Profile of the cdrc
+	Files        int       // Files processed
+	Records      int       // Records read out of the files
+	Imported     int       // CDRs accepted by CDRS
+	Filtered     int       // Records not imported because of the filters
+	Duplicates   int       // CDRs rejected by CDRS as duplicates
+	Failed       int       // Records which could not be read or posted
+	LastFile     string    // Name of the last file processed
+	LastFileTime time.Time // Time when the processing of the last file completed
+}
+
+// Result of processing one file
+type CdrcFileSummary struct {
+	CdrcId        string    // Profile of the cdrc processing the file
+	FileName      string    // Name of the file processed
+	ProcessedTime time.Time // Time when the processing completed
+	Total         int       // Records read out of the file
+	Imported      int       // CDRs accepted by CDRS
+	Filtered      int       // Records not imported because of the filters
+	Duplicates    int       // CDRs rejected by CDRS as duplicates
+	Failed        int       // Records which could not be read or posted
+	FailedFile    string    // Path of the file holding the failed records, empty if none failed
+	ReadError     string    // Error which stopped the reading of the file, marking it as failed
+	Unprocessed   int       // Records left unread after ReadError, -1 if they cannot be counted
+}
+
+func NewCdrc(cdrcCfg *config.CdrcConfig) (*Cdrc, error) {
+	cdrc := &Cdrc{cdrcCfg: cdrcCfg, stats: &CdrcStats{Id: cdrcCfg.Id}}
 	// Before processing, make sure in and out folders exist
-	for _, dir := range []string{cdrc.cgrCfg.CdrcCdrInDir, cdrc.cgrCfg.CdrcCdrOutDir} {
+	for _, dir := range []string{cdrc.cdrcCfg.CdrInDir, cdrc.cdrcCfg.CdrOutDir} {
 		if _, err := os.Stat(dir); err != nil && os.IsNotExist(err) {
 			return nil, fmt.Errorf("Folder %s does not exist", dir)
 		}
@@ -55,26 +125,89 @@ func NewCdrc(config *config.CGRConfig, cdrServer *cdrs.CDRS) (*Cdrc, error) {
 	if err := cdrc.parseFieldsConfig(); err != nil {
 		return nil, err
 	}
+	if err := cdrc.parseFiltersConfig(); err != nil {
+		return nil, err
+	}
 	cdrc.httpClient = new(http.Client)
 	return cdrc, nil
 }
 
 type Cdrc struct {
-	cgrCfg       *config.CGRConfig
+	cdrcCfg      *config.CdrcConfig
 	cdrServer    *cdrs.CDRS
 	cfgCdrFields map[string]string // Key is the name of the field
 	httpClient   *http.Client
+	filePattern  *regexp.Regexp // Only the files with names matching it are processed
+	skipRows     *regexp.Regexp // Records matching it are not imported
+	fieldFilters []*fieldFilter // Records are imported only if all their fields match
+	stats        *CdrcStats
+	statsMux     sync.RWMutex
+}
+
+func (self *Cdrc) Id() string {
+	return self.cdrcCfg.Id
+}
+
+// CDR server receiving the CDRs when configured as internal, to be set before Run
+func (self *Cdrc) SetCdrServer(cdrServer *cdrs.CDRS) {
+	self.cdrServer = cdrServer
+}
+
+func (self *Cdrc) Stats() *CdrcStats {
+	self.statsMux.RLock()
+	defer self.statsMux.RUnlock()
+	stats := *self.stats
+	return &stats
+}
+
+// Summaries of the processed files, oldest first, optionally only the one of fileName.
+// Read out of the summary files kept in the out folder, so they outlive restarts.
+func (self *Cdrc) FileSummaries(fileName string) ([]*CdrcFileSummary, error) {
+	var fileNames []string
+	if len(fileName) != 0 {
+		fileNames = []string{fileName + SUMMARY_FILE_SUFFIX}
+	} else {
+		fileInfos, err := ioutil.ReadDir(self.cdrcCfg.CdrOutDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fileInfo := range fileInfos {
+			if !fileInfo.IsDir() && strings.HasSuffix(fileInfo.Name(), SUMMARY_FILE_SUFFIX) {
+				fileNames = append(fileNames, fileInfo.Name())
+			}
+		}
+	}
+	summaries := make([]*CdrcFileSummary, 0)
+	for _, fn := range fileNames {
+		content, err := ioutil.ReadFile(path.Join(self.cdrcCfg.CdrOutDir, fn))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		summary := new(CdrcFileSummary)
+		if err := json.Unmarshal(content, summary); err != nil {
+			return nil, fmt.Errorf("Invalid summary file %s: %s", fn, err.Error())
+		}
+		if summary.CdrcId != self.cdrcCfg.Id { // Out folder shared with other cdrcs
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Sort(cdrcFileSummaries(summaries))
+	return summaries, nil
 }
 
 // When called fires up folder monitoring, either automated via inotify or manual by sleeping between processing
 func (self *Cdrc) Run() error {
-	if self.cgrCfg.CdrcRunDelay == time.Duration(0) { // Automated via inotify
+	if self.cdrcCfg.RunDelay == time.Duration(0) { // Automated via inotify
 		return self.trackCDRFiles()
 	}
 	// No automated, process and sleep approach
 	for {
 		self.processCdrDir()
-		time.Sleep(self.cgrCfg.CdrcRunDelay)
+		time.Sleep(self.cdrcCfg.RunDelay)
 	}
 }
 
@@ -82,21 +215,21 @@ func (self *Cdrc) Run() error {
 func (self *Cdrc) parseFieldsConfig() error {
 	var err error
 	self.cfgCdrFields = map[string]string{
-		utils.ACCID:       self.cgrCfg.CdrcAccIdField,
-		utils.REQTYPE:     self.cgrCfg.CdrcReqTypeField,
-		utils.DIRECTION:   self.cgrCfg.CdrcDirectionField,
-		utils.TENANT:      self.cgrCfg.CdrcTenantField,
-		utils.TOR:         self.cgrCfg.CdrcTorField,
-		utils.ACCOUNT:     self.cgrCfg.CdrcAccountField,
-		utils.SUBJECT:     self.cgrCfg.CdrcSubjectField,
-		utils.DESTINATION: self.cgrCfg.CdrcDestinationField,
-		utils.ANSWER_TIME: self.cgrCfg.CdrcAnswerTimeField,
-		utils.DURATION:    self.cgrCfg.CdrcDurationField,
+		utils.ACCID:       self.cdrcCfg.AccIdField,
+		utils.REQTYPE:     self.cdrcCfg.ReqTypeField,
+		utils.DIRECTION:   self.cdrcCfg.DirectionField,
+		utils.TENANT:      self.cdrcCfg.TenantField,
+		utils.TOR:         self.cdrcCfg.TorField,
+		utils.ACCOUNT:     self.cdrcCfg.AccountField,
+		utils.SUBJECT:     self.cdrcCfg.SubjectField,
+		utils.DESTINATION: self.cdrcCfg.DestinationField,
+		utils.ANSWER_TIME: self.cdrcCfg.AnswerTimeField,
+		utils.DURATION:    self.cdrcCfg.DurationField,
 	}
 
 	// Add extra fields here, config extra fields in the form of []string{"fieldName1:indxInCsv1","fieldName2: indexInCsv2"}
-	for _, fieldWithIdx := range self.cgrCfg.CdrcExtraFields {
-		splt := strings.Split(fieldWithIdx, ":")
+	for _, fieldWithIdx := range self.cdrcCfg.ExtraFields {
+		splt := strings.SplitN(fieldWithIdx, ":", 2) // Fixed width identifiers contain : on their own
 		if len(splt) != 2 {
 			return errors.New("Cannot parse cdrc.extra_fields")
 		}
@@ -106,34 +239,54 @@ func (self *Cdrc) parseFieldsConfig() error {
 		self.cfgCdrFields[splt[0]] = splt[1]
 	}
 	// Fields populated, do some sanity checks here
+	if !utils.IsSliceMember(cdrTypes, self.cdrcCfg.CdrType) {
+		return fmt.Errorf("Unsupported cdr_type %s", self.cdrcCfg.CdrType)
+	}
 	for cdrField, cfgVal := range self.cfgCdrFields {
-		if utils.IsSliceMember([]string{CSV, FS_CSV}, self.cgrCfg.CdrcCdrType) && !strings.HasPrefix(cfgVal, utils.STATIC_VALUE_PREFIX) {
+		if strings.HasPrefix(cfgVal, utils.STATIC_VALUE_PREFIX) {
+			continue
+		}
+		switch self.cdrcCfg.CdrType {
+		case CSV, FS_CSV:
 			if _, err = strconv.Atoi(cfgVal); err != nil {
 				return fmt.Errorf("Cannot parse configuration field %s into integer", cdrField)
 			}
+		case FIXED_WIDTH:
+			if _, _, err = parseFixedWidthFieldId(cfgVal); err != nil {
+				return fmt.Errorf("Cannot parse configuration field %s, err: %s", cdrField, err.Error())
+			}
+		default:
+			if len(cfgVal) == 0 {
+				return fmt.Errorf("Missing path of configuration field %s", cdrField)
+			}
 		}
 	}
 	return nil
 }
 
-// Takes the record out of csv and turns it into http form which can be posted
-func (self *Cdrc) recordAsStoredCdr(record []string) (*utils.StoredCdr, error) {
-	ratedCdr := &utils.StoredCdr{CdrSource: self.cgrCfg.CdrcSourceId, ExtraFields: map[string]string{}, Cost: -1}
+// Returns the reader of the records out of a file, depending on configured cdr_type
+func (self *Cdrc) newRecordReader(rdr io.Reader) recordReader {
+	switch self.cdrcCfg.CdrType {
+	case FIXED_WIDTH:
+		return newFixedWidthRecordReader(rdr)
+	case JSON_LINES:
+		return newJsonLinesRecordReader(rdr)
+	case XML:
+		return newXmlRecordReader(rdr, self.cdrcCfg.XmlRecordPath)
+	}
+	return newCsvRecordReader(rdr)
+}
+
+// Takes the record read out of the file and turns it into a StoredCdr which can be posted
+func (self *Cdrc) recordAsStoredCdr(record cdrRecord) (*utils.StoredCdr, error) {
+	ratedCdr := &utils.StoredCdr{CdrSource: self.cdrcCfg.CdrSourceId, ExtraFields: map[string]string{}, Cost: -1}
 	var err error
 	for cfgFieldName, cfgFieldVal := range self.cfgCdrFields {
 		var fieldVal string
 		if strings.HasPrefix(cfgFieldVal, utils.STATIC_VALUE_PREFIX) {
 			fieldVal = cfgFieldVal[1:]
-		} else if utils.IsSliceMember([]string{CSV, FS_CSV}, self.cgrCfg.CdrcCdrType) {
-			if cfgFieldIdx, err := strconv.Atoi(cfgFieldVal); err != nil { // Should in theory never happen since we have already parsed config
-				return nil, err
-			} else if len(record) <= cfgFieldIdx {
-				return nil, fmt.Errorf("Ignoring record: %v - cannot extract field %s", record, cfgFieldName)
-			} else {
-				fieldVal = record[cfgFieldIdx]
-			}
-		} else { // Modify here when we add more supported cdr formats
-			fieldVal = "UNKNOWN"
+		} else if fieldVal, err = record.FieldValue(cfgFieldVal); err != nil {
+			return nil, fmt.Errorf("Ignoring record: %v - cannot extract field %s, err: %s", record, cfgFieldName, err.Error())
 		}
 		switch cfgFieldName {
 		case utils.ACCID:
@@ -171,38 +324,59 @@ func (self *Cdrc) recordAsStoredCdr(record []string) (*utils.StoredCdr, error) {
 
 // One run over the CDR folder
 func (self *Cdrc) processCdrDir() error {
-	engine.Logger.Info(fmt.Sprintf("<Cdrc> Parsing folder %s for CDR files.", self.cgrCfg.CdrcCdrInDir))
-	filesInDir, _ := ioutil.ReadDir(self.cgrCfg.CdrcCdrInDir)
-	for _, file := range filesInDir {
-		if self.cgrCfg.CdrcCdrType != FS_CSV || path.Ext(file.Name()) != ".csv" {
-			if err := self.processFile(path.Join(self.cgrCfg.CdrcCdrInDir, file.Name())); err != nil {
-				return err
-			}
+	engine.Logger.Info(fmt.Sprintf("<Cdrc> Parsing folder %s for CDR files.", self.cdrcCfg.CdrInDir))
+	readyFiles, _ := self.readyFiles() // Pending files will be processed on next run
+	var lastErr error
+	for _, filePath := range readyFiles {
+		if err := self.processFile(filePath); err != nil { // One file failing should not block the ones after it
+			engine.Logger.Err(fmt.Sprintf("Processing file %s, error: %s", filePath, err.Error()))
+			lastErr = err
 		}
 	}
-	return nil
+	return lastErr
 }
 
-// Watch the specified folder for file moves and parse the files on events
+// Processes the files ready in the folder, scheduling a new check on retryChan if some are still written
+func (self *Cdrc) processReadyFiles(retryChan chan struct{}) {
+	readyFiles, pending := self.readyFiles()
+	for _, filePath := range readyFiles {
+		if err := self.processFile(filePath); err != nil {
+			engine.Logger.Err(fmt.Sprintf("Processing file %s, error: %s", filePath, err.Error()))
+		}
+	}
+	if pending {
+		time.AfterFunc(self.cdrcCfg.StableTime, func() {
+			select {
+			case retryChan <- struct{}{}:
+			default: // Check already scheduled
+			}
+		})
+	}
+}
+
+// Watch the specified folder for files created or moved in and parse the ready ones on events
 func (self *Cdrc) trackCDRFiles() (err error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return
 	}
 	defer watcher.Close()
-	err = watcher.Watch(self.cgrCfg.CdrcCdrInDir)
+	err = watcher.Watch(self.cdrcCfg.CdrInDir)
 	if err != nil {
 		return
 	}
-	engine.Logger.Info(fmt.Sprintf("<Cdrc> Monitoring %s for file moves.", self.cgrCfg.CdrcCdrInDir))
+	engine.Logger.Info(fmt.Sprintf("<Cdrc> Monitoring %s for file moves, profile %s.", self.cdrcCfg.CdrInDir, self.cdrcCfg.Id))
+	retryChan := make(chan struct{}, 1)
+	self.processReadyFiles(retryChan) // Files arrived while not monitoring
 	for {
 		select {
 		case ev := <-watcher.Event:
-			if ev.IsCreate() && (self.cgrCfg.CdrcCdrType != FS_CSV || path.Ext(ev.Name) != ".csv") {
-				if err = self.processFile(ev.Name); err != nil {
-					engine.Logger.Err(fmt.Sprintf("Processing file %s, error: %s", ev.Name, err.Error()))
-				}
+			// Renamed files and done markers can make other files than the one in the event ready, hence checking the whole folder
+			if ev.IsCreate() || ev.IsRename() {
+				self.processReadyFiles(retryChan)
 			}
+		case <-retryChan:
+			self.processReadyFiles(retryChan)
 		case err := <-watcher.Error:
 			engine.Logger.Err(fmt.Sprintf("Inotify error: %s", err.Error()))
 		}
@@ -219,38 +393,171 @@ func (self *Cdrc) processFile(filePath string) error {
 		engine.Logger.Crit(err.Error())
 		return err
 	}
-	csvReader := csv.NewReader(bufio.NewReader(file))
+	summary := &CdrcFileSummary{CdrcId: self.cdrcCfg.Id, FileName: fn}
+	var failedRecords [][]string // Reason and content of each failed record
+	if fileReader, err := newFileReader(file); err != nil {
+		// Moved out as any other file so it is not picked up again, the summary tells it could not be read
+		engine.Logger.Err(fmt.Sprintf("<Cdrc> Cannot decompress %s, error: %s", filePath, err.Error()))
+		summary.Total, summary.ReadError, summary.Unprocessed = 1, err.Error(), -1
+		failedRecords = append(failedRecords, failedRecord(nil, err))
+	} else {
+		failedRecords = self.processRecords(fileReader, summary)
+	}
+	if summary.Failed = len(failedRecords); summary.Failed != 0 {
+		// Failed records are kept next to the processed file so they can be corrected and imported again
+		failedPath := path.Join(self.cdrcCfg.CdrOutDir, fn+FAILED_FILE_SUFFIX)
+		if err := writeFailedRecords(failedPath, failedRecords); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<Cdrc> Cannot write failed records of %s, error: %s", fn, err.Error()))
+		} else {
+			summary.FailedFile = failedPath
+		}
+	}
+	self.addFileSummary(summary)
+	if err := writeFileSummary(path.Join(self.cdrcCfg.CdrOutDir, fn+SUMMARY_FILE_SUFFIX), summary); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Cdrc> Cannot write summary of %s, error: %s", fn, err.Error()))
+	}
+	// Finished with file, move it to processed folder
+	newPath := path.Join(self.cdrcCfg.CdrOutDir, fn)
+	if err := os.Rename(filePath, newPath); err != nil {
+		engine.Logger.Err(err.Error())
+		return err
+	}
+	if len(self.cdrcCfg.DoneSuffix) != 0 {
+		if err := os.Remove(filePath + self.cdrcCfg.DoneSuffix); err != nil && !os.IsNotExist(err) {
+			engine.Logger.Warning(fmt.Sprintf("<Cdrc> Cannot remove done marker of %s, error: %s", fn, err.Error()))
+		}
+	}
+	if len(summary.ReadError) != 0 {
+		engine.Logger.Err(fmt.Sprintf("<Cdrc> Failed reading %s, moved to %s. Records: %d, imported: %d, filtered: %d, duplicates: %d, failed: %d, unprocessed: %d, error: %s",
+			fn, newPath, summary.Total, summary.Imported, summary.Filtered, summary.Duplicates, summary.Failed, summary.Unprocessed, summary.ReadError))
+		return nil
+	}
+	engine.Logger.Info(fmt.Sprintf("Finished processing %s, moved to %s. Records: %d, imported: %d, filtered: %d, duplicates: %d, failed: %d",
+		fn, newPath, summary.Total, summary.Imported, summary.Filtered, summary.Duplicates, summary.Failed))
+	return nil
+}
+
+// Posts the valid records read out of the file content, accounting them in the summary. Returns the reason and content of the failed ones.
+func (self *Cdrc) processRecords(fileReader io.Reader, summary *CdrcFileSummary) (failedRecords [][]string) {
+	recordReader := self.newRecordReader(fileReader)
 	for {
-		record, err := csvReader.Read()
+		record, err := recordReader.Read()
 		if err != nil && err == io.EOF {
 			break // End of file
-		} else if err != nil {
-			engine.Logger.Err(fmt.Sprintf("<Cdrc> Error in csv file: %s", err.Error()))
-			continue // Other csv related errors, ignore
+		}
+		summary.Total += 1
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<Cdrc> Error in %s file: %s", self.cdrcCfg.CdrType, err.Error()))
+			failedRecords = append(failedRecords, failedRecord(record, err))
+			continue // Other record related errors, ignore
+		}
+		if self.skipRows != nil && self.skipRows.MatchString(record.Raw()) {
+			summary.Filtered += 1
+			continue
 		}
 		rawCdr, err := self.recordAsStoredCdr(record)
 		if err != nil {
-			engine.Logger.Err(fmt.Sprintf("<Cdrc> Error in csv file: %s", err.Error()))
+			engine.Logger.Err(fmt.Sprintf("<Cdrc> Error in %s file: %s", self.cdrcCfg.CdrType, err.Error()))
+			failedRecords = append(failedRecords, failedRecord(record, err))
 			continue
 		}
-		if self.cgrCfg.CdrcCdrs == utils.INTERNAL {
-			if err := self.cdrServer.ProcessRawCdr(rawCdr); err != nil {
-				engine.Logger.Err(fmt.Sprintf("<Cdrc> Failed posting CDR, error: %s", err.Error()))
-				continue
-			}
-		} else { // CDRs listening on IP
-			if _, err := self.httpClient.PostForm(fmt.Sprintf("http://%s/cgr", self.cgrCfg.HTTPListen), rawCdr.AsRawCdrHttpForm()); err != nil {
-				engine.Logger.Err(fmt.Sprintf("<Cdrc> Failed posting CDR, error: %s", err.Error()))
-				continue
+		if !self.passesFilters(rawCdr) {
+			summary.Filtered += 1
+			continue
+		}
+		if err := self.postCdr(rawCdr); err == engine.ErrDuplicateCdr {
+			engine.Logger.Warning(fmt.Sprintf("<Cdrc> Duplicate CDR, cgrid: %s", rawCdr.GetCgrId()))
+			summary.Duplicates += 1
+		} else if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<Cdrc> Failed posting CDR, error: %s", err.Error()))
+			failedRecords = append(failedRecords, failedRecord(record, err))
+		} else {
+			summary.Imported += 1
+		}
+	}
+	// Decompression errors first, the record reader sees them as its own
+	for _, rdr := range []interface{}{fileReader, recordReader} {
+		if failing, canFail := rdr.(failingReader); canFail {
+			if unprocessed, err := failing.Failure(); err != nil {
+				summary.ReadError, summary.Unprocessed = err.Error(), unprocessed
+				break
 			}
 		}
 	}
-	// Finished with file, move it to processed folder
-	newPath := path.Join(self.cgrCfg.CdrcCdrOutDir, fn)
-	if err := os.Rename(filePath, newPath); err != nil {
-		engine.Logger.Err(err.Error())
+	return failedRecords
+}
+
+// Posts the CDR to the CDR server, internally or over http
+func (self *Cdrc) postCdr(rawCdr *utils.StoredCdr) error {
+	if self.cdrcCfg.Cdrs == utils.INTERNAL {
+		return self.cdrServer.ProcessRawCdr(rawCdr)
+	}
+	resp, err := self.httpClient.PostForm(fmt.Sprintf("http://%s/cgr", self.cdrcCfg.Cdrs), rawCdr.AsRawCdrHttpForm())
+	if err != nil {
 		return err
 	}
-	engine.Logger.Info(fmt.Sprintf("Finished processing %s, moved to %s", fn, newPath))
-	return nil
+	return cdrs.ReplyError(resp)
+}
+
+func (self *Cdrc) addFileSummary(summary *CdrcFileSummary) {
+	self.statsMux.Lock()
+	defer self.statsMux.Unlock()
+	summary.ProcessedTime = time.Now()
+	self.stats.Files += 1
+	self.stats.Records += summary.Total
+	self.stats.Imported += summary.Imported
+	self.stats.Filtered += summary.Filtered
+	self.stats.Duplicates += summary.Duplicates
+	self.stats.Failed += summary.Failed
+	self.stats.LastFile = summary.FileName
+	self.stats.LastFileTime = summary.ProcessedTime
+}
+
+// Writes the summary as json, under a temporary name first so a crash does not leave incomplete summaries behind
+func writeFileSummary(filePath string, summary *CdrcFileSummary) error {
+	content, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filePath+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filePath+".tmp", filePath)
+}
+
+// Reason and content of a failed record, content left empty when it could not be read
+func failedRecord(record cdrRecord, err error) []string {
+	var raw string
+	if record != nil {
+		raw = record.Raw()
+	}
+	return []string{err.Error(), raw}
+}
+
+// Writes the failed records as csv, with the reason of the failure in the first column and the record in the second
+func writeFailedRecords(filePath string, failedRecords [][]string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	csvWriter := csv.NewWriter(file)
+	if err := csvWriter.WriteAll(failedRecords); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+type cdrcFileSummaries []*CdrcFileSummary
+
+func (summaries cdrcFileSummaries) Len() int {
+	return len(summaries)
+}
+
+func (summaries cdrcFileSummaries) Swap(i, j int) {
+	summaries[i], summaries[j] = summaries[j], summaries[i]
+}
+
+func (summaries cdrcFileSummaries) Less(i, j int) bool {
+	return summaries[i].ProcessedTime.Before(summaries[j].ProcessedTime)
 }
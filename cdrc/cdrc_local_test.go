@@ -136,7 +136,7 @@ func TestProcessCdrDir(t *testing.T) {
 	if err := startEngine(); err != nil {
 		t.Fatal(err.Error())
 	}
-	cdrc, err := NewCdrc(cfg, nil)
+	cdrc, err := NewCdrc(cfg.DefaultCdrcConfig())
 	if err != nil {
 		t.Fatal(err.Error())
 	}
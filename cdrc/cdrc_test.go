@@ -19,9 +19,17 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package cdrc
 
 import (
+	"encoding/csv"
+	"github.com/cgrates/cgrates/cdrs"
 	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
 	"github.com/cgrates/cgrates/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -31,13 +39,13 @@ func TestParseFieldsConfig(t *testing.T) {
 	cgrConfig, _ := config.NewDefaultCGRConfig()
 	// Test primary field index definition
 	cgrConfig.CdrcAccIdField = "detect_me"
-	cdrc := &Cdrc{cgrCfg: cgrConfig}
+	cdrc := &Cdrc{cdrcCfg: cgrConfig.DefaultCdrcConfig()}
 	if err := cdrc.parseFieldsConfig(); err == nil {
 		t.Error("Failed detecting error in accounting id definition", err)
 	}
 	cgrConfig.CdrcAccIdField = "^static_val"
 	cgrConfig.CdrcSubjectField = "1"
-	cdrc = &Cdrc{cgrCfg: cgrConfig}
+	cdrc = &Cdrc{cdrcCfg: cgrConfig.DefaultCdrcConfig()}
 	if err := cdrc.parseFieldsConfig(); err != nil {
 		t.Error("Failed to corectly parse primary fields %v", cdrc.cfgCdrFields)
 	}
@@ -45,12 +53,12 @@ func TestParseFieldsConfig(t *testing.T) {
 	// Test extra field index definition
 	cgrConfig.CdrcAccIdField = "0" // Put back as int
 	cgrConfig.CdrcExtraFields = []string{"supplier1", "orig_ip:11"}
-	cdrc = &Cdrc{cgrCfg: cgrConfig}
+	cdrc = &Cdrc{cdrcCfg: cgrConfig.DefaultCdrcConfig()}
 	if err := cdrc.parseFieldsConfig(); err == nil {
 		t.Error("Failed detecting error in extra fields definition", err)
 	}
 	cgrConfig.CdrcExtraFields = []string{"supplier1:^top_supplier", "orig_ip:11"}
-	cdrc = &Cdrc{cgrCfg: cgrConfig}
+	cdrc = &Cdrc{cdrcCfg: cgrConfig.DefaultCdrcConfig()}
 	if err := cdrc.parseFieldsConfig(); err != nil {
 		t.Errorf("Failed to corectly parse extra fields %v", cdrc.cfgCdrFields)
 	}
@@ -59,17 +67,17 @@ func TestParseFieldsConfig(t *testing.T) {
 func TestRecordAsStoredCdr(t *testing.T) {
 	cgrConfig, _ := config.NewDefaultCGRConfig()
 	cgrConfig.CdrcExtraFields = []string{"supplier:10"}
-	cdrc := &Cdrc{cgrCfg: cgrConfig}
+	cdrc := &Cdrc{cdrcCfg: cgrConfig.DefaultCdrcConfig()}
 	if err := cdrc.parseFieldsConfig(); err != nil {
 		t.Error("Failed parsing default fieldIndexesFromConfig", err)
 	}
 	cdrRow := []string{"firstField", "secondField"}
-	_, err := cdrc.recordAsStoredCdr(cdrRow)
+	_, err := cdrc.recordAsStoredCdr(csvRecord(cdrRow))
 	if err == nil {
 		t.Error("Failed to corectly detect missing fields from record")
 	}
 	cdrRow = []string{"acc1", "prepaid", "*out", "cgrates.org", "call", "1001", "1001", "+4986517174963", "2013-02-03 19:54:00", "62", "supplier1", "172.16.1.1"}
-	rtCdr, err := cdrc.recordAsStoredCdr(cdrRow)
+	rtCdr, err := cdrc.recordAsStoredCdr(csvRecord(cdrRow))
 	if err != nil {
 		t.Error("Failed to parse CDR in rated cdr", err)
 	}
@@ -104,3 +112,149 @@ func TestRecordAsStoredCdr(t *testing.T) {
 		}
 	*/
 }
+
+// Reads all the records out of file content, as configured on cdrc
+func readStoredCdrs(t *testing.T, cdrc *Cdrc, content string) []*utils.StoredCdr {
+	rdr := cdrc.newRecordReader(strings.NewReader(content))
+	var rtCdrs []*utils.StoredCdr
+	for {
+		record, err := rdr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rtCdr, err := cdrc.recordAsStoredCdr(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rtCdrs = append(rtCdrs, rtCdr)
+	}
+	return rtCdrs
+}
+
+func TestProcessFileReport(t *testing.T) {
+	cdrDir, err := ioutil.TempDir("", "cdrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cdrDir)
+	cgrConfig, _ := config.NewDefaultCGRConfig()
+	cgrConfig.CDRSMediator = ""
+	cgrConfig.CdrcCdrInDir, cgrConfig.CdrcCdrOutDir = cdrDir, cdrDir
+	cgrConfig.CdrcSkipRows = "^accid,"
+	cgrConfig.CdrcSkipUnanswered = true
+	cgrConfig.CdrcFieldFilters = []string{"tenant:^cgrates.org$"}
+	cdrc, err := NewCdrc(cgrConfig.DefaultCdrcConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdrDb, _ := engine.NewMapStorage()
+	cdrc.SetCdrServer(cdrs.New(cdrDb, nil, cgrConfig))
+	fileContent := `accid,reqtype,direction,tenant,tor,account,subject,destination,answer_time,duration
+acc1,prepaid,*out,cgrates.org,call,1001,1001,+4986517174963,2013-02-03 19:54:00,62
+acc2,prepaid,*out,cgrates.org,call,1002,1002,+4986517174964,2013-02-03 19:55:00,120
+acc1,prepaid,*out,cgrates.org,call,1001,1001,+4986517174963,2013-02-03 19:54:00,62
+acc3,prepaid
+acc4,prepaid,*out,cgrates.org,call,1001,1001,+4986517174963,,0
+acc5,prepaid,*out,other.org,call,1001,1001,+4986517174963,2013-02-03 19:56:00,10
+`
+	filePath := path.Join(cdrDir, "file1.csv")
+	if err := ioutil.WriteFile(filePath, []byte(fileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdrc.processFile(filePath); err != nil {
+		t.Fatal(err)
+	}
+	summaries, err := cdrc.FileSummaries("")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(summaries) != 1 {
+		t.Fatal("Unexpected summaries: ", summaries)
+	}
+	eSummary := &CdrcFileSummary{CdrcId: config.CDRC_DEFAULT_PROFILE, FileName: "file1.csv", ProcessedTime: summaries[0].ProcessedTime,
+		Total: 7, Imported: 2, Filtered: 3, Duplicates: 1, Failed: 1, FailedFile: filePath + FAILED_FILE_SUFFIX}
+	if summaries[0].ProcessedTime.IsZero() || !reflect.DeepEqual(eSummary, summaries[0]) {
+		t.Errorf("Expected: %+v, received: %+v", eSummary, summaries[0])
+	}
+	if summaries, err := cdrc.FileSummaries("file2.csv"); err != nil || len(summaries) != 0 {
+		t.Error("Unexpected summaries: ", summaries, err)
+	}
+	// Summaries are read out of the out folder, so a new cdrc sees them
+	if otherCdrc, err := NewCdrc(cgrConfig.DefaultCdrcConfig()); err != nil {
+		t.Error(err)
+	} else if fileSummaries, err := otherCdrc.FileSummaries("file1.csv"); err != nil || len(fileSummaries) != 1 || !reflect.DeepEqual(summaries[0], fileSummaries[0]) {
+		t.Error("Unexpected summaries: ", fileSummaries, err)
+	}
+	stats := cdrc.Stats()
+	if !stats.LastFileTime.Equal(summaries[0].ProcessedTime) {
+		t.Errorf("Unexpected last file time: %v", stats.LastFileTime)
+	}
+	eStats := &CdrcStats{Id: config.CDRC_DEFAULT_PROFILE, Files: 1, Records: 7, Imported: 2, Filtered: 3, Duplicates: 1, Failed: 1,
+		LastFile: "file1.csv", LastFileTime: stats.LastFileTime}
+	if !reflect.DeepEqual(eStats, stats) {
+		t.Errorf("Expected: %+v, received: %+v", eStats, stats)
+	}
+	failedFile, err := os.Open(eSummary.FailedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer failedFile.Close()
+	if failedRecords, err := csv.NewReader(failedFile).ReadAll(); err != nil {
+		t.Error(err)
+	} else if len(failedRecords) != 1 || len(failedRecords[0]) != 2 || len(failedRecords[0][0]) == 0 || failedRecords[0][1] != "acc3,prepaid" {
+		t.Error("Unexpected failed records: ", failedRecords)
+	}
+}
+
+func TestProcessCdrDirUnreadableFile(t *testing.T) {
+	inDir, err := ioutil.TempDir("", "cdrc_in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(inDir)
+	outDir, err := ioutil.TempDir("", "cdrc_out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	cgrConfig, _ := config.NewDefaultCGRConfig()
+	cgrConfig.CDRSMediator = ""
+	cgrConfig.CdrcCdrInDir, cgrConfig.CdrcCdrOutDir = inDir, outDir
+	cdrc, err := NewCdrc(cgrConfig.DefaultCdrcConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdrDb, _ := engine.NewMapStorage()
+	cdrc.SetCdrServer(cdrs.New(cdrDb, nil, cgrConfig))
+	badContent := append([]byte{0x1f, 0x8b}, []byte("not really gzip compressed\n")...) // Processed first, by name
+	if err := ioutil.WriteFile(path.Join(inDir, "file1.csv"), badContent, 0644); err != nil {
+		t.Fatal(err)
+	}
+	goodContent := "acc1,prepaid,*out,cgrates.org,call,1001,1001,+4986517174963,2013-02-03 19:54:00,62\n"
+	if err := ioutil.WriteFile(path.Join(inDir, "file2.csv"), []byte(goodContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdrc.processCdrDir(); err != nil {
+		t.Error(err)
+	}
+	if filesIn, _ := ioutil.ReadDir(inDir); len(filesIn) != 0 {
+		t.Error("Files left in the in folder: ", filesIn)
+	}
+	summaries, err := cdrc.FileSummaries("")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(summaries) != 2 {
+		t.Fatal("Unexpected summaries: ", summaries)
+	}
+	if summaries[0].FileName != "file1.csv" || len(summaries[0].ReadError) == 0 || summaries[0].Unprocessed != -1 || summaries[0].Failed != 1 ||
+		summaries[0].FailedFile != path.Join(outDir, "file1.csv"+FAILED_FILE_SUFFIX) {
+		t.Errorf("Unexpected summary of the unreadable file: %+v", summaries[0])
+	}
+	if summaries[1].FileName != "file2.csv" || len(summaries[1].ReadError) != 0 || summaries[1].Imported != 1 {
+		t.Errorf("Unexpected summary of the valid file: %+v", summaries[1])
+	}
+	if _, err := os.Stat(path.Join(outDir, "file1.csv")); err != nil {
+		t.Error("Unreadable file not moved out: ", err)
+	}
+}
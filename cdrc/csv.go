@@ -0,0 +1,68 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func newCsvRecordReader(rdr io.Reader) *csvRecordReader {
+	return &csvRecordReader{csv.NewReader(bufio.NewReader(rdr))}
+}
+
+// Reads one record out of each csv line
+type csvRecordReader struct {
+	csvReader *csv.Reader
+}
+
+func (self *csvRecordReader) Read() (cdrRecord, error) {
+	record, err := self.csvReader.Read()
+	if record == nil {
+		return nil, err
+	}
+	return csvRecord(record), err // Records with unexpected number of fields come along with the error
+}
+
+// Fields are identified by their index in the record
+type csvRecord []string
+
+func (self csvRecord) FieldValue(fieldId string) (string, error) {
+	fieldIdx, err := strconv.Atoi(fieldId)
+	if err != nil {
+		return "", err
+	}
+	if len(self) <= fieldIdx {
+		return "", fmt.Errorf("Field index %d out of record", fieldIdx)
+	}
+	return self[fieldIdx], nil
+}
+
+func (self csvRecord) Raw() string {
+	var buf bytes.Buffer
+	csvWriter := csv.NewWriter(&buf)
+	csvWriter.Write(self)
+	csvWriter.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
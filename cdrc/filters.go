@@ -0,0 +1,73 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Imports only the CDRs with the value of field matching
+type fieldFilter struct {
+	field string // Name of the CDR field, as in extra fields
+	rgxp  *regexp.Regexp
+}
+
+// Compiles the filters deciding which records to import
+func (self *Cdrc) parseFiltersConfig() (err error) {
+	if len(self.cdrcCfg.FilePattern) != 0 {
+		if self.filePattern, err = regexp.Compile(self.cdrcCfg.FilePattern); err != nil {
+			return fmt.Errorf("Cannot parse cdrc.file_pattern, err: %s", err.Error())
+		}
+	}
+	if len(self.cdrcCfg.SkipRows) != 0 {
+		if self.skipRows, err = regexp.Compile(self.cdrcCfg.SkipRows); err != nil {
+			return fmt.Errorf("Cannot parse cdrc.skip_rows, err: %s", err.Error())
+		}
+	}
+	self.fieldFilters = make([]*fieldFilter, len(self.cdrcCfg.FieldFilters))
+	for idx, fltrStr := range self.cdrcCfg.FieldFilters {
+		splt := strings.SplitN(fltrStr, ":", 2)
+		if len(splt) != 2 || len(splt[0]) == 0 {
+			return fmt.Errorf("Cannot parse cdrc.field_filters: %s", fltrStr)
+		}
+		fltr := &fieldFilter{field: splt[0]}
+		if fltr.rgxp, err = regexp.Compile(splt[1]); err != nil {
+			return fmt.Errorf("Cannot parse cdrc.field_filters: %s, err: %s", fltrStr, err.Error())
+		}
+		self.fieldFilters[idx] = fltr
+	}
+	return nil
+}
+
+// Checks the CDR against the configured filters, false if it should not be imported
+func (self *Cdrc) passesFilters(storedCdr *utils.StoredCdr) bool {
+	if self.cdrcCfg.SkipUnanswered && (storedCdr.Duration == 0 || storedCdr.AnswerTime.IsZero()) {
+		return false
+	}
+	for _, fltr := range self.fieldFilters {
+		if !fltr.rgxp.MatchString(storedCdr.FieldAsString(fltr.field)) {
+			return false
+		}
+	}
+	return true
+}
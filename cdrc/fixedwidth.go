@@ -0,0 +1,90 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Parses fixed width field identifiers in the form <start>:<length>, start counted from 0
+func parseFixedWidthFieldId(fieldId string) (start, length int, err error) {
+	splt := strings.Split(fieldId, ":")
+	if len(splt) != 2 {
+		return 0, 0, errors.New("Fixed width fields should be defined as <start>:<length>")
+	}
+	if start, err = strconv.Atoi(splt[0]); err != nil {
+		return 0, 0, err
+	}
+	if length, err = strconv.Atoi(splt[1]); err != nil {
+		return 0, 0, err
+	}
+	if start < 0 || length <= 0 {
+		return 0, 0, fmt.Errorf("Invalid fixed width field %s", fieldId)
+	}
+	return start, length, nil
+}
+
+func newFixedWidthRecordReader(rdr io.Reader) *fixedWidthRecordReader {
+	return &fixedWidthRecordReader{bufio.NewScanner(rdr)}
+}
+
+// Reads one record out of each line, ignoring empty lines
+type fixedWidthRecordReader struct {
+	scanner *bufio.Scanner
+}
+
+func (self *fixedWidthRecordReader) Read() (cdrRecord, error) {
+	for self.scanner.Scan() {
+		if line := strings.TrimRight(self.scanner.Text(), "\r"); len(line) != 0 {
+			return fixedWidthRecord(line), nil
+		}
+	}
+	if err := self.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Fields are identified by their position in the line, values are stripped of their padding spaces.
+// Lines can miss their trailing padding, fields past the end of the line are empty.
+type fixedWidthRecord string
+
+func (self fixedWidthRecord) FieldValue(fieldId string) (string, error) {
+	start, length, err := parseFixedWidthFieldId(fieldId)
+	if err != nil {
+		return "", err
+	}
+	if start >= len(self) { // Trailing padding stripped out of the line
+		return "", nil
+	}
+	end := start + length
+	if end > len(self) {
+		end = len(self)
+	}
+	return strings.TrimSpace(string(self[start:end])), nil
+}
+
+func (self fixedWidthRecord) Raw() string {
+	return string(self)
+}
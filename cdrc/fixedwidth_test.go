@@ -0,0 +1,79 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestParseFixedWidthFieldId(t *testing.T) {
+	if start, length, err := parseFixedWidthFieldId("20:12"); err != nil {
+		t.Error(err)
+	} else if start != 20 || length != 12 {
+		t.Error("Unexpected field position: ", start, length)
+	}
+	for _, fieldId := range []string{"20", "a:12", "20:0", "-1:12"} {
+		if _, _, err := parseFixedWidthFieldId(fieldId); err == nil {
+			t.Error("Failed detecting invalid field: ", fieldId)
+		}
+	}
+}
+
+func TestFixedWidthRecords(t *testing.T) {
+	cgrConfig, _ := config.NewDefaultCGRConfig()
+	cdrcCfg := cgrConfig.DefaultCdrcConfig()
+	cdrcCfg.CdrType = FIXED_WIDTH
+	cdrcCfg.AccIdField = "0:8"
+	cdrcCfg.ReqTypeField = "^rated"
+	cdrcCfg.DirectionField = "^*out"
+	cdrcCfg.TenantField = "^cgrates.org"
+	cdrcCfg.TorField = "^call"
+	cdrcCfg.AccountField = "8:6"
+	cdrcCfg.SubjectField = "8:6"
+	cdrcCfg.DestinationField = "14:16"
+	cdrcCfg.AnswerTimeField = "30:19"
+	cdrcCfg.DurationField = "49:5"
+	cdrcCfg.ExtraFields = []string{"supplier:54:10"}
+	cdrc := &Cdrc{cdrcCfg: cdrcCfg}
+	if err := cdrc.parseFieldsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cdrs := "dsafdsaf1001  +4986517174963  2013-02-03 19:54:00   62supplier1\r\n\n" +
+		"bsafdsaf1002  +4986517174964  2013-02-03 20:54:00  120\n"
+	rtCdrs := readStoredCdrs(t, cdrc, cdrs)
+	expectedCdrs := []*utils.StoredCdr{
+		&utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", CdrSource: cdrcCfg.CdrSourceId, ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1001", Subject: "1001", Destination: "+4986517174963", AnswerTime: time.Date(2013, 2, 3, 19, 54, 0, 0, time.UTC),
+			Duration: time.Duration(62) * time.Second, ExtraFields: map[string]string{"supplier": "supplier1"}, Cost: -1},
+		&utils.StoredCdr{CgrId: utils.FSCgrId("bsafdsaf"), AccId: "bsafdsaf", CdrSource: cdrcCfg.CdrSourceId, ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1002", Subject: "1002", Destination: "+4986517174964", AnswerTime: time.Date(2013, 2, 3, 20, 54, 0, 0, time.UTC),
+			Duration: time.Duration(120) * time.Second, ExtraFields: map[string]string{"supplier": ""}, Cost: -1},
+	}
+	if !reflect.DeepEqual(expectedCdrs, rtCdrs) {
+		t.Errorf("Expected: %+v, received: %+v", expectedCdrs, rtCdrs)
+	}
+	if fieldVal, err := fixedWidthRecord("dsafdsaf").FieldValue("8:6"); err != nil || fieldVal != "" {
+		t.Error("Unexpected field past the end of line: ", fieldVal, err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	bzip2Magic    = []byte("BZh")
+	bzip2Block    = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59} // First block of the stream
+	bzip2EndBlock = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90} // Empty stream
+)
+
+// Checks if the file in the in folder is one to be processed, out of its name
+func (self *Cdrc) acceptsFile(fileName string) bool {
+	if self.cdrcCfg.CdrType == FS_CSV && path.Ext(fileName) == ".csv" { // File still written by FreeSWITCH
+		return false
+	}
+	if len(self.cdrcCfg.DoneSuffix) != 0 && strings.HasSuffix(fileName, self.cdrcCfg.DoneSuffix) {
+		return false
+	}
+	if strings.HasSuffix(fileName, FAILED_FILE_SUFFIX) {
+		return false
+	}
+	return self.filePattern == nil || self.filePattern.MatchString(fileName)
+}
+
+// Lists the files in the in folder which are ready to be processed.
+// Pending is true when some of the files are still written, hence should be checked again later.
+func (self *Cdrc) readyFiles() (ready []string, pending bool) {
+	filesInDir, _ := ioutil.ReadDir(self.cdrcCfg.CdrInDir)
+	var candidates []os.FileInfo
+	for _, file := range filesInDir {
+		if file.IsDir() || !self.acceptsFile(file.Name()) {
+			continue
+		}
+		if len(self.cdrcCfg.DoneSuffix) != 0 { // Marker created once the file is complete
+			if _, err := os.Stat(path.Join(self.cdrcCfg.CdrInDir, file.Name()+self.cdrcCfg.DoneSuffix)); err != nil {
+				continue
+			}
+		}
+		candidates = append(candidates, file)
+	}
+	if self.cdrcCfg.StableTime == time.Duration(0) || len(candidates) == 0 {
+		for _, file := range candidates {
+			ready = append(ready, path.Join(self.cdrcCfg.CdrInDir, file.Name()))
+		}
+		return ready, false
+	}
+	time.Sleep(self.cdrcCfg.StableTime) // Files not changing meanwhile are complete
+	for _, file := range candidates {
+		filePath := path.Join(self.cdrcCfg.CdrInDir, file.Name())
+		if fileNow, err := os.Stat(filePath); err != nil {
+			continue // Moved away meanwhile
+		} else if fileNow.Size() != file.Size() || !fileNow.ModTime().Equal(file.ModTime()) {
+			pending = true
+			continue
+		}
+		ready = append(ready, filePath)
+	}
+	return ready, pending
+}
+
+// Returns the content of the file, decompressed if gzip or bzip2 compressed
+func newFileReader(file io.Reader) (io.Reader, error) {
+	bufRdr := bufio.NewReader(file)
+	header, _ := bufRdr.Peek(len(bzip2Magic) + 1 + len(bzip2Block)) // Shorter files will not be compressed
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		gzipRdr, err := gzip.NewReader(bufRdr)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressedReader{rdr: gzipRdr}, nil
+	case isBzip2(header):
+		return &decompressedReader{rdr: bzip2.NewReader(bufRdr)}, nil
+	}
+	return bufRdr, nil
+}
+
+func isBzip2(header []byte) bool {
+	if !bytes.HasPrefix(header, bzip2Magic) || len(header) != len(bzip2Magic)+1+len(bzip2Block) {
+		return false
+	}
+	if blockSize := header[len(bzip2Magic)]; blockSize < '1' || blockSize > '9' {
+		return false
+	}
+	blockMagic := header[len(bzip2Magic)+1:]
+	return bytes.Equal(blockMagic, bzip2Block) || bytes.Equal(blockMagic, bzip2EndBlock)
+}
+
+// Stops at the first error of the decompression, corrupted data would otherwise be read forever
+type decompressedReader struct {
+	rdr io.Reader
+	err error // Error which stopped the decompression
+}
+
+func (self *decompressedReader) Read(p []byte) (int, error) {
+	if self.err != nil {
+		return 0, io.EOF
+	}
+	n, err := self.rdr.Read(p)
+	if err != nil && err != io.EOF {
+		self.err = err
+	}
+	return n, err
+}
+
+// The records left in the corrupted data cannot be counted
+func (self *decompressedReader) Failure() (int, error) {
+	return -1, self.err
+}
@@ -0,0 +1,113 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+)
+
+func TestNewFileReader(t *testing.T) {
+	content := []byte("acc1,prepaid\n")
+	var gzipContent bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipContent)
+	gzipWriter.Write(content)
+	gzipWriter.Close()
+	bzip2Content := []byte{0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xd4, 0x0f, 0x12, 0x79, 0x00, 0x00, 0x01, 0xd9, 0x80, 0x00,
+		0x10, 0x00, 0x04, 0x20, 0x00, 0x2e, 0x20, 0x50, 0x00, 0x20, 0x00, 0x31, 0x00, 0x30, 0x20, 0x69, 0xa3, 0x21, 0x04, 0x2b, 0xd4, 0xed, 0xbd,
+		0xf1, 0x77, 0x24, 0x53, 0x85, 0x09, 0x0d, 0x40, 0xf1, 0x27, 0x90}
+	for _, fileContent := range [][]byte{content, gzipContent.Bytes(), bzip2Content, []byte("a\n")} {
+		rdr, err := newFileReader(bytes.NewReader(fileContent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eContent := content
+		if len(fileContent) < len(content) { // Too short to be compressed
+			eContent = fileContent
+		}
+		if readContent, err := ioutil.ReadAll(rdr); err != nil {
+			t.Error(err)
+		} else if !bytes.Equal(eContent, readContent) {
+			t.Errorf("Unexpected content: %q", readContent)
+		}
+	}
+	corrupted := append([]byte{}, gzipContent.Bytes()[:15]...) // Truncated
+	rdr, err := newFileReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(rdr); err == nil {
+		t.Error("Failed detecting truncated file")
+	}
+	if n, err := rdr.Read(make([]byte, 10)); n != 0 || err == nil {
+		t.Error("Reading should stop after decompression errors: ", n, err)
+	}
+	if unread, err := rdr.(failingReader).Failure(); err == nil || unread != -1 {
+		t.Error("Unexpected failure: ", unread, err)
+	}
+}
+
+func TestReadyFiles(t *testing.T) {
+	cdrDir, err := ioutil.TempDir("", "cdrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cdrDir)
+	cgrConfig, _ := config.NewDefaultCGRConfig()
+	cdrcCfg := cgrConfig.DefaultCdrcConfig()
+	cdrcCfg.CdrInDir = cdrDir
+	cdrcCfg.FilePattern = `\.csv(\.gz)?$`
+	cdrcCfg.DoneSuffix = ".done"
+	cdrc := &Cdrc{cdrcCfg: cdrcCfg}
+	if err := cdrc.parseFiltersConfig(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fileName := range []string{"file1.csv", "file1.csv.done", "file2.csv.gz", "file2.csv.gz.done", "file3.csv", "file4.txt", "file4.txt.done",
+		"file5.csv.failed", "file5.csv.failed.done"} {
+		if err := ioutil.WriteFile(path.Join(cdrDir, fileName), []byte("acc1,prepaid\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	eReady := []string{path.Join(cdrDir, "file1.csv"), path.Join(cdrDir, "file2.csv.gz")}
+	if ready, pending := cdrc.readyFiles(); pending || !reflect.DeepEqual(eReady, ready) {
+		t.Error("Unexpected ready files: ", ready, pending)
+	}
+	cdrcCfg.DoneSuffix = ""
+	cdrcCfg.StableTime = 50 * time.Millisecond
+	growing, err := os.OpenFile(path.Join(cdrDir, "file3.csv"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer growing.Close()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		growing.Write([]byte("acc2,prepaid\n"))
+	}()
+	if ready, pending := cdrc.readyFiles(); !pending || !reflect.DeepEqual(eReady, ready) {
+		t.Error("Unexpected ready files: ", ready, pending)
+	}
+}
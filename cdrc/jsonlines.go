@@ -0,0 +1,101 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const JSON_PATH_SEP = "."
+
+func newJsonLinesRecordReader(rdr io.Reader) *jsonLinesRecordReader {
+	return &jsonLinesRecordReader{bufio.NewReader(rdr)}
+}
+
+// Reads one record out of each line holding a json object, ignoring empty lines
+type jsonLinesRecordReader struct {
+	rdr *bufio.Reader
+}
+
+func (self *jsonLinesRecordReader) Read() (cdrRecord, error) {
+	for {
+		line, err := self.rdr.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) { // Last line can miss the line terminator
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber() // Keep numbers as they are written
+		record := &jsonRecord{raw: string(line)}
+		if err := decoder.Decode(&record.fields); err != nil {
+			return invalidRecord(line), fmt.Errorf("Cannot decode json line %s, err: %s", line, err.Error())
+		}
+		return record, nil
+	}
+}
+
+// Fields are identified by their path in the object: keys and array indexes separated by .
+type jsonRecord struct {
+	raw    string
+	fields map[string]interface{}
+}
+
+func (self *jsonRecord) Raw() string {
+	return self.raw
+}
+
+func (self *jsonRecord) FieldValue(fieldId string) (string, error) {
+	var value interface{} = self.fields
+	for _, elm := range strings.Split(fieldId, JSON_PATH_SEP) {
+		switch container := value.(type) {
+		case map[string]interface{}:
+			var hasKey bool
+			if value, hasKey = container[elm]; !hasKey {
+				return "", fmt.Errorf("Missing %s out of path %s", elm, fieldId)
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(elm)
+			if err != nil || idx < 0 || idx >= len(container) {
+				return "", fmt.Errorf("Invalid array index %s out of path %s", elm, fieldId)
+			}
+			value = container[idx]
+		default:
+			return "", fmt.Errorf("Path %s goes past a value", fieldId)
+		}
+	}
+	switch fieldVal := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return fieldVal, nil
+	case json.Number:
+		return fieldVal.String(), nil
+	case bool:
+		return strconv.FormatBool(fieldVal), nil
+	}
+	return "", fmt.Errorf("Path %s does not point to a value", fieldId)
+}
@@ -0,0 +1,81 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestJsonLinesRecords(t *testing.T) {
+	cgrConfig, _ := config.NewDefaultCGRConfig()
+	cdrcCfg := cgrConfig.DefaultCdrcConfig()
+	cdrcCfg.CdrType = JSON_LINES
+	cdrcCfg.AccIdField = "uuid"
+	cdrcCfg.ReqTypeField = "^rated"
+	cdrcCfg.DirectionField = "^*out"
+	cdrcCfg.TenantField = "^cgrates.org"
+	cdrcCfg.TorField = "^call"
+	cdrcCfg.AccountField = "caller.number"
+	cdrcCfg.SubjectField = "caller.number"
+	cdrcCfg.DestinationField = "callee.numbers.0"
+	cdrcCfg.AnswerTimeField = "times.answer"
+	cdrcCfg.DurationField = "billsec"
+	cdrcCfg.ExtraFields = []string{"supplier:route.supplier", "recorded:recorded"}
+	cdrc := &Cdrc{cdrcCfg: cdrcCfg}
+	if err := cdrc.parseFieldsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cdrs := `{"uuid":"dsafdsaf","caller":{"number":"1001"},"callee":{"numbers":["+4986517174963"]},"times":{"answer":"2013-02-03T19:54:00Z"},"billsec":62,"route":{"supplier":"supplier1"},"recorded":true}
+
+{"uuid":"bsafdsaf","caller":{"number":1002},"callee":{"numbers":["+4986517174964","+4986517174965"]},"times":{"answer":1359921240},"billsec":"120","route":{"supplier":null},"recorded":false}`
+	rtCdrs := readStoredCdrs(t, cdrc, cdrs)
+	expectedCdrs := []*utils.StoredCdr{
+		&utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", CdrSource: cdrcCfg.CdrSourceId, ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1001", Subject: "1001", Destination: "+4986517174963", AnswerTime: time.Date(2013, 2, 3, 19, 54, 0, 0, time.UTC),
+			Duration: time.Duration(62) * time.Second, ExtraFields: map[string]string{"supplier": "supplier1", "recorded": "true"}, Cost: -1},
+		&utils.StoredCdr{CgrId: utils.FSCgrId("bsafdsaf"), AccId: "bsafdsaf", CdrSource: cdrcCfg.CdrSourceId, ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1002", Subject: "1002", Destination: "+4986517174964", AnswerTime: time.Unix(1359921240, 0),
+			Duration: time.Duration(120) * time.Second, ExtraFields: map[string]string{"supplier": "", "recorded": "false"}, Cost: -1},
+	}
+	if !reflect.DeepEqual(expectedCdrs, rtCdrs) {
+		t.Errorf("Expected: %+v, received: %+v", expectedCdrs, rtCdrs)
+	}
+}
+
+func TestJsonRecordFieldValue(t *testing.T) {
+	record := &jsonRecord{fields: map[string]interface{}{"caller": map[string]interface{}{"number": "1001"}, "callee": []interface{}{"1002"}}}
+	for _, fieldId := range []string{"missing", "caller", "caller.number.digits", "callee.1", "callee.first"} {
+		if _, err := record.FieldValue(fieldId); err == nil {
+			t.Error("Failed detecting invalid path: ", fieldId)
+		}
+	}
+	rdr := newJsonLinesRecordReader(strings.NewReader("not json\n"))
+	if record, err := rdr.Read(); err == nil || err == io.EOF {
+		t.Error("Failed detecting invalid json line: ", err)
+	} else if record.Raw() != "not json" {
+		t.Error("Unexpected invalid record: ", record.Raw())
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	XML_PATH_SEP    = "/"
+	XML_ATTR_PREFIX = "@"
+)
+
+// Keeps the input not yet discarded so the records can be returned as found in the file
+type xmlInput struct {
+	rdr    io.Reader
+	buf    []byte
+	offset int64 // Input offset of the first byte in buf
+}
+
+func (self *xmlInput) Read(p []byte) (int, error) {
+	n, err := self.rdr.Read(p)
+	self.buf = append(self.buf, p[:n]...)
+	return n, err
+}
+
+// Input between the two offsets, which should not be discarded
+func (self *xmlInput) slice(start, end int64) string {
+	return string(self.buf[start-self.offset : end-self.offset])
+}
+
+// Drops the input before offset
+func (self *xmlInput) discard(offset int64) {
+	self.buf = self.buf[offset-self.offset:]
+	self.offset = offset
+}
+
+func newXmlRecordReader(rdr io.Reader, recordPath string) *xmlRecordReader {
+	input := &xmlInput{rdr: rdr}
+	xmlRdr := &xmlRecordReader{input: input, decoder: xml.NewDecoder(input)}
+	if len(recordPath) != 0 {
+		xmlRdr.recordPath = strings.Split(strings.Trim(recordPath, XML_PATH_SEP), XML_PATH_SEP)
+	}
+	return xmlRdr
+}
+
+// Reads one record out of each element found on recordPath, by default the children of the root element
+type xmlRecordReader struct {
+	input      *xmlInput
+	decoder    *xml.Decoder
+	recordPath []string // Element names starting with the root one
+	elmPath    []string // Path of the element currently decoded
+	err        error    // Syntax errors stop the decoding
+	unread     int      // Records left after the syntax error
+}
+
+func (self *xmlRecordReader) isRecordPath() bool {
+	if len(self.recordPath) == 0 {
+		return len(self.elmPath) == 2
+	}
+	if len(self.elmPath) != len(self.recordPath) {
+		return false
+	}
+	for idx, elm := range self.recordPath {
+		if self.elmPath[idx] != elm {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *xmlRecordReader) Read() (cdrRecord, error) {
+	if self.err != nil {
+		return nil, io.EOF
+	}
+	for {
+		start := self.decoder.InputOffset()
+		self.input.discard(start)
+		token, err := self.decoder.Token()
+		if err != nil {
+			if err != io.EOF {
+				self.fail(err)
+			}
+			return nil, err
+		}
+		switch elm := token.(type) {
+		case xml.StartElement:
+			self.elmPath = append(self.elmPath, elm.Name.Local)
+			if !self.isRecordPath() {
+				continue
+			}
+			record, err := self.readRecord(elm)
+			if err != nil {
+				self.fail(err)
+				return nil, err
+			}
+			record.raw = self.input.slice(start, self.decoder.InputOffset())
+			self.elmPath = self.elmPath[:len(self.elmPath)-1]
+			return record, nil
+		case xml.EndElement:
+			self.elmPath = self.elmPath[:len(self.elmPath)-1]
+		}
+	}
+}
+
+// Stops the decoding, counting the records left in the input after the error. Only records identified by name can be counted.
+func (self *xmlRecordReader) fail(err error) {
+	self.err, self.unread = err, -1
+	if len(self.recordPath) == 0 {
+		return
+	}
+	notDecoded := bytes.NewReader(self.input.buf[self.decoder.InputOffset()-self.input.offset:])
+	self.unread = countStartTags(io.MultiReader(notDecoded, self.input.rdr), self.recordPath[len(self.recordPath)-1])
+}
+
+func (self *xmlRecordReader) Failure() (int, error) {
+	return self.unread, self.err
+}
+
+// Counts the start tags of the elements with the given name, without decoding the input
+func countStartTags(rdr io.Reader, name string) (count int) {
+	bufRdr := bufio.NewReader(rdr)
+	for {
+		if _, err := bufRdr.ReadSlice('<'); err == bufio.ErrBufferFull { // Long text, the tag is further
+			continue
+		} else if err != nil {
+			return count
+		}
+		tag, _ := bufRdr.Peek(len(name) + 1)
+		if len(tag) == len(name)+1 && string(tag[:len(name)]) == name && strings.ContainsRune(" \t\r\n/>", rune(tag[len(name)])) {
+			count += 1
+		}
+	}
+}
+
+// Collects the values out of the record element, up to its end
+func (self *xmlRecordReader) readRecord(recordElm xml.StartElement) (*xmlRecord, error) {
+	record := &xmlRecord{fields: make(map[string]string)}
+	for _, attr := range recordElm.Attr {
+		record.fields[XML_ATTR_PREFIX+attr.Name.Local] = attr.Value
+	}
+	var path []string                           // Path of the current element relative to the record
+	texts := []*bytes.Buffer{new(bytes.Buffer)} // Text of each element on path, record one first
+	for {
+		token, err := self.decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch elm := token.(type) {
+		case xml.StartElement:
+			path = append(path, elm.Name.Local)
+			elmPath := strings.Join(path, XML_PATH_SEP)
+			for _, attr := range elm.Attr {
+				if attrPath := elmPath + XML_PATH_SEP + XML_ATTR_PREFIX + attr.Name.Local; !record.hasField(attrPath) {
+					record.fields[attrPath] = attr.Value
+				}
+			}
+			texts = append(texts, new(bytes.Buffer))
+		case xml.CharData:
+			texts[len(texts)-1].Write(elm)
+		case xml.EndElement:
+			if len(path) == 0 { // End of the record
+				return record, nil
+			}
+			if elmPath := strings.Join(path, XML_PATH_SEP); !record.hasField(elmPath) { // First element on the path wins
+				record.fields[elmPath] = strings.TrimSpace(texts[len(texts)-1].String())
+			}
+			path, texts = path[:len(path)-1], texts[:len(texts)-1]
+		}
+	}
+}
+
+// Fields are identified by the path of their element within the record element, attributes prefixed with @
+type xmlRecord struct {
+	raw    string
+	fields map[string]string
+}
+
+func (self *xmlRecord) hasField(fieldId string) bool {
+	_, hasField := self.fields[fieldId]
+	return hasField
+}
+
+func (self *xmlRecord) Raw() string {
+	return self.raw
+}
+
+func (self *xmlRecord) FieldValue(fieldId string) (string, error) {
+	fieldVal, hasField := self.fields[strings.Trim(fieldId, XML_PATH_SEP)]
+	if !hasField {
+		return "", fmt.Errorf("Missing element %s", fieldId)
+	}
+	return fieldVal, nil
+}
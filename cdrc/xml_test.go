@@ -0,0 +1,131 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrc
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestXmlRecords(t *testing.T) {
+	cgrConfig, _ := config.NewDefaultCGRConfig()
+	cdrcCfg := cgrConfig.DefaultCdrcConfig()
+	cdrcCfg.CdrType = XML
+	cdrcCfg.XmlRecordPath = "file/cdrs/cdr"
+	cdrcCfg.AccIdField = "@id"
+	cdrcCfg.ReqTypeField = "^rated"
+	cdrcCfg.DirectionField = "^*out"
+	cdrcCfg.TenantField = "^cgrates.org"
+	cdrcCfg.TorField = "^call"
+	cdrcCfg.AccountField = "caller/number"
+	cdrcCfg.SubjectField = "caller/number"
+	cdrcCfg.DestinationField = "callee/number"
+	cdrcCfg.AnswerTimeField = "answer"
+	cdrcCfg.DurationField = "billsec"
+	cdrcCfg.ExtraFields = []string{"supplier:route/@supplier"}
+	cdrc := &Cdrc{cdrcCfg: cdrcCfg}
+	if err := cdrc.parseFieldsConfig(); err != nil {
+		t.Fatal(err)
+	}
+	cdrs := `<?xml version="1.0" encoding="UTF-8"?>
+<file>
+  <header><cdr id="ignored"/></header>
+  <cdrs>
+    <cdr id="dsafdsaf">
+      <caller><number>1001</number></caller>
+      <callee><number>+4986517174963</number><number>+4986517174965</number></callee>
+      <answer>2013-02-03T19:54:00Z</answer>
+      <billsec>62</billsec>
+      <route supplier="supplier1"/>
+    </cdr>
+    <cdr id="bsafdsaf">
+      <caller>
+        <number> 1002 </number>
+      </caller>
+      <callee><number>+4986517174964</number></callee>
+      <answer>2013-02-03 20:54:00</answer>
+      <billsec>120</billsec>
+      <route supplier="supplier2"/>
+    </cdr>
+  </cdrs>
+</file>`
+	rtCdrs := readStoredCdrs(t, cdrc, cdrs)
+	expectedCdrs := []*utils.StoredCdr{
+		&utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", CdrSource: cdrcCfg.CdrSourceId, ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1001", Subject: "1001", Destination: "+4986517174963", AnswerTime: time.Date(2013, 2, 3, 19, 54, 0, 0, time.UTC),
+			Duration: time.Duration(62) * time.Second, ExtraFields: map[string]string{"supplier": "supplier1"}, Cost: -1},
+		&utils.StoredCdr{CgrId: utils.FSCgrId("bsafdsaf"), AccId: "bsafdsaf", CdrSource: cdrcCfg.CdrSourceId, ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1002", Subject: "1002", Destination: "+4986517174964", AnswerTime: time.Date(2013, 2, 3, 20, 54, 0, 0, time.UTC),
+			Duration: time.Duration(120) * time.Second, ExtraFields: map[string]string{"supplier": "supplier2"}, Cost: -1},
+	}
+	if !reflect.DeepEqual(expectedCdrs, rtCdrs) {
+		t.Errorf("Expected: %+v, received: %+v", expectedCdrs, rtCdrs)
+	}
+	if _, err := (&xmlRecord{}).FieldValue("caller/number"); err == nil {
+		t.Error("Failed detecting missing element")
+	}
+}
+
+func TestXmlRecordsDefaultPath(t *testing.T) {
+	rdr := newXmlRecordReader(strings.NewReader(`<cdrs><cdr><a>1</a></cdr><cdr><a>2</a></cdr></cdrs>`), "")
+	for _, expected := range []string{"1", "2"} {
+		if record, err := rdr.Read(); err != nil {
+			t.Fatal(err)
+		} else if fieldVal, err := record.FieldValue("a"); err != nil || fieldVal != expected {
+			t.Error("Unexpected field value: ", fieldVal, err)
+		} else if record.Raw() != "<cdr><a>"+expected+"</a></cdr>" {
+			t.Error("Unexpected raw record: ", record.Raw())
+		}
+	}
+	if _, err := rdr.Read(); err != io.EOF {
+		t.Error("Expected EOF, received: ", err)
+	}
+}
+
+func TestXmlRecordsSyntaxError(t *testing.T) {
+	rdr := newXmlRecordReader(strings.NewReader(`<cdrs><cdr><a>1</b></cdr><cdr><a>2</a></cdr></cdrs>`), "")
+	if _, err := rdr.Read(); err == nil || err == io.EOF {
+		t.Error("Failed detecting syntax error: ", err)
+	}
+	if _, err := rdr.Read(); err != io.EOF { // Decoding cannot continue
+		t.Error("Expected EOF, received: ", err)
+	}
+	if unread, err := rdr.Failure(); err == nil || unread != -1 { // Records not identified by name
+		t.Error("Unexpected failure: ", unread, err)
+	}
+	rdr = newXmlRecordReader(strings.NewReader(`<cdrs><cdr><a>1</a></cdr><cdr><a>2</b></cdr><cdr><a>3</a></cdr><cdrx/><cdr id="4"/></cdrs>`), "cdrs/cdr")
+	if _, err := rdr.Read(); err != nil {
+		t.Error(err)
+	}
+	if unread, err := rdr.Failure(); err != nil || unread != 0 {
+		t.Error("Unexpected failure: ", unread, err)
+	}
+	if _, err := rdr.Read(); err == nil || err == io.EOF {
+		t.Error("Failed detecting syntax error: ", err)
+	}
+	if unread, err := rdr.Failure(); err == nil || unread != 2 {
+		t.Error("Unexpected failure: ", unread, err)
+	}
+}
@@ -23,6 +23,12 @@ import (
 )
 
 type CdrWriter interface {
-	Write(cdr *utils.StoredCdr) string
-	Close()
+	Write(cdr *utils.StoredCdr) error
+	Close() error
+}
+
+// Writes one record out of its field names and values, in the output format of an export template
+type recordWriter interface {
+	writeRecord(names, values []string) error
+	flush() error
 }
@@ -54,6 +54,21 @@ func (dcw *CsvCdrWriter) Write(cdr *utils.StoredCdr) error {
 	return dcw.writer.Write(row)
 }
 
-func (dcw *CsvCdrWriter) Close() {
+func (dcw *CsvCdrWriter) Close() error {
 	dcw.writer.Flush()
+	return dcw.writer.Error()
+}
+
+// Writes the records of export templates in csv format
+type csvRecordWriter struct {
+	writer *csv.Writer
+}
+
+func (crw *csvRecordWriter) writeRecord(names, values []string) error {
+	return crw.writer.Write(values)
+}
+
+func (crw *csvRecordWriter) flush() error {
+	crw.writer.Flush()
+	return crw.writer.Error()
 }
@@ -20,12 +20,22 @@ package cdrexporter
 
 import (
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
 )
 
-type DeanCdrWriter struct{}
+// Writes the records of export templates in fixed width format, fields already filtered to their width
+type fixedWidthRecordWriter struct {
+	writer io.Writer
+}
+
+func (fwrw *fixedWidthRecordWriter) writeRecord(names, values []string) error {
+	_, err := io.WriteString(fwrw.writer, strings.Join(values, "")+"\n")
+	return err
+}
 
-func (dcw *DeanCdrWriter) Write(cdr []string) error {
+func (fwrw *fixedWidthRecordWriter) flush() error {
 	return nil
 }
 
@@ -44,7 +54,7 @@ func filterField(source string, maxLen int, stripAllowed, lStrip, lPadding, padW
 	}
 	if len(source) > maxLen { //the source is bigger than allowed
 		if !stripAllowed {
-			return "", fmt.Errorf("source %s is bigger than the maximum allowed length %s", source, maxLen)
+			return "", fmt.Errorf("source %s is bigger than the maximum allowed length %d", source, maxLen)
 		}
 		if !lStrip {
 			return source[:maxLen], nil
@@ -0,0 +1,176 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+const JOB_TIME_LAYOUT = "20060102150405" // Layout of the times in export file names
+
+var ErrJobRunning = errors.New(utils.ERR_JOB_RUNNING)
+
+// Result of the last run of an export job
+type CdrExportJobStatus struct {
+	engine.JobStatus
+	FilePath string // File written on the last run, empty if there were no CDRs to export
+	Records  int    // Number of CDRs exported on the last run
+}
+
+// Exports CDRs periodically, on the timing configured for it
+type CdrExportJob struct {
+	cfg    *config.CdreJobConfig
+	cgrCfg *config.CGRConfig
+	cdrDb  engine.CdrStorage
+	tpl    *config.CdreTemplateConfig
+	status *CdrExportJobStatus
+	mux    sync.RWMutex
+	runLck chan struct{} // Held for the duration of a run, so runs never overlap
+}
+
+func NewCdrExportJob(jobCfg *config.CdreJobConfig, cgrCfg *config.CGRConfig, cdrDb engine.CdrStorage) (*CdrExportJob, error) {
+	job := &CdrExportJob{cfg: jobCfg, cgrCfg: cgrCfg, cdrDb: cdrDb, status: &CdrExportJobStatus{JobStatus: engine.JobStatus{Id: jobCfg.Id}},
+		runLck: make(chan struct{}, 1)}
+	if len(jobCfg.ExportTemplate) != 0 {
+		if job.tpl = cgrCfg.CdreTemplates[jobCfg.ExportTemplate]; job.tpl == nil {
+			return nil, fmt.Errorf("Unknown export template %s for export job %s", jobCfg.ExportTemplate, jobCfg.Id)
+		}
+	}
+	return job, nil
+}
+
+func (job *CdrExportJob) Id() string {
+	return job.cfg.Id
+}
+
+// Schedules the job, daily if no days are configured
+func (job *CdrExportJob) ActionTiming() *engine.ActionTiming {
+	timing := &engine.RITiming{Years: job.cfg.Years, Months: job.cfg.Months, MonthDays: job.cfg.MonthDays, WeekDays: job.cfg.WeekDays,
+		StartTime: job.cfg.StartTime}
+	return engine.NewJobActionTiming(config.CDRE_JOB_PREFIX+job.cfg.Id, timing, func() error { return job.Run(time.Now()) })
+}
+
+func (job *CdrExportJob) Status() *CdrExportJobStatus {
+	job.mux.RLock()
+	defer job.mux.RUnlock()
+	status := *job.status
+	return &status
+}
+
+// Interval of answer times exported by a run started at runTime
+func (job *CdrExportJob) exportPeriod(runTime time.Time) (timeStart, timeEnd time.Time) {
+	switch job.cfg.ExportPeriod {
+	case "":
+	case utils.PREVIOUS_DAY, utils.PREVIOUS_MONTH:
+		timeStart, timeEnd = engine.JobPeriod(job.cfg.ExportPeriod, runTime)
+	default:
+		period, _ := utils.ParseDurationWithSecs(job.cfg.ExportPeriod) // Checked when loading config
+		timeStart, timeEnd = runTime.Add(-period), runTime
+	}
+	return
+}
+
+func (job *CdrExportJob) fileName(runTime, timeStart, timeEnd time.Time) string {
+	var tStartStr, tEndStr string
+	if !timeStart.IsZero() {
+		tStartStr, tEndStr = timeStart.Format(JOB_TIME_LAYOUT), timeEnd.Format(JOB_TIME_LAYOUT)
+	}
+	fileName := strings.NewReplacer(utils.CDRE_JOB_ID, job.cfg.Id, utils.CDRE_TIME_START, tStartStr, utils.CDRE_TIME_END, tEndStr,
+		utils.CDRE_EXPORT_TIME, runTime.Format(JOB_TIME_LAYOUT)).Replace(job.cfg.FileName)
+	cdrFormat := utils.CDRE_CSV
+	if job.tpl != nil {
+		cdrFormat = job.tpl.CdrFormat
+	}
+	exportDir := job.cfg.ExportDir
+	if len(exportDir) == 0 {
+		exportDir = job.cgrCfg.CdreDir
+	}
+	return path.Join(exportDir, fileName+"."+FileExtension(cdrFormat))
+}
+
+// Exports the CDRs matching the job filters, skipping the ones already exported by it when marking is enabled.
+// Waits for the run in progress to finish first, if any.
+func (job *CdrExportJob) Run(runTime time.Time) error {
+	job.runLck <- struct{}{}
+	defer func() { <-job.runLck }()
+	return job.run(runTime)
+}
+
+// Runs the job now unless a run is already in progress, returning ErrJobRunning in that case
+func (job *CdrExportJob) TryRun(runTime time.Time) error {
+	select {
+	case job.runLck <- struct{}{}:
+	default:
+		return ErrJobRunning
+	}
+	defer func() { <-job.runLck }()
+	return job.run(runTime)
+}
+
+func (job *CdrExportJob) run(runTime time.Time) error {
+	filePath, records, err := job.export(runTime)
+	job.mux.Lock()
+	job.status = &CdrExportJobStatus{JobStatus: engine.NewJobStatus(job.cfg.Id, runTime, err), FilePath: filePath, Records: records}
+	job.mux.Unlock()
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Cdre> Export job %s failed: %s", job.cfg.Id, err.Error()))
+	} else {
+		engine.Logger.Info(fmt.Sprintf("<Cdre> Export job %s exported %d CDRs into <%s>", job.cfg.Id, records, filePath))
+	}
+	return err
+}
+
+func (job *CdrExportJob) export(runTime time.Time) (string, int, error) {
+	fltr := &utils.CdrsFilter{Tenants: job.cfg.Tenants, Accounts: job.cfg.Accounts, ReqTypes: job.cfg.ReqTypes, MediationRunIds: job.cfg.MediationRunIds,
+		CdrSources: job.cfg.CdrSources, DestinationPrefixes: job.cfg.DestinationPrefixes}
+	fltr.AnswerTimeStart, fltr.AnswerTimeEnd = job.exportPeriod(runTime)
+	if job.cfg.SkipErrors {
+		minCost := 0.0
+		fltr.MinCost = &minCost
+	}
+	if job.cfg.MarkExported {
+		fltr.NotExportedBy = job.cfg.Id
+	}
+	cdrs, _, err := job.cdrDb.GetCdrs(fltr)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(cdrs) == 0 {
+		return "", 0, nil
+	}
+	filePath := job.fileName(runTime, fltr.AnswerTimeStart, fltr.AnswerTimeEnd)
+	if err := ExportCdrsToFile(filePath, cdrs, job.tpl, job.cgrCfg.RoundingDecimals, job.cgrCfg.CdreExtraFields); err != nil {
+		return "", 0, err
+	}
+	if job.cfg.MarkExported {
+		if err := job.cdrDb.SetCdrsExported(job.cfg.Id, cdrs); err != nil {
+			return filePath, len(cdrs), fmt.Errorf("Exported CDRs could not be marked: %s", err.Error())
+		}
+	}
+	return filePath, len(cdrs), nil
+}
@@ -0,0 +1,105 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestCdrExportJobPeriod(t *testing.T) {
+	job := &CdrExportJob{cfg: config.NewDefaultCdreJobConfig("test")}
+	runTime := time.Date(2014, 3, 1, 1, 30, 0, 0, time.UTC)
+	if tStart, tEnd := job.exportPeriod(runTime); !tStart.IsZero() || !tEnd.IsZero() {
+		t.Error("Unexpected export period: ", tStart, tEnd)
+	}
+	job.cfg.ExportPeriod = utils.PREVIOUS_DAY
+	if tStart, tEnd := job.exportPeriod(runTime); !tStart.Equal(time.Date(2014, 2, 28, 0, 0, 0, 0, time.UTC)) || !tEnd.Equal(time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Unexpected export period: ", tStart, tEnd)
+	}
+	job.cfg.ExportPeriod = utils.PREVIOUS_MONTH
+	if tStart, tEnd := job.exportPeriod(runTime); !tStart.Equal(time.Date(2014, 2, 1, 0, 0, 0, 0, time.UTC)) || !tEnd.Equal(time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Unexpected export period: ", tStart, tEnd)
+	}
+	job.cfg.ExportPeriod = "1h"
+	if tStart, tEnd := job.exportPeriod(runTime); !tStart.Equal(time.Date(2014, 3, 1, 0, 30, 0, 0, time.UTC)) || !tEnd.Equal(runTime) {
+		t.Error("Unexpected export period: ", tStart, tEnd)
+	}
+}
+
+func TestCdrExportJobRun(t *testing.T) {
+	exportDir, err := ioutil.TempDir("", "cdre_job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(exportDir)
+	cdrDb, _ := engine.NewMapStorage()
+	for _, cdr := range getExportCdrs() {
+		if err := cdrDb.SetCdr(cdr); err != nil {
+			t.Fatal(err)
+		}
+		if err := cdrDb.SetRatedCdr(cdr, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	jobCfg := config.NewDefaultCdreJobConfig("test")
+	jobCfg.ExportDir = exportDir
+	jobCfg.Accounts = []string{"1001"}
+	job, err := NewCdrExportJob(jobCfg, cgrCfg, cdrDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTime := time.Date(2014, 3, 1, 1, 30, 0, 0, time.UTC)
+	if err := job.Run(runTime); err != nil {
+		t.Fatal(err)
+	}
+	status := job.Status()
+	if status.Status != utils.JOB_OK || status.Records != 1 || status.FilePath != exportDir+"/cdrs_test_20140301013000.csv" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if _, err := os.Stat(status.FilePath); err != nil {
+		t.Error(err)
+	}
+	if err := job.Run(runTime.Add(time.Hour)); err != nil { // Already exported
+		t.Fatal(err)
+	}
+	if status = job.Status(); status.Records != 0 || status.FilePath != "" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if cdrs, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{NotExportedBy: "other"}); err != nil { // Marks are kept per job
+		t.Error(err)
+	} else if len(cdrs) != 2 {
+		t.Error("Unexpected CDRs: ", cdrs)
+	}
+	job.runLck <- struct{}{} // Run in progress
+	if err := job.TryRun(runTime.Add(2 * time.Hour)); err != ErrJobRunning {
+		t.Error("Expecting job running error, received: ", err)
+	}
+	<-job.runLck
+	if err := job.TryRun(runTime.Add(2 * time.Hour)); err != nil {
+		t.Error(err)
+	}
+}
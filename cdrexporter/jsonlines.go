@@ -0,0 +1,52 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Writes the records of export templates as json objects, one per line, keys kept in the order of the template fields
+type jsonRecordWriter struct {
+	writer io.Writer
+}
+
+func (jrw *jsonRecordWriter) writeRecord(names, values []string) error {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for idx, name := range names {
+		if idx != 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(name)
+		value, _ := json.Marshal(values[idx])
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	_, err := jrw.writer.Write(buf.Bytes())
+	return err
+}
+
+func (jrw *jsonRecordWriter) flush() error {
+	return nil
+}
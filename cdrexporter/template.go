@@ -0,0 +1,259 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Field of an export template, compiled out of its config
+type exportField struct {
+	cfg      *config.CdreFieldConfig
+	rsrField *utils.RSRField // nil for variables
+	unit     time.Duration   // Unit of the duration values
+}
+
+func newExportFields(fldCfgs []*config.CdreFieldConfig, allowCdrFields bool) ([]*exportField, error) {
+	flds := make([]*exportField, len(fldCfgs))
+	for idx, fldCfg := range fldCfgs {
+		fld := &exportField{cfg: fldCfg, unit: time.Second}
+		if len(fldCfg.DurationUnit) != 0 {
+			var err error
+			if fld.unit, err = time.ParseDuration("1" + fldCfg.DurationUnit); err != nil {
+				return nil, err
+			}
+		}
+		if !utils.IsSliceMember(utils.CdreVariables, fldCfg.Source) {
+			rsrField, err := utils.NewRSRField(fldCfg.Source)
+			if err != nil {
+				return nil, err
+			}
+			if rsrField == nil || (len(rsrField.Id) != 0 && !allowCdrFields) {
+				return nil, fmt.Errorf("Invalid source of header or trailer field: <%s>", fldCfg.Source)
+			}
+			fld.rsrField = rsrField
+		}
+		flds[idx] = fld
+	}
+	return flds, nil
+}
+
+// Writes CDRs out of an export template, with header and trailer records containing counters and totals.
+// The records are written on Close, once the totals are known.
+type TemplateCdrWriter struct {
+	recWriter     recordWriter
+	roundDecimals int // Decimals of the costs when not specified in template
+	header        []*exportField
+	content       []*exportField
+	trailer       []*exportField
+	cdrs          []*utils.StoredCdr
+	totalCost     float64
+	totalDuration time.Duration
+	firstCdrTime  time.Time
+	lastCdrTime   time.Time
+	exportTime    time.Time
+}
+
+func NewTemplateCdrWriter(writer io.Writer, tpl *config.CdreTemplateConfig, roundDecimals int) (*TemplateCdrWriter, error) {
+	tcw := &TemplateCdrWriter{roundDecimals: roundDecimals, exportTime: time.Now()}
+	switch tpl.CdrFormat {
+	case utils.CDRE_CSV:
+		csvWriter := csv.NewWriter(writer)
+		if len(tpl.FieldSeparator) == 1 {
+			csvWriter.Comma = rune(tpl.FieldSeparator[0])
+		}
+		tcw.recWriter = &csvRecordWriter{csvWriter}
+	case utils.CDRE_FIXED_WIDTH:
+		tcw.recWriter = &fixedWidthRecordWriter{writer}
+	case utils.CDRE_JSON_LINES:
+		tcw.recWriter = &jsonRecordWriter{writer}
+	default:
+		return nil, fmt.Errorf("Unsupported cdr_format: <%s>", tpl.CdrFormat)
+	}
+	var err error
+	if tcw.header, err = newExportFields(tpl.HeaderFields, false); err != nil {
+		return nil, err
+	}
+	if tcw.content, err = newExportFields(tpl.ContentFields, true); err != nil {
+		return nil, err
+	}
+	if tcw.trailer, err = newExportFields(tpl.TrailerFields, false); err != nil {
+		return nil, err
+	}
+	return tcw, nil
+}
+
+func (tcw *TemplateCdrWriter) Write(cdr *utils.StoredCdr) error {
+	tcw.cdrs = append(tcw.cdrs, cdr)
+	if cdr.Cost > -1 { // Errors are not summed up
+		tcw.totalCost += cdr.Cost
+	}
+	tcw.totalDuration += cdr.Duration
+	if tcw.firstCdrTime.IsZero() || cdr.AnswerTime.Before(tcw.firstCdrTime) {
+		tcw.firstCdrTime = cdr.AnswerTime
+	}
+	if cdr.AnswerTime.After(tcw.lastCdrTime) {
+		tcw.lastCdrTime = cdr.AnswerTime
+	}
+	return nil
+}
+
+func (tcw *TemplateCdrWriter) Close() error {
+	if len(tcw.header) != 0 {
+		if err := tcw.writeRecord(tcw.header, nil); err != nil {
+			return err
+		}
+	}
+	for _, cdr := range tcw.cdrs {
+		if err := tcw.writeRecord(tcw.content, cdr); err != nil {
+			return fmt.Errorf("CDR with cgrid: %s, runid: %s, error: %s", cdr.CgrId, cdr.MediationRunId, err.Error())
+		}
+	}
+	if len(tcw.trailer) != 0 {
+		if err := tcw.writeRecord(tcw.trailer, nil); err != nil {
+			return err
+		}
+	}
+	return tcw.recWriter.flush()
+}
+
+func (tcw *TemplateCdrWriter) writeRecord(flds []*exportField, cdr *utils.StoredCdr) error {
+	names := make([]string, len(flds))
+	values := make([]string, len(flds))
+	for idx, fld := range flds {
+		var err error
+		names[idx] = fld.cfg.Name
+		if values[idx], err = tcw.fieldValue(fld, cdr); err != nil {
+			return err
+		}
+	}
+	return tcw.recWriter.writeRecord(names, values)
+}
+
+func (tcw *TemplateCdrWriter) fieldValue(fld *exportField, cdr *utils.StoredCdr) (string, error) {
+	var value string
+	if fld.rsrField == nil {
+		value = tcw.variableValue(fld)
+	} else if len(fld.rsrField.Id) == 0 {
+		value = fld.rsrField.ParseValue("")
+	} else {
+		value = fld.rsrField.ParseValue(tcw.cdrFieldValue(fld, cdr))
+	}
+	if fld.cfg.Width == 0 {
+		return value, nil
+	}
+	return filterField(value, fld.cfg.Width, len(fld.cfg.Strip) != 0, fld.cfg.Strip == utils.PADDING_LEFT,
+		fld.cfg.Padding != utils.PADDING_RIGHT, fld.cfg.Padding == utils.PADDING_ZEROLEFT)
+}
+
+func (tcw *TemplateCdrWriter) cdrFieldValue(fld *exportField, cdr *utils.StoredCdr) string {
+	switch fld.rsrField.Id {
+	case utils.COST:
+		return tcw.formatCost(cdr.Cost, fld)
+	case utils.ANSWER_TIME:
+		return cdr.AnswerTime.Format(fld.cfg.Layout)
+	case utils.DURATION:
+		return formatDuration(cdr.Duration, fld)
+	}
+	value := cdr.FieldAsString(fld.rsrField.Id)
+	if fld.cfg.Decimals != -1 {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			value = strconv.FormatFloat(floatVal, 'f', fld.cfg.Decimals, 64)
+		}
+	}
+	return value
+}
+
+func (tcw *TemplateCdrWriter) variableValue(fld *exportField) string {
+	switch fld.cfg.Source {
+	case utils.CDRE_CDRS_NUMBER:
+		return strconv.Itoa(len(tcw.cdrs))
+	case utils.CDRE_TOTAL_COST:
+		return tcw.formatCost(tcw.totalCost, fld)
+	case utils.CDRE_TOTAL_DURATION:
+		return formatDuration(tcw.totalDuration, fld)
+	case utils.CDRE_FIRST_CDR_TIME:
+		return tcw.firstCdrTime.Format(fld.cfg.Layout)
+	case utils.CDRE_LAST_CDR_TIME:
+		return tcw.lastCdrTime.Format(fld.cfg.Layout)
+	case utils.CDRE_EXPORT_TIME:
+		return tcw.exportTime.Format(fld.cfg.Layout)
+	}
+	return ""
+}
+
+func (tcw *TemplateCdrWriter) formatCost(cost float64, fld *exportField) string {
+	decimals := fld.cfg.Decimals
+	if decimals == -1 {
+		decimals = tcw.roundDecimals
+	}
+	return strconv.FormatFloat(cost, 'f', decimals, 64)
+}
+
+func formatDuration(dur time.Duration, fld *exportField) string {
+	return strconv.FormatFloat(float64(dur)/float64(fld.unit), 'f', fld.cfg.Decimals, 64)
+}
+
+// Extension of the export files, based on the format of their records
+func FileExtension(cdrFormat string) string {
+	switch cdrFormat {
+	case utils.CDRE_FIXED_WIDTH:
+		return "fwv"
+	case utils.CDRE_JSON_LINES:
+		return "jsonl"
+	}
+	return "csv"
+}
+
+// Exports the CDRs into a new file, out of the template if one is provided, otherwise as csv with the primary and extra fields.
+// The file is removed on errors.
+func ExportCdrsToFile(fileName string, cdrs []*utils.StoredCdr, tpl *config.CdreTemplateConfig, roundDecimals int, extraFields []string) (err error) {
+	fileOut, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		fileOut.Close()
+		if err != nil {
+			os.Remove(fileName)
+		}
+	}()
+	var cdrWriter CdrWriter
+	if tpl != nil {
+		if cdrWriter, err = NewTemplateCdrWriter(fileOut, tpl, roundDecimals); err != nil {
+			return err
+		}
+	} else {
+		cdrWriter = NewCsvCdrWriter(fileOut, roundDecimals, extraFields)
+	}
+	for _, cdr := range cdrs {
+		if err = cdrWriter.Write(cdr); err != nil {
+			return err
+		}
+	}
+	return cdrWriter.Close()
+}
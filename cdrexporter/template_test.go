@@ -0,0 +1,123 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrexporter
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func getExportCdrs() []*utils.StoredCdr {
+	return []*utils.StoredCdr{
+		&utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", CdrHost: "192.168.1.1", ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1001", Subject: "1001", Destination: "+4986517174963", AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC),
+			Duration: time.Duration(10) * time.Second, MediationRunId: utils.DEFAULT_RUNID, ExtraFields: map[string]string{"supplier": "supplier1"}, Cost: 1.01},
+		&utils.StoredCdr{CgrId: utils.FSCgrId("bsafdsaf"), AccId: "bsafdsaf", CdrHost: "192.168.1.1", ReqType: "rated", Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1002", Subject: "1002", Destination: "+4986517174964", AnswerTime: time.Date(2013, 11, 7, 9, 42, 26, 0, time.UTC),
+			Duration: time.Duration(90) * time.Second, MediationRunId: utils.DEFAULT_RUNID, ExtraFields: map[string]string{}, Cost: 2.5},
+	}
+}
+
+func exportWithTemplate(t *testing.T, tpl *config.CdreTemplateConfig) string {
+	writer := &bytes.Buffer{}
+	tplWriter, err := NewTemplateCdrWriter(writer, tpl, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cdr := range getExportCdrs() {
+		if err := tplWriter.Write(cdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tplWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writer.String()
+}
+
+func parseTplFields(t *testing.T, fldStrs ...string) []*config.CdreFieldConfig {
+	flds, err := config.ParseCdreFields(fldStrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return flds
+}
+
+func TestTemplateCsv(t *testing.T) {
+	tpl := config.NewDefaultCdreTemplateConfig("test")
+	tpl.FieldSeparator = ";"
+	tpl.HeaderFields = parseTplFields(t, "^HDR", "*first_cdr_time|layout=20060102150405")
+	tpl.ContentFields = parseTplFields(t, "account", "~destination:s/^\\+49(\\d+)/0${1}/", "answer_time|layout=2006-01-02 15:04:05",
+		"duration|unit=m|decimals=2", "cost", "supplier")
+	tpl.TrailerFields = parseTplFields(t, "*cdrs_number", "*total_cost|decimals=2", "*total_duration")
+	expected := `HDR;20131107084226
+1001;086517174963;2013-11-07 08:42:26;0.17;1.0100;supplier1
+1002;086517174964;2013-11-07 09:42:26;1.50;2.5000;
+2;3.51;100
+`
+	if result := exportWithTemplate(t, tpl); result != expected {
+		t.Errorf("Expected:\n%s\nreceived:\n%s", expected, result)
+	}
+}
+
+func TestTemplateFixedWidth(t *testing.T) {
+	tpl := config.NewDefaultCdreTemplateConfig("test")
+	tpl.CdrFormat = utils.CDRE_FIXED_WIDTH
+	tpl.HeaderFields = parseTplFields(t, "^HDR|width=3", "*cdrs_number|width=6|padding=zeroleft")
+	tpl.ContentFields = parseTplFields(t, "account|width=6", "destination|width=8|strip=left", "duration|width=4|padding=zeroleft",
+		"cost|width=8|decimals=2|padding=left")
+	tpl.TrailerFields = parseTplFields(t, "^TRL|width=3", "*total_cost|width=8|decimals=2|padding=left")
+	expected := "HDR000002\n1001  171749630010    1.01\n1002  171749640090    2.50\nTRL    3.51\n"
+	if result := exportWithTemplate(t, tpl); result != expected {
+		t.Errorf("Expected:\n%s\nreceived:\n%s", expected, result)
+	}
+	tpl.ContentFields = parseTplFields(t, "destination|width=8")
+	tplWriter, _ := NewTemplateCdrWriter(&bytes.Buffer{}, tpl, 4)
+	tplWriter.Write(getExportCdrs()[0])
+	if err := tplWriter.Close(); err == nil {
+		t.Error("Expected error on value longer than width")
+	}
+}
+
+func TestTemplateJsonLines(t *testing.T) {
+	tpl := config.NewDefaultCdreTemplateConfig("test")
+	tpl.CdrFormat = utils.CDRE_JSON_LINES
+	tpl.ContentFields = parseTplFields(t, "cgrid|name=id", "account", "duration|unit=ms", "^partner1|name=partner")
+	tpl.TrailerFields = parseTplFields(t, "*cdrs_number|name=count")
+	expected := `{"id":"b18944ef4dc618569f24c27b9872827a242bad0c","account":"1001","duration":"10000","partner":"partner1"}
+{"id":"f102ac6ba36ae959b066e25d59929cf037076434","account":"1002","duration":"90000","partner":"partner1"}
+{"count":"2"}
+`
+	if result := exportWithTemplate(t, tpl); result != expected {
+		t.Errorf("Expected:\n%s\nreceived:\n%s", expected, result)
+	}
+}
+
+func TestTemplateInvalidHeader(t *testing.T) {
+	tpl := config.NewDefaultCdreTemplateConfig("test")
+	tpl.HeaderFields = parseTplFields(t, "account")
+	tpl.ContentFields = parseTplFields(t, "account")
+	if _, err := NewTemplateCdrWriter(&bytes.Buffer{}, tpl, 4); err == nil {
+		t.Error("Expected error on CDR field in header")
+	}
+}
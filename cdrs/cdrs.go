@@ -19,9 +19,13 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package cdrs
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
 	"github.com/cgrates/cgrates/config"
 	"github.com/cgrates/cgrates/engine"
@@ -30,35 +34,169 @@ import (
 )
 
 var (
-	cfg     *config.CGRConfig // Share the configuration with the rest of the package
-	storage engine.CdrStorage
-	medi    *mediator.Mediator
+	cfg         *config.CGRConfig // Share the configuration with the rest of the package
+	storage     engine.CdrStorage
+	medi        *mediator.Mediator
+	stats       *engine.CdrStats
+	replicators []*CdrReplicator
+	partials    *partialCdrs // Nil when merging partial CDRs is disabled
 )
 
-// Returns error if not able to properly store the CDR, mediation is async since we can always recover offline
-func storeAndMediate(rawCdr utils.RawCDR) error {
-	if err := storage.SetCdr(rawCdr); err != nil {
-		return err
+// Buffers the partial records of long calls when enabled, storing and mediating the complete CDRs.
+// Returns the CDR as stored, which differs from the one received when its cgrid was changed.
+func processCdr(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+	if partials != nil {
+		return partials.process(rawCdr)
 	}
-	if cfg.CDRSMediator == utils.INTERNAL {
-		go func() {
-			if err := medi.RateCdr(rawCdr); err != nil {
-				engine.Logger.Err(fmt.Sprintf("Could not run mediation on CDR: %s", err.Error()))
+	return storeAndMediate(rawCdr)
+}
+
+// Returns error if not able to properly store the CDR, mediation is async since we can always recover offline.
+// Duplicates of CDRs already stored return engine.ErrDuplicateCdr, mediator decides on re-rating them based on its duplicates policy.
+// CDRs reusing the accid of one received from another CdrHost or CdrSource are stored under a cgrid of their own.
+func storeAndMediate(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+	err := storage.SetCdr(rawCdr)
+	if err != nil && strings.HasPrefix(err.Error(), utils.ERR_EXISTS) {
+		storedCdr, errStored := utils.NewStoredCdrFromRawCDR(rawCdr)
+		if errStored != nil {
+			return rawCdr, err
+		}
+		storedCdr.CgrId = utils.OriginCgrId(storedCdr.AccId, storedCdr.CdrHost, storedCdr.CdrSource)
+		rawCdr = storedCdr
+		err = storage.SetCdr(rawCdr)
+	}
+	if err != nil && err != engine.ErrDuplicateCdr {
+		return rawCdr, err
+	}
+	duplicate := err == engine.ErrDuplicateCdr
+	if !duplicate && len(replicators) != 0 {
+		replicate(rawCdr)
+	}
+	if duplicate {
+		engine.Logger.Info(fmt.Sprintf("<CDRS> Duplicate CDR received, cgrid: %s", rawCdr.GetCgrId()))
+		if stats != nil {
+			if storedCdr, errStored := utils.NewStoredCdrFromRawCDR(rawCdr); errStored == nil {
+				stats.AppendDuplicate(storedCdr)
 			}
-		}()
+		}
+	}
+	if cfg.CDRSMediator == utils.INTERNAL {
+		medi.QueueCdr(rawCdr, duplicate) // Blocks while the mediator is overloaded
+	}
+	return rawCdr, err
+}
+
+// Overwrites the CDR stored under the same cgrid and mediates it again, replacing its previous rating.
+// Used for the partials merged on timeout which got completed by late records, the replicas keep the CDR as first stored.
+func updateAndMediate(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+	if err := storage.UpdateCdr(rawCdr); err != nil {
+		return rawCdr, err
+	}
+	engine.Logger.Info(fmt.Sprintf("<CDRS> Updated merged partial CDRs with cgrid: %s", rawCdr.GetCgrId()))
+	if cfg.CDRSMediator == utils.INTERNAL {
+		medi.QueueCdr(rawCdr, false)
+	}
+	return rawCdr, nil
+}
+
+// Result of processing one CDR, sent back as JSON to the CDR sender
+type CdrReply struct {
+	CgrId  string
+	AccId  string
+	Status string // OK, DUPLICATE or the error code
+	Error  string
+}
+
+// Builds the http status code and reply for one CDR out of the error processing it
+func newCdrReply(rawCdr utils.RawCDR, err error) (int, *CdrReply) {
+	reply := &CdrReply{CgrId: rawCdr.GetCgrId(), AccId: rawCdr.GetAccId(), Status: "OK"}
+	switch {
+	case err == nil:
+		return http.StatusOK, reply
+	case err == engine.ErrDuplicateCdr: // Acknowledge so the sender stops retrying
+		reply.Status = utils.ERR_DUPLICATE
+		return http.StatusOK, reply
+	}
+	reply.Error = err.Error()
+	errCode := strings.SplitN(err.Error(), ":", 2)[0]
+	switch errCode {
+	case utils.ERR_MANDATORY_IE_MISSING, utils.ERR_INVALID_CDR:
+		reply.Status = errCode
+		return http.StatusBadRequest, reply
+	case utils.ERR_EXISTS:
+		reply.Status = errCode
+		return http.StatusConflict, reply
+	}
+	reply.Status = utils.ERR_SERVER_ERROR
+	return http.StatusInternalServerError, reply
+}
+
+// Writes the reply JSON encoded with the status code
+func writeReply(w http.ResponseWriter, statusCode int, reply interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(reply); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<CDRS> Could not write reply: %s", err.Error()))
+	}
+}
+
+// Converts the reply of a remote CDRS into error, engine.ErrDuplicateCdr for CDRs already stored
+func ReplyError(resp *http.Response) error {
+	defer resp.Body.Close()
+	var cdrReply CdrReply
+	if err := json.NewDecoder(resp.Body).Decode(&cdrReply); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, resp.Status)
+		}
+		return nil // Older CDRS replying with empty body
+	}
+	switch {
+	case cdrReply.Status == utils.ERR_DUPLICATE:
+		return engine.ErrDuplicateCdr
+	case resp.StatusCode != http.StatusOK:
+		return errors.New(cdrReply.Error)
 	}
 	return nil
 }
 
+// Replies to the sender of one CDR with the result of storing it
+func writeCdrReply(w http.ResponseWriter, rawCdr utils.RawCDR, err error) {
+	if err != nil && err != engine.ErrDuplicateCdr {
+		engine.Logger.Err(fmt.Sprintf("Errors when storing CDR entry: %s", err.Error()))
+	}
+	statusCode, reply := newCdrReply(rawCdr, err)
+	writeReply(w, statusCode, reply)
+}
+
+// Replies with http.StatusBadRequest to CDRs which cannot be decoded
+func writeDecodeError(w http.ResponseWriter, err error) {
+	engine.Logger.Err(fmt.Sprintf("Could not create CDR entry: %s", err.Error()))
+	writeReply(w, http.StatusBadRequest, &CdrReply{Status: utils.ERR_INVALID_CDR, Error: err.Error()})
+}
+
+// Queues the CDR for replication, errors are logged since the CDR is already stored locally
+func replicate(rawCdr utils.RawCDR) {
+	storedCdr, err := utils.NewStoredCdrFromRawCDR(rawCdr)
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<CDRS> Could not replicate CDR with cgrid: %s, error: %s", rawCdr.GetCgrId(), err.Error()))
+		return
+	}
+	for _, rpl := range replicators {
+		if err := rpl.Replicate(storedCdr); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<CDRS> Could not queue CDR with cgrid: %s for replication to %s, error: %s", storedCdr.CgrId, rpl.cfg.Id, err.Error()))
+		}
+	}
+}
+
 // Handler for generic cgr cdr http
 func cgrCdrHandler(w http.ResponseWriter, r *http.Request) {
 	cgrCdr, err := utils.NewCgrCdrFromHttpReq(r)
 	if err != nil {
-		engine.Logger.Err(fmt.Sprintf("Could not create CDR entry: %s", err.Error()))
-	}
-	if err := storeAndMediate(cgrCdr); err != nil {
-		engine.Logger.Err(fmt.Sprintf("Errors when storing CDR entry: %s", err.Error()))
+		writeDecodeError(w, err)
+		return
 	}
+	storedCdr, err := processCdr(cgrCdr)
+	writeCdrReply(w, storedCdr, err)
 }
 
 // Handler for fs http
@@ -66,11 +204,88 @@ func fsCdrHandler(w http.ResponseWriter, r *http.Request) {
 	body, _ := ioutil.ReadAll(r.Body)
 	fsCdr, err := new(FSCdr).New(body)
 	if err != nil {
-		engine.Logger.Err(fmt.Sprintf("Could not create CDR entry: %s", err.Error()))
+		writeDecodeError(w, err)
+		return
 	}
-	if err := storeAndMediate(fsCdr); err != nil {
-		engine.Logger.Err(fmt.Sprintf("Errors when storing CDR entry: %s", err.Error()))
+	storedCdr, err := processCdr(fsCdr)
+	writeCdrReply(w, storedCdr, err)
+}
+
+// Returns the primary fields missing out of CDR, zero duration is accepted since it marks a failed call
+func missingPrimaryFields(cdr *utils.StoredCdr) []string {
+	fldVals := map[string]string{utils.ACCID: cdr.AccId, utils.CDRHOST: cdr.CdrHost, utils.CDRSOURCE: cdr.CdrSource, utils.REQTYPE: cdr.ReqType,
+		utils.DIRECTION: cdr.Direction, utils.TENANT: cdr.Tenant, utils.TOR: cdr.TOR, utils.ACCOUNT: cdr.Account, utils.SUBJECT: cdr.Subject,
+		utils.DESTINATION: cdr.Destination}
+	missing := []string{}
+	for _, fld := range utils.PrimaryCdrFields {
+		switch fld {
+		case utils.ANSWER_TIME:
+			if cdr.AnswerTime.IsZero() {
+				missing = append(missing, fld)
+			}
+		case utils.DURATION:
+		default:
+			if fldVals[fld] == "" {
+				missing = append(missing, fld)
+			}
+		}
 	}
+	return missing
+}
+
+// Validates and stores one CDR received as JSON
+func storeJsonCdr(cdr *utils.StoredCdr, remoteAddr string) (utils.RawCDR, error) {
+	if cdr.CdrHost == "" {
+		cdr.CdrHost = remoteAddr
+	}
+	if missing := missingPrimaryFields(cdr); len(missing) != 0 {
+		return cdr, fmt.Errorf("%s:%v", utils.ERR_MANDATORY_IE_MISSING, missing)
+	}
+	if cdr.CgrId == "" {
+		cdr.CgrId = utils.FSCgrId(cdr.AccId)
+	}
+	cdr.MediationRunId = utils.DEFAULT_RUNID
+	cdr.Cost = -1
+	return processCdr(cdr)
+}
+
+// Handler for StoredCdr as JSON, one object or an array of them.
+// Single CDRs are answered with the status code of processing them, batches with http.StatusOK and the per CDR replies.
+func jsonCdrHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) != 0 && body[0] == '[' {
+		var cdrs []*utils.StoredCdr
+		if err := json.Unmarshal(body, &cdrs); err != nil {
+			writeDecodeError(w, err)
+			return
+		}
+		replies := make([]*CdrReply, len(cdrs))
+		for idx, cdr := range cdrs {
+			if cdr == nil {
+				replies[idx] = &CdrReply{Status: utils.ERR_INVALID_CDR, Error: "null CDR"}
+				continue
+			}
+			storedCdr, err := storeJsonCdr(cdr, r.RemoteAddr)
+			if err != nil && err != engine.ErrDuplicateCdr {
+				engine.Logger.Err(fmt.Sprintf("Errors when storing CDR entry: %s", err.Error()))
+			}
+			_, replies[idx] = newCdrReply(storedCdr, err)
+		}
+		writeReply(w, http.StatusOK, replies)
+		return
+	}
+	cdr := new(utils.StoredCdr)
+	if err := json.Unmarshal(body, cdr); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	storedCdr, err := storeJsonCdr(cdr, r.RemoteAddr)
+	writeCdrReply(w, storedCdr, err)
 }
 
 type CDRS struct{}
@@ -79,15 +294,55 @@ func New(s engine.CdrStorage, m *mediator.Mediator, c *config.CGRConfig) *CDRS {
 	storage = s
 	medi = m
 	cfg = c
+	partials = nil
+	if cfg.CDRSPartialFlagField != "" {
+		partials = newPartialCdrs(cfg.CDRSPartialFlagField, cfg.CDRSPartialSeqField, cfg.CDRSPartialTimeout, cfg.CDRSPartialDir, storeAndMediate, updateAndMediate)
+	}
 	return &CDRS{}
 }
 
+// Duplicate CDRs will be counted in the stats queues
+func (cdrs *CDRS) SetCdrStats(cdrStats *engine.CdrStats) {
+	stats = cdrStats
+}
+
+// Raw CDRs stored will be copied to the replicator target
+func (cdrs *CDRS) AddReplicator(rpl *CdrReplicator) {
+	replicators = append(replicators, rpl)
+}
+
+// Returns the status of the replication targets
+func (cdrs *CDRS) GetReplicationStatus() []*CdrReplicationStatus {
+	statuses := make([]*CdrReplicationStatus, len(replicators))
+	for idx, rpl := range replicators {
+		statuses[idx] = rpl.GetStatus()
+	}
+	return statuses
+}
+
 func (cdrs *CDRS) RegisterHanlersToServer(server *engine.Server) {
 	server.RegisterHttpFunc("/cgr", cgrCdrHandler)
 	server.RegisterHttpFunc("/freeswitch_json", fsCdrHandler)
+	server.RegisterHttpFunc("/cdr_json", jsonCdrHandler)
 }
 
 // Used to internally process CDR
 func (cdrs *CDRS) ProcessRawCdr(rawCdr utils.RawCDR) error {
-	return storeAndMediate(rawCdr)
+	_, err := processCdr(rawCdr)
+	return err
+}
+
+// Reloads the partial CDRs buffered before the last shutdown or crash, called on start
+func (cdrs *CDRS) LoadPartialCdrs() error {
+	if partials != nil {
+		return partials.load()
+	}
+	return nil
+}
+
+// Merges and stores the partial CDRs still waiting for their final record, called on shutdown
+func (cdrs *CDRS) FlushPartialCdrs() {
+	if partials != nil {
+		partials.flush()
+	}
 }
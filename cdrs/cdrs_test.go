@@ -0,0 +1,145 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrs
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func newTestCdrs() {
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	cdrDb, _ := engine.NewMapStorage()
+	New(cdrDb, nil, cgrCfg)
+}
+
+func TestCgrCdrHandlerReplies(t *testing.T) {
+	newTestCdrs()
+	form := url.Values{utils.ACCID: []string{"dsafdsaf"}, utils.CDRHOST: []string{"192.168.1.1"}, utils.CDRSOURCE: []string{"test"}}
+	for _, eStatus := range []string{"OK", utils.ERR_DUPLICATE} {
+		req, _ := http.NewRequest("POST", "/cgr", bytes.NewBufferString(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		cgrCdrHandler(w, req)
+		var reply CdrReply
+		if w.Code != http.StatusOK {
+			t.Errorf("Unexpected status code: %d", w.Code)
+		} else if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+			t.Error(err)
+		} else if reply.Status != eStatus || reply.CgrId != utils.FSCgrId("dsafdsaf") {
+			t.Errorf("Unexpected reply: %+v", reply)
+		}
+	}
+}
+
+func TestCgrCdrHandlerOtherOrigin(t *testing.T) {
+	newTestCdrs()
+	for _, tc := range []struct {
+		cdrHost, eStatus, eCgrId string
+	}{
+		{"192.168.1.1", "OK", utils.FSCgrId("dsafdsaf")},
+		{"192.168.1.2", "OK", utils.OriginCgrId("dsafdsaf", "192.168.1.2", "test")}, // Same accid out of another switch
+		{"192.168.1.2", utils.ERR_DUPLICATE, utils.OriginCgrId("dsafdsaf", "192.168.1.2", "test")},
+		{"192.168.1.1", utils.ERR_DUPLICATE, utils.FSCgrId("dsafdsaf")},
+	} {
+		form := url.Values{utils.ACCID: []string{"dsafdsaf"}, utils.CDRHOST: []string{tc.cdrHost}, utils.CDRSOURCE: []string{"test"}}
+		req, _ := http.NewRequest("POST", "/cgr", bytes.NewBufferString(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		cgrCdrHandler(w, req)
+		var reply CdrReply
+		if w.Code != http.StatusOK {
+			t.Errorf("Unexpected status code: %d", w.Code)
+		} else if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil {
+			t.Error(err)
+		} else if reply.Status != tc.eStatus || reply.CgrId != tc.eCgrId {
+			t.Errorf("Unexpected reply: %+v", reply)
+		}
+	}
+	if cdrs, _, err := storage.GetCdrs(&utils.CdrsFilter{AccIds: []string{"dsafdsaf"}}); err != nil {
+		t.Error(err)
+	} else if len(cdrs) != 2 {
+		t.Error("Unexpected CDRs: ", cdrs)
+	}
+}
+
+func TestJsonCdrHandler(t *testing.T) {
+	newTestCdrs()
+	cdr := &utils.StoredCdr{AccId: "dsafdsaf", CdrHost: "192.168.1.1", CdrSource: "test", ReqType: utils.RATED, Direction: "*out", Tenant: "cgrates.org",
+		TOR: "call", Account: "1001", Subject: "1001", Destination: "1002", AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC),
+		Duration: time.Duration(10) * time.Second}
+	body, _ := json.Marshal(cdr)
+	w := httptest.NewRecorder()
+	jsonCdrHandler(w, httptest.NewRequest("POST", "/cdr_json", bytes.NewBuffer(body)))
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code: %d, body: %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	jsonCdrHandler(w, httptest.NewRequest("POST", "/cdr_json", bytes.NewBufferString("{invalid")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code: %d", w.Code)
+	}
+	cdrMissing := *cdr
+	cdrMissing.AccId = "accid2"
+	cdrMissing.Tenant = ""
+	w = httptest.NewRecorder()
+	body, _ = json.Marshal(&cdrMissing)
+	jsonCdrHandler(w, httptest.NewRequest("POST", "/cdr_json", bytes.NewBuffer(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Unexpected status code: %d", w.Code)
+	}
+	// Batch with one duplicate, one invalid and one new CDR
+	cdrNew := *cdr
+	cdrNew.AccId = "accid3"
+	body, _ = json.Marshal([]*utils.StoredCdr{cdr, &cdrMissing, &cdrNew})
+	w = httptest.NewRecorder()
+	jsonCdrHandler(w, httptest.NewRequest("POST", "/cdr_json", bytes.NewBuffer(body)))
+	var replies []*CdrReply
+	if w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code: %d", w.Code)
+	} else if err := json.Unmarshal(w.Body.Bytes(), &replies); err != nil {
+		t.Error(err)
+	} else if len(replies) != 3 || replies[0].Status != utils.ERR_DUPLICATE || replies[1].Status != utils.ERR_MANDATORY_IE_MISSING ||
+		replies[2].Status != "OK" || replies[2].CgrId != utils.FSCgrId("accid3") {
+		t.Errorf("Unexpected replies: %+v", replies)
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	for statusCode, body := range map[int]string{http.StatusOK: `{"Status":"DUPLICATE"}`, http.StatusConflict: `{"Status":"EXISTS","Error":"EXISTS:cgrid"}`} {
+		w := httptest.NewRecorder()
+		w.WriteHeader(statusCode)
+		w.Body.WriteString(body)
+		err := ReplyError(w.Result())
+		if statusCode == http.StatusOK && err != engine.ErrDuplicateCdr {
+			t.Error("Expecting duplicate, received: ", err)
+		} else if statusCode == http.StatusConflict && (err == nil || err.Error() != "EXISTS:cgrid") {
+			t.Error("Unexpected error: ", err)
+		}
+	}
+}
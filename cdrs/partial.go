@@ -0,0 +1,408 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package cdrs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	PARTIAL_RECORD_EXT = ".json" // Partial records buffered
+	PARTIAL_TMP_EXT    = ".tmp"  // Partial records being written
+)
+
+// One partial record of a call, with its sequence number when configured
+type partialRecord struct {
+	Seq  int
+	Cdr  *utils.StoredCdr
+	file string // Name of the file keeping the record on disk
+}
+
+type partialRecordsBySeq []*partialRecord
+
+func (recs partialRecordsBySeq) Len() int           { return len(recs) }
+func (recs partialRecordsBySeq) Swap(i, j int)      { recs[i], recs[j] = recs[j], recs[i] }
+func (recs partialRecordsBySeq) Less(i, j int) bool { return recs[i].Seq < recs[j].Seq }
+
+// The partial records received so far for one call
+type partialCall struct {
+	records []*partialRecord
+	timer   *time.Timer // Merges the records when the final one does not arrive, forgets them once merged
+	cgrId   string      // Cgrid of the CDR stored out of the records merged on timeout
+}
+
+// Returns the record buffered which the new one retransmits: same sequence number when configured, otherwise same answer time and duration
+func (call *partialCall) retransmitted(rec *partialRecord, hasSeq bool) *partialRecord {
+	for _, buffered := range call.records {
+		if hasSeq && buffered.Seq == rec.Seq {
+			return buffered
+		}
+		if !hasSeq && buffered.Cdr.AnswerTime.Equal(rec.Cdr.AnswerTime) && buffered.Cdr.Duration == rec.Cdr.Duration {
+			return buffered
+		}
+	}
+	return nil
+}
+
+// Buffers the partial records of long calls, indexed on AccId, until their final record or the timeout.
+// The records are written to disk before being acknowledged, one folder per call, so they can be reloaded after a restart.
+// The records merged on timeout are kept in memory for one more timeout, the ones arriving late updating the CDR stored out of them.
+type partialCdrs struct {
+	flagField string
+	seqField  string
+	timeout   time.Duration
+	dir       string
+	mux       sync.Mutex // Guards the maps only, the disk is accessed without holding it
+	calls     map[string]*partialCall
+	merged    map[string]*partialCall                  // Calls stored on timeout, waiting for late records
+	lastFile  int64                                    // Keeps the record file names unique and ordered
+	store     func(utils.RawCDR) (utils.RawCDR, error) // Stores the complete CDRs
+	update    func(utils.RawCDR) (utils.RawCDR, error) // Overwrites the CDRs stored on timeout
+}
+
+func newPartialCdrs(flagField, seqField string, timeout time.Duration, dir string, store, update func(utils.RawCDR) (utils.RawCDR, error)) *partialCdrs {
+	return &partialCdrs{flagField: flagField, seqField: seqField, timeout: timeout, dir: dir, calls: make(map[string]*partialCall),
+		merged: make(map[string]*partialCall), store: store, update: update}
+}
+
+func (pc *partialCdrs) callDir(accId string) string {
+	return path.Join(pc.dir, utils.SHA1(accId))
+}
+
+// Names the file of the record, reusing the one of the record it retransmits. Called with the mutex held.
+func (pc *partialCdrs) nameFile(rec *partialRecord, replaced *partialRecord) {
+	if replaced != nil {
+		rec.file = replaced.file
+		return
+	}
+	fileIdx := time.Now().UnixNano()
+	if fileIdx <= pc.lastFile {
+		fileIdx = pc.lastFile + 1
+	}
+	pc.lastFile = fileIdx
+	rec.file = fmt.Sprintf("%020d%s", fileIdx, PARTIAL_RECORD_EXT)
+}
+
+// Writes the record to disk under its file name
+func (pc *partialCdrs) persist(accId string, rec *partialRecord) error {
+	content, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	callDir := pc.callDir(accId)
+	if err := os.MkdirAll(callDir, 0755); err != nil {
+		return err
+	}
+	filePath := path.Join(callDir, rec.file)
+	// Write under temporary name so a crash does not leave incomplete records behind
+	if err := ioutil.WriteFile(filePath+PARTIAL_TMP_EXT, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filePath+PARTIAL_TMP_EXT, filePath)
+}
+
+// Removes the files of the records once their CDR is stored. The folder stays when records of a newer call with the same AccId were written meanwhile.
+func (pc *partialCdrs) remove(accId string, call *partialCall) {
+	callDir := pc.callDir(accId)
+	for _, rec := range call.records {
+		if err := os.Remove(path.Join(callDir, rec.file)); err != nil && !os.IsNotExist(err) {
+			engine.Logger.Err(fmt.Sprintf("<CDRS> Could not remove partial CDR file, error: %s", err.Error()))
+		}
+	}
+	os.Remove(callDir)
+}
+
+// Reloads the partials left on disk by a previous run, their timeout starting over
+func (pc *partialCdrs) load() error {
+	if err := os.MkdirAll(pc.dir, 0755); err != nil {
+		return err
+	}
+	dirInfos, err := ioutil.ReadDir(pc.dir)
+	if err != nil {
+		return err
+	}
+	calls := make(map[string]*partialCall)
+	for _, di := range dirInfos {
+		if !di.IsDir() {
+			continue
+		}
+		fileInfos, err := ioutil.ReadDir(path.Join(pc.dir, di.Name())) // Sorted by name, so in the order received
+		if err != nil {
+			return err
+		}
+		call := new(partialCall)
+		for _, fi := range fileInfos {
+			if !strings.HasSuffix(fi.Name(), PARTIAL_RECORD_EXT) {
+				continue
+			}
+			content, err := ioutil.ReadFile(path.Join(pc.dir, di.Name(), fi.Name()))
+			if err != nil {
+				return err
+			}
+			rec := &partialRecord{file: fi.Name()}
+			if err := json.Unmarshal(content, rec); err != nil || rec.Cdr == nil {
+				engine.Logger.Err(fmt.Sprintf("<CDRS> Ignoring invalid partial CDR file %s", path.Join(pc.dir, di.Name(), fi.Name())))
+				continue
+			}
+			call.records = append(call.records, rec)
+		}
+		if len(call.records) == 0 {
+			continue
+		}
+		calls[call.records[0].Cdr.AccId] = call
+	}
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	for accId, call := range calls {
+		accId, call := accId, call
+		pc.calls[accId] = call
+		call.timer = time.AfterFunc(pc.timeout, func() { pc.expire(accId, call) })
+	}
+	if len(calls) != 0 {
+		engine.Logger.Info(fmt.Sprintf("<CDRS> Reloaded partial CDRs of %d calls", len(calls)))
+	}
+	return nil
+}
+
+// Returns the CDR as stored. Partial records are buffered and returned as received, once written to disk.
+// The final record of a call with partials buffered is stored merged with them, the records of a call merged on timeout update its CDR.
+func (pc *partialCdrs) process(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+	extraFields := rawCdr.GetExtraFields()
+	partial := false
+	if flag := extraFields[pc.flagField]; flag != "" {
+		var err error
+		if partial, err = strconv.ParseBool(flag); err != nil {
+			return rawCdr, fmt.Errorf("%s:%s:%s", utils.ERR_INVALID_CDR, pc.flagField, flag)
+		}
+	}
+	rec := new(partialRecord)
+	hasSeq := pc.seqField != "" && extraFields[pc.seqField] != ""
+	if hasSeq {
+		var err error
+		if rec.Seq, err = strconv.Atoi(extraFields[pc.seqField]); err != nil {
+			return rawCdr, fmt.Errorf("%s:%s:%s", utils.ERR_INVALID_CDR, pc.seqField, extraFields[pc.seqField])
+		}
+	}
+	accId := rawCdr.GetAccId()
+	pc.mux.Lock()
+	call, buffered := pc.calls[accId]
+	mergedCall, merged := pc.merged[accId]
+	if !partial && !buffered && !merged { // Calls without partials are stored as received
+		pc.mux.Unlock()
+		return pc.store(rawCdr)
+	}
+	var err error
+	if rec.Cdr, err = utils.NewStoredCdrFromRawCDR(rawCdr); err != nil {
+		pc.mux.Unlock()
+		return rawCdr, fmt.Errorf("%s:%s", utils.ERR_INVALID_CDR, err.Error())
+	}
+	if !buffered && merged { // Late record, stored alone it would be taken for a duplicate of the merged CDR
+		mergedCall.timer.Stop()
+		delete(pc.merged, accId)
+		pc.mux.Unlock()
+		return pc.updateMerged(accId, mergedCall, rec, hasSeq)
+	}
+	if !buffered {
+		call = new(partialCall)
+	}
+	replaced := call.retransmitted(rec, hasSeq)
+	if !partial {
+		call.timer.Stop()
+		delete(pc.calls, accId)
+		pc.mux.Unlock()
+		records := append(withoutRecord(call.records, replaced), rec)
+		storedCdr, err := pc.store(mergePartialRecords(records, pc.seqField != ""))
+		if err != nil && err != engine.ErrDuplicateCdr { // Final record not acknowledged, it will be resent
+			pc.requeue(accId, call)
+			return rawCdr, err
+		}
+		pc.remove(accId, call)
+		return storedCdr, err
+	}
+	if replaced != nil && !hasSeq { // Retransmission of a record already written
+		pc.mux.Unlock()
+		return rawCdr, nil
+	}
+	pc.nameFile(rec, replaced)
+	pc.mux.Unlock()
+	if err := pc.persist(accId, rec); err != nil {
+		return rawCdr, fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
+	}
+	if orphan := pc.buffer(accId, rec, hasSeq); orphan != "" {
+		os.Remove(path.Join(pc.callDir(accId), orphan))
+	}
+	return rawCdr, nil
+}
+
+// Adds the record written to disk to its call, looked up again since the call could have been merged meanwhile.
+// Returns the file of the record replaced when the retransmission of the record was written under another name.
+func (pc *partialCdrs) buffer(accId string, rec *partialRecord, hasSeq bool) (orphan string) {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	call, buffered := pc.calls[accId]
+	if buffered {
+		call.timer.Stop()
+	} else {
+		call = new(partialCall)
+		pc.calls[accId] = call
+	}
+	if replaced := call.retransmitted(rec, hasSeq); replaced != nil {
+		call.records[indexOfRecord(call.records, replaced)] = rec
+		if replaced.file != rec.file {
+			orphan = replaced.file
+		}
+	} else {
+		call.records = append(call.records, rec)
+	}
+	call.timer = time.AfterFunc(pc.timeout, func() { pc.expire(accId, call) })
+	return
+}
+
+// Updates the CDR stored out of the records merged on timeout with a record arriving late.
+// The records stay in memory only, waiting for the following ones until the timeout passes without any.
+func (pc *partialCdrs) updateMerged(accId string, call *partialCall, rec *partialRecord, hasSeq bool) (utils.RawCDR, error) {
+	call.records = append(withoutRecord(call.records, call.retransmitted(rec, hasSeq)), rec)
+	merged := mergePartialRecords(call.records, pc.seqField != "")
+	merged.CgrId = call.cgrId
+	updatedCdr, err := pc.update(merged)
+	pc.mux.Lock()
+	pc.merged[accId] = call
+	call.timer = time.AfterFunc(pc.timeout, func() { pc.forget(accId, call) })
+	pc.mux.Unlock()
+	return updatedCdr, err
+}
+
+// Drops the records merged on timeout once no more late records are expected
+func (pc *partialCdrs) forget(accId string, call *partialCall) {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	if pc.merged[accId] == call {
+		delete(pc.merged, accId)
+	}
+}
+
+// Buffers again the records of a call whose merged CDR could not be stored, together with the ones received meanwhile
+func (pc *partialCdrs) requeue(accId string, call *partialCall) {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	if newer, has := pc.calls[accId]; has {
+		newer.records = append(call.records, newer.records...)
+		return
+	}
+	pc.calls[accId] = call
+	call.timer = time.AfterFunc(pc.timeout, func() { pc.expire(accId, call) })
+}
+
+// Stores the partials of a call whose final record did not arrive in time
+func (pc *partialCdrs) expire(accId string, call *partialCall) {
+	pc.mux.Lock()
+	if pc.calls[accId] != call { // Final record processed meanwhile
+		pc.mux.Unlock()
+		return
+	}
+	delete(pc.calls, accId)
+	pc.mux.Unlock()
+	engine.Logger.Warning(fmt.Sprintf("<CDRS> No final record received for partial CDRs with accid: %s, merging %d partials", accId, len(call.records)))
+	cgrId, stored := pc.storeMerged(accId, call)
+	if !stored {
+		pc.requeue(accId, call)
+		return
+	}
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	call.cgrId = cgrId
+	pc.merged[accId] = call
+	call.timer = time.AfterFunc(pc.timeout, func() { pc.forget(accId, call) })
+}
+
+// Stores the merged CDR and removes the records from disk, returns the cgrid it was stored under and false when it could not be stored
+func (pc *partialCdrs) storeMerged(accId string, call *partialCall) (string, bool) {
+	merged := mergePartialRecords(call.records, pc.seqField != "")
+	storedCdr, err := pc.store(merged)
+	if err != nil && err != engine.ErrDuplicateCdr {
+		engine.Logger.Err(fmt.Sprintf("<CDRS> Could not store merged partial CDRs with cgrid: %s, error: %s", merged.CgrId, err.Error()))
+		return "", false
+	}
+	pc.remove(accId, call)
+	return storedCdr.GetCgrId(), true
+}
+
+// Merges and stores the partials of all calls, used on shutdown. The ones failing to store stay on disk for the next start.
+func (pc *partialCdrs) flush() {
+	pc.mux.Lock()
+	calls := pc.calls
+	pc.calls = make(map[string]*partialCall)
+	pc.mux.Unlock()
+	for accId, call := range calls {
+		call.timer.Stop()
+		pc.storeMerged(accId, call)
+	}
+}
+
+func indexOfRecord(records []*partialRecord, rec *partialRecord) int {
+	for idx, buffered := range records {
+		if buffered == rec {
+			return idx
+		}
+	}
+	return -1
+}
+
+func withoutRecord(records []*partialRecord, rec *partialRecord) []*partialRecord {
+	filtered := make([]*partialRecord, 0, len(records))
+	for _, buffered := range records {
+		if buffered != rec {
+			filtered = append(filtered, buffered)
+		}
+	}
+	return filtered
+}
+
+// Builds one CDR out of the partial records of a call: primary fields out of the last record, earliest answer time,
+// summed duration and the extra fields of all records, the later ones overwriting the earlier.
+func mergePartialRecords(records []*partialRecord, ordered bool) *utils.StoredCdr {
+	if ordered {
+		sort.Stable(partialRecordsBySeq(records))
+	}
+	last := records[len(records)-1].Cdr
+	merged := *last
+	merged.Duration = 0
+	merged.ExtraFields = make(map[string]string)
+	for _, rec := range records {
+		if !rec.Cdr.AnswerTime.IsZero() && (merged.AnswerTime.IsZero() || rec.Cdr.AnswerTime.Before(merged.AnswerTime)) {
+			merged.AnswerTime = rec.Cdr.AnswerTime
+		}
+		merged.Duration += rec.Cdr.Duration
+		for fld, val := range rec.Cdr.ExtraFields {
+			merged.ExtraFields[fld] = val
+		}
+	}
+	return &merged
+}
@@ -0,0 +1,250 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package cdrs
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+func newPartialCgrCdr(seq, partial, duration, answerTime string) utils.CgrCdr {
+	form := url.Values{utils.ACCID: []string{"longcall"}, utils.CDRHOST: []string{"192.168.1.1"}, utils.CDRSOURCE: []string{"test"},
+		utils.REQTYPE: []string{utils.RATED}, utils.DIRECTION: []string{"*out"}, utils.TENANT: []string{"cgrates.org"}, utils.TOR: []string{"call"},
+		utils.ACCOUNT: []string{"1001"}, utils.SUBJECT: []string{"1001"}, utils.DESTINATION: []string{"1002"}, utils.ANSWER_TIME: []string{answerTime},
+		utils.DURATION: []string{duration}, "seq": []string{seq}, "partial": []string{partial}, "field_" + seq: []string{seq}}
+	cgrCdr := make(utils.CgrCdr)
+	for fld, vals := range form {
+		cgrCdr[fld] = vals[0]
+	}
+	return cgrCdr
+}
+
+func newTestPartialCdrs(t *testing.T, seqField string, timeout time.Duration, store func(utils.RawCDR) (utils.RawCDR, error)) *partialCdrs {
+	partialDir, err := ioutil.TempDir("", "cdrs_partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newPartialCdrs("partial", seqField, timeout, partialDir, store, nil)
+}
+
+// Returns the number of partial records kept on disk
+func partialFilesOnDisk(t *testing.T, pc *partialCdrs) int {
+	count := 0
+	dirInfos, err := ioutil.ReadDir(pc.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, di := range dirInfos {
+		fileInfos, err := ioutil.ReadDir(path.Join(pc.dir, di.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		count += len(fileInfos)
+	}
+	return count
+}
+
+func TestPartialCdrsMerge(t *testing.T) {
+	var stored []utils.RawCDR
+	pc := newTestPartialCdrs(t, "seq", time.Duration(1)*time.Hour, func(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+		stored = append(stored, rawCdr)
+		return rawCdr, nil
+	})
+	defer os.RemoveAll(pc.dir)
+	single := newPartialCgrCdr("1", "false", "10", "2013-11-07T08:42:26Z")
+	single["accid"] = "shortcall"
+	if rawCdr, err := pc.process(single); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(rawCdr, single) || len(stored) != 1 {
+		t.Errorf("Unexpected CDR: %+v", rawCdr)
+	}
+	for _, cgrCdr := range []utils.CgrCdr{newPartialCgrCdr("2", "true", "1800", "2013-11-07T09:12:26Z"),
+		newPartialCgrCdr("1", "true", "1800", "2013-11-07T08:42:26Z"), newPartialCgrCdr("2", "1", "1800", "2013-11-07T09:12:26Z")} {
+		if _, err := pc.process(cgrCdr); err != nil || len(stored) != 1 {
+			t.Errorf("Partial not buffered, cdr: %+v, error: %v", cgrCdr, err)
+		}
+	}
+	if files := partialFilesOnDisk(t, pc); files != 2 {
+		t.Errorf("Expecting 2 partials on disk, have: %d", files)
+	}
+	if _, err := pc.process(newPartialCgrCdr("3", "invalid", "10", "2013-11-07T09:42:26Z")); err == nil {
+		t.Error("Expecting error on invalid partial flag")
+	}
+	rawCdr, err := pc.process(newPartialCgrCdr("3", "false", "300", "2013-11-07T09:42:26Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := rawCdr.(*utils.StoredCdr)
+	if merged.CgrId != utils.FSCgrId("longcall") || merged.Duration != time.Duration(3900)*time.Second ||
+		!merged.AnswerTime.Equal(time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC)) {
+		t.Errorf("Unexpected merged CDR: %+v", merged)
+	}
+	eExtraFields := map[string]string{"seq": "3", "partial": "false", "field_1": "1", "field_2": "2", "field_3": "3"}
+	if !reflect.DeepEqual(merged.ExtraFields, eExtraFields) {
+		t.Errorf("Unexpected extra fields: %+v", merged.ExtraFields)
+	}
+	if len(pc.calls) != 0 || len(stored) != 2 {
+		t.Errorf("Unexpected calls buffered: %d, stored: %d", len(pc.calls), len(stored))
+	}
+	if files := partialFilesOnDisk(t, pc); files != 0 {
+		t.Errorf("Partials left on disk: %d", files)
+	}
+}
+
+func TestPartialCdrsRetransmitWithoutSeq(t *testing.T) {
+	var stored []utils.RawCDR
+	pc := newTestPartialCdrs(t, "", time.Duration(1)*time.Hour, func(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+		stored = append(stored, rawCdr)
+		return rawCdr, nil
+	})
+	defer os.RemoveAll(pc.dir)
+	for _, cgrCdr := range []utils.CgrCdr{newPartialCgrCdr("1", "true", "1800", "2013-11-07T08:42:26Z"),
+		newPartialCgrCdr("1", "true", "1800", "2013-11-07T08:42:26Z")} {
+		if _, err := pc.process(cgrCdr); err != nil {
+			t.Error(err)
+		}
+	}
+	rawCdr, err := pc.process(newPartialCgrCdr("2", "false", "300", "2013-11-07T09:12:26Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged := rawCdr.(*utils.StoredCdr); merged.Duration != time.Duration(2100)*time.Second {
+		t.Errorf("Retransmitted partial merged twice: %+v", merged)
+	}
+}
+
+func TestPartialCdrsReload(t *testing.T) {
+	storeErr := errors.New("SERVER_ERROR")
+	pc := newTestPartialCdrs(t, "seq", time.Duration(1)*time.Hour, func(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+		return rawCdr, storeErr
+	})
+	defer os.RemoveAll(pc.dir)
+	for _, cgrCdr := range []utils.CgrCdr{newPartialCgrCdr("1", "true", "1800", "2013-11-07T08:42:26Z"),
+		newPartialCgrCdr("2", "true", "1800", "2013-11-07T09:12:26Z")} {
+		if _, err := pc.process(cgrCdr); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := pc.process(newPartialCgrCdr("3", "false", "300", "2013-11-07T09:42:26Z")); err != storeErr {
+		t.Errorf("Expecting store error, received: %v", err)
+	}
+	if len(pc.calls["longcall"].records) != 2 {
+		t.Error("Partials not buffered again after failing to store")
+	}
+	// Crash, the partials are reloaded out of disk by the next run
+	var stored []utils.RawCDR
+	reloaded := newPartialCdrs("partial", "seq", time.Duration(1)*time.Hour, pc.dir, func(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+		stored = append(stored, rawCdr)
+		return rawCdr, nil
+	}, nil)
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	rawCdr, err := reloaded.process(newPartialCgrCdr("3", "false", "300", "2013-11-07T09:42:26Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged := rawCdr.(*utils.StoredCdr); merged.Duration != time.Duration(3900)*time.Second || merged.ExtraFields["field_1"] != "1" {
+		t.Errorf("Unexpected merged CDR: %+v", merged)
+	}
+	if files := partialFilesOnDisk(t, reloaded); files != 0 {
+		t.Errorf("Partials left on disk: %d", files)
+	}
+}
+
+func TestPartialCdrsTimeout(t *testing.T) {
+	storedChan := make(chan utils.RawCDR, 1)
+	pc := newTestPartialCdrs(t, "", time.Duration(10)*time.Millisecond, func(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+		storedChan <- rawCdr
+		return rawCdr, nil
+	})
+	defer os.RemoveAll(pc.dir)
+	for _, cgrCdr := range []utils.CgrCdr{newPartialCgrCdr("1", "true", "1800", "2013-11-07T08:42:26Z"),
+		newPartialCgrCdr("2", "true", "1800", "2013-11-07T09:12:26Z")} {
+		if _, err := pc.process(cgrCdr); err != nil {
+			t.Errorf("Partial not buffered, cdr: %+v, error: %v", cgrCdr, err)
+		}
+	}
+	select {
+	case rawCdr := <-storedChan:
+		if merged := rawCdr.(*utils.StoredCdr); merged.Duration != time.Duration(3600)*time.Second || merged.ExtraFields["partial"] != "true" {
+			t.Errorf("Unexpected merged CDR: %+v", merged)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Partials not merged on timeout")
+	}
+	// Records arriving late update the merged CDR instead of being stored as duplicates
+	var updated []*utils.StoredCdr
+	pc.update = func(rawCdr utils.RawCDR) (utils.RawCDR, error) {
+		updated = append(updated, rawCdr.(*utils.StoredCdr))
+		return rawCdr, nil
+	}
+	for i := 0; ; i++ { // Kept once stored
+		pc.mux.Lock()
+		mergedCall := pc.merged["longcall"]
+		if mergedCall != nil {
+			mergedCall.timer.Stop() // Keep them until the final record
+		}
+		pc.mux.Unlock()
+		if mergedCall != nil {
+			break
+		} else if i == 100 {
+			t.Fatal("Merged partials not kept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, cgrCdr := range []utils.CgrCdr{newPartialCgrCdr("3", "true", "1800", "2013-11-07T09:42:26Z"),
+		newPartialCgrCdr("4", "false", "600", "2013-11-07T10:12:26Z")} {
+		if _, err := pc.process(cgrCdr); err != nil {
+			t.Errorf("Late record not processed, cdr: %+v, error: %v", cgrCdr, err)
+		}
+	}
+	if len(updated) != 2 || updated[0].Duration != time.Duration(5400)*time.Second || updated[1].Duration != time.Duration(6000)*time.Second ||
+		updated[1].CgrId != utils.FSCgrId("longcall") || updated[1].ExtraFields["partial"] != "false" {
+		t.Errorf("Unexpected updates: %+v", updated)
+	}
+	pc.flush()
+	select {
+	case rawCdr := <-storedChan:
+		t.Errorf("Late records stored: %+v", rawCdr)
+	default:
+	}
+	if partialFilesOnDisk(t, pc) != 0 {
+		t.Error("Late records written to disk")
+	}
+	// Once forgotten, the records are buffered as a new call
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	pc.process(newPartialCgrCdr("5", "true", "1800", "2013-11-07T10:42:26Z"))
+	pc.flush()
+	select {
+	case rawCdr := <-storedChan:
+		if merged := rawCdr.(*utils.StoredCdr); merged.Duration != time.Duration(1800)*time.Second {
+			t.Errorf("Unexpected flushed CDR: %+v", merged)
+		}
+	default:
+		t.Error("Partials not merged on flush")
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	REPLICATION_QUEUED_EXT = ".json"   // CDRs waiting to be replicated
+	REPLICATION_TMP_EXT    = ".tmp"    // CDRs being written to the queue
+	REPLICATION_FAILED_EXT = ".failed" // CDRs refused by the target, kept for manual processing
+)
+
+// Status of one replication target, as reported over the API
+type CdrReplicationStatus struct {
+	TargetId      string
+	Address       string
+	Queued        int   // CDRs waiting on disk to be replicated
+	Replicated    int64 // CDRs replicated since start
+	Failed        int64 // CDRs refused by the target since start
+	LastError     string
+	LastErrorTime time.Time
+}
+
+// Copies the raw CDRs to a remote CDRS, queueing them on disk until delivered so they survive outages and restarts
+type CdrReplicator struct {
+	cfg           *config.CdrReplicationConfig
+	queueDir      string
+	retryInterval time.Duration
+	httpClient    *http.Client
+	queued        chan struct{} // Wakes up the sender on new CDRs
+	mux           sync.RWMutex  // Protects the counters and queue file names
+	lastQueued    int64         // Keeps the queue file names unique and ordered
+	replicated    int64
+	failed        int64
+	lastError     string
+	lastErrorTime time.Time
+}
+
+func NewCdrReplicator(rplCfg *config.CdrReplicationConfig, replicationDir string, retryInterval, timeout time.Duration) (*CdrReplicator, error) {
+	queueDir := path.Join(replicationDir, rplCfg.Id)
+	if err := os.MkdirAll(queueDir, 0755); err != nil {
+		return nil, err
+	}
+	return &CdrReplicator{cfg: rplCfg, queueDir: queueDir, retryInterval: retryInterval, httpClient: &http.Client{Timeout: timeout}, // A hanging target would block the queue otherwise
+		queued: make(chan struct{}, 1)}, nil
+}
+
+func (rpl *CdrReplicator) acceptsCdr(cdr *utils.StoredCdr) bool {
+	if len(rpl.cfg.Tenants) != 0 && !utils.IsSliceMember(rpl.cfg.Tenants, cdr.Tenant) {
+		return false
+	}
+	if len(rpl.cfg.ReqTypes) != 0 && !utils.IsSliceMember(rpl.cfg.ReqTypes, cdr.ReqType) {
+		return false
+	}
+	return true
+}
+
+// Queues the CDR on disk and wakes up the sender
+func (rpl *CdrReplicator) Replicate(cdr *utils.StoredCdr) error {
+	if !rpl.acceptsCdr(cdr) {
+		return nil
+	}
+	content, err := json.Marshal(cdr)
+	if err != nil {
+		return err
+	}
+	rpl.mux.Lock()
+	queueIdx := time.Now().UnixNano()
+	if queueIdx <= rpl.lastQueued {
+		queueIdx = rpl.lastQueued + 1
+	}
+	rpl.lastQueued = queueIdx
+	rpl.mux.Unlock()
+	filePath := path.Join(rpl.queueDir, fmt.Sprintf("%020d_%s", queueIdx, cdr.CgrId))
+	// Write under temporary name so the sender does not pick up incomplete files
+	if err := writeSynced(filePath+REPLICATION_TMP_EXT, content); err != nil {
+		return err
+	}
+	if err := os.Rename(filePath+REPLICATION_TMP_EXT, filePath+REPLICATION_QUEUED_EXT); err != nil {
+		return err
+	}
+	if err := syncDir(rpl.queueDir); err != nil { // Rename persisted too, so the CDR is not lost on a crash
+		return err
+	}
+	select {
+	case rpl.queued <- struct{}{}:
+	default: // Sender already notified
+	}
+	return nil
+}
+
+// Writes the content and flushes it to disk before returning
+func writeSynced(filePath string, content []byte) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Flushes to disk the changes of the directory entries, eg: renames
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Sends the queued CDRs, retrying at interval while the target is unreachable
+func (rpl *CdrReplicator) Run() {
+	for {
+		if err := rpl.sendQueued(); err != nil {
+			engine.Logger.Warning(fmt.Sprintf("<CDRS> Replication to %s failed, retrying in %v, error: %s", rpl.cfg.Id, rpl.retryInterval, err.Error()))
+			select {
+			case <-time.After(rpl.retryInterval):
+			case <-rpl.queued:
+				time.Sleep(rpl.retryInterval) // Do not hammer the target on each new CDR
+			}
+			continue
+		}
+		<-rpl.queued
+	}
+}
+
+// Returns the file names of the queued CDRs, in the order they were received
+func (rpl *CdrReplicator) queuedFiles() ([]string, error) {
+	fileInfos, err := ioutil.ReadDir(rpl.queueDir) // Sorted by name
+	if err != nil {
+		return nil, err
+	}
+	fileNames := make([]string, 0)
+	for _, fi := range fileInfos {
+		if strings.HasSuffix(fi.Name(), REPLICATION_QUEUED_EXT) {
+			fileNames = append(fileNames, fi.Name())
+		}
+	}
+	return fileNames, nil
+}
+
+// Sends the CDRs queued on disk, returns error if the target could not process them at this time
+func (rpl *CdrReplicator) sendQueued() error {
+	fileNames, err := rpl.queuedFiles()
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		filePath := path.Join(rpl.queueDir, fileName)
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		cdr := new(utils.StoredCdr)
+		if err := json.Unmarshal(content, cdr); err != nil {
+			rpl.setFailed(filePath, err)
+			continue
+		}
+		if retry, err := rpl.post(cdr); err != nil && err != engine.ErrDuplicateCdr {
+			if retry {
+				rpl.setLastError(err)
+				return err
+			}
+			rpl.setFailed(filePath, err)
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			return err
+		}
+		rpl.mux.Lock()
+		rpl.replicated += 1
+		rpl.mux.Unlock()
+	}
+	return nil
+}
+
+// Posts the CDR to the target, retry is true when the target could not process the CDR at this time
+func (rpl *CdrReplicator) post(cdr *utils.StoredCdr) (retry bool, err error) {
+	var resp *http.Response
+	if rpl.cfg.Transport == utils.HTTP_JSON {
+		body, errMarshal := json.Marshal(cdr)
+		if errMarshal != nil {
+			return false, errMarshal
+		}
+		resp, err = rpl.httpClient.Post(fmt.Sprintf("http://%s/cdr_json", rpl.cfg.Address), "application/json", bytes.NewBuffer(body))
+	} else {
+		resp, err = rpl.httpClient.PostForm(fmt.Sprintf("http://%s/cgr", rpl.cfg.Address), cdr.AsRawCdrHttpForm())
+	}
+	if err != nil {
+		return true, err
+	}
+	return resp.StatusCode >= http.StatusInternalServerError, ReplyError(resp)
+}
+
+func (rpl *CdrReplicator) setLastError(err error) {
+	rpl.mux.Lock()
+	defer rpl.mux.Unlock()
+	rpl.lastError = err.Error()
+	rpl.lastErrorTime = time.Now()
+}
+
+// Keeps the CDR refused by target out of the queue
+func (rpl *CdrReplicator) setFailed(filePath string, err error) {
+	engine.Logger.Err(fmt.Sprintf("<CDRS> Replication to %s refused CDR in %s, error: %s", rpl.cfg.Id, filePath, err.Error()))
+	rpl.setLastError(err)
+	if errRename := os.Rename(filePath, strings.TrimSuffix(filePath, REPLICATION_QUEUED_EXT)+REPLICATION_FAILED_EXT); errRename != nil {
+		engine.Logger.Err(fmt.Sprintf("<CDRS> Could not move failed CDR out of replication queue, error: %s", errRename.Error()))
+	}
+	rpl.mux.Lock()
+	rpl.failed += 1
+	rpl.mux.Unlock()
+}
+
+func (rpl *CdrReplicator) GetStatus() *CdrReplicationStatus {
+	rpl.mux.RLock()
+	defer rpl.mux.RUnlock()
+	status := &CdrReplicationStatus{TargetId: rpl.cfg.Id, Address: rpl.cfg.Address, Replicated: rpl.replicated, Failed: rpl.failed,
+		LastError: rpl.lastError, LastErrorTime: rpl.lastErrorTime}
+	if fileNames, err := rpl.queuedFiles(); err == nil {
+		status.Queued = len(fileNames)
+	}
+	return status
+}
@@ -0,0 +1,119 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package cdrs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestCdrReplicatorQueue(t *testing.T) {
+	replicationDir, err := ioutil.TempDir("", "cdrs_replication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(replicationDir)
+	statusCode := http.StatusInternalServerError
+	var received []*utils.StoredCdr
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if statusCode == http.StatusOK {
+			cdr := new(utils.StoredCdr)
+			json.NewDecoder(r.Body).Decode(cdr)
+			received = append(received, cdr)
+		}
+		w.WriteHeader(statusCode)
+	}))
+	defer target.Close()
+	rplCfg := config.NewDefaultCdrReplicationConfig("central")
+	rplCfg.Address = strings.TrimPrefix(target.URL, "http://")
+	rplCfg.Transport = utils.HTTP_JSON
+	rplCfg.Tenants = []string{"cgrates.org"}
+	rpl, err := NewCdrReplicator(rplCfg, replicationDir, time.Duration(1)*time.Second, time.Duration(1)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdr := &utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", CdrHost: "192.168.1.1", Tenant: "cgrates.org", Account: "1001",
+		AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: time.Duration(10) * time.Second}
+	for _, rplCdr := range []*utils.StoredCdr{cdr, &utils.StoredCdr{CgrId: "filtered", Tenant: "itsyscom.com"}} {
+		if err := rpl.Replicate(rplCdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Target unreachable, CDR stays queued
+	if err := rpl.sendQueued(); err == nil {
+		t.Error("Should return error on unreachable target")
+	} else if status := rpl.GetStatus(); status.Queued != 1 || status.Replicated != 0 || status.LastError == "" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	statusCode = http.StatusOK
+	if err := rpl.sendQueued(); err != nil {
+		t.Error(err)
+	} else if status := rpl.GetStatus(); status.Queued != 0 || status.Replicated != 1 {
+		t.Errorf("Unexpected status: %+v", status)
+	} else if len(received) != 1 || received[0].CgrId != cdr.CgrId || !received[0].AnswerTime.Equal(cdr.AnswerTime) {
+		t.Errorf("Unexpected CDRs received: %+v", received)
+	}
+	// CDR refused by target is moved out of the queue
+	statusCode = http.StatusBadRequest
+	rpl.Replicate(cdr)
+	if err := rpl.sendQueued(); err != nil {
+		t.Error(err)
+	} else if status := rpl.GetStatus(); status.Queued != 0 || status.Failed != 1 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if fileInfos, _ := ioutil.ReadDir(path.Join(replicationDir, "central")); len(fileInfos) != 1 || !strings.HasSuffix(fileInfos[0].Name(), REPLICATION_FAILED_EXT) {
+		t.Errorf("Unexpected files in queue: %+v", fileInfos)
+	}
+}
+
+func TestCdrReplicatorTimeout(t *testing.T) {
+	replicationDir, err := ioutil.TempDir("", "replication")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(replicationDir)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(200) * time.Millisecond) // Hanging target
+	}))
+	defer target.Close()
+	rplCfg := config.NewDefaultCdrReplicationConfig("central")
+	rplCfg.Address = strings.TrimPrefix(target.URL, "http://")
+	rpl, err := NewCdrReplicator(rplCfg, replicationDir, time.Duration(1)*time.Second, time.Duration(20)*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rpl.Replicate(&utils.StoredCdr{CgrId: utils.FSCgrId("dsafdsaf"), AccId: "dsafdsaf", Tenant: "cgrates.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpl.sendQueued(); err == nil {
+		t.Error("Should return error on target not answering in time")
+	} else if status := rpl.GetStatus(); status.Queued != 1 || status.Replicated != 0 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
@@ -29,13 +29,16 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/cgrates/cgrates/agents"
 	"github.com/cgrates/cgrates/apier"
 	"github.com/cgrates/cgrates/balancer2go"
 	"github.com/cgrates/cgrates/cdrc"
+	"github.com/cgrates/cgrates/cdrexporter"
 	"github.com/cgrates/cgrates/cdrs"
 	"github.com/cgrates/cgrates/config"
 	"github.com/cgrates/cgrates/engine"
 	"github.com/cgrates/cgrates/history"
+	"github.com/cgrates/cgrates/invoices"
 	"github.com/cgrates/cgrates/mediator"
 	"github.com/cgrates/cgrates/scheduler"
 	"github.com/cgrates/cgrates/sessionmanager"
@@ -69,6 +72,8 @@ var (
 	cdrServer       *cdrs.CDRS
 	sm              sessionmanager.SessionManager
 	medi            *mediator.Mediator
+	cdrStats        *engine.CdrStats
+	fraudDetector   *engine.FraudDetector
 	cfg             *config.CGRConfig
 	err             error
 )
@@ -87,7 +92,7 @@ func cacheData(ratingDb engine.RatingStorage, accountDb engine.AccountingStorage
 	close(doneChan)
 }
 
-func startMediator(responder *engine.Responder, loggerDb engine.LogStorage, cdrDb engine.CdrStorage, cacheChan, chanDone chan struct{}) {
+func startMediator(responder *engine.Responder, apierV1 *apier.ApierV1, loggerDb engine.LogStorage, cdrDb engine.CdrStorage, accountDb engine.AccountingStorage, cacheChan, chanDone chan struct{}) {
 	var connector engine.Connector
 	if cfg.MediatorRater == utils.INTERNAL {
 		<-cacheChan // Cache needs to come up before we are ready
@@ -117,6 +122,27 @@ func startMediator(responder *engine.Responder, loggerDb engine.LogStorage, cdrD
 		exitChan <- true
 		return
 	}
+	if cdrStats != nil {
+		medi.SetCdrStats(cdrStats)
+	}
+	if fraudDetector != nil {
+		medi.SetFraudDetector(fraudDetector)
+	}
+	if dcRules, err := accountDb.GetDerivedChargingRules(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<Mediator> Could not get derived charging rules: %v", err))
+		exitChan <- true
+		return
+	} else if err := medi.SetDerivedChargingRules(dcRules); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<Mediator> Invalid derived charging rules: %v", err))
+		exitChan <- true
+		return
+	}
+	if err := medi.LoadPendingCdrs(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<Mediator> Could not reload pending CDRs, error: %s", err.Error()))
+		exitChan <- true
+		return
+	}
+	apierV1.Mediator = medi
 	engine.Logger.Info("Registering Mediator RPC service.")
 	server.RpcRegister(&mediator.MediatorV1{Medi: medi})
 	
@@ -136,23 +162,18 @@ func registerApier(waitOnChans []chan struct{}) {
 	}
 }
 
-func startCdrc(cdrsChan chan struct{}) {
-	if cfg.CdrcCdrs == utils.INTERNAL {
+func startCdrc(cdrClient *cdrc.Cdrc, cdrcCfg *config.CdrcConfig, cdrsChan chan struct{}) {
+	if cdrcCfg.Cdrs == utils.INTERNAL {
 		<-cdrsChan // Wait for CDRServer to come up before start processing
+		cdrClient.SetCdrServer(cdrServer)
 	}
-	cdrc, err := cdrc.NewCdrc(cfg, cdrServer)
-	if err != nil {
-		engine.Logger.Crit(fmt.Sprintf("Cdrc config parsing error: %s", err.Error()))
-		exitChan <- true
-		return
-	}
-	if err := cdrc.Run(); err != nil {
-		engine.Logger.Crit(fmt.Sprintf("Cdrc run error: %s", err.Error()))
+	if err := cdrClient.Run(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("Cdrc %s run error: %s", cdrcCfg.Id, err.Error()))
 	}
 	exitChan <- true // If run stopped, something is bad, stop the application
 }
 
-func startSessionManager(responder *engine.Responder, loggerDb engine.LogStorage, cacheChan chan struct{}) {
+func startSessionManager(responder *engine.Responder, apierV1 *apier.ApierV1, loggerDb engine.LogStorage, cdrDb engine.CdrStorage, cacheChan, mediChan chan struct{}) {
 	var connector engine.Connector
 	if cfg.SMRater == utils.INTERNAL {
 		<-cacheChan // Wait for the cache to init before start doing queries
@@ -177,7 +198,20 @@ func startSessionManager(responder *engine.Responder, loggerDb engine.LogStorage
 	switch cfg.SMSwitchType {
 	case FS:
 		dp, _ := time.ParseDuration(fmt.Sprintf("%vs", cfg.SMDebitInterval))
-		sm = sessionmanager.NewFSSessionManager(loggerDb, connector, dp)
+		fsSm := sessionmanager.NewFSSessionManager(loggerDb, connector, dp, cfg.SMDebitMargin)
+		if fraudDetector != nil {
+			fsSm.SetFraudDetector(fraudDetector)
+		}
+		fsSm.SetCdrDb(cdrDb)
+		if cfg.SMLowBalanceUrl != "" {
+			fsSm.AddLowBalanceHook(sessionmanager.NewLowBalancePoster(cfg.SMLowBalanceUrl))
+		}
+		if cfg.MediatorEnabled {
+			<-mediChan // Hand over the session costs to the internal mediator instead of having it poll for them
+			fsSm.SetCostNotifier(medi.NotifyCallCost)
+		}
+		sm = fsSm
+		apierV1.SetSessionManager(sm) // Expose active sessions over the API
 		errConn := sm.Connect(cfg)
 		if errConn != nil {
 			engine.Logger.Err(fmt.Sprintf("<SessionManager> error: %s!", errConn))
@@ -188,7 +222,81 @@ func startSessionManager(responder *engine.Responder, loggerDb engine.LogStorage
 	exitChan <- true
 }
 
-func startCDRS(responder *engine.Responder, cdrDb engine.CdrStorage, mediChan, doneChan chan struct{}) {
+func startDiameterAgent(responder *engine.Responder, loggerDb engine.LogStorage, cacheChan, mediChan chan struct{}) {
+	var connector engine.Connector
+	if cfg.DARater == utils.INTERNAL {
+		<-cacheChan // Wait for the cache to init before start doing queries
+		connector = responder
+	} else {
+		var client *rpc.Client
+		var err error
+		for i := 0; i < cfg.DARaterReconnects; i++ {
+			client, err = rpc.Dial("tcp", cfg.DARater)
+			if err == nil { //Connected so no need to reiterate
+				break
+			}
+			time.Sleep(time.Duration(i+1) * time.Second)
+		}
+		if err != nil {
+			engine.Logger.Crit(fmt.Sprintf("<DiameterAgent> Could not connect to engine: %v", err))
+			exitChan <- true
+			return
+		}
+		connector = &engine.RPCClientConnector{Client: client}
+	}
+	da := agents.NewDiameterAgent(cfg, connector, loggerDb)
+	if cfg.MediatorEnabled {
+		<-mediChan // Hand over the session costs to the internal mediator instead of having it poll for them
+		da.SetCostNotifier(medi.NotifyCallCost)
+	}
+	if err := da.ListenAndServe(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<DiameterAgent> error: %s!", err))
+	}
+	exitChan <- true
+}
+
+func startRadiusAgent(responder *engine.Responder, loggerDb engine.LogStorage, cacheChan, cdrsChan, mediChan chan struct{}) {
+	var connector engine.Connector
+	if cfg.RARater == utils.INTERNAL {
+		<-cacheChan // Wait for the cache to init before start doing queries
+		connector = responder
+	} else {
+		var client *rpc.Client
+		var err error
+		for i := 0; i < cfg.RARaterReconnects; i++ {
+			client, err = rpc.Dial("tcp", cfg.RARater)
+			if err == nil { //Connected so no need to reiterate
+				break
+			}
+			time.Sleep(time.Duration(i+1) * time.Second)
+		}
+		if err != nil {
+			engine.Logger.Crit(fmt.Sprintf("<RadiusAgent> Could not connect to engine: %v", err))
+			exitChan <- true
+			return
+		}
+		connector = &engine.RPCClientConnector{Client: client}
+	}
+	if cfg.RACdrs == utils.INTERNAL {
+		<-cdrsChan // Wait for CDRServer to come up before start processing
+	}
+	ra, err := agents.NewRadiusAgent(cfg, connector, cdrServer, loggerDb)
+	if err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<RadiusAgent> Config parsing error: %s", err.Error()))
+		exitChan <- true
+		return
+	}
+	if cfg.MediatorEnabled {
+		<-mediChan // Hand over the session costs to the internal mediator instead of having it poll for them
+		ra.SetCostNotifier(medi.NotifyCallCost)
+	}
+	if err := ra.ListenAndServe(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<RadiusAgent> error: %s!", err))
+	}
+	exitChan <- true
+}
+
+func startCDRS(responder *engine.Responder, apierV1 *apier.ApierV1, cdrDb engine.CdrStorage, mediChan, doneChan chan struct{}) {
 	if cfg.CDRSMediator == utils.INTERNAL {
 		<-mediChan // Deadlock if mediator not started
 		if medi == nil {
@@ -198,6 +306,26 @@ func startCDRS(responder *engine.Responder, cdrDb engine.CdrStorage, mediChan, d
 		}
 	}
 	cdrServer = cdrs.New(cdrDb, medi, cfg)
+	if cdrStats != nil {
+		cdrServer.SetCdrStats(cdrStats)
+	}
+	for _, rplCfg := range cfg.CDRSReplicationTargets {
+		rpl, err := cdrs.NewCdrReplicator(rplCfg, cfg.CDRSReplicationDir, cfg.CDRSReplicationRetry, cfg.CDRSReplicationTimeout)
+		if err != nil {
+			engine.Logger.Crit(fmt.Sprintf("<CDRS> Could not start replication to %s, error: %s", rplCfg.Id, err.Error()))
+			exitChan <- true
+			return
+		}
+		engine.Logger.Info(fmt.Sprintf("<CDRS> Replicating CDRs to %s", rplCfg.Address))
+		cdrServer.AddReplicator(rpl)
+		go rpl.Run()
+	}
+	if err := cdrServer.LoadPartialCdrs(); err != nil {
+		engine.Logger.Crit(fmt.Sprintf("<CDRS> Could not reload partial CDRs, error: %s", err.Error()))
+		exitChan <- true
+		return
+	}
+	apierV1.CdrServer = cdrServer
 	cdrServer.RegisterHanlersToServer(server)
 	close(doneChan)
 }
@@ -276,6 +404,26 @@ func checkConfigSanity() error {
 		engine.Logger.Crit("The history agent is enabled and internal and history server is disabled!")
 		return errors.New("Improperly configured history service")
 	}
+	cdrcInDirs := make(map[string]string) // Profile watching each directory
+	for _, cdrcCfg := range cfg.CdrcConfigs() {
+		if cdrcCfg.Cdrs == utils.INTERNAL && !cfg.CDRSEnabled {
+			engine.Logger.Crit(fmt.Sprintf("Cdrc %s cannot connect to CDRS, CDRS not enabled in configuration!", cdrcCfg.Id))
+			return errors.New("Internal CDRS required by Cdrc")
+		}
+		if profileId, hasDir := cdrcInDirs[cdrcCfg.CdrInDir]; hasDir {
+			engine.Logger.Crit(fmt.Sprintf("Cdrc %s watching the same directory as cdrc %s!", cdrcCfg.Id, profileId))
+			return errors.New("Cdrc directory shared")
+		}
+		cdrcInDirs[cdrcCfg.CdrInDir] = cdrcCfg.Id
+	}
+	if len(cfg.CdreJobs) != 0 && !cfg.SchedulerEnabled {
+		engine.Logger.Crit("Export jobs are configured but the scheduler running them is not enabled!")
+		return errors.New("Scheduler required by export jobs")
+	}
+	if len(cfg.InvoiceCycles) != 0 && !cfg.SchedulerEnabled {
+		engine.Logger.Crit("Billing cycles are configured but the scheduler running them is not enabled!")
+		return errors.New("Scheduler required by billing cycles")
+	}
 	return nil
 }
 
@@ -423,8 +571,30 @@ func main() {
 
 	if cfg.SchedulerEnabled {
 		engine.Logger.Info("Starting CGRateS Scheduler.")
+		sched := scheduler.NewScheduler()
+		for _, jobCfg := range cfg.CdreJobs {
+			job, err := cdrexporter.NewCdrExportJob(jobCfg, cfg, cdrDb)
+			if err != nil {
+				engine.Logger.Crit(fmt.Sprintf("<Cdre> Could not start export job: %s", err.Error()))
+				return
+			}
+			sched.AddTask(job.ActionTiming())
+			apier.CdrExportJobs = append(apier.CdrExportJobs, job)
+		}
+		if len(cfg.InvoiceCycles) != 0 {
+			invStorage := invoices.NewInvoiceStorage(cdrDb, cfg.InvoiceNumberPrefix)
+			for _, cycleCfg := range cfg.InvoiceCycles {
+				bc, err := invoices.NewBillingCycle(cycleCfg, cfg, cdrDb, invStorage)
+				if err != nil {
+					engine.Logger.Crit(fmt.Sprintf("<Invoices> Could not start billing cycle: %s", err.Error()))
+					return
+				}
+				sched.AddTask(bc.ActionTiming())
+				apier.BillingCycles = append(apier.BillingCycles, bc)
+			}
+			apier.InvoiceStorage = invStorage
+		}
 		go func() {
-			sched := scheduler.NewScheduler()
 			go reloadSchedulerSingnalHandler(sched, accountDb)
 			apier.Sched = sched
 			sched.LoadActionTimings(accountDb)
@@ -444,11 +614,23 @@ func main() {
 		go startHistoryAgent(scribeServer, histServChan)
 	}
 
+	if cfg.CdrStatsEnabled {
+		engine.Logger.Info("Starting CGRateS CDR stats service.")
+		cdrStats = engine.NewCdrStats(cfg.CdrStatsQueues)
+		apier.CdrStats = cdrStats
+	}
+
+	if cfg.FraudEnabled {
+		engine.Logger.Info("Starting CGRateS fraud detection.")
+		fraudDetector = engine.NewFraudDetector(cfg.FraudRules, cfg.FraudMaxIncidents)
+		apier.FraudDetector = fraudDetector
+	}
+
 	var medChan chan struct{}
 	if cfg.MediatorEnabled {
 		engine.Logger.Info("Starting CGRateS Mediator service.")
 		medChan = make(chan struct{})
-		go startMediator(responder, logDb, cdrDb, cacheChan, medChan)
+		go startMediator(responder, apier, logDb, cdrDb, accountDb, cacheChan, medChan)
 	}
 
 	var cdrsChan chan struct{}
@@ -456,19 +638,35 @@ func main() {
 		engine.Logger.Info("Starting CGRateS CDRS service.")
 		cdrsChan = make(chan struct{})
 		httpWait = append(httpWait, cdrsChan)
-		go startCDRS(responder, cdrDb, medChan, cdrsChan)
+		go startCDRS(responder, apier, cdrDb, medChan, cdrsChan)
 	}
 
 	if cfg.SMEnabled {
 		engine.Logger.Info("Starting CGRateS SessionManager service.")
-		go startSessionManager(responder, logDb, cacheChan)
+		go startSessionManager(responder, apier, logDb, cdrDb, cacheChan, medChan)
 		// close all sessions on shutdown
 		go shutdownSessionmanagerSingnalHandler()
 	}
 
-	if cfg.CdrcEnabled {
-		engine.Logger.Info("Starting CGRateS CDR client.")
-		go startCdrc(cdrsChan)
+	if cfg.DAEnabled {
+		engine.Logger.Info("Starting CGRateS DiameterAgent service.")
+		go startDiameterAgent(responder, logDb, cacheChan, medChan)
+	}
+
+	if cfg.RAEnabled {
+		engine.Logger.Info("Starting CGRateS RadiusAgent service.")
+		go startRadiusAgent(responder, logDb, cacheChan, cdrsChan, medChan)
+	}
+
+	for _, cdrcCfg := range cfg.CdrcConfigs() {
+		engine.Logger.Info(fmt.Sprintf("Starting CGRateS CDR client, profile %s.", cdrcCfg.Id))
+		cdrClient, err := cdrc.NewCdrc(cdrcCfg)
+		if err != nil {
+			engine.Logger.Crit(fmt.Sprintf("Cdrc %s config parsing error: %s", cdrcCfg.Id, err.Error()))
+			return
+		}
+		apier.Cdrcs = append(apier.Cdrcs, cdrClient)
+		go startCdrc(cdrClient, cdrcCfg, cdrsChan)
 	}
 
 	// Start the servers
@@ -477,6 +675,9 @@ func main() {
 
 	<-exitChan
 
+	if cdrServer != nil {
+		cdrServer.FlushPartialCdrs()
+	}
 	if *pidFile != "" {
 		if err := os.Remove(*pidFile); err != nil {
 			engine.Logger.Warning("Could not remove pid file: " + err.Error())
@@ -0,0 +1,177 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	CDRC_PROFILE_PREFIX  = "cdrc_profile_" // Sections defining additional cdrc instances, suffixed by the profile id
+	CDRC_DEFAULT_PROFILE = "*default"      // Profile of the cdrc configured in the [cdrc] section
+)
+
+// Configuration of one cdrc instance
+type CdrcConfig struct {
+	Id               string
+	Enabled          bool
+	Cdrs             string        // Address where to reach CDR server
+	CdrsMethod       string        // Mechanism to use when posting CDRs on server  <http_cgr>
+	RunDelay         time.Duration // Sleep interval between consecutive runs, 0 to use automation via inotify
+	CdrType          string        // CDR file format <csv|freeswitch_csv|fixed_width|json_lines|xml>
+	XmlRecordPath    string        // Path of the elements holding one CDR each in xml files
+	CdrInDir         string        // Absolute path towards the directory where the CDRs are stored
+	CdrOutDir        string        // Absolute path towards the directory where processed CDRs will be moved
+	FilePattern      string        // Process only the files with names matching this regexp
+	StableTime       time.Duration // Process files only after their size did not change for this long
+	DoneSuffix       string        // Process files only once a marker file named as them plus this suffix exists
+	CdrSourceId      string        // Tag identifying the source of the CDRs within CGRS database
+	AccIdField       string
+	ReqTypeField     string
+	DirectionField   string
+	TenantField      string
+	TorField         string
+	AccountField     string
+	SubjectField     string
+	DestinationField string
+	AnswerTimeField  string
+	DurationField    string
+	ExtraFields      []string
+	SkipUnanswered   bool     // Do not import the records of calls not answered
+	SkipRows         string   // Do not import the records matching this regexp
+	FieldFilters     []string // Import only the records with fields matching, in the form field:regexp
+}
+
+// Configuration of the cdrc defined in the [cdrc] section, used as defaults by the profiles
+func (self *CGRConfig) DefaultCdrcConfig() *CdrcConfig {
+	return &CdrcConfig{Id: CDRC_DEFAULT_PROFILE, Enabled: self.CdrcEnabled, Cdrs: self.CdrcCdrs, CdrsMethod: self.CdrcCdrsMethod,
+		RunDelay: self.CdrcRunDelay, CdrType: self.CdrcCdrType, XmlRecordPath: self.CdrcXmlRecordPath, CdrInDir: self.CdrcCdrInDir,
+		CdrOutDir: self.CdrcCdrOutDir, FilePattern: self.CdrcFilePattern, StableTime: self.CdrcStableTime, DoneSuffix: self.CdrcDoneSuffix,
+		CdrSourceId: self.CdrcSourceId, AccIdField: self.CdrcAccIdField, ReqTypeField: self.CdrcReqTypeField,
+		DirectionField: self.CdrcDirectionField, TenantField: self.CdrcTenantField, TorField: self.CdrcTorField, AccountField: self.CdrcAccountField,
+		SubjectField: self.CdrcSubjectField, DestinationField: self.CdrcDestinationField, AnswerTimeField: self.CdrcAnswerTimeField,
+		DurationField: self.CdrcDurationField, ExtraFields: append([]string{}, self.CdrcExtraFields...), SkipUnanswered: self.CdrcSkipUnanswered,
+		SkipRows: self.CdrcSkipRows, FieldFilters: append([]string{}, self.CdrcFieldFilters...)}
+}
+
+// Enabled cdrc instances, the one out of [cdrc] section first followed by the profiles sorted on id
+func (self *CGRConfig) CdrcConfigs() []*CdrcConfig {
+	var cdrcCfgs []*CdrcConfig
+	if self.CdrcEnabled {
+		cdrcCfgs = append(cdrcCfgs, self.DefaultCdrcConfig())
+	}
+	profileIds := make([]string, 0, len(self.CdrcProfiles))
+	for profileId := range self.CdrcProfiles {
+		profileIds = append(profileIds, profileId)
+	}
+	sort.Strings(profileIds)
+	for _, profileId := range profileIds {
+		if self.CdrcProfiles[profileId].Enabled {
+			cdrcCfgs = append(cdrcCfgs, self.CdrcProfiles[profileId])
+		}
+	}
+	return cdrcCfgs
+}
+
+// Loads the cdrc profiles out of their own config sections, options missing are taken out of dfltCfg.
+// The source id defaults to the profile id, CDRs with the same accid out of different profiles would be taken for duplicates otherwise.
+func loadCdrcProfiles(c *conf.ConfigFile, dfltCfg *CdrcConfig) (map[string]*CdrcConfig, error) {
+	profiles := make(map[string]*CdrcConfig)
+	var err error
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, CDRC_PROFILE_PREFIX) || len(section) == len(CDRC_PROFILE_PREFIX) {
+			continue
+		}
+		pCfg := *dfltCfg
+		pCfg.Id = section[len(CDRC_PROFILE_PREFIX):]
+		pCfg.Enabled = true // Defining the profile is enough to start it
+		pCfg.CdrSourceId = pCfg.Id
+		pCfg.ExtraFields = append([]string{}, dfltCfg.ExtraFields...)
+		pCfg.FieldFilters = append([]string{}, dfltCfg.FieldFilters...)
+		if c.HasOption(section, "enabled") {
+			pCfg.Enabled, _ = c.GetBool(section, "enabled")
+		}
+		if c.HasOption(section, "skip_unanswered") {
+			pCfg.SkipUnanswered, _ = c.GetBool(section, "skip_unanswered")
+		}
+		for _, opt := range []struct {
+			name string
+			val  *time.Duration
+		}{
+			{"run_delay", &pCfg.RunDelay},
+			{"stable_time", &pCfg.StableTime},
+		} {
+			if c.HasOption(section, opt.name) {
+				durStr, _ := c.GetString(section, opt.name)
+				if *opt.val, err = utils.ParseDurationWithSecs(durStr); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, opt := range []struct {
+			name string
+			val  *string
+		}{
+			{"cdrs", &pCfg.Cdrs},
+			{"cdrs_method", &pCfg.CdrsMethod},
+			{"cdr_type", &pCfg.CdrType},
+			{"xml_record_path", &pCfg.XmlRecordPath},
+			{"cdr_in_dir", &pCfg.CdrInDir},
+			{"cdr_out_dir", &pCfg.CdrOutDir},
+			{"file_pattern", &pCfg.FilePattern},
+			{"done_suffix", &pCfg.DoneSuffix},
+			{"cdr_source_id", &pCfg.CdrSourceId},
+			{"accid_field", &pCfg.AccIdField},
+			{"reqtype_field", &pCfg.ReqTypeField},
+			{"direction_field", &pCfg.DirectionField},
+			{"tenant_field", &pCfg.TenantField},
+			{"tor_field", &pCfg.TorField},
+			{"account_field", &pCfg.AccountField},
+			{"subject_field", &pCfg.SubjectField},
+			{"destination_field", &pCfg.DestinationField},
+			{"answer_time_field", &pCfg.AnswerTimeField},
+			{"duration_field", &pCfg.DurationField},
+			{"skip_rows", &pCfg.SkipRows},
+		} {
+			if c.HasOption(section, opt.name) {
+				*opt.val, _ = c.GetString(section, opt.name)
+			}
+		}
+		for _, opt := range []struct {
+			name string
+			val  *[]string
+		}{
+			{"extra_fields", &pCfg.ExtraFields},
+			{"field_filters", &pCfg.FieldFilters},
+		} {
+			if c.HasOption(section, opt.name) {
+				if *opt.val, err = ConfigSlice(c, section, opt.name); err != nil {
+					return nil, err
+				}
+			}
+		}
+		profiles[pCfg.Id] = &pCfg
+	}
+	return profiles, nil
+}
@@ -0,0 +1,280 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	CDRE_TEMPLATE_PREFIX = "cdre_template_" // Sections defining export templates, suffixed by the template id
+	CDRE_FIELD_OPTS_SEP  = "|"              // Separates the source of an exported field from its options
+	CDRE_JOB_PREFIX      = "cdre_job_"      // Sections defining scheduled exports, suffixed by the job id
+)
+
+// One field of an exported record
+type CdreFieldConfig struct {
+	Name         string // Label of the field, used as key in json_lines format. Defaults to the source
+	Source       string // CDR field, ^constant, ~field:s/regexp/replacement/ or one of the utils.CdreVariables
+	Width        int    // Fixed length of the value, 0 to keep it as it is
+	Padding      string // Filling values shorter than width <left|right|zeroleft>
+	Strip        string // Stripping values longer than width <left|right>, empty to fail the export instead
+	Decimals     int    // Decimals kept on numeric values, -1 to leave them unchanged. Costs default to rounding_decimals
+	Layout       string // Layout of the time values
+	DurationUnit string // Unit the durations are expressed in, eg: s, ms
+}
+
+func NewDefaultCdreFieldConfig(source string) *CdreFieldConfig {
+	return &CdreFieldConfig{Name: source, Source: source, Padding: utils.PADDING_RIGHT, Decimals: -1, Layout: time.RFC3339, DurationUnit: "s"}
+}
+
+// Configuration of one export template
+type CdreTemplateConfig struct {
+	Id             string
+	CdrFormat      string // One of the utils.CdreTplFormats
+	FieldSeparator string // Separator of the csv fields
+	HeaderFields   []*CdreFieldConfig
+	ContentFields  []*CdreFieldConfig // One record for each exported CDR
+	TrailerFields  []*CdreFieldConfig
+}
+
+func NewDefaultCdreTemplateConfig(id string) *CdreTemplateConfig {
+	return &CdreTemplateConfig{Id: id, CdrFormat: utils.CDRE_CSV, FieldSeparator: ",", HeaderFields: []*CdreFieldConfig{},
+		ContentFields: []*CdreFieldConfig{}, TrailerFields: []*CdreFieldConfig{}}
+}
+
+// Parses field definitions in the format source|option=value|option=value, options being name, width, padding, strip, decimals, layout and unit
+func ParseCdreFields(fldStrs []string) ([]*CdreFieldConfig, error) {
+	flds := make([]*CdreFieldConfig, len(fldStrs))
+	for idx, fldStr := range fldStrs {
+		fldVals := strings.Split(strings.TrimSpace(fldStr), CDRE_FIELD_OPTS_SEP)
+		if _, err := utils.NewRSRField(fldVals[0]); err != nil {
+			return nil, err
+		}
+		fld := NewDefaultCdreFieldConfig(fldVals[0])
+		for _, optStr := range fldVals[1:] {
+			optVals := strings.SplitN(optStr, "=", 2)
+			if len(optVals) != 2 {
+				return nil, fmt.Errorf("Invalid cdre field option: %s", optStr)
+			}
+			var err error
+			switch optVals[0] {
+			case "name":
+				fld.Name = optVals[1]
+			case "width":
+				if fld.Width, err = strconv.Atoi(optVals[1]); err != nil {
+					return nil, fmt.Errorf("Invalid cdre field width: %s", optVals[1])
+				}
+			case "padding":
+				if !utils.IsSliceMember([]string{utils.PADDING_LEFT, utils.PADDING_RIGHT, utils.PADDING_ZEROLEFT}, optVals[1]) {
+					return nil, fmt.Errorf("Unsupported cdre field padding: %s", optVals[1])
+				}
+				fld.Padding = optVals[1]
+			case "strip":
+				if !utils.IsSliceMember([]string{utils.PADDING_LEFT, utils.PADDING_RIGHT}, optVals[1]) {
+					return nil, fmt.Errorf("Unsupported cdre field strip: %s", optVals[1])
+				}
+				fld.Strip = optVals[1]
+			case "decimals":
+				if fld.Decimals, err = strconv.Atoi(optVals[1]); err != nil {
+					return nil, fmt.Errorf("Invalid cdre field decimals: %s", optVals[1])
+				}
+			case "layout":
+				fld.Layout = optVals[1]
+			case "unit":
+				if _, err = time.ParseDuration("1" + optVals[1]); err != nil {
+					return nil, fmt.Errorf("Unsupported cdre field duration unit: %s", optVals[1])
+				}
+				fld.DurationUnit = optVals[1]
+			default:
+				return nil, fmt.Errorf("Unsupported cdre field option: %s", optVals[0])
+			}
+		}
+		flds[idx] = fld
+	}
+	return flds, nil
+}
+
+// Loads the export templates out of their own config sections
+func loadCdreTemplates(c *conf.ConfigFile) (map[string]*CdreTemplateConfig, error) {
+	tpls := make(map[string]*CdreTemplateConfig)
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, CDRE_TEMPLATE_PREFIX) || len(section) == len(CDRE_TEMPLATE_PREFIX) {
+			continue
+		}
+		tCfg := NewDefaultCdreTemplateConfig(section[len(CDRE_TEMPLATE_PREFIX):])
+		if c.HasOption(section, "cdr_format") {
+			tCfg.CdrFormat, _ = c.GetString(section, "cdr_format")
+		}
+		if !utils.IsSliceMember(utils.CdreTplFormats, tCfg.CdrFormat) {
+			return nil, fmt.Errorf("Unsupported cdr_format: <%s> for export template %s", tCfg.CdrFormat, tCfg.Id)
+		}
+		if c.HasOption(section, "field_separator") {
+			tCfg.FieldSeparator, _ = c.GetString(section, "field_separator")
+			if len(tCfg.FieldSeparator) != 1 {
+				return nil, fmt.Errorf("Invalid field_separator: <%s> for export template %s", tCfg.FieldSeparator, tCfg.Id)
+			}
+		}
+		for _, flds := range []struct {
+			opt string
+			val *[]*CdreFieldConfig
+		}{
+			{"header_fields", &tCfg.HeaderFields},
+			{"content_fields", &tCfg.ContentFields},
+			{"trailer_fields", &tCfg.TrailerFields},
+		} {
+			if !c.HasOption(section, flds.opt) {
+				continue
+			}
+			fldStrs, err := ConfigSlice(c, section, flds.opt)
+			if err != nil {
+				return nil, err
+			}
+			if *flds.val, err = ParseCdreFields(fldStrs); err != nil {
+				return nil, fmt.Errorf("%s for export template %s", err.Error(), tCfg.Id)
+			}
+			if tCfg.CdrFormat != utils.CDRE_FIXED_WIDTH {
+				continue
+			}
+			for _, fld := range *flds.val {
+				if fld.Width == 0 {
+					return nil, fmt.Errorf("Missing width of field %s for fixed_width export template %s", fld.Name, tCfg.Id)
+				}
+			}
+		}
+		if len(tCfg.ContentFields) == 0 {
+			return nil, fmt.Errorf("No content_fields defined for export template %s", tCfg.Id)
+		}
+		tpls[tCfg.Id] = tCfg
+	}
+	return tpls, nil
+}
+
+// Configuration of one export job, run by the scheduler
+type CdreJobConfig struct {
+	Id                  string
+	ExportTemplate      string // Export template id, empty for the csv format with primary and extra fields
+	ExportDir           string // Directory the files are written to, empty for the one in cdre section
+	FileName            string // File name without extension, the utils.CDRE_JOB_ID, CDRE_TIME_START, CDRE_TIME_END and CDRE_EXPORT_TIME variables are replaced
+	ExportPeriod        string // CDRs answered within <*previous_day|*previous_month|duration before the run>, empty for all
+	Years               utils.Years
+	Months              utils.Months
+	MonthDays           utils.MonthDays
+	WeekDays            utils.WeekDays // Runs daily if no days are defined
+	StartTime           string         // Time of the day the job runs at
+	Tenants             []string       // Filter CDRs on tenants, empty to accept all
+	Accounts            []string       // Filter CDRs on accounts, empty to accept all
+	ReqTypes            []string       // Filter CDRs on request types, empty to accept all
+	MediationRunIds     []string       // Filter CDRs on mediation run ids, empty to accept all
+	CdrSources          []string       // Filter CDRs on sources, empty to accept all
+	DestinationPrefixes []string       // Filter CDRs on destination prefixes, empty to accept all
+	SkipErrors          bool           // Do not export CDRs not rated or with rating errors
+	MarkExported        bool           // Mark the exported CDRs so the job does not export them again
+}
+
+func NewDefaultCdreJobConfig(id string) *CdreJobConfig {
+	return &CdreJobConfig{Id: id, FileName: "cdrs_" + utils.CDRE_JOB_ID + "_" + utils.CDRE_EXPORT_TIME, Years: utils.Years{}, Months: utils.Months{},
+		MonthDays: utils.MonthDays{}, WeekDays: utils.WeekDays{}, StartTime: "00:00:00", Tenants: []string{}, Accounts: []string{}, ReqTypes: []string{},
+		MediationRunIds: []string{}, CdrSources: []string{}, DestinationPrefixes: []string{}, MarkExported: true}
+}
+
+// Loads the export jobs out of their own config sections, their templates need to be already loaded
+func loadCdreJobs(c *conf.ConfigFile, tpls map[string]*CdreTemplateConfig) (map[string]*CdreJobConfig, error) {
+	jobs := make(map[string]*CdreJobConfig)
+	var err error
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, CDRE_JOB_PREFIX) || len(section) == len(CDRE_JOB_PREFIX) {
+			continue
+		}
+		jCfg := NewDefaultCdreJobConfig(section[len(CDRE_JOB_PREFIX):])
+		if c.HasOption(section, "export_template") {
+			jCfg.ExportTemplate, _ = c.GetString(section, "export_template")
+			if _, hasTpl := tpls[jCfg.ExportTemplate]; len(jCfg.ExportTemplate) != 0 && !hasTpl {
+				return nil, fmt.Errorf("Unknown export_template: <%s> for export job %s", jCfg.ExportTemplate, jCfg.Id)
+			}
+		}
+		if c.HasOption(section, "export_dir") {
+			jCfg.ExportDir, _ = c.GetString(section, "export_dir")
+		}
+		if c.HasOption(section, "file_name") {
+			jCfg.FileName, _ = c.GetString(section, "file_name")
+		}
+		if c.HasOption(section, "export_period") {
+			jCfg.ExportPeriod, _ = c.GetString(section, "export_period")
+			if len(jCfg.ExportPeriod) != 0 && jCfg.ExportPeriod != utils.PREVIOUS_DAY && jCfg.ExportPeriod != utils.PREVIOUS_MONTH {
+				if _, err = utils.ParseDurationWithSecs(jCfg.ExportPeriod); err != nil {
+					return nil, fmt.Errorf("Invalid export_period: <%s> for export job %s", jCfg.ExportPeriod, jCfg.Id)
+				}
+			}
+		}
+		if c.HasOption(section, "years") {
+			yearsStr, _ := c.GetString(section, "years")
+			jCfg.Years.Parse(yearsStr, ",")
+		}
+		if c.HasOption(section, "months") {
+			monthsStr, _ := c.GetString(section, "months")
+			jCfg.Months.Parse(monthsStr, ",")
+		}
+		if c.HasOption(section, "month_days") {
+			monthDaysStr, _ := c.GetString(section, "month_days")
+			jCfg.MonthDays.Parse(monthDaysStr, ",")
+		}
+		if c.HasOption(section, "week_days") {
+			weekDaysStr, _ := c.GetString(section, "week_days")
+			jCfg.WeekDays.Parse(weekDaysStr, ",")
+		}
+		if c.HasOption(section, "start_time") {
+			jCfg.StartTime, _ = c.GetString(section, "start_time")
+			if _, err = time.Parse("15:04:05", jCfg.StartTime); err != nil {
+				return nil, fmt.Errorf("Invalid start_time: <%s> for export job %s", jCfg.StartTime, jCfg.Id)
+			}
+		}
+		for _, fltr := range []struct {
+			opt string
+			val *[]string
+		}{
+			{"tenants", &jCfg.Tenants},
+			{"accounts", &jCfg.Accounts},
+			{"reqtypes", &jCfg.ReqTypes},
+			{"mediation_run_ids", &jCfg.MediationRunIds},
+			{"cdr_sources", &jCfg.CdrSources},
+			{"destination_prefixes", &jCfg.DestinationPrefixes},
+		} {
+			if c.HasOption(section, fltr.opt) {
+				if *fltr.val, err = ConfigSlice(c, section, fltr.opt); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if c.HasOption(section, "skip_errors") {
+			jCfg.SkipErrors, _ = c.GetBool(section, "skip_errors")
+		}
+		if c.HasOption(section, "mark_exported") {
+			jCfg.MarkExported, _ = c.GetBool(section, "mark_exported")
+		}
+		jobs[jCfg.Id] = jCfg
+	}
+	return jobs, nil
+}
@@ -0,0 +1,75 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"strings"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+const CDRS_REPLICATION_PREFIX = "cdrs_replication_" // Sections defining CDR replication targets, suffixed by the target id
+
+// Remote CDR server the raw CDRs are copied to
+type CdrReplicationConfig struct {
+	Id        string
+	Address   string   // Address of the remote CDRS, eg: 127.0.0.1:2022
+	Transport string   // Remote handler the CDRs are posted to <http_cgr|http_json>
+	Tenants   []string // Filter CDRs on tenants, empty to accept all
+	ReqTypes  []string // Filter CDRs on request types, empty to accept all
+}
+
+func NewDefaultCdrReplicationConfig(id string) *CdrReplicationConfig {
+	return &CdrReplicationConfig{Id: id, Transport: utils.HTTP_CGR, Tenants: []string{}, ReqTypes: []string{}}
+}
+
+// Loads the replication targets out of their own config sections
+func loadCdrReplicationTargets(c *conf.ConfigFile) (map[string]*CdrReplicationConfig, error) {
+	targets := make(map[string]*CdrReplicationConfig)
+	var err error
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, CDRS_REPLICATION_PREFIX) || len(section) == len(CDRS_REPLICATION_PREFIX) {
+			continue
+		}
+		rplCfg := NewDefaultCdrReplicationConfig(section[len(CDRS_REPLICATION_PREFIX):])
+		if rplCfg.Address, _ = c.GetString(section, "address"); len(rplCfg.Address) == 0 {
+			return nil, fmt.Errorf("Missing address for CDR replication target: %s", rplCfg.Id)
+		}
+		if c.HasOption(section, "transport") {
+			rplCfg.Transport, _ = c.GetString(section, "transport")
+			if rplCfg.Transport != utils.HTTP_CGR && rplCfg.Transport != utils.HTTP_JSON {
+				return nil, fmt.Errorf("Unsupported transport for CDR replication target %s: %s", rplCfg.Id, rplCfg.Transport)
+			}
+		}
+		if c.HasOption(section, "tenants") {
+			if rplCfg.Tenants, err = ConfigSlice(c, section, "tenants"); err != nil {
+				return nil, err
+			}
+		}
+		if c.HasOption(section, "reqtypes") {
+			if rplCfg.ReqTypes, err = ConfigSlice(c, section, "reqtypes"); err != nil {
+				return nil, err
+			}
+		}
+		targets[rplCfg.Id] = rplCfg
+	}
+	return targets, nil
+}
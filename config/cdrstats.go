@@ -0,0 +1,134 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+const CDRSTATS_QUEUE_PREFIX = "cdrstats_queue_" // Sections defining stats queues, suffixed by the queue id
+
+// Threshold on one of the queue metrics, executing ActionsId when crossed
+type CdrStatsThreshold struct {
+	Metric         string // One of the utils.CdrStatsMetrics
+	ThresholdType  string // <*min|*max>
+	ThresholdValue float64
+	ActionsId      string
+}
+
+// Configuration of one CDR stats queue
+type CdrStatsConfig struct {
+	Id                  string
+	QueueLength         int           // Number of CDRs kept in the queue, 0 for unlimited
+	TimeWindow          time.Duration // Keep only the CDRs received within this window, 0 for unlimited
+	Tenants             []string      // Filter CDRs on tenants, empty to accept all
+	Accounts            []string      // Filter CDRs on accounts, empty to accept all
+	DestinationPrefixes []string      // Filter CDRs on destination prefixes, empty to accept all
+	SupplierField       string        // Extra field holding the supplier of the call
+	Suppliers           []string      // Filter CDRs on suppliers, empty to accept all
+	MediationRunIds     []string      // Filter CDRs on mediation run ids, empty to accept all
+	Thresholds          []*CdrStatsThreshold
+}
+
+func NewDefaultCdrStatsConfig(id string) *CdrStatsConfig {
+	return &CdrStatsConfig{Id: id, QueueLength: 50, SupplierField: "supplier", Tenants: []string{}, Accounts: []string{},
+		DestinationPrefixes: []string{}, Suppliers: []string{}, MediationRunIds: []string{}, Thresholds: []*CdrStatsThreshold{}}
+}
+
+// Parses threshold definitions in the format metric:threshold_type:threshold_value:actions_id
+func ParseCdrStatsThresholds(thresholdStrs []string) ([]*CdrStatsThreshold, error) {
+	thresholds := make([]*CdrStatsThreshold, len(thresholdStrs))
+	for idx, thresholdStr := range thresholdStrs {
+		thresholdVals := strings.Split(strings.TrimSpace(thresholdStr), ":")
+		if len(thresholdVals) != 4 {
+			return nil, fmt.Errorf("Invalid cdrstats threshold: %s", thresholdStr)
+		}
+		if !utils.IsSliceMember(utils.CdrStatsMetrics, thresholdVals[0]) {
+			return nil, fmt.Errorf("Unsupported cdrstats metric: %s", thresholdVals[0])
+		}
+		if thresholdVals[1] != utils.STATS_MIN && thresholdVals[1] != utils.STATS_MAX {
+			return nil, fmt.Errorf("Unsupported cdrstats threshold type: %s", thresholdVals[1])
+		}
+		thresholdValue, err := strconv.ParseFloat(thresholdVals[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cdrstats threshold value: %s", thresholdVals[2])
+		}
+		thresholds[idx] = &CdrStatsThreshold{Metric: thresholdVals[0], ThresholdType: thresholdVals[1], ThresholdValue: thresholdValue, ActionsId: thresholdVals[3]}
+	}
+	return thresholds, nil
+}
+
+// Loads the stats queues out of their own config sections
+func loadCdrStatsQueues(c *conf.ConfigFile) (map[string]*CdrStatsConfig, error) {
+	queues := make(map[string]*CdrStatsConfig)
+	var err error
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, CDRSTATS_QUEUE_PREFIX) || len(section) == len(CDRSTATS_QUEUE_PREFIX) {
+			continue
+		}
+		qCfg := NewDefaultCdrStatsConfig(section[len(CDRSTATS_QUEUE_PREFIX):])
+		if c.HasOption(section, "queue_length") {
+			if qCfg.QueueLength, err = c.GetInt(section, "queue_length"); err != nil {
+				return nil, err
+			}
+		}
+		if c.HasOption(section, "time_window") {
+			timeWindowStr, _ := c.GetString(section, "time_window")
+			if qCfg.TimeWindow, err = utils.ParseDurationWithSecs(timeWindowStr); err != nil {
+				return nil, err
+			}
+		}
+		for _, fltr := range []struct {
+			opt string
+			val *[]string
+		}{
+			{"tenants", &qCfg.Tenants},
+			{"accounts", &qCfg.Accounts},
+			{"destination_prefixes", &qCfg.DestinationPrefixes},
+			{"suppliers", &qCfg.Suppliers},
+			{"mediation_run_ids", &qCfg.MediationRunIds},
+		} {
+			if c.HasOption(section, fltr.opt) {
+				if *fltr.val, err = ConfigSlice(c, section, fltr.opt); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if c.HasOption(section, "supplier_field") {
+			qCfg.SupplierField, _ = c.GetString(section, "supplier_field")
+		}
+		if c.HasOption(section, "thresholds") {
+			thresholdStrs, err := ConfigSlice(c, section, "thresholds")
+			if err != nil {
+				return nil, err
+			}
+			if qCfg.Thresholds, err = ParseCdrStatsThresholds(thresholdStrs); err != nil {
+				return nil, err
+			}
+		}
+		queues[qCfg.Id] = qCfg
+	}
+	return queues, nil
+}
@@ -84,62 +84,131 @@ type CGRConfig struct {
 	RaterBalancer            string // balancer address host:port
 	BalancerEnabled          bool
 	SchedulerEnabled         bool
-	CDRSEnabled              bool          // Enable CDR Server service
-	CDRSExtraFields          []string      //Extra fields to store in CDRs
-	CDRSMediator             string        // Address where to reach the Mediator. Empty for disabling mediation. <""|internal>
-	CdreCdrFormat            string        // Format of the exported CDRs. <csv>
-	CdreExtraFields          []string      // Extra fields list to add in exported CDRs
-	CdreDir                  string        // Path towards exported cdrs directory
-	CdrcEnabled              bool          // Enable CDR client functionality
-	CdrcCdrs                 string        // Address where to reach CDR server
-	CdrcCdrsMethod           string        // Mechanism to use when posting CDRs on server  <http_cgr>
-	CdrcRunDelay             time.Duration // Sleep interval between consecutive runs, if time unit missing, defaults to seconds, 0 to use automation via inotify
-	CdrcCdrType              string        // CDR file format <csv>.
-	CdrcCdrInDir             string        // Absolute path towards the directory where the CDRs are stored.
-	CdrcCdrOutDir            string        // Absolute path towards the directory where processed CDRs will be moved.
-	CdrcSourceId             string        // Tag identifying the source of the CDRs within CGRS database.
-	CdrcAccIdField           string        // Accounting id field identifier. Use index number in case of .csv cdrs.
-	CdrcReqTypeField         string        // Request type field identifier. Use index number in case of .csv cdrs.
-	CdrcDirectionField       string        // Direction field identifier. Use index numbers in case of .csv cdrs.
-	CdrcTenantField          string        // Tenant field identifier. Use index numbers in case of .csv cdrs.
-	CdrcTorField             string        // Type of Record field identifier. Use index numbers in case of .csv cdrs.
-	CdrcAccountField         string        // Account field identifier. Use index numbers in case of .csv cdrs.
-	CdrcSubjectField         string        // Subject field identifier. Use index numbers in case of .csv CDRs.
-	CdrcDestinationField     string        // Destination field identifier. Use index numbers in case of .csv cdrs.
-	CdrcAnswerTimeField      string        // Answer time field identifier. Use index numbers in case of .csv cdrs.
-	CdrcDurationField        string        // Duration field identifier. Use index numbers in case of .csv cdrs.
-	CdrcExtraFields          []string      // Field identifiers of the fields to add in extra fields section, special format in case of .csv "field1:index1,field2:index2"
+	CDRSEnabled              bool                             // Enable CDR Server service
+	CDRSExtraFields          []string                         //Extra fields to store in CDRs
+	CDRSMediator             string                           // Address where to reach the Mediator. Empty for disabling mediation. <""|internal>
+	CDRSPartialFlagField     string                           // Extra field marking the partial records of long calls, true on the interim ones. Empty disables merging partials.
+	CDRSPartialSeqField      string                           // Extra field with the sequence number of the partial records, empty to merge them in the order received
+	CDRSPartialTimeout       time.Duration                    // Merge the partials buffered for a call when its final record does not arrive within this interval since the last one
+	CDRSPartialDir           string                           // Directory keeping the partials buffered, reloaded on start
+	CDRSReplicationDir       string                           // Directory keeping the CDRs queued for replication, one subdirectory per target
+	CDRSReplicationRetry     time.Duration                    // Interval to retry replicating when a target is unreachable
+	CDRSReplicationTimeout   time.Duration                    // Time to wait for a target to answer one CDR posted
+	CDRSReplicationTargets   map[string]*CdrReplicationConfig // Remote CDR servers the raw CDRs are copied to, indexed on target id
+	CdrStatsEnabled          bool                             // Enable CDR stats service, fed by the internal mediator
+	CdrStatsQueues           map[string]*CdrStatsConfig       // Stats queues, indexed on queue id
+	FraudEnabled             bool                             // Enable fraud detection on authorizations and rated CDRs
+	FraudMaxIncidents        int                              // Number of fraud incidents kept for queries
+	FraudRules               map[string]*FraudRuleConfig      // Fraud detection rules, indexed on rule id
+	CdreCdrFormat            string                           // Format of the exported CDRs. <csv>
+	CdreExtraFields          []string                         // Extra fields list to add in exported CDRs
+	CdreDir                  string                           // Path towards exported cdrs directory
+	CdreTemplates            map[string]*CdreTemplateConfig   // Export templates, indexed on template id
+	CdreJobs                 map[string]*CdreJobConfig        // Exports run by the scheduler, indexed on job id
+	InvoiceNumberPrefix      string                           // Prefix of the invoice numbers, followed by the sequence
+	InvoiceCycles            map[string]*InvoiceCycleConfig   // Billing cycles run by the scheduler, indexed on cycle id
+	CdrcEnabled              bool                             // Enable CDR client functionality
+	CdrcCdrs                 string                           // Address where to reach CDR server
+	CdrcCdrsMethod           string                           // Mechanism to use when posting CDRs on server  <http_cgr>
+	CdrcRunDelay             time.Duration                    // Sleep interval between consecutive runs, if time unit missing, defaults to seconds, 0 to use automation via inotify
+	CdrcCdrType              string                           // CDR file format <csv|freeswitch_csv|fixed_width|json_lines|xml>.
+	CdrcXmlRecordPath        string                           // Path of the elements holding one CDR each in xml files, empty for the children of the root element.
+	CdrcCdrInDir             string                           // Absolute path towards the directory where the CDRs are stored.
+	CdrcCdrOutDir            string                           // Absolute path towards the directory where processed CDRs will be moved.
+	CdrcFilePattern          string                           // Process only the files with names matching this regexp, empty for all.
+	CdrcStableTime           time.Duration                    // Process files only after their size did not change for this long, 0 to disable.
+	CdrcDoneSuffix           string                           // Process files only once a marker file named as them plus this suffix exists, empty to disable.
+	CdrcSourceId             string                           // Tag identifying the source of the CDRs within CGRS database.
+	CdrcAccIdField           string                           // Accounting id field identifier. Use index number in case of .csv cdrs.
+	CdrcReqTypeField         string                           // Request type field identifier. Use index number in case of .csv cdrs.
+	CdrcDirectionField       string                           // Direction field identifier. Use index numbers in case of .csv cdrs.
+	CdrcTenantField          string                           // Tenant field identifier. Use index numbers in case of .csv cdrs.
+	CdrcTorField             string                           // Type of Record field identifier. Use index numbers in case of .csv cdrs.
+	CdrcAccountField         string                           // Account field identifier. Use index numbers in case of .csv cdrs.
+	CdrcSubjectField         string                           // Subject field identifier. Use index numbers in case of .csv CDRs.
+	CdrcDestinationField     string                           // Destination field identifier. Use index numbers in case of .csv cdrs.
+	CdrcAnswerTimeField      string                           // Answer time field identifier. Use index numbers in case of .csv cdrs.
+	CdrcDurationField        string                           // Duration field identifier. Use index numbers in case of .csv cdrs.
+	CdrcExtraFields          []string                         // Field identifiers of the fields to add in extra fields section, special format in case of .csv "field1:index1,field2:index2"
+	CdrcSkipUnanswered       bool                             // Do not import the records of calls not answered (zero duration or answer time).
+	CdrcSkipRows             string                           // Do not import the records matching this regexp, empty to import all.
+	CdrcFieldFilters         []string                         // Import only the records with fields matching, in the form "field1:regexp1,field2:regexp2".
+	CdrcProfiles             map[string]*CdrcConfig           // Additional cdrc instances, indexed on profile id
 	SMEnabled                bool
 	SMSwitchType             string
-	SMRater                  string        // address where to access rater. Can be internal, direct rater address or the address of a balancer
-	SMRaterReconnects        int           // Number of reconnect attempts to rater
-	SMDebitInterval          int           // the period to be debited in advanced during a call (in seconds)
-	SMMaxCallDuration        time.Duration // The maximum duration of a call
-	MediatorEnabled          bool          // Starts Mediator service: <true|false>.
-	MediatorRater            string        // Address where to reach the Rater: <internal|x.y.z.y:1234>
-	MediatorRaterReconnects  int           // Number of reconnects to rater before giving up.
-	MediatorRunIds           []string      // Identifiers for each mediation run on CDRs
-	MediatorReqTypeFields    []string      // Name of request type fields to be used during mediation. Use index number in case of .csv cdrs.
-	MediatorDirectionFields  []string      // Name of direction fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorTenantFields     []string      // Name of tenant fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorTORFields        []string      // Name of tor fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorAccountFields    []string      // Name of account fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorSubjectFields    []string      // Name of subject fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorDestFields       []string      // Name of destination fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorAnswerTimeFields []string      // Name of time_start fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	MediatorDurationFields   []string      // Name of duration fields to be used during mediation. Use index numbers in case of .csv cdrs.
-	FreeswitchServer         string        // freeswitch address host:port
-	FreeswitchPass           string        // FS socket password
-	FreeswitchReconnects     int           // number of times to attempt reconnect after connect fails
-	HistoryAgentEnabled      bool          // Starts History as an agent: <true|false>.
-	HistoryServer            string        // Address where to reach the master history server: <internal|x.y.z.y:1234>
-	HistoryServerEnabled     bool          // Starts History as server: <true|false>.
-	HistoryDir               string        // Location on disk where to store history files.
-	HistorySaveInterval      time.Duration // The timout duration between history writes
-	MailerServer             string        // The server to use when sending emails out
-	MailerAuthUser           string        // Authenticate to email server using this user
-	MailerAuthPass           string        // Authenticate to email server with this password
-	MailerFromAddr           string        // From address used when sending emails out
+	SMRater                  string                     // address where to access rater. Can be internal, direct rater address or the address of a balancer
+	SMRaterReconnects        int                        // Number of reconnect attempts to rater
+	SMDebitInterval          int                        // the period to be debited in advanced during a call (in seconds)
+	SMDebitMargin            time.Duration              // Debit this much before the already debited time is consumed
+	SMMaxCallDuration        time.Duration              // The maximum duration of a call
+	SMLowBalanceWarnings     []time.Duration            // Remaining call durations under which the prepaid user is warned about low balance
+	SMLowBalanceAccounts     map[string][]time.Duration // Low balance warnings overwriting the defaults, indexed on tenant:account
+	SMLowBalanceSubjects     map[string][]time.Duration // Low balance warnings overwriting the defaults, indexed on tenant:subject
+	SMLowBalanceUrl          string                     // Url to post the low balance warnings to as JSON, empty to disable
+	MediatorEnabled          bool                       // Starts Mediator service: <true|false>.
+	MediatorRater            string                     // Address where to reach the Rater: <internal|x.y.z.y:1234>
+	MediatorRaterReconnects  int                        // Number of reconnects to rater before giving up.
+	MediatorRunIds           []string                   // Identifiers for each mediation run on CDRs
+	MediatorDuplicateCdrs    string                     // Mediation of duplicate CDRs <*skip|*rerate>
+	MediatorWorkers          int                        // Number of CDRs mediated in parallel, CDRs of the same account are mediated sequentially
+	MediatorQueueLength      int                        // Number of CDRs waiting for each worker, mediation requests block when the queue is full
+	MediatorCostTimeout      time.Duration              // Time to wait for the session manager to hand over the cost of prepaid and postpaid CDRs
+	MediatorCorrelationField string                     // Extra field carrying the accid of the A-leg on all legs of a call. Empty disables correlating the legs.
+	MediatorCustomerRunId    string                     // Mediation run of the A-leg providing the customer cost of correlated calls
+	MediatorSupplierRunId    string                     // Mediation run of the B-legs providing the supplier cost of correlated calls
+	MediatorCorrelationWait  time.Duration              // Time to wait for the legs of a call after the first one is rated, before combining them
+	MediatorReqTypeFields    []string                   // Name of request type fields to be used during mediation. Use index number in case of .csv cdrs.
+	MediatorDirectionFields  []string                   // Name of direction fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorTenantFields     []string                   // Name of tenant fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorTORFields        []string                   // Name of tor fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorAccountFields    []string                   // Name of account fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorSubjectFields    []string                   // Name of subject fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorDestFields       []string                   // Name of destination fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorAnswerTimeFields []string                   // Name of time_start fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	MediatorDurationFields   []string                   // Name of duration fields to be used during mediation. Use index numbers in case of .csv cdrs.
+	FreeswitchServer         string                     // freeswitch address host:port
+	FreeswitchPass           string                     // FS socket password
+	FreeswitchReconnects     int                        // number of times to attempt reconnect after connect fails
+	FreeswitchLowBalanceAnn  string                     // Sound file broadcasted to the call on low balance warnings, empty to disable
+	DAEnabled                bool                       // Starts DiameterAgent service: <true|false>.
+	DAListen                 string                     // Address where to listen for Diameter connections <x.y.z.y:1234>
+	DARater                  string                     // Address where to access rater. Can be internal, direct rater address or the address of a balancer
+	DARaterReconnects        int                        // Number of reconnect attempts to rater
+	DADebitInterval          time.Duration              // Units granted on requests not specifying them
+	DAOriginHost             string                     // Origin-Host AVP used in answers
+	DAOriginRealm            string                     // Origin-Realm AVP used in answers
+	DAVendorId               int                        // Vendor-Id AVP used in capabilities exchange
+	DAProductName            string                     // Product-Name AVP used in capabilities exchange
+	RAEnabled                bool                       // Starts RadiusAgent service: <true|false>.
+	RAListenAuth             string                     // Address where to listen for RADIUS authorization requests <x.y.z.y:1812>
+	RAListenAcct             string                     // Address where to listen for RADIUS accounting requests <x.y.z.y:1813>
+	RASecret                 string                     // Shared secret with the RADIUS clients
+	RADictionariesDir        string                     // Folder with dictionaries for vendor attributes, empty to use only the built-in one
+	RARater                  string                     // Address where to access rater. Can be internal, direct rater address or the address of a balancer
+	RARaterReconnects        int                        // Number of reconnect attempts to rater
+	RACdrs                   string                     // Address where to reach CDR server <internal|x.y.z.y:1234>
+	RAMaxCallDuration        time.Duration              // The maximum duration of a call, used on authorization
+	RADisconnectPort         int                        // Port of the NAS receiving Disconnect-Requests for the prepaid sessions out of balance
+	RASourceId               string                     // Tag identifying the source of the CDRs within CGRS database.
+	RAAccIdField             string                     // Accounting id attribute name. Use ^ prefix for static values.
+	RAReqTypeField           string                     // Request type attribute name. Use ^ prefix for static values.
+	RADirectionField         string                     // Direction attribute name. Use ^ prefix for static values.
+	RATenantField            string                     // Tenant attribute name. Use ^ prefix for static values.
+	RATorField               string                     // Type of Record attribute name. Use ^ prefix for static values.
+	RAAccountField           string                     // Account attribute name. Use ^ prefix for static values.
+	RASubjectField           string                     // Subject attribute name. Use ^ prefix for static values.
+	RADestinationField       string                     // Destination attribute name. Use ^ prefix for static values.
+	RADurationField          string                     // Duration attribute name. Use ^ prefix for static values.
+	RAExtraFields            []string                   // Extra fields in the form "field1:Attribute-Name1,field2:Attribute-Name2"
+	HistoryAgentEnabled      bool                       // Starts History as an agent: <true|false>.
+	HistoryServer            string                     // Address where to reach the master history server: <internal|x.y.z.y:1234>
+	HistoryServerEnabled     bool                       // Starts History as server: <true|false>.
+	HistoryDir               string                     // Location on disk where to store history files.
+	HistorySaveInterval      time.Duration              // The timout duration between history writes
+	MailerServer             string                     // The server to use when sending emails out
+	MailerAuthUser           string                     // Authenticate to email server using this user
+	MailerAuthPass           string                     // Authenticate to email server with this password
+	MailerFromAddr           string                     // From address used when sending emails out
 }
 
 func (self *CGRConfig) setDefaults() error {
@@ -178,16 +247,37 @@ func (self *CGRConfig) setDefaults() error {
 	self.CDRSEnabled = false
 	self.CDRSExtraFields = []string{}
 	self.CDRSMediator = ""
+	self.CDRSPartialFlagField = ""
+	self.CDRSPartialSeqField = ""
+	self.CDRSPartialTimeout = time.Duration(3600) * time.Second
+	self.CDRSPartialDir = "/var/log/cgrates/cdr/partial"
+	self.CDRSReplicationDir = "/var/log/cgrates/cdr/replication"
+	self.CDRSReplicationRetry = time.Duration(60) * time.Second
+	self.CDRSReplicationTimeout = time.Duration(10) * time.Second
+	self.CDRSReplicationTargets = make(map[string]*CdrReplicationConfig)
+	self.CdrStatsEnabled = false
+	self.CdrStatsQueues = make(map[string]*CdrStatsConfig)
+	self.FraudEnabled = false
+	self.FraudMaxIncidents = 1000
+	self.FraudRules = make(map[string]*FraudRuleConfig)
 	self.CdreCdrFormat = "csv"
 	self.CdreExtraFields = []string{}
 	self.CdreDir = "/var/log/cgrates/cdr/cdrexport/csv"
+	self.CdreTemplates = make(map[string]*CdreTemplateConfig)
+	self.CdreJobs = make(map[string]*CdreJobConfig)
+	self.InvoiceNumberPrefix = "INV"
+	self.InvoiceCycles = make(map[string]*InvoiceCycleConfig)
 	self.CdrcEnabled = false
 	self.CdrcCdrs = utils.INTERNAL
-	self.CdrcCdrsMethod = "http_cgr"
+	self.CdrcCdrsMethod = utils.HTTP_CGR
 	self.CdrcRunDelay = time.Duration(0)
 	self.CdrcCdrType = "csv"
+	self.CdrcXmlRecordPath = ""
 	self.CdrcCdrInDir = "/var/log/cgrates/cdr/cdrc/in"
 	self.CdrcCdrOutDir = "/var/log/cgrates/cdr/cdrc/out"
+	self.CdrcFilePattern = ""
+	self.CdrcStableTime = time.Duration(0)
+	self.CdrcDoneSuffix = ""
 	self.CdrcSourceId = "freeswitch_csv"
 	self.CdrcAccIdField = "0"
 	self.CdrcReqTypeField = "1"
@@ -200,10 +290,22 @@ func (self *CGRConfig) setDefaults() error {
 	self.CdrcAnswerTimeField = "8"
 	self.CdrcDurationField = "9"
 	self.CdrcExtraFields = []string{}
+	self.CdrcSkipUnanswered = false
+	self.CdrcSkipRows = ""
+	self.CdrcFieldFilters = []string{}
+	self.CdrcProfiles = make(map[string]*CdrcConfig)
 	self.MediatorEnabled = false
 	self.MediatorRater = "internal"
 	self.MediatorRaterReconnects = 3
 	self.MediatorRunIds = []string{}
+	self.MediatorDuplicateCdrs = utils.DUPLICATE_SKIP
+	self.MediatorWorkers = 4
+	self.MediatorQueueLength = 100
+	self.MediatorCostTimeout = time.Duration(3) * time.Second
+	self.MediatorCorrelationField = ""
+	self.MediatorCustomerRunId = utils.DEFAULT_RUNID
+	self.MediatorSupplierRunId = utils.DEFAULT_RUNID
+	self.MediatorCorrelationWait = time.Duration(60) * time.Second
 	self.MediatorSubjectFields = []string{}
 	self.MediatorReqTypeFields = []string{}
 	self.MediatorDirectionFields = []string{}
@@ -218,10 +320,46 @@ func (self *CGRConfig) setDefaults() error {
 	self.SMRater = "internal"
 	self.SMRaterReconnects = 3
 	self.SMDebitInterval = 10
+	self.SMDebitMargin = time.Duration(1) * time.Second
 	self.SMMaxCallDuration = time.Duration(3) * time.Hour
+	self.SMLowBalanceWarnings = []time.Duration{}
+	self.SMLowBalanceAccounts = make(map[string][]time.Duration)
+	self.SMLowBalanceSubjects = make(map[string][]time.Duration)
+	self.SMLowBalanceUrl = ""
 	self.FreeswitchServer = "127.0.0.1:8021"
 	self.FreeswitchPass = "ClueCon"
 	self.FreeswitchReconnects = 5
+	self.FreeswitchLowBalanceAnn = ""
+	self.DAEnabled = false
+	self.DAListen = "127.0.0.1:3868"
+	self.DARater = "internal"
+	self.DARaterReconnects = 3
+	self.DADebitInterval = time.Duration(5) * time.Minute
+	self.DAOriginHost = "CGR-DA"
+	self.DAOriginRealm = "cgrates.org"
+	self.DAVendorId = 0
+	self.DAProductName = "CGRateS"
+	self.RAEnabled = false
+	self.RAListenAuth = "127.0.0.1:1812"
+	self.RAListenAcct = "127.0.0.1:1813"
+	self.RASecret = "CGRateS.org"
+	self.RADictionariesDir = ""
+	self.RARater = "internal"
+	self.RARaterReconnects = 3
+	self.RACdrs = "internal"
+	self.RAMaxCallDuration = time.Duration(3) * time.Hour
+	self.RADisconnectPort = 3799
+	self.RASourceId = "radius"
+	self.RAAccIdField = "Acct-Session-Id"
+	self.RAReqTypeField = "^" + utils.PREPAID
+	self.RADirectionField = "^*out"
+	self.RATenantField = "^cgrates.org"
+	self.RATorField = "^call"
+	self.RAAccountField = "User-Name"
+	self.RASubjectField = "User-Name"
+	self.RADestinationField = "Called-Station-Id"
+	self.RADurationField = "Acct-Session-Time"
+	self.RAExtraFields = []string{}
 	self.HistoryAgentEnabled = false
 	self.HistoryServerEnabled = false
 	self.HistoryServer = "internal"
@@ -369,6 +507,54 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("cdrs", "mediator"); hasOpt {
 		cfg.CDRSMediator, _ = c.GetString("cdrs", "mediator")
 	}
+	if hasOpt = c.HasOption("cdrs", "partial_flag_field"); hasOpt {
+		cfg.CDRSPartialFlagField, _ = c.GetString("cdrs", "partial_flag_field")
+	}
+	if hasOpt = c.HasOption("cdrs", "partial_seq_field"); hasOpt {
+		cfg.CDRSPartialSeqField, _ = c.GetString("cdrs", "partial_seq_field")
+	}
+	if hasOpt = c.HasOption("cdrs", "partial_timeout"); hasOpt {
+		timeoutStr, _ := c.GetString("cdrs", "partial_timeout")
+		if cfg.CDRSPartialTimeout, errParse = utils.ParseDurationWithSecs(timeoutStr); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption("cdrs", "partial_dir"); hasOpt {
+		cfg.CDRSPartialDir, _ = c.GetString("cdrs", "partial_dir")
+	}
+	if hasOpt = c.HasOption("cdrs", "replication_dir"); hasOpt {
+		cfg.CDRSReplicationDir, _ = c.GetString("cdrs", "replication_dir")
+	}
+	if hasOpt = c.HasOption("cdrs", "replication_retry"); hasOpt {
+		retryStr, _ := c.GetString("cdrs", "replication_retry")
+		if cfg.CDRSReplicationRetry, errParse = utils.ParseDurationWithSecs(retryStr); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption("cdrs", "replication_timeout"); hasOpt {
+		timeoutStr, _ := c.GetString("cdrs", "replication_timeout")
+		if cfg.CDRSReplicationTimeout, errParse = utils.ParseDurationWithSecs(timeoutStr); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if cfg.CDRSReplicationTargets, errParse = loadCdrReplicationTargets(c); errParse != nil {
+		return nil, errParse
+	}
+	if hasOpt = c.HasOption("cdrstats", "enabled"); hasOpt {
+		cfg.CdrStatsEnabled, _ = c.GetBool("cdrstats", "enabled")
+	}
+	if cfg.CdrStatsQueues, errParse = loadCdrStatsQueues(c); errParse != nil {
+		return nil, errParse
+	}
+	if hasOpt = c.HasOption("fraud", "enabled"); hasOpt {
+		cfg.FraudEnabled, _ = c.GetBool("fraud", "enabled")
+	}
+	if hasOpt = c.HasOption("fraud", "max_incidents"); hasOpt {
+		cfg.FraudMaxIncidents, _ = c.GetInt("fraud", "max_incidents")
+	}
+	if cfg.FraudRules, errParse = loadFraudRules(c); errParse != nil {
+		return nil, errParse
+	}
 	if hasOpt = c.HasOption("cdre", "cdr_format"); hasOpt {
 		cfg.CdreCdrFormat, _ = c.GetString("cdre", "cdr_format")
 	}
@@ -380,6 +566,18 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("cdre", "export_dir"); hasOpt {
 		cfg.CdreDir, _ = c.GetString("cdre", "export_dir")
 	}
+	if cfg.CdreTemplates, errParse = loadCdreTemplates(c); errParse != nil {
+		return nil, errParse
+	}
+	if cfg.CdreJobs, errParse = loadCdreJobs(c, cfg.CdreTemplates); errParse != nil {
+		return nil, errParse
+	}
+	if hasOpt = c.HasOption("invoices", "number_prefix"); hasOpt {
+		cfg.InvoiceNumberPrefix, _ = c.GetString("invoices", "number_prefix")
+	}
+	if cfg.InvoiceCycles, errParse = loadInvoiceCycles(c); errParse != nil {
+		return nil, errParse
+	}
 	if hasOpt = c.HasOption("cdrc", "enabled"); hasOpt {
 		cfg.CdrcEnabled, _ = c.GetBool("cdrc", "enabled")
 	}
@@ -398,12 +596,27 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("cdrc", "cdr_type"); hasOpt {
 		cfg.CdrcCdrType, _ = c.GetString("cdrc", "cdr_type")
 	}
+	if hasOpt = c.HasOption("cdrc", "xml_record_path"); hasOpt {
+		cfg.CdrcXmlRecordPath, _ = c.GetString("cdrc", "xml_record_path")
+	}
 	if hasOpt = c.HasOption("cdrc", "cdr_in_dir"); hasOpt {
 		cfg.CdrcCdrInDir, _ = c.GetString("cdrc", "cdr_in_dir")
 	}
 	if hasOpt = c.HasOption("cdrc", "cdr_out_dir"); hasOpt {
 		cfg.CdrcCdrOutDir, _ = c.GetString("cdrc", "cdr_out_dir")
 	}
+	if hasOpt = c.HasOption("cdrc", "file_pattern"); hasOpt {
+		cfg.CdrcFilePattern, _ = c.GetString("cdrc", "file_pattern")
+	}
+	if hasOpt = c.HasOption("cdrc", "stable_time"); hasOpt {
+		durStr, _ := c.GetString("cdrc", "stable_time")
+		if cfg.CdrcStableTime, errParse = utils.ParseDurationWithSecs(durStr); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption("cdrc", "done_suffix"); hasOpt {
+		cfg.CdrcDoneSuffix, _ = c.GetString("cdrc", "done_suffix")
+	}
 	if hasOpt = c.HasOption("cdrc", "cdr_source_id"); hasOpt {
 		cfg.CdrcSourceId, _ = c.GetString("cdrc", "cdr_source_id")
 	}
@@ -442,6 +655,20 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 			return nil, errParse
 		}
 	}
+	if hasOpt = c.HasOption("cdrc", "skip_unanswered"); hasOpt {
+		cfg.CdrcSkipUnanswered, _ = c.GetBool("cdrc", "skip_unanswered")
+	}
+	if hasOpt = c.HasOption("cdrc", "skip_rows"); hasOpt {
+		cfg.CdrcSkipRows, _ = c.GetString("cdrc", "skip_rows")
+	}
+	if hasOpt = c.HasOption("cdrc", "field_filters"); hasOpt {
+		if cfg.CdrcFieldFilters, errParse = ConfigSlice(c, "cdrc", "field_filters"); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if cfg.CdrcProfiles, errParse = loadCdrcProfiles(c, cfg.DefaultCdrcConfig()); errParse != nil {
+		return nil, errParse
+	}
 	if hasOpt = c.HasOption("mediator", "enabled"); hasOpt {
 		cfg.MediatorEnabled, _ = c.GetBool("mediator", "enabled")
 	}
@@ -451,6 +678,36 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("mediator", "rater_reconnects"); hasOpt {
 		cfg.MediatorRaterReconnects, _ = c.GetInt("mediator", "rater_reconnects")
 	}
+	if hasOpt = c.HasOption("mediator", "duplicate_cdrs"); hasOpt {
+		cfg.MediatorDuplicateCdrs, _ = c.GetString("mediator", "duplicate_cdrs")
+	}
+	if hasOpt = c.HasOption("mediator", "workers"); hasOpt {
+		cfg.MediatorWorkers, _ = c.GetInt("mediator", "workers")
+	}
+	if hasOpt = c.HasOption("mediator", "queue_length"); hasOpt {
+		cfg.MediatorQueueLength, _ = c.GetInt("mediator", "queue_length")
+	}
+	if hasOpt = c.HasOption("mediator", "cost_timeout"); hasOpt {
+		costTimeoutStr, _ := c.GetString("mediator", "cost_timeout")
+		if cfg.MediatorCostTimeout, errParse = utils.ParseDurationWithSecs(costTimeoutStr); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption("mediator", "correlation_field"); hasOpt {
+		cfg.MediatorCorrelationField, _ = c.GetString("mediator", "correlation_field")
+	}
+	if hasOpt = c.HasOption("mediator", "customer_run_id"); hasOpt {
+		cfg.MediatorCustomerRunId, _ = c.GetString("mediator", "customer_run_id")
+	}
+	if hasOpt = c.HasOption("mediator", "supplier_run_id"); hasOpt {
+		cfg.MediatorSupplierRunId, _ = c.GetString("mediator", "supplier_run_id")
+	}
+	if hasOpt = c.HasOption("mediator", "correlation_wait"); hasOpt {
+		waitStr, _ := c.GetString("mediator", "correlation_wait")
+		if cfg.MediatorCorrelationWait, errParse = utils.ParseDurationWithSecs(waitStr); errParse != nil {
+			return nil, errParse
+		}
+	}
 	if hasOpt = c.HasOption("mediator", "run_ids"); hasOpt {
 		if cfg.MediatorRunIds, errParse = ConfigSlice(c, "mediator", "run_ids"); errParse != nil {
 			return nil, errParse
@@ -516,12 +773,29 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("session_manager", "debit_interval"); hasOpt {
 		cfg.SMDebitInterval, _ = c.GetInt("session_manager", "debit_interval")
 	}
+	if hasOpt = c.HasOption("session_manager", "debit_margin"); hasOpt {
+		debitMarginStr, _ := c.GetString("session_manager", "debit_margin")
+		if cfg.SMDebitMargin, errParse = utils.ParseDurationWithSecs(debitMarginStr); errParse != nil {
+			return nil, errParse
+		}
+	}
 	if hasOpt = c.HasOption("session_manager", "max_call_duration"); hasOpt {
 		maxCallDurStr, _ := c.GetString("session_manager", "max_call_duration")
 		if cfg.SMMaxCallDuration, errParse = utils.ParseDurationWithSecs(maxCallDurStr); errParse != nil {
 			return nil, errParse
 		}
 	}
+	if hasOpt = c.HasOption("session_manager", "low_balance_warnings"); hasOpt {
+		if cfg.SMLowBalanceWarnings, errParse = ConfigDurationSlice(c, "session_manager", "low_balance_warnings"); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if cfg.SMLowBalanceAccounts, cfg.SMLowBalanceSubjects, errParse = loadLowBalanceProfiles(c); errParse != nil {
+		return nil, errParse
+	}
+	if hasOpt = c.HasOption("session_manager", "low_balance_url"); hasOpt {
+		cfg.SMLowBalanceUrl, _ = c.GetString("session_manager", "low_balance_url")
+	}
 	if hasOpt = c.HasOption("freeswitch", "server"); hasOpt {
 		cfg.FreeswitchServer, _ = c.GetString("freeswitch", "server")
 	}
@@ -531,6 +805,107 @@ func loadConfig(c *conf.ConfigFile) (*CGRConfig, error) {
 	if hasOpt = c.HasOption("freeswitch", "reconnects"); hasOpt {
 		cfg.FreeswitchReconnects, _ = c.GetInt("freeswitch", "reconnects")
 	}
+	if hasOpt = c.HasOption("freeswitch", "low_balance_announcement"); hasOpt {
+		cfg.FreeswitchLowBalanceAnn, _ = c.GetString("freeswitch", "low_balance_announcement")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "enabled"); hasOpt {
+		cfg.DAEnabled, _ = c.GetBool("diameter_agent", "enabled")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "listen"); hasOpt {
+		cfg.DAListen, _ = c.GetString("diameter_agent", "listen")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "rater"); hasOpt {
+		cfg.DARater, _ = c.GetString("diameter_agent", "rater")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "rater_reconnects"); hasOpt {
+		cfg.DARaterReconnects, _ = c.GetInt("diameter_agent", "rater_reconnects")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "debit_interval"); hasOpt {
+		debitIntvlStr, _ := c.GetString("diameter_agent", "debit_interval")
+		if cfg.DADebitInterval, errParse = utils.ParseDurationWithSecs(debitIntvlStr); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption("diameter_agent", "origin_host"); hasOpt {
+		cfg.DAOriginHost, _ = c.GetString("diameter_agent", "origin_host")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "origin_realm"); hasOpt {
+		cfg.DAOriginRealm, _ = c.GetString("diameter_agent", "origin_realm")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "vendor_id"); hasOpt {
+		cfg.DAVendorId, _ = c.GetInt("diameter_agent", "vendor_id")
+	}
+	if hasOpt = c.HasOption("diameter_agent", "product_name"); hasOpt {
+		cfg.DAProductName, _ = c.GetString("diameter_agent", "product_name")
+	}
+	if hasOpt = c.HasOption("radius_agent", "enabled"); hasOpt {
+		cfg.RAEnabled, _ = c.GetBool("radius_agent", "enabled")
+	}
+	if hasOpt = c.HasOption("radius_agent", "listen_auth"); hasOpt {
+		cfg.RAListenAuth, _ = c.GetString("radius_agent", "listen_auth")
+	}
+	if hasOpt = c.HasOption("radius_agent", "listen_acct"); hasOpt {
+		cfg.RAListenAcct, _ = c.GetString("radius_agent", "listen_acct")
+	}
+	if hasOpt = c.HasOption("radius_agent", "secret"); hasOpt {
+		cfg.RASecret, _ = c.GetString("radius_agent", "secret")
+	}
+	if hasOpt = c.HasOption("radius_agent", "dictionaries_dir"); hasOpt {
+		cfg.RADictionariesDir, _ = c.GetString("radius_agent", "dictionaries_dir")
+	}
+	if hasOpt = c.HasOption("radius_agent", "rater"); hasOpt {
+		cfg.RARater, _ = c.GetString("radius_agent", "rater")
+	}
+	if hasOpt = c.HasOption("radius_agent", "rater_reconnects"); hasOpt {
+		cfg.RARaterReconnects, _ = c.GetInt("radius_agent", "rater_reconnects")
+	}
+	if hasOpt = c.HasOption("radius_agent", "cdrs"); hasOpt {
+		cfg.RACdrs, _ = c.GetString("radius_agent", "cdrs")
+	}
+	if hasOpt = c.HasOption("radius_agent", "max_call_duration"); hasOpt {
+		maxCallDurStr, _ := c.GetString("radius_agent", "max_call_duration")
+		if cfg.RAMaxCallDuration, errParse = utils.ParseDurationWithSecs(maxCallDurStr); errParse != nil {
+			return nil, errParse
+		}
+	}
+	if hasOpt = c.HasOption("radius_agent", "disconnect_port"); hasOpt {
+		cfg.RADisconnectPort, _ = c.GetInt("radius_agent", "disconnect_port")
+	}
+	if hasOpt = c.HasOption("radius_agent", "source_id"); hasOpt {
+		cfg.RASourceId, _ = c.GetString("radius_agent", "source_id")
+	}
+	if hasOpt = c.HasOption("radius_agent", "accid_field"); hasOpt {
+		cfg.RAAccIdField, _ = c.GetString("radius_agent", "accid_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "reqtype_field"); hasOpt {
+		cfg.RAReqTypeField, _ = c.GetString("radius_agent", "reqtype_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "direction_field"); hasOpt {
+		cfg.RADirectionField, _ = c.GetString("radius_agent", "direction_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "tenant_field"); hasOpt {
+		cfg.RATenantField, _ = c.GetString("radius_agent", "tenant_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "tor_field"); hasOpt {
+		cfg.RATorField, _ = c.GetString("radius_agent", "tor_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "account_field"); hasOpt {
+		cfg.RAAccountField, _ = c.GetString("radius_agent", "account_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "subject_field"); hasOpt {
+		cfg.RASubjectField, _ = c.GetString("radius_agent", "subject_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "destination_field"); hasOpt {
+		cfg.RADestinationField, _ = c.GetString("radius_agent", "destination_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "duration_field"); hasOpt {
+		cfg.RADurationField, _ = c.GetString("radius_agent", "duration_field")
+	}
+	if hasOpt = c.HasOption("radius_agent", "extra_fields"); hasOpt {
+		if cfg.RAExtraFields, errParse = ConfigSlice(c, "radius_agent", "extra_fields"); errParse != nil {
+			return nil, errParse
+		}
+	}
 	if hasOpt = c.HasOption("history_agent", "enabled"); hasOpt {
 		cfg.HistoryAgentEnabled, _ = c.GetBool("history_agent", "enabled")
 	}
@@ -80,16 +80,37 @@ func TestDefaults(t *testing.T) {
 	eCfg.CDRSEnabled = false
 	eCfg.CDRSExtraFields = []string{}
 	eCfg.CDRSMediator = ""
+	eCfg.CDRSPartialFlagField = ""
+	eCfg.CDRSPartialSeqField = ""
+	eCfg.CDRSPartialTimeout = time.Duration(3600) * time.Second
+	eCfg.CDRSPartialDir = "/var/log/cgrates/cdr/partial"
+	eCfg.CDRSReplicationDir = "/var/log/cgrates/cdr/replication"
+	eCfg.CDRSReplicationRetry = time.Duration(60) * time.Second
+	eCfg.CDRSReplicationTimeout = time.Duration(10) * time.Second
+	eCfg.CDRSReplicationTargets = make(map[string]*CdrReplicationConfig)
+	eCfg.CdrStatsEnabled = false
+	eCfg.CdrStatsQueues = make(map[string]*CdrStatsConfig)
+	eCfg.FraudEnabled = false
+	eCfg.FraudMaxIncidents = 1000
+	eCfg.FraudRules = make(map[string]*FraudRuleConfig)
 	eCfg.CdreCdrFormat = "csv"
 	eCfg.CdreExtraFields = []string{}
+	eCfg.CdreTemplates = make(map[string]*CdreTemplateConfig)
+	eCfg.CdreJobs = make(map[string]*CdreJobConfig)
+	eCfg.InvoiceNumberPrefix = "INV"
+	eCfg.InvoiceCycles = make(map[string]*InvoiceCycleConfig)
 	eCfg.CdreDir = "/var/log/cgrates/cdr/cdrexport/csv"
 	eCfg.CdrcEnabled = false
 	eCfg.CdrcCdrs = utils.INTERNAL
 	eCfg.CdrcCdrsMethod = "http_cgr"
 	eCfg.CdrcRunDelay = time.Duration(0)
 	eCfg.CdrcCdrType = "csv"
+	eCfg.CdrcXmlRecordPath = ""
 	eCfg.CdrcCdrInDir = "/var/log/cgrates/cdr/cdrc/in"
 	eCfg.CdrcCdrOutDir = "/var/log/cgrates/cdr/cdrc/out"
+	eCfg.CdrcFilePattern = ""
+	eCfg.CdrcStableTime = time.Duration(0)
+	eCfg.CdrcDoneSuffix = ""
 	eCfg.CdrcSourceId = "freeswitch_csv"
 	eCfg.CdrcAccIdField = "0"
 	eCfg.CdrcReqTypeField = "1"
@@ -102,10 +123,22 @@ func TestDefaults(t *testing.T) {
 	eCfg.CdrcAnswerTimeField = "8"
 	eCfg.CdrcDurationField = "9"
 	eCfg.CdrcExtraFields = []string{}
+	eCfg.CdrcSkipUnanswered = false
+	eCfg.CdrcSkipRows = ""
+	eCfg.CdrcFieldFilters = []string{}
+	eCfg.CdrcProfiles = make(map[string]*CdrcConfig)
 	eCfg.MediatorEnabled = false
 	eCfg.MediatorRater = "internal"
 	eCfg.MediatorRaterReconnects = 3
 	eCfg.MediatorRunIds = []string{}
+	eCfg.MediatorDuplicateCdrs = utils.DUPLICATE_SKIP
+	eCfg.MediatorWorkers = 4
+	eCfg.MediatorQueueLength = 100
+	eCfg.MediatorCostTimeout = time.Duration(3) * time.Second
+	eCfg.MediatorCorrelationField = ""
+	eCfg.MediatorCustomerRunId = utils.DEFAULT_RUNID
+	eCfg.MediatorSupplierRunId = utils.DEFAULT_RUNID
+	eCfg.MediatorCorrelationWait = time.Duration(60) * time.Second
 	eCfg.MediatorSubjectFields = []string{}
 	eCfg.MediatorReqTypeFields = []string{}
 	eCfg.MediatorDirectionFields = []string{}
@@ -120,10 +153,46 @@ func TestDefaults(t *testing.T) {
 	eCfg.SMRater = "internal"
 	eCfg.SMRaterReconnects = 3
 	eCfg.SMDebitInterval = 10
+	eCfg.SMDebitMargin = time.Duration(1) * time.Second
 	eCfg.SMMaxCallDuration = time.Duration(3) * time.Hour
+	eCfg.SMLowBalanceWarnings = []time.Duration{}
+	eCfg.SMLowBalanceAccounts = make(map[string][]time.Duration)
+	eCfg.SMLowBalanceSubjects = make(map[string][]time.Duration)
+	eCfg.SMLowBalanceUrl = ""
 	eCfg.FreeswitchServer = "127.0.0.1:8021"
 	eCfg.FreeswitchPass = "ClueCon"
 	eCfg.FreeswitchReconnects = 5
+	eCfg.FreeswitchLowBalanceAnn = ""
+	eCfg.DAEnabled = false
+	eCfg.DAListen = "127.0.0.1:3868"
+	eCfg.DARater = "internal"
+	eCfg.DARaterReconnects = 3
+	eCfg.DADebitInterval = time.Duration(5) * time.Minute
+	eCfg.DAOriginHost = "CGR-DA"
+	eCfg.DAOriginRealm = "cgrates.org"
+	eCfg.DAVendorId = 0
+	eCfg.DAProductName = "CGRateS"
+	eCfg.RAEnabled = false
+	eCfg.RAListenAuth = "127.0.0.1:1812"
+	eCfg.RAListenAcct = "127.0.0.1:1813"
+	eCfg.RASecret = "CGRateS.org"
+	eCfg.RADictionariesDir = ""
+	eCfg.RARater = "internal"
+	eCfg.RARaterReconnects = 3
+	eCfg.RACdrs = "internal"
+	eCfg.RAMaxCallDuration = time.Duration(3) * time.Hour
+	eCfg.RADisconnectPort = 3799
+	eCfg.RASourceId = "radius"
+	eCfg.RAAccIdField = "Acct-Session-Id"
+	eCfg.RAReqTypeField = "^prepaid"
+	eCfg.RADirectionField = "^*out"
+	eCfg.RATenantField = "^cgrates.org"
+	eCfg.RATorField = "^call"
+	eCfg.RAAccountField = "User-Name"
+	eCfg.RASubjectField = "User-Name"
+	eCfg.RADestinationField = "Called-Station-Id"
+	eCfg.RADurationField = "Acct-Session-Time"
+	eCfg.RAExtraFields = []string{}
 	eCfg.HistoryAgentEnabled = false
 	eCfg.HistoryServer = "internal"
 	eCfg.HistoryServerEnabled = false
@@ -204,16 +273,55 @@ func TestConfigFromFile(t *testing.T) {
 	eCfg.CDRSEnabled = true
 	eCfg.CDRSExtraFields = []string{"test"}
 	eCfg.CDRSMediator = "test"
+	eCfg.CDRSPartialFlagField = "test"
+	eCfg.CDRSPartialSeqField = "test"
+	eCfg.CDRSPartialTimeout = time.Duration(99) * time.Second
+	eCfg.CDRSPartialDir = "test"
+	eCfg.CDRSReplicationDir = "test"
+	eCfg.CDRSReplicationRetry = time.Duration(99) * time.Second
+	eCfg.CDRSReplicationTimeout = time.Duration(99) * time.Second
+	eCfg.CDRSReplicationTargets = map[string]*CdrReplicationConfig{"test": &CdrReplicationConfig{Id: "test", Address: "test", Transport: "http_json",
+		Tenants: []string{"test"}, ReqTypes: []string{"test"}}}
+	eCfg.CdrStatsEnabled = true
+	eCfg.CdrStatsQueues = map[string]*CdrStatsConfig{"test": &CdrStatsConfig{Id: "test", QueueLength: 99, TimeWindow: time.Duration(99) * time.Second,
+		Tenants: []string{"test"}, Accounts: []string{"test"}, DestinationPrefixes: []string{"test"}, SupplierField: "test", Suppliers: []string{"test"},
+		MediationRunIds: []string{"test"}, Thresholds: []*CdrStatsThreshold{&CdrStatsThreshold{Metric: "ASR", ThresholdType: "*min", ThresholdValue: 99, ActionsId: "test"}}}}
+	eCfg.FraudEnabled = true
+	eCfg.FraudMaxIncidents = 99
+	eCfg.FraudRules = map[string]*FraudRuleConfig{"test": &FraudRuleConfig{Id: "test", RuleType: "*risky_destination", Threshold: 99,
+		TimeWindow: time.Duration(99) * time.Second, DestinationPrefixes: []string{"test"}, RejectCall: true, ActionsId: "test"}}
 	eCfg.CdreCdrFormat = "test"
 	eCfg.CdreExtraFields = []string{"test"}
 	eCfg.CdreDir = "test"
+	eCfg.CdreTemplates = map[string]*CdreTemplateConfig{"test": &CdreTemplateConfig{Id: "test", CdrFormat: "fixed_width", FieldSeparator: "|",
+		HeaderFields: []*CdreFieldConfig{
+			&CdreFieldConfig{Name: "^10", Source: "^10", Width: 2, Padding: "right", Decimals: -1, Layout: time.RFC3339, DurationUnit: "s"},
+			&CdreFieldConfig{Name: "*cdrs_number", Source: "*cdrs_number", Width: 99, Padding: "zeroleft", Decimals: -1, Layout: time.RFC3339, DurationUnit: "s"}},
+		ContentFields: []*CdreFieldConfig{
+			&CdreFieldConfig{Name: "test", Source: "test", Width: 99, Padding: "right", Strip: "left", Decimals: 99, Layout: "test", DurationUnit: "ms"}},
+		TrailerFields: []*CdreFieldConfig{
+			&CdreFieldConfig{Name: "*total_cost", Source: "*total_cost", Width: 99, Padding: "left", Decimals: -1, Layout: time.RFC3339, DurationUnit: "s"}}}}
+	eCfg.CdreJobs = map[string]*CdreJobConfig{"test": &CdreJobConfig{Id: "test", ExportTemplate: "test", ExportDir: "test", FileName: "test",
+		ExportPeriod: "*previous_day", Years: utils.Years{2099}, Months: utils.Months{time.September}, MonthDays: utils.MonthDays{9},
+		WeekDays: utils.WeekDays{time.Monday, time.Tuesday}, StartTime: "09:09:09", Tenants: []string{"test"}, Accounts: []string{"test"},
+		ReqTypes: []string{"test"}, MediationRunIds: []string{"test"}, CdrSources: []string{"test"}, DestinationPrefixes: []string{"test"},
+		SkipErrors: true, MarkExported: false}}
+	eCfg.InvoiceNumberPrefix = "test"
+	eCfg.InvoiceCycles = map[string]*InvoiceCycleConfig{"test": &InvoiceCycleConfig{Id: "test", Tenant: "test", Accounts: []string{"test"},
+		Period: "*previous_day", Years: utils.Years{2099}, Months: utils.Months{time.September}, MonthDays: utils.MonthDays{9},
+		WeekDays: utils.WeekDays{time.Monday, time.Tuesday}, StartTime: "09:09:09", MediationRunIds: []string{"test"}, GroupFields: []string{"test"},
+		RecurringFees: []*InvoiceFeeConfig{&InvoiceFeeConfig{Description: "test", Amount: 99}}, Currency: "test", Template: "test"}}
 	eCfg.CdrcEnabled = true
 	eCfg.CdrcCdrs = "test"
 	eCfg.CdrcCdrsMethod = "test"
 	eCfg.CdrcRunDelay = time.Duration(99) * time.Second
 	eCfg.CdrcCdrType = "test"
+	eCfg.CdrcXmlRecordPath = "test"
 	eCfg.CdrcCdrInDir = "test"
 	eCfg.CdrcCdrOutDir = "test"
+	eCfg.CdrcFilePattern = "test"
+	eCfg.CdrcStableTime = time.Duration(99) * time.Second
+	eCfg.CdrcDoneSuffix = "test"
 	eCfg.CdrcSourceId = "test"
 	eCfg.CdrcAccIdField = "test"
 	eCfg.CdrcReqTypeField = "test"
@@ -226,10 +334,27 @@ func TestConfigFromFile(t *testing.T) {
 	eCfg.CdrcAnswerTimeField = "test"
 	eCfg.CdrcDurationField = "test"
 	eCfg.CdrcExtraFields = []string{"test"}
+	eCfg.CdrcSkipUnanswered = true
+	eCfg.CdrcSkipRows = "test"
+	eCfg.CdrcFieldFilters = []string{"test"}
+	eCfg.CdrcProfiles = map[string]*CdrcConfig{"test": &CdrcConfig{Id: "test", Enabled: false, Cdrs: "test", CdrsMethod: "test", RunDelay: time.Duration(99) * time.Second,
+		CdrType: "test", XmlRecordPath: "test", CdrInDir: "test_profile", CdrOutDir: "test", FilePattern: "test",
+		StableTime: time.Duration(99) * time.Second, DoneSuffix: "test", CdrSourceId: "test", AccIdField: "test", ReqTypeField: "test",
+		DirectionField: "test", TenantField: "test", TorField: "test", AccountField: "test", SubjectField: "test", DestinationField: "test",
+		AnswerTimeField: "test", DurationField: "test", ExtraFields: []string{"test", "test_profile"}, SkipUnanswered: true, SkipRows: "test",
+		FieldFilters: []string{"test"}}}
 	eCfg.MediatorEnabled = true
 	eCfg.MediatorRater = "test"
 	eCfg.MediatorRaterReconnects = 99
 	eCfg.MediatorRunIds = []string{"test"}
+	eCfg.MediatorDuplicateCdrs = "test"
+	eCfg.MediatorWorkers = 99
+	eCfg.MediatorQueueLength = 99
+	eCfg.MediatorCostTimeout = time.Duration(99) * time.Second
+	eCfg.MediatorCorrelationField = "test"
+	eCfg.MediatorCustomerRunId = "test"
+	eCfg.MediatorSupplierRunId = "test"
+	eCfg.MediatorCorrelationWait = time.Duration(99) * time.Second
 	eCfg.MediatorSubjectFields = []string{"test"}
 	eCfg.MediatorReqTypeFields = []string{"test"}
 	eCfg.MediatorDirectionFields = []string{"test"}
@@ -244,10 +369,46 @@ func TestConfigFromFile(t *testing.T) {
 	eCfg.SMRater = "test"
 	eCfg.SMRaterReconnects = 99
 	eCfg.SMDebitInterval = 99
+	eCfg.SMDebitMargin = time.Duration(99) * time.Second
 	eCfg.SMMaxCallDuration = time.Duration(99) * time.Second
+	eCfg.SMLowBalanceWarnings = []time.Duration{time.Duration(99) * time.Second}
+	eCfg.SMLowBalanceAccounts = map[string][]time.Duration{"test:test": []time.Duration{time.Duration(99) * time.Second}}
+	eCfg.SMLowBalanceSubjects = map[string][]time.Duration{"test:test": []time.Duration{time.Duration(99) * time.Second}}
+	eCfg.SMLowBalanceUrl = "test"
 	eCfg.FreeswitchServer = "test"
 	eCfg.FreeswitchPass = "test"
 	eCfg.FreeswitchReconnects = 99
+	eCfg.FreeswitchLowBalanceAnn = "test"
+	eCfg.DAEnabled = true
+	eCfg.DAListen = "test"
+	eCfg.DARater = "test"
+	eCfg.DARaterReconnects = 99
+	eCfg.DADebitInterval = time.Duration(99) * time.Second
+	eCfg.DAOriginHost = "test"
+	eCfg.DAOriginRealm = "test"
+	eCfg.DAVendorId = 99
+	eCfg.DAProductName = "test"
+	eCfg.RAEnabled = true
+	eCfg.RAListenAuth = "test"
+	eCfg.RAListenAcct = "test"
+	eCfg.RASecret = "test"
+	eCfg.RADictionariesDir = "test"
+	eCfg.RARater = "test"
+	eCfg.RARaterReconnects = 99
+	eCfg.RACdrs = "test"
+	eCfg.RAMaxCallDuration = time.Duration(99) * time.Second
+	eCfg.RADisconnectPort = 99
+	eCfg.RASourceId = "test"
+	eCfg.RAAccIdField = "test"
+	eCfg.RAReqTypeField = "test"
+	eCfg.RADirectionField = "test"
+	eCfg.RATenantField = "test"
+	eCfg.RATorField = "test"
+	eCfg.RAAccountField = "test"
+	eCfg.RASubjectField = "test"
+	eCfg.RADestinationField = "test"
+	eCfg.RADurationField = "test"
+	eCfg.RAExtraFields = []string{"test"}
 	eCfg.HistoryAgentEnabled = true
 	eCfg.HistoryServer = "test"
 	eCfg.HistoryServerEnabled = true
@@ -263,3 +424,72 @@ func TestConfigFromFile(t *testing.T) {
 		t.Error("Loading of configuration from file failed!")
 	}
 }
+
+func TestCdrcConfigs(t *testing.T) {
+	cfg, _ := NewDefaultCGRConfig()
+	cfg.CdrcProfiles = map[string]*CdrcConfig{"switch3": &CdrcConfig{Id: "switch3", Enabled: true}, "switch2": &CdrcConfig{Id: "switch2", Enabled: true},
+		"switch4": &CdrcConfig{Id: "switch4"}}
+	var cdrcIds []string
+	for _, cdrcCfg := range cfg.CdrcConfigs() {
+		cdrcIds = append(cdrcIds, cdrcCfg.Id)
+	}
+	if !reflect.DeepEqual([]string{"switch2", "switch3"}, cdrcIds) {
+		t.Error("Unexpected cdrc profiles: ", cdrcIds)
+	}
+	cfg.CdrcEnabled = true
+	if cdrcCfgs := cfg.CdrcConfigs(); len(cdrcCfgs) != 3 || cdrcCfgs[0].Id != CDRC_DEFAULT_PROFILE {
+		t.Error("Unexpected cdrc profiles: ", cdrcCfgs)
+	}
+}
+
+func TestCdrcProfilesSourceId(t *testing.T) {
+	cfg, err := NewCGRConfigBytes([]byte(`[cdrc]
+cdr_source_id = switch1
+[cdrc_profile_switch2]
+cdr_in_dir = /tmp/switch2
+[cdrc_profile_switch3]
+cdr_source_id = switch3_csv
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for profileId, eSourceId := range map[string]string{"switch2": "switch2", "switch3": "switch3_csv"} {
+		if sourceId := cfg.CdrcProfiles[profileId].CdrSourceId; sourceId != eSourceId {
+			t.Errorf("Profile %s, expecting source id: %s, received: %s", profileId, eSourceId, sourceId)
+		}
+	}
+}
+
+func TestLowBalanceProfiles(t *testing.T) {
+	cfgData := []byte(`
+[low_balance_profile_gold]
+tenant = cgrates.org
+accounts = 1001,1002
+warnings = 120s,60s
+
+[low_balance_profile_premium]
+tenant = cgrates.org
+subjects = 1001
+`)
+	cfg, err := NewCGRConfigBytes(cfgData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eAccounts := map[string][]time.Duration{"cgrates.org:1001": []time.Duration{time.Duration(120) * time.Second, time.Duration(60) * time.Second},
+		"cgrates.org:1002": []time.Duration{time.Duration(120) * time.Second, time.Duration(60) * time.Second}}
+	if !reflect.DeepEqual(eAccounts, cfg.SMLowBalanceAccounts) {
+		t.Errorf("Expecting: %v, received: %v", eAccounts, cfg.SMLowBalanceAccounts)
+	}
+	if eSubjects := map[string][]time.Duration{"cgrates.org:1001": []time.Duration{}}; !reflect.DeepEqual(eSubjects, cfg.SMLowBalanceSubjects) {
+		t.Errorf("Expecting: %v, received: %v", eSubjects, cfg.SMLowBalanceSubjects)
+	}
+	for _, invalidData := range []string{
+		"[low_balance_profile_gold]\naccounts = 1001\n",
+		"[low_balance_profile_gold]\ntenant = cgrates.org\n",
+		"[low_balance_profile_gold]\ntenant = cgrates.org\naccounts = 1001\n[low_balance_profile_silver]\ntenant = cgrates.org\naccounts = 1001\n",
+	} {
+		if _, err := NewCGRConfigBytes([]byte(invalidData)); err == nil {
+			t.Errorf("Expecting error for config: %s", invalidData)
+		}
+	}
+}
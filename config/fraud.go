@@ -0,0 +1,89 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+const FRAUD_RULE_PREFIX = "fraud_rule_" // Sections defining fraud rules, suffixed by the rule id
+
+// Configuration of one fraud detection rule
+type FraudRuleConfig struct {
+	Id                  string
+	RuleType            string        // One of the utils.FraudRuleTypes
+	Threshold           float64       // Cost or number of calls the rule matches over
+	TimeWindow          time.Duration // Period the costs are summed up on
+	DestinationPrefixes []string      // Destination prefixes considered risky
+	RejectCall          bool          // Reject the authorization matching the rule
+	ActionsId           string        // Actions executed on the account matching the rule
+}
+
+func NewDefaultFraudRuleConfig(id string) *FraudRuleConfig {
+	return &FraudRuleConfig{Id: id, TimeWindow: time.Duration(1) * time.Hour, DestinationPrefixes: []string{}}
+}
+
+// Loads the fraud rules out of their own config sections
+func loadFraudRules(c *conf.ConfigFile) (map[string]*FraudRuleConfig, error) {
+	rules := make(map[string]*FraudRuleConfig)
+	var err error
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, FRAUD_RULE_PREFIX) || len(section) == len(FRAUD_RULE_PREFIX) {
+			continue
+		}
+		rCfg := NewDefaultFraudRuleConfig(section[len(FRAUD_RULE_PREFIX):])
+		if c.HasOption(section, "rule_type") {
+			rCfg.RuleType, _ = c.GetString(section, "rule_type")
+		}
+		if !utils.IsSliceMember(utils.FraudRuleTypes, rCfg.RuleType) {
+			return nil, fmt.Errorf("Unsupported fraud rule type: <%s> for rule %s", rCfg.RuleType, rCfg.Id)
+		}
+		if c.HasOption(section, "threshold") {
+			thresholdStr, _ := c.GetString(section, "threshold")
+			if rCfg.Threshold, err = strconv.ParseFloat(thresholdStr, 64); err != nil {
+				return nil, err
+			}
+		}
+		if c.HasOption(section, "time_window") {
+			timeWindowStr, _ := c.GetString(section, "time_window")
+			if rCfg.TimeWindow, err = utils.ParseDurationWithSecs(timeWindowStr); err != nil {
+				return nil, err
+			}
+		}
+		if c.HasOption(section, "destination_prefixes") {
+			if rCfg.DestinationPrefixes, err = ConfigSlice(c, section, "destination_prefixes"); err != nil {
+				return nil, err
+			}
+		}
+		if c.HasOption(section, "reject_call") {
+			rCfg.RejectCall, _ = c.GetBool(section, "reject_call")
+		}
+		if c.HasOption(section, "actions_id") {
+			rCfg.ActionsId, _ = c.GetString(section, "actions_id")
+		}
+		rules[rCfg.Id] = rCfg
+	}
+	return rules, nil
+}
@@ -22,6 +22,9 @@ import (
 	"code.google.com/p/goconf/conf"
 	"errors"
 	"strings"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
 )
 
 // Adds support for slice values in config
@@ -42,3 +45,18 @@ func ConfigSlice(c *conf.ConfigFile, section, valName string) ([]string, error)
 	}
 	return cfgValStrs, nil
 }
+
+// Adds support for slice of durations in config
+func ConfigDurationSlice(c *conf.ConfigFile, section, valName string) ([]time.Duration, error) {
+	durStrs, err := ConfigSlice(c, section, valName)
+	if err != nil {
+		return nil, err
+	}
+	durs := make([]time.Duration, len(durStrs))
+	for idx, durStr := range durStrs {
+		if durs[idx], err = utils.ParseDurationWithSecs(strings.TrimSpace(durStr)); err != nil {
+			return nil, err
+		}
+	}
+	return durs, nil
+}
@@ -0,0 +1,168 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	INVOICE_CYCLE_PREFIX = "invoice_cycle_" // Sections defining billing cycles, suffixed by the cycle id
+	INVOICE_FEE_SEP      = ":"              // Separates the description of a recurring fee from its amount
+)
+
+// Amount charged on each invoice of a billing cycle
+type InvoiceFeeConfig struct {
+	Description string
+	Amount      float64
+}
+
+// Billing cycle, invoicing periodically the accounts of one tenant out of their rated CDRs
+type InvoiceCycleConfig struct {
+	Id              string
+	Tenant          string
+	Accounts        []string // Accounts invoiced, empty for the ones with rated CDRs within the period
+	Period          string   // CDRs answered within <*previous_day|*previous_month> before the run
+	Years           utils.Years
+	Months          utils.Months
+	MonthDays       utils.MonthDays // Runs daily if no days are defined
+	WeekDays        utils.WeekDays
+	StartTime       string              // Time of the day the cycle runs at
+	MediationRunIds []string            // Runs invoiced, each of them in its own section of the invoice
+	GroupFields     []string            // CDR fields grouping the invoice lines, search&replace templates accepted
+	RecurringFees   []*InvoiceFeeConfig // Charged on each invoice
+	Currency        string
+	Template        string // Path of the text/template rendering the invoices, html/template for .html files. Empty for the built-in text one
+}
+
+func NewDefaultInvoiceCycleConfig(id string) *InvoiceCycleConfig {
+	return &InvoiceCycleConfig{Id: id, Accounts: []string{}, Period: utils.PREVIOUS_MONTH, Years: utils.Years{}, Months: utils.Months{},
+		MonthDays: utils.MonthDays{1}, WeekDays: utils.WeekDays{}, StartTime: "00:00:00", MediationRunIds: []string{utils.DEFAULT_RUNID},
+		GroupFields: []string{utils.DESTINATION, utils.TOR, utils.SUBJECT}, RecurringFees: []*InvoiceFeeConfig{}}
+}
+
+// Parses the recurring fees out of description:amount values
+func parseInvoiceFees(feeStrs []string) ([]*InvoiceFeeConfig, error) {
+	fees := make([]*InvoiceFeeConfig, len(feeStrs))
+	for idx, feeStr := range feeStrs {
+		sepIdx := strings.LastIndex(feeStr, INVOICE_FEE_SEP)
+		if sepIdx == -1 {
+			return nil, fmt.Errorf("Invalid recurring fee: <%s>", feeStr)
+		}
+		amount, err := strconv.ParseFloat(feeStr[sepIdx+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid recurring fee: <%s>", feeStr)
+		}
+		fees[idx] = &InvoiceFeeConfig{Description: feeStr[:sepIdx], Amount: amount}
+	}
+	return fees, nil
+}
+
+// Loads the billing cycles out of their own config sections
+func loadInvoiceCycles(c *conf.ConfigFile) (map[string]*InvoiceCycleConfig, error) {
+	cycles := make(map[string]*InvoiceCycleConfig)
+	var err error
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, INVOICE_CYCLE_PREFIX) || len(section) == len(INVOICE_CYCLE_PREFIX) {
+			continue
+		}
+		cCfg := NewDefaultInvoiceCycleConfig(section[len(INVOICE_CYCLE_PREFIX):])
+		if c.HasOption(section, "tenant") {
+			cCfg.Tenant, _ = c.GetString(section, "tenant")
+		}
+		if len(cCfg.Tenant) == 0 {
+			return nil, fmt.Errorf("Missing tenant for billing cycle %s", cCfg.Id)
+		}
+		if c.HasOption(section, "period") {
+			cCfg.Period, _ = c.GetString(section, "period")
+			if cCfg.Period != utils.PREVIOUS_DAY && cCfg.Period != utils.PREVIOUS_MONTH {
+				return nil, fmt.Errorf("Invalid period: <%s> for billing cycle %s", cCfg.Period, cCfg.Id)
+			}
+		}
+		if c.HasOption(section, "years") {
+			yearsStr, _ := c.GetString(section, "years")
+			cCfg.Years.Parse(yearsStr, ",")
+		}
+		if c.HasOption(section, "months") {
+			monthsStr, _ := c.GetString(section, "months")
+			cCfg.Months.Parse(monthsStr, ",")
+		}
+		if c.HasOption(section, "month_days") {
+			monthDaysStr, _ := c.GetString(section, "month_days")
+			cCfg.MonthDays = utils.MonthDays{}
+			cCfg.MonthDays.Parse(monthDaysStr, ",")
+		}
+		if c.HasOption(section, "week_days") {
+			weekDaysStr, _ := c.GetString(section, "week_days")
+			cCfg.WeekDays.Parse(weekDaysStr, ",")
+		}
+		if c.HasOption(section, "start_time") {
+			cCfg.StartTime, _ = c.GetString(section, "start_time")
+			if _, err = time.Parse("15:04:05", cCfg.StartTime); err != nil {
+				return nil, fmt.Errorf("Invalid start_time: <%s> for billing cycle %s", cCfg.StartTime, cCfg.Id)
+			}
+		}
+		for _, optSlice := range []struct {
+			opt string
+			val *[]string
+		}{
+			{"accounts", &cCfg.Accounts},
+			{"mediation_run_ids", &cCfg.MediationRunIds},
+			{"group_fields", &cCfg.GroupFields},
+		} {
+			if c.HasOption(section, optSlice.opt) {
+				if *optSlice.val, err = ConfigSlice(c, section, optSlice.opt); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if len(cCfg.MediationRunIds) == 0 {
+			return nil, fmt.Errorf("Missing mediation_run_ids for billing cycle %s", cCfg.Id)
+		}
+		for _, fldStr := range cCfg.GroupFields {
+			if rsrField, err := utils.NewRSRField(fldStr); err != nil {
+				return nil, err
+			} else if len(rsrField.Id) == 0 {
+				return nil, fmt.Errorf("Invalid group field: <%s> for billing cycle %s", fldStr, cCfg.Id)
+			}
+		}
+		if c.HasOption(section, "recurring_fees") {
+			feeStrs, err := ConfigSlice(c, section, "recurring_fees")
+			if err != nil {
+				return nil, err
+			}
+			if cCfg.RecurringFees, err = parseInvoiceFees(feeStrs); err != nil {
+				return nil, err
+			}
+		}
+		if c.HasOption(section, "currency") {
+			cCfg.Currency, _ = c.GetString(section, "currency")
+		}
+		if c.HasOption(section, "template") {
+			cCfg.Template, _ = c.GetString(section, "template")
+		}
+		cycles[cCfg.Id] = cCfg
+	}
+	return cycles, nil
+}
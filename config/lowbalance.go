@@ -0,0 +1,86 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package config
+
+import (
+	"code.google.com/p/goconf/conf"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	LOW_BALANCE_PROFILE_PREFIX = "low_balance_profile_" // Sections defining low balance profiles, suffixed by the profile id
+	LOW_BALANCE_KEY_SEP        = ":"                    // Separates the tenant from the account or subject in the profile keys
+)
+
+// Indexes the low balance warnings of an account or subject, unique only within their tenant
+func LowBalanceKey(tenant, accountOrSubject string) string {
+	return tenant + LOW_BALANCE_KEY_SEP + accountOrSubject
+}
+
+// Loads the low balance warnings out of the profile sections, indexed on tenant:account and tenant:subject
+func loadLowBalanceProfiles(c *conf.ConfigFile) (accounts, subjects map[string][]time.Duration, err error) {
+	accounts, subjects = make(map[string][]time.Duration), make(map[string][]time.Duration)
+	for _, section := range c.GetSections() {
+		if !strings.HasPrefix(section, LOW_BALANCE_PROFILE_PREFIX) || len(section) == len(LOW_BALANCE_PROFILE_PREFIX) {
+			continue
+		}
+		profileId := section[len(LOW_BALANCE_PROFILE_PREFIX):]
+		tenant, _ := c.GetString(section, "tenant")
+		if len(tenant) == 0 {
+			return nil, nil, fmt.Errorf("Missing tenant for low balance profile %s", profileId)
+		}
+		warnings := []time.Duration{} // Disables the warnings if not configured
+		if c.HasOption(section, "warnings") {
+			if warnings, err = ConfigDurationSlice(c, section, "warnings"); err != nil {
+				return nil, nil, err
+			}
+		}
+		var accountIds, subjectIds []string
+		if c.HasOption(section, "accounts") {
+			if accountIds, err = ConfigSlice(c, section, "accounts"); err != nil {
+				return nil, nil, err
+			}
+		}
+		if c.HasOption(section, "subjects") {
+			if subjectIds, err = ConfigSlice(c, section, "subjects"); err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(accountIds) == 0 && len(subjectIds) == 0 {
+			return nil, nil, fmt.Errorf("Missing accounts or subjects for low balance profile %s", profileId)
+		}
+		for _, profiles := range []struct {
+			ids   []string
+			index map[string][]time.Duration
+		}{
+			{accountIds, accounts},
+			{subjectIds, subjects},
+		} {
+			for _, id := range profiles.ids {
+				key := LowBalanceKey(tenant, id)
+				if _, hasKey := profiles.index[key]; hasKey {
+					return nil, nil, fmt.Errorf("Low balance warnings for %s defined twice, in profile %s", key, profileId)
+				}
+				profiles.index[key] = warnings
+			}
+		}
+	}
+	return accounts, subjects, nil
+}
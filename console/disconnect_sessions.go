@@ -0,0 +1,74 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package console
+
+import (
+	"fmt"
+
+	"github.com/cgrates/cgrates/apier"
+)
+
+func init() {
+	commands["disconnect_sessions"] = &CmdDisconnectSessions{}
+}
+
+// Commander implementation
+type CmdDisconnectSessions struct {
+	rpcMethod string
+	rpcParams *apier.AttrDisconnectSessions
+	rpcResult []string
+}
+
+// name should be exec's name
+func (self *CmdDisconnectSessions) Usage(name string) string {
+	return fmt.Sprintf("\n\tUsage: cgr-console [cfg_opts...{-h}] disconnect_sessions <uuid>|<tenant> <account>")
+}
+
+// set param defaults
+func (self *CmdDisconnectSessions) defaults() error {
+	self.rpcMethod = "ApierV1.DisconnectSessions"
+	self.rpcParams = &apier.AttrDisconnectSessions{}
+	return nil
+}
+
+func (self *CmdDisconnectSessions) FromArgs(args []string) error {
+	self.defaults()
+	switch len(args) {
+	case 3:
+		self.rpcParams.Uuid = args[2]
+	case 4:
+		self.rpcParams.Tenant = args[2]
+		self.rpcParams.Account = args[3]
+	default:
+		return fmt.Errorf(self.Usage(""))
+	}
+	return nil
+}
+
+func (self *CmdDisconnectSessions) RpcMethod() string {
+	return self.rpcMethod
+}
+
+func (self *CmdDisconnectSessions) RpcParams() interface{} {
+	return self.rpcParams
+}
+
+func (self *CmdDisconnectSessions) RpcResult() interface{} {
+	return &self.rpcResult
+}
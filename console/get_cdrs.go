@@ -0,0 +1,121 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package console
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+func init() {
+	commands["get_cdrs"] = &CmdGetCdrs{}
+}
+
+// Commander implementation
+type CmdGetCdrs struct {
+	rpcMethod string
+	rpcParams *utils.AttrGetCdrs
+	rpcResult utils.GetCdrsReply
+}
+
+// name should be exec's name
+func (self *CmdGetCdrs) Usage(name string) string {
+	return fmt.Sprintf("\n\tUsage: cgr-console [cfg_opts...{-h}] get_cdrs [<filter>=<value>...]" +
+		"\n\tList filters, comma separated values: cgrids, accids, tenants, accounts, subjects, destination_prefixes, reqtypes, mediation_runids, cdrsources" +
+		"\n\tValue filters: answer_time_start, answer_time_end, min_cost, max_cost, min_duration, max_duration, order_by, order_descending, limit, offset, count")
+}
+
+// set param defaults
+func (self *CmdGetCdrs) defaults() error {
+	self.rpcMethod = "ApierV1.GetCdrs"
+	self.rpcParams = &utils.AttrGetCdrs{}
+	return nil
+}
+
+func (self *CmdGetCdrs) FromArgs(args []string) error {
+	self.defaults()
+	for _, arg := range args[2:] {
+		fltrVal := strings.SplitN(arg, "=", 2)
+		if len(fltrVal) != 2 {
+			return fmt.Errorf(self.Usage(""))
+		}
+		if err := self.setFilter(fltrVal[0], fltrVal[1]); err != nil {
+			return fmt.Errorf("Invalid value for %s: %s%s", fltrVal[0], err.Error(), self.Usage(""))
+		}
+	}
+	return nil
+}
+
+func (self *CmdGetCdrs) setFilter(fltrName, val string) (err error) {
+	listFltrs := map[string]*[]string{"cgrids": &self.rpcParams.CgrIds, "accids": &self.rpcParams.AccIds, "tenants": &self.rpcParams.Tenants,
+		"accounts": &self.rpcParams.Accounts, "subjects": &self.rpcParams.Subjects, "destination_prefixes": &self.rpcParams.DestinationPrefixes,
+		"reqtypes": &self.rpcParams.ReqTypes, "mediation_runids": &self.rpcParams.MediationRunIds, "cdrsources": &self.rpcParams.CdrSources}
+	if listFltr, hasKey := listFltrs[fltrName]; hasKey {
+		*listFltr = strings.Split(val, ",")
+		return nil
+	}
+	switch fltrName {
+	case "answer_time_start":
+		self.rpcParams.AnswerTimeStart = val
+	case "answer_time_end":
+		self.rpcParams.AnswerTimeEnd = val
+	case "min_cost", "max_cost":
+		cost, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		if fltrName == "min_cost" {
+			self.rpcParams.MinCost = &cost
+		} else {
+			self.rpcParams.MaxCost = &cost
+		}
+	case "min_duration":
+		self.rpcParams.MinDuration = val
+	case "max_duration":
+		self.rpcParams.MaxDuration = val
+	case "order_by":
+		self.rpcParams.OrderBy = val
+	case "order_descending":
+		self.rpcParams.OrderDescending, err = strconv.ParseBool(val)
+	case "limit":
+		self.rpcParams.Limit, err = strconv.Atoi(val)
+	case "offset":
+		self.rpcParams.Offset, err = strconv.Atoi(val)
+	case "count":
+		self.rpcParams.Count, err = strconv.ParseBool(val)
+	default:
+		return fmt.Errorf("unsupported filter")
+	}
+	return err
+}
+
+func (self *CmdGetCdrs) RpcMethod() string {
+	return self.rpcMethod
+}
+
+func (self *CmdGetCdrs) RpcParams() interface{} {
+	return self.rpcParams
+}
+
+func (self *CmdGetCdrs) RpcResult() interface{} {
+	return &self.rpcResult
+}
@@ -0,0 +1,75 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package console
+
+import (
+	"fmt"
+
+	"github.com/cgrates/cgrates/apier"
+	"github.com/cgrates/cgrates/sessionmanager"
+)
+
+func init() {
+	commands["get_sessions"] = &CmdGetSessions{}
+}
+
+// Commander implementation
+type CmdGetSessions struct {
+	rpcMethod string
+	rpcParams *apier.AttrGetActiveSessions
+	rpcResult []*sessionmanager.ActiveSession
+}
+
+// name should be exec's name
+func (self *CmdGetSessions) Usage(name string) string {
+	return fmt.Sprintf("\n\tUsage: cgr-console [cfg_opts...{-h}] get_sessions [<tenant> [<account>]]")
+}
+
+// set param defaults
+func (self *CmdGetSessions) defaults() error {
+	self.rpcMethod = "ApierV1.GetActiveSessions"
+	self.rpcParams = &apier.AttrGetActiveSessions{}
+	return nil
+}
+
+func (self *CmdGetSessions) FromArgs(args []string) error {
+	if len(args) > 4 {
+		return fmt.Errorf(self.Usage(""))
+	}
+	self.defaults()
+	if len(args) > 2 {
+		self.rpcParams.Tenant = args[2]
+	}
+	if len(args) > 3 {
+		self.rpcParams.Account = args[3]
+	}
+	return nil
+}
+
+func (self *CmdGetSessions) RpcMethod() string {
+	return self.rpcMethod
+}
+
+func (self *CmdGetSessions) RpcParams() interface{} {
+	return self.rpcParams
+}
+
+func (self *CmdGetSessions) RpcResult() interface{} {
+	return &self.rpcResult
+}
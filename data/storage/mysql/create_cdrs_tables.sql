@@ -16,7 +16,13 @@ CREATE TABLE cdrs_primary (
   answer_time datetime NOT NULL,
   duration bigint NOT NULL,
   PRIMARY KEY (id),
-  UNIQUE KEY cgrid (cgrid)
+  UNIQUE KEY cgrid (cgrid),
+  KEY answer_time (answer_time),
+  KEY tenant_account (tenant,account),
+  KEY subject (subject),
+  KEY destination (destination),
+  KEY accid (accid),
+  KEY cdrsource (cdrsource)
 );
 
 DROP TABLE IF EXISTS cdrs_extra;
@@ -28,3 +34,16 @@ CREATE TABLE cdrs_extra (
   PRIMARY KEY (id),
   UNIQUE KEY cgrid (cgrid)
 );
+
+DROP TABLE IF EXISTS cdrs_exported;
+
+CREATE TABLE cdrs_exported (
+  id int(11) NOT NULL AUTO_INCREMENT,
+  cgrid char(40) NOT NULL,
+  runid varchar(64) NOT NULL,
+  export_id varchar(64) NOT NULL,
+  export_time datetime NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY exportid (cgrid,runid,export_id),
+  KEY export_id (export_id)
+);
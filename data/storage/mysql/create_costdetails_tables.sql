@@ -23,3 +23,17 @@ CREATE TABLE `cost_details` (
   UNIQUE KEY `costid` (`cgrid`,`runid`)
 );
 
+
+--
+-- Table structure for table `session_states`
+--
+
+DROP TABLE IF EXISTS `session_states`;
+CREATE TABLE `session_states` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `uuid` varchar(64) NOT NULL,
+  `state` mediumtext NOT NULL,
+  `update_time` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uuid` (`uuid`)
+);
@@ -0,0 +1,49 @@
+--
+-- Table structure for table `invoices`
+--
+
+DROP TABLE IF EXISTS `invoices`;
+CREATE TABLE `invoices` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `number` varchar(64) NOT NULL,
+  `cycle_id` varchar(64) NOT NULL,
+  `tenant` varchar(64) NOT NULL,
+  `account` varchar(128) NOT NULL,
+  `period_start` datetime NOT NULL,
+  `period_end` datetime NOT NULL,
+  `issue_time` datetime NOT NULL,
+  `total` DECIMAL(20,4) NOT NULL,
+  `content` mediumtext NOT NULL,
+  `rendered` mediumtext NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `number` (`number`),
+  UNIQUE KEY `period` (`cycle_id`,`tenant`,`account`,`period_start`),
+  KEY `tenant_account` (`tenant`,`account`)
+);
+
+--
+-- Table structure for table `invoice_sequences`
+--
+
+DROP TABLE IF EXISTS `invoice_sequences`;
+CREATE TABLE `invoice_sequences` (
+  `number_prefix` varchar(64) NOT NULL,
+  `last_number` bigint NOT NULL,
+  PRIMARY KEY (`number_prefix`)
+);
+
+--
+-- Table structure for table `invoice_adjustments`
+--
+
+DROP TABLE IF EXISTS `invoice_adjustments`;
+CREATE TABLE `invoice_adjustments` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `tenant` varchar(64) NOT NULL,
+  `account` varchar(128) NOT NULL,
+  `description` varchar(255) NOT NULL,
+  `amount` DECIMAL(20,4) NOT NULL,
+  `invoice_number` varchar(64) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `tenant_account` (`tenant`,`account`)
+);
@@ -11,6 +11,9 @@ CREATE TABLE `rated_cdrs` (
   `cost` DECIMAL(20,4) DEFAULT NULL,
   `mediation_time` datetime NOT NULL,
   `extra_info` text,
+  `correlation_fields` text,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `costid` (`cgrid`,`runid`)
+  UNIQUE KEY `costid` (`cgrid`,`runid`),
+  KEY `runid` (`runid`),
+  KEY `cost` (`cost`)
 );
@@ -24,8 +24,10 @@ mysql -u $1 -p$2 -h $host -D cgrates < create_mediator_tables.sql
 mdt=$?
 mysql -u $1 -p$2 -h $host -D cgrates < create_tariffplan_tables.sql
 tpt=$?
+mysql -u $1 -p$2 -h $host -D cgrates < create_invoices_tables.sql
+invt=$?
 
-if [ $cu = 0 ] && [ $cdrt = 0 ] && [ $cdt = 0 ] && [ $mdt = 0 ] && [ $tpt = 0 ]; then
+if [ $cu = 0 ] && [ $cdrt = 0 ] && [ $cdt = 0 ] && [ $mdt = 0 ] && [ $tpt = 0 ] && [ $invt = 0 ]; then
 	echo ""
 	echo "\t+++ CGR-DB successfully set-up! +++"
 	echo ""
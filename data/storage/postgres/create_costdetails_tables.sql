@@ -0,0 +1,36 @@
+--
+-- Table structure for table cost_details
+--
+
+DROP TABLE IF EXISTS cost_details;
+CREATE TABLE cost_details (
+  id SERIAL PRIMARY KEY,
+  cgrid CHAR(40) NOT NULL,
+  accid VARCHAR(64) NOT NULL,
+  direction VARCHAR(8) NOT NULL,
+  tenant VARCHAR(128) NOT NULL,
+  tor VARCHAR(32) NOT NULL,
+  account VARCHAR(128) NOT NULL,
+  subject VARCHAR(128) NOT NULL,
+  destination VARCHAR(128) NOT NULL,
+  cost NUMERIC(20,4) NOT NULL,
+  timespans TEXT,
+  source VARCHAR(64) NOT NULL,
+  runid  VARCHAR(64) NOT NULL,
+  cost_time TIMESTAMP NOT NULL,
+  UNIQUE (cgrid, runid)
+);
+
+
+--
+-- Table structure for table session_states
+--
+
+DROP TABLE IF EXISTS session_states;
+CREATE TABLE session_states (
+  id SERIAL PRIMARY KEY,
+  uuid VARCHAR(64) NOT NULL,
+  state TEXT NOT NULL,
+  update_time TIMESTAMP NOT NULL,
+  UNIQUE (uuid)
+);
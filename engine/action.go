@@ -221,19 +221,24 @@ func callUrlAsync(ub *UserBalance, a *Action) error {
 	if err != nil {
 		return err
 	}
+	postJsonAsync(a.ExtraParameters, body)
+	return nil
+}
+
+// Posts the body in the background, retrying on errors, shared by the actions of balances and stats
+func postJsonAsync(url string, body []byte) {
 	go func() {
 		for i := 0; i < 5; i++ { // Loop so we can increase the success rate on best effort
-			if _, err = http.Post(a.ExtraParameters, "application/json", bytes.NewBuffer(body)); err == nil {
+			if _, err := http.Post(url, "application/json", bytes.NewBuffer(body)); err == nil {
 				break // Success, no need to reinterate
 			} else if i == 4 { // Last iteration, syslog the warning
-				Logger.Warning(fmt.Sprintf("<Triggers> WARNING: Failed calling url: [%s], error: [%s], balance: %s", a.ExtraParameters, err.Error(), body))
+				Logger.Warning(fmt.Sprintf("<Triggers> WARNING: Failed calling url: [%s], error: [%s], body: %s", url, err.Error(), body))
 				break
 			}
 			time.Sleep(time.Duration(i) * time.Minute)
 		}
 
 	}()
-	return nil
 }
 
 // Mails the balance hitting the threshold towards predefined list of addresses
@@ -242,8 +247,13 @@ func mailAsync(ub *UserBalance, a *Action) error {
 	if err != nil {
 		return err
 	}
+	return sendMailAsync(a.ExtraParameters, fmt.Sprintf("Threshold hit on balance: %s", ub.Id), fmt.Sprintf("Balance:\r\n\t%s", ubJson), "CGR Balance Monitor")
+}
+
+// Mails the notification towards the addresses in the action parameters, shared by the actions of balances and stats
+func sendMailAsync(actionParams, subject, content, signature string) error {
 	cgrCfg := config.CgrConfig()
-	params := strings.Split(a.ExtraParameters, string(utils.CSV_SEP))
+	params := strings.Split(actionParams, string(utils.CSV_SEP))
 	if len(params) == 0 {
 		return errors.New("Unconfigured parameters for mail action")
 	}
@@ -255,14 +265,14 @@ func mailAsync(ub *UserBalance, a *Action) error {
 		}
 		toAddrStr += addr
 	}
-	message := []byte(fmt.Sprintf("To: %s\r\nSubject: [CGR Notification] Threshold hit on balance: %s\r\n\r\nTime: \r\n\t%s\r\n\r\nBalance:\r\n\t%s\r\n\r\nYours faithfully,\r\nCGR Balance Monitor\r\n", toAddrStr, ub.Id, time.Now(), ubJson))
+	message := []byte(fmt.Sprintf("To: %s\r\nSubject: [CGR Notification] %s\r\n\r\nTime: \r\n\t%s\r\n\r\n%s\r\n\r\nYours faithfully,\r\n%s\r\n", toAddrStr, subject, time.Now(), content, signature))
 	auth := smtp.PlainAuth("", cgrCfg.MailerAuthUser, cgrCfg.MailerAuthPass, strings.Split(cgrCfg.MailerServer, ":")[0]) // We only need host part, so ignore port
 	go func() {
 		for i := 0; i < 5; i++ { // Loop so we can increase the success rate on best effort
 			if err := smtp.SendMail(cgrCfg.MailerServer, auth, cgrCfg.MailerFromAddr, toAddrs, message); err == nil {
 				break
 			} else if i == 4 {
-				Logger.Warning(fmt.Sprintf("<Triggers> WARNING: Failed emailing, params: [%s], error: [%s], balance: %s", a.ExtraParameters, err.Error(), ubJson))
+				Logger.Warning(fmt.Sprintf("<Triggers> WARNING: Failed emailing, params: [%s], error: [%s], content: %s", actionParams, err.Error(), content))
 				break
 			}
 			time.Sleep(time.Duration(i) * time.Minute)
@@ -42,11 +42,17 @@ type ActionTiming struct {
 	Weight         float64
 	ActionsId      string
 	actions        Actions
-	stCache        time.Time // cached time of the next start
+	stCache        time.Time    // cached time of the next start
+	task           func() error // executed instead of the actions, for scheduled jobs not bound to accounts
 }
 
 type ActionPlan []*ActionTiming
 
+// Schedules a task not bound to accounts, eg: CDR exports, on the same kind of timing as the actions
+func NewTaskActionTiming(tag string, timing *RITiming, task func() error) *ActionTiming {
+	return &ActionTiming{Id: utils.GenUUID(), Tag: tag, Timing: &RateInterval{Timing: timing}, task: task}
+}
+
 func (at *ActionTiming) GetNextStartTime(now time.Time) (t time.Time) {
 	if !at.stCache.IsZero() {
 		return at.stCache
@@ -220,12 +226,18 @@ func (at *ActionTiming) getActions() (as []*Action, err error) {
 
 func (at *ActionTiming) Execute() (err error) {
 	at.resetStartTimeCache()
+	if at.task != nil {
+		return at.task()
+	}
 	aac, err := at.getActions()
 	if err != nil {
 		Logger.Err(fmt.Sprintf("Failed to get actions for %s: %s", at.ActionsId, err))
 		return
 	}
 	for _, a := range aac {
+		if a.Balance == nil {
+			a.Balance = &Balance{}
+		}
 		a.Balance.ExpirationDate, _ = utils.ParseDate(a.ExpirationString)
 		actionFunction, exists := getActionFunc(a.ActionType)
 		if !exists {
@@ -235,13 +247,13 @@ func (at *ActionTiming) Execute() (err error) {
 		for _, ubId := range at.UserBalanceIds {
 			_, err := AccLock.Guard(ubId, func() (float64, error) {
 				ub, err := accountingStorage.GetUserBalance(ubId)
-				if ub.Disabled {
-					return 0, fmt.Errorf("User %s is disabled", ubId)
-				}
 				if err != nil {
 					Logger.Warning(fmt.Sprintf("Could not get user balances for this id: %s. Skipping!", ubId))
 					return 0, err
 				}
+				if ub.Disabled {
+					return 0, fmt.Errorf("User %s is disabled", ubId)
+				}
 
 				Logger.Info(fmt.Sprintf("Executing %v on %v", a.ActionType, ub.Id))
 				err = actionFunction(ub, a)
@@ -117,6 +117,7 @@ type CallDescriptor struct {
 	RatingInfos                           RatingInfos
 	Increments                            Increments
 	userBalance                           *UserBalance
+	ratingData                            ratingGetter // Rating data other than the one in the rating db, eg: when re-rating
 }
 
 func (cd *CallDescriptor) ValidateCallData() error {
@@ -129,6 +130,18 @@ func (cd *CallDescriptor) ValidateCallData() error {
 	return nil
 }
 
+// Rates the call with the data of a tariff plan instead of the one loaded in the rating db.
+func (cd *CallDescriptor) SetTPRatingData(rd *TPRatingData) {
+	cd.ratingData = rd
+}
+
+func (cd *CallDescriptor) getRatingData() ratingGetter {
+	if cd.ratingData == nil {
+		return storageRatingGetter{}
+	}
+	return cd.ratingData
+}
+
 // Adds a rating plan that applyes to current call descriptor.
 func (cd *CallDescriptor) AddRatingInfo(ris ...*RatingInfo) {
 	cd.RatingInfos = append(cd.RatingInfos, ris...)
@@ -178,7 +191,7 @@ func (cd *CallDescriptor) getRatingPlansForPrefix(key string, recursionDepth int
 		err = errors.New("Max fallback recursion depth reached!" + key)
 		return
 	}
-	rpf, err := dataStorage.GetRatingProfile(key, false)
+	rpf, err := cd.getRatingData().getRatingProfile(key)
 	if err != nil || rpf == nil {
 		return err
 	}
@@ -197,6 +210,7 @@ func (cd *CallDescriptor) getRatingPlansForPrefix(key string, recursionDepth int
 					Direction:   cd.Direction,
 					Tenant:      cd.Tenant,
 					Destination: cd.Destination,
+					ratingData:  cd.ratingData,
 				}
 				if index == 0 {
 					tempCD.TimeStart = cd.TimeStart
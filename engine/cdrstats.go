@@ -0,0 +1,294 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Fields of a CDR the stats metrics are computed on
+type statsCdr struct {
+	cgrId    string
+	runId    string
+	received time.Time
+	duration time.Duration
+	cost     float64
+}
+
+// Queue of the last CDRs matching the configured filters, computing quality metrics over them
+type CdrStatsQueue struct {
+	cfg        *config.CdrStatsConfig
+	cdrs       []*statsCdr
+	duplicates []time.Time // Arrival times of the duplicate CDRs, kept within the queue length and time window
+	triggered  []bool      // Thresholds already executed, rearmed once the metric gets back within bounds
+	mux        sync.Mutex
+}
+
+func NewCdrStatsQueue(cfg *config.CdrStatsConfig) *CdrStatsQueue {
+	return &CdrStatsQueue{cfg: cfg, triggered: make([]bool, len(cfg.Thresholds))}
+}
+
+// Checks the CDR against the queue filters
+func (sq *CdrStatsQueue) acceptsCdr(cdr *utils.StoredCdr) bool {
+	if len(sq.cfg.Tenants) != 0 && !utils.IsSliceMember(sq.cfg.Tenants, cdr.Tenant) {
+		return false
+	}
+	if len(sq.cfg.Accounts) != 0 && !utils.IsSliceMember(sq.cfg.Accounts, cdr.Account) {
+		return false
+	}
+	if len(sq.cfg.MediationRunIds) != 0 && !utils.IsSliceMember(sq.cfg.MediationRunIds, cdr.MediationRunId) {
+		return false
+	}
+	if len(sq.cfg.Suppliers) != 0 && !utils.IsSliceMember(sq.cfg.Suppliers, cdr.ExtraFields[sq.cfg.SupplierField]) {
+		return false
+	}
+	if len(sq.cfg.DestinationPrefixes) != 0 && !hasAnyPrefix(cdr.Destination, sq.cfg.DestinationPrefixes) {
+		return false
+	}
+	return true
+}
+
+// Adds the CDR to the queue, replacing its previous version when re-rated. Returns the thresholds crossed together with the metrics crossing them
+func (sq *CdrStatsQueue) appendCdr(cdr *utils.StoredCdr, now time.Time) ([]*config.CdrStatsThreshold, map[string]float64) {
+	sq.mux.Lock()
+	defer sq.mux.Unlock()
+	sCdr := &statsCdr{cgrId: cdr.CgrId, runId: cdr.MediationRunId, received: now, duration: cdr.Duration, cost: cdr.Cost}
+	replaced := false
+	for idx, queued := range sq.cdrs {
+		if queued.cgrId == sCdr.cgrId && queued.runId == sCdr.runId {
+			sCdr.received = queued.received
+			sq.cdrs[idx] = sCdr
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		sq.cdrs = append(sq.cdrs, sCdr)
+	}
+	sq.purge(now)
+	metrics := sq.metrics()
+	return sq.crossedThresholds(metrics), metrics
+}
+
+// Counts a duplicate of a CDR already received, returns the thresholds crossed together with the metrics crossing them
+func (sq *CdrStatsQueue) appendDuplicate(now time.Time) ([]*config.CdrStatsThreshold, map[string]float64) {
+	sq.mux.Lock()
+	defer sq.mux.Unlock()
+	sq.duplicates = append(sq.duplicates, now)
+	sq.purge(now)
+	metrics := sq.metrics()
+	return sq.crossedThresholds(metrics), metrics
+}
+
+// Returns the thresholds crossed since last check, needs to be called under lock
+func (sq *CdrStatsQueue) crossedThresholds(metrics map[string]float64) []*config.CdrStatsThreshold {
+	var crossed []*config.CdrStatsThreshold
+	for idx, threshold := range sq.cfg.Thresholds {
+		value := metrics[threshold.Metric]
+		if (threshold.ThresholdType == utils.STATS_MIN && value < threshold.ThresholdValue) ||
+			(threshold.ThresholdType == utils.STATS_MAX && value > threshold.ThresholdValue) {
+			if !sq.triggered[idx] {
+				sq.triggered[idx] = true
+				crossed = append(crossed, threshold)
+			}
+		} else {
+			sq.triggered[idx] = false
+		}
+	}
+	return crossed
+}
+
+// Removes the CDRs and duplicates out of queue length or time window
+func (sq *CdrStatsQueue) purge(now time.Time) {
+	if sq.cfg.QueueLength > 0 && len(sq.cdrs) > sq.cfg.QueueLength {
+		sq.cdrs = sq.cdrs[len(sq.cdrs)-sq.cfg.QueueLength:]
+	}
+	if sq.cfg.QueueLength > 0 && len(sq.duplicates) > sq.cfg.QueueLength {
+		sq.duplicates = sq.duplicates[len(sq.duplicates)-sq.cfg.QueueLength:]
+	}
+	if sq.cfg.TimeWindow > 0 {
+		idx := 0
+		for idx < len(sq.cdrs) && now.Sub(sq.cdrs[idx].received) > sq.cfg.TimeWindow {
+			idx++
+		}
+		sq.cdrs = sq.cdrs[idx:]
+		idx = 0
+		for idx < len(sq.duplicates) && now.Sub(sq.duplicates[idx]) > sq.cfg.TimeWindow {
+			idx++
+		}
+		sq.duplicates = sq.duplicates[idx:]
+	}
+}
+
+// Computes the metrics over the CDRs in the queue, durations in seconds
+func (sq *CdrStatsQueue) metrics() map[string]float64 {
+	metrics := make(map[string]float64)
+	for _, metric := range utils.CdrStatsMetrics {
+		metrics[metric] = 0
+	}
+	metrics[utils.STATS_DDC] = float64(len(sq.duplicates))
+	if len(sq.cdrs) == 0 {
+		return metrics
+	}
+	var answered, costed int
+	var tcd time.Duration
+	var tcc float64
+	for _, sCdr := range sq.cdrs {
+		if sCdr.duration > 0 {
+			answered++
+			tcd += sCdr.duration
+		}
+		if sCdr.cost < 0 { // Rating errors
+			continue
+		}
+		tcc += sCdr.cost
+		if sCdr.duration > 0 {
+			costed++
+		}
+	}
+	metrics[utils.STATS_ASR] = float64(answered) / float64(len(sq.cdrs)) * 100
+	if answered != 0 {
+		metrics[utils.STATS_ACD] = tcd.Seconds() / float64(answered)
+	}
+	if costed != 0 {
+		metrics[utils.STATS_ACC] = tcc / float64(costed)
+	}
+	metrics[utils.STATS_TCC] = tcc
+	metrics[utils.STATS_TCD] = tcd.Seconds()
+	return metrics
+}
+
+// Returns the metrics over the CDRs in the queue at the time of the call
+func (sq *CdrStatsQueue) GetMetrics() map[string]float64 {
+	sq.mux.Lock()
+	defer sq.mux.Unlock()
+	sq.purge(time.Now())
+	return sq.metrics()
+}
+
+// Passed to the actions executed when one of the queue thresholds is crossed
+type CdrStatsAlert struct {
+	QueueId   string
+	Threshold *config.CdrStatsThreshold
+	Metrics   map[string]float64
+}
+
+// Executes the threshold actions. Stats have no balance to act on so only the notification actions are supported.
+func (alert *CdrStatsAlert) execute() {
+	aac, err := accountingStorage.GetActions(alert.Threshold.ActionsId, false)
+	if err != nil {
+		Logger.Err(fmt.Sprintf("<CdrStats> Failed to get actions %s: %v", alert.Threshold.ActionsId, err))
+		return
+	}
+	aac.Sort()
+	body, err := json.Marshal(alert)
+	if err != nil {
+		Logger.Err(fmt.Sprintf("<CdrStats> Failed to encode alert: %v", err))
+		return
+	}
+	for _, a := range aac {
+		switch a.ActionType {
+		case LOG:
+			Logger.Info(fmt.Sprintf("<CdrStats> Threshold reached: %s", body))
+		case CALL_URL:
+			if _, err := http.Post(a.ExtraParameters, "application/json", bytes.NewBuffer(body)); err != nil {
+				Logger.Err(fmt.Sprintf("<CdrStats> Failed calling url %s: %v", a.ExtraParameters, err))
+			}
+		case CALL_URL_ASYNC:
+			postJsonAsync(a.ExtraParameters, body)
+		case MAIL_ASYNC:
+			if err := sendMailAsync(a.ExtraParameters, fmt.Sprintf("Threshold hit on stats queue: %s", alert.QueueId),
+				fmt.Sprintf("Stats:\r\n\t%s", body), "CGR Stats Monitor"); err != nil {
+				Logger.Err(fmt.Sprintf("<CdrStats> Failed mailing: %v", err))
+			}
+		default:
+			Logger.Warning(fmt.Sprintf("<CdrStats> Action type %s not available on stats, ignoring", a.ActionType))
+		}
+	}
+}
+
+// Stats subsystem, dispatching the rated CDRs to the queues
+type CdrStats struct {
+	queues map[string]*CdrStatsQueue
+}
+
+func NewCdrStats(queueCfgs map[string]*config.CdrStatsConfig) *CdrStats {
+	cs := &CdrStats{queues: make(map[string]*CdrStatsQueue)}
+	for qId, qCfg := range queueCfgs {
+		cs.queues[qId] = NewCdrStatsQueue(qCfg)
+	}
+	return cs
+}
+
+// Adds the CDR to the queues accepting it, executing the actions of the thresholds crossed
+func (cs *CdrStats) AppendCdr(cdr *utils.StoredCdr) {
+	now := time.Now()
+	for qId, sq := range cs.queues {
+		if !sq.acceptsCdr(cdr) {
+			continue
+		}
+		crossed, metrics := sq.appendCdr(cdr, now)
+		cs.alert(qId, crossed, metrics)
+	}
+}
+
+func (cs *CdrStats) alert(queueId string, crossed []*config.CdrStatsThreshold, metrics map[string]float64) {
+	for _, threshold := range crossed {
+		go (&CdrStatsAlert{QueueId: queueId, Threshold: threshold, Metrics: metrics}).execute()
+	}
+}
+
+// Counts the duplicate CDR in the queues accepting it
+func (cs *CdrStats) AppendDuplicate(cdr *utils.StoredCdr) {
+	now := time.Now()
+	for qId, sq := range cs.queues {
+		if !sq.acceptsCdr(cdr) {
+			continue
+		}
+		crossed, metrics := sq.appendDuplicate(now)
+		cs.alert(qId, crossed, metrics)
+	}
+}
+
+func (cs *CdrStats) GetQueueIds() []string {
+	qIds := make([]string, 0, len(cs.queues))
+	for qId := range cs.queues {
+		qIds = append(qIds, qId)
+	}
+	sort.Strings(qIds)
+	return qIds
+}
+
+func (cs *CdrStats) GetMetrics(queueId string) (map[string]float64, error) {
+	sq, hasIt := cs.queues[queueId]
+	if !hasIt {
+		return nil, errors.New(utils.ERR_NOT_FOUND)
+	}
+	return sq.GetMetrics(), nil
+}
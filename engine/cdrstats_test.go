@@ -0,0 +1,159 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestCdrStatsQueueFilters(t *testing.T) {
+	qCfg := config.NewDefaultCdrStatsConfig("carrier1")
+	qCfg.Tenants = []string{"cgrates.org"}
+	qCfg.DestinationPrefixes = []string{"49", "40"}
+	qCfg.Suppliers = []string{"carrier1"}
+	qCfg.MediationRunIds = []string{utils.DEFAULT_RUNID}
+	sq := NewCdrStatsQueue(qCfg)
+	cdr := &utils.StoredCdr{CgrId: "cgrid1", Tenant: "cgrates.org", Account: "1001", Destination: "4986517174963",
+		ExtraFields: map[string]string{"supplier": "carrier1"}, MediationRunId: utils.DEFAULT_RUNID}
+	if !sq.acceptsCdr(cdr) {
+		t.Error("Should accept CDR")
+	}
+	cdr.Destination = "1002"
+	if sq.acceptsCdr(cdr) {
+		t.Error("Should not accept destination")
+	}
+	cdr.Destination = "4086517174963"
+	cdr.ExtraFields["supplier"] = "carrier2"
+	if sq.acceptsCdr(cdr) {
+		t.Error("Should not accept supplier")
+	}
+	cdr.ExtraFields["supplier"] = "carrier1"
+	cdr.MediationRunId = "extra1"
+	if sq.acceptsCdr(cdr) {
+		t.Error("Should not accept mediation run")
+	}
+}
+
+func TestCdrStatsQueueMetrics(t *testing.T) {
+	qCfg := config.NewDefaultCdrStatsConfig("carrier1")
+	qCfg.QueueLength = 4
+	qCfg.TimeWindow = time.Duration(1) * time.Hour
+	sq := NewCdrStatsQueue(qCfg)
+	now := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	for idx, cdr := range []*utils.StoredCdr{
+		&utils.StoredCdr{CgrId: "cgrid1", MediationRunId: utils.DEFAULT_RUNID, Duration: time.Duration(60) * time.Second, Cost: 1},
+		&utils.StoredCdr{CgrId: "cgrid2", MediationRunId: utils.DEFAULT_RUNID, Duration: time.Duration(30) * time.Second, Cost: 0.5},
+		&utils.StoredCdr{CgrId: "cgrid3", MediationRunId: utils.DEFAULT_RUNID, Duration: 0, Cost: 0},
+		&utils.StoredCdr{CgrId: "cgrid4", MediationRunId: utils.DEFAULT_RUNID, Duration: time.Duration(90) * time.Second, Cost: -1},
+	} {
+		sq.appendCdr(cdr, now.Add(time.Duration(idx)*time.Minute))
+	}
+	eMetrics := map[string]float64{utils.STATS_ASR: 75, utils.STATS_ACD: 60, utils.STATS_ACC: 0.75, utils.STATS_TCC: 1.5, utils.STATS_TCD: 180, utils.STATS_DDC: 0}
+	if metrics := sq.metrics(); !reflect.DeepEqual(eMetrics, metrics) {
+		t.Errorf("Expecting: %v, received: %v", eMetrics, metrics)
+	}
+	// Re-rated CDR replaces the old one
+	sq.appendCdr(&utils.StoredCdr{CgrId: "cgrid4", MediationRunId: utils.DEFAULT_RUNID, Duration: time.Duration(90) * time.Second, Cost: 1.5}, now.Add(time.Duration(5)*time.Minute))
+	if len(sq.cdrs) != 4 {
+		t.Errorf("Unexpected queue length: %d", len(sq.cdrs))
+	} else if metrics := sq.metrics(); metrics[utils.STATS_TCC] != 3 || metrics[utils.STATS_ACC] != 1 {
+		t.Errorf("Unexpected metrics: %v", metrics)
+	}
+	// Queue length drops the first CDR
+	sq.appendCdr(&utils.StoredCdr{CgrId: "cgrid5", MediationRunId: utils.DEFAULT_RUNID, Duration: 0}, now.Add(time.Duration(10)*time.Minute))
+	if len(sq.cdrs) != 4 || sq.cdrs[0].cgrId != "cgrid2" {
+		t.Errorf("Unexpected queue: %+v", sq.cdrs)
+	} else if metrics := sq.metrics(); metrics[utils.STATS_ASR] != 50 {
+		t.Errorf("Unexpected metrics: %v", metrics)
+	}
+	// Duplicates are counted within the time window
+	sq.appendDuplicate(now.Add(time.Duration(8) * time.Minute))
+	if _, metrics := sq.appendDuplicate(now.Add(time.Duration(65) * time.Minute)); metrics[utils.STATS_DDC] != 2 {
+		t.Errorf("Unexpected metrics: %v", metrics)
+	}
+	// Time window leaves only the last CDR and duplicate
+	sq.purge(now.Add(time.Duration(69) * time.Minute))
+	if len(sq.cdrs) != 1 || sq.cdrs[0].cgrId != "cgrid5" {
+		t.Errorf("Unexpected queue: %+v", sq.cdrs)
+	} else if metrics := sq.metrics(); metrics[utils.STATS_DDC] != 1 {
+		t.Errorf("Unexpected metrics: %v", metrics)
+	}
+	// Without time window the duplicates are limited by the queue length
+	qCfg.TimeWindow = 0
+	for i := 0; i < 6; i++ {
+		sq.appendDuplicate(now.Add(time.Duration(70+i) * time.Minute))
+	}
+	if metrics := sq.metrics(); metrics[utils.STATS_DDC] != 4 {
+		t.Errorf("Unexpected metrics: %v", metrics)
+	}
+}
+
+func TestCdrStatsThresholds(t *testing.T) {
+	alerts := make(chan *CdrStatsAlert, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert CdrStatsAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Error(err)
+		}
+		alerts <- &alert
+	}))
+	defer srv.Close()
+	if err := accountingStorage.SetActions("STATS_ALERT", Actions{&Action{Id: "STATS_ALERT", ActionType: CALL_URL, ExtraParameters: srv.URL}}); err != nil {
+		t.Fatal(err)
+	}
+	qCfg := config.NewDefaultCdrStatsConfig("carrier1")
+	qCfg.Thresholds = []*config.CdrStatsThreshold{&config.CdrStatsThreshold{Metric: utils.STATS_ASR, ThresholdType: utils.STATS_MIN, ThresholdValue: 50, ActionsId: "STATS_ALERT"}}
+	cdrStats := NewCdrStats(map[string]*config.CdrStatsConfig{"carrier1": qCfg})
+	cdrStats.AppendCdr(&utils.StoredCdr{CgrId: "cgrid1", MediationRunId: utils.DEFAULT_RUNID, Duration: time.Duration(60) * time.Second})
+	cdrStats.AppendCdr(&utils.StoredCdr{CgrId: "cgrid2", MediationRunId: utils.DEFAULT_RUNID})
+	cdrStats.AppendCdr(&utils.StoredCdr{CgrId: "cgrid3", MediationRunId: utils.DEFAULT_RUNID}) // ASR drops under 50
+	cdrStats.AppendCdr(&utils.StoredCdr{CgrId: "cgrid4", MediationRunId: utils.DEFAULT_RUNID}) // Already executed
+	select {
+	case alert := <-alerts:
+		if alert.QueueId != "carrier1" || alert.Threshold.Metric != utils.STATS_ASR || alert.Metrics[utils.STATS_ASR] >= 50 {
+			t.Errorf("Unexpected alert: %+v", alert)
+		}
+	case <-time.After(time.Duration(1) * time.Second):
+		t.Fatal("Threshold actions not executed")
+	}
+	select {
+	case alert := <-alerts:
+		t.Errorf("Threshold executed twice: %+v", alert)
+	case <-time.After(time.Duration(100) * time.Millisecond):
+	}
+	if qIds := cdrStats.GetQueueIds(); !reflect.DeepEqual(qIds, []string{"carrier1"}) {
+		t.Errorf("Unexpected queue ids: %v", qIds)
+	}
+	if _, err := cdrStats.GetMetrics("carrier2"); err == nil || err.Error() != utils.ERR_NOT_FOUND {
+		t.Error("Unexpected error: ", err)
+	}
+	if metrics, err := cdrStats.GetMetrics("carrier1"); err != nil {
+		t.Error(err)
+	} else if metrics[utils.STATS_ASR] != 25 {
+		t.Errorf("Unexpected metrics: %v", metrics)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Recorded each time a fraud rule matches
+type FraudIncident struct {
+	RuleId      string
+	RuleType    string
+	Direction   string
+	Tenant      string
+	Account     string
+	Destination string
+	CallId      string  // Uuid of the authorized call or AccId of the CDR
+	Value       float64 // Cost or number of calls which matched the rule
+	Threshold   float64
+	RejectCall  bool
+	ActionsId   string
+	Time        time.Time
+}
+
+// Cost of one CDR as tracked by the fraud rules
+type fraudCost struct {
+	cgrId      string
+	answerTime time.Time
+	cost       float64
+}
+
+// Activity of one account as seen by a rule
+type fraudAccount struct {
+	firstSeen time.Time // Start of the account activity, reset after a time window without traffic
+	lastSeen  time.Time
+	costs     []*fraudCost
+	triggered bool // Rule already matched, rearmed once the cost gets back under threshold
+}
+
+type fraudRule struct {
+	cfg      *config.FraudRuleConfig
+	accounts map[string]*fraudAccount
+}
+
+// Adds the cost of the CDR to the account, returns the cost the rule is checked against and whether the rule should be checked at all
+func (rule *fraudRule) addCost(acntKey string, cdr *utils.StoredCdr, now, detectorStart time.Time) (float64, bool) {
+	if now.Sub(cdr.AnswerTime) > rule.cfg.TimeWindow { // Out of window, probably re-rated
+		return 0, false
+	}
+	acnt, hasIt := rule.accounts[acntKey]
+	if !hasIt || now.Sub(acnt.lastSeen) > rule.cfg.TimeWindow {
+		acnt = &fraudAccount{firstSeen: now}
+		rule.accounts[acntKey] = acnt
+	}
+	acnt.lastSeen = now
+	costs := make([]*fraudCost, 0, len(acnt.costs)+1)
+	for _, fCost := range acnt.costs {
+		if fCost.cgrId != cdr.CgrId && now.Sub(fCost.answerTime) <= rule.cfg.TimeWindow {
+			costs = append(costs, fCost)
+		}
+	}
+	acnt.costs = append(costs, &fraudCost{cgrId: cdr.CgrId, answerTime: cdr.AnswerTime, cost: cdr.Cost})
+	if rule.cfg.RuleType == utils.FRAUD_NEW_ACCOUNT_COST &&
+		(acnt.firstSeen.Sub(detectorStart) < rule.cfg.TimeWindow || now.Sub(acnt.firstSeen) > rule.cfg.TimeWindow) {
+		return 0, false // Traffic history unknown since seen too close to our start, or not new anymore
+	}
+	var totalCost float64
+	for _, fCost := range acnt.costs {
+		totalCost += fCost.cost
+	}
+	if totalCost <= rule.cfg.Threshold {
+		acnt.triggered = false
+		return totalCost, false
+	}
+	if acnt.triggered {
+		return totalCost, false
+	}
+	acnt.triggered = true
+	return totalCost, true
+}
+
+// Evaluates fraud rules on SessionManager authorizations and on rated CDRs, executing the rule actions on the accounts matching
+type FraudDetector struct {
+	rules        []*fraudRule
+	maxIncidents int
+	incidents    []*FraudIncident
+	authorized   map[string]time.Time // Calls checked on authorization, not checked again for destinations on their CDRs
+	startTime    time.Time
+	lastCleanup  time.Time
+	mux          sync.Mutex
+}
+
+func NewFraudDetector(ruleCfgs map[string]*config.FraudRuleConfig, maxIncidents int) *FraudDetector {
+	fd := &FraudDetector{maxIncidents: maxIncidents, authorized: make(map[string]time.Time), startTime: time.Now()}
+	for _, rCfg := range ruleCfgs {
+		fd.rules = append(fd.rules, &fraudRule{cfg: rCfg, accounts: make(map[string]*fraudAccount)})
+	}
+	sort.Sort(fraudRulesById(fd.rules))
+	return fd
+}
+
+type fraudRulesById []*fraudRule
+
+func (rules fraudRulesById) Len() int           { return len(rules) }
+func (rules fraudRulesById) Swap(i, j int)      { rules[i], rules[j] = rules[j], rules[i] }
+func (rules fraudRulesById) Less(i, j int) bool { return rules[i].cfg.Id < rules[j].cfg.Id }
+
+// Evaluates the rules on an authorization request, activeCalls being the number of calls of the account already in progress.
+// Returns the incidents recorded, the call should be rejected if one of them requests it.
+func (fd *FraudDetector) CheckAuthorization(callId, direction, tenant, account, destination string, activeCalls int) []*FraudIncident {
+	return fd.checkAuthorization(callId, direction, tenant, account, destination, activeCalls, time.Now())
+}
+
+func (fd *FraudDetector) checkAuthorization(callId, direction, tenant, account, destination string, activeCalls int, now time.Time) []*FraudIncident {
+	fd.mux.Lock()
+	var incidents []*FraudIncident
+	for _, rule := range fd.rules {
+		var value float64
+		switch rule.cfg.RuleType {
+		case utils.FRAUD_RISKY_DESTINATION:
+			if !hasAnyPrefix(destination, rule.cfg.DestinationPrefixes) {
+				continue
+			}
+		case utils.FRAUD_MAX_CONCURRENT_CALLS:
+			if value = float64(activeCalls + 1); value <= rule.cfg.Threshold {
+				continue
+			}
+		default:
+			continue
+		}
+		incidents = append(incidents, fd.newIncident(rule, direction, tenant, account, destination, callId, value, now))
+	}
+	fd.authorized[callId] = now
+	fd.cleanup(now)
+	fd.mux.Unlock()
+	fd.executeActions(incidents)
+	return incidents
+}
+
+// Evaluates the rules on a rated CDR, only the default mediation run is considered since the others are derived out of it
+func (fd *FraudDetector) CheckCdr(cdr *utils.StoredCdr) []*FraudIncident {
+	return fd.checkCdr(cdr, time.Now())
+}
+
+func (fd *FraudDetector) checkCdr(cdr *utils.StoredCdr, now time.Time) []*FraudIncident {
+	if cdr.MediationRunId != utils.DEFAULT_RUNID || cdr.Cost < 0 {
+		return nil
+	}
+	fd.mux.Lock()
+	_, authorized := fd.authorized[cdr.AccId]
+	delete(fd.authorized, cdr.AccId)
+	acntKey := fmt.Sprintf("%s:%s:%s", cdr.Direction, cdr.Tenant, cdr.Account)
+	var incidents []*FraudIncident
+	for _, rule := range fd.rules {
+		var value float64
+		switch rule.cfg.RuleType {
+		case utils.FRAUD_RISKY_DESTINATION:
+			if authorized || !hasAnyPrefix(cdr.Destination, rule.cfg.DestinationPrefixes) {
+				continue
+			}
+		case utils.FRAUD_MAX_ACCOUNT_COST, utils.FRAUD_NEW_ACCOUNT_COST:
+			var matched bool
+			if value, matched = rule.addCost(acntKey, cdr, now, fd.startTime); !matched {
+				continue
+			}
+		default:
+			continue
+		}
+		incidents = append(incidents, fd.newIncident(rule, cdr.Direction, cdr.Tenant, cdr.Account, cdr.Destination, cdr.AccId, value, now))
+	}
+	fd.cleanup(now)
+	fd.mux.Unlock()
+	fd.executeActions(incidents)
+	return incidents
+}
+
+// Records the incident, needs to be called under lock
+func (fd *FraudDetector) newIncident(rule *fraudRule, direction, tenant, account, destination, callId string, value float64, now time.Time) *FraudIncident {
+	incident := &FraudIncident{RuleId: rule.cfg.Id, RuleType: rule.cfg.RuleType, Direction: direction, Tenant: tenant, Account: account,
+		Destination: destination, CallId: callId, Value: value, Threshold: rule.cfg.Threshold, RejectCall: rule.cfg.RejectCall,
+		ActionsId: rule.cfg.ActionsId, Time: now}
+	Logger.Warning(fmt.Sprintf("<FraudDetector> Rule %s matched on account %s:%s, call: %s, value: %v", incident.RuleId, tenant, account, callId, value))
+	fd.incidents = append(fd.incidents, incident)
+	if fd.maxIncidents > 0 && len(fd.incidents) > fd.maxIncidents {
+		fd.incidents = fd.incidents[len(fd.incidents)-fd.maxIncidents:]
+	}
+	return incident
+}
+
+// Executes the rule actions on the accounts of the incidents
+func (fd *FraudDetector) executeActions(incidents []*FraudIncident) {
+	for _, incident := range incidents {
+		if incident.ActionsId == "" {
+			continue
+		}
+		at := &ActionTiming{UserBalanceIds: []string{fmt.Sprintf("%s:%s:%s", incident.Direction, incident.Tenant, incident.Account)},
+			ActionsId: incident.ActionsId}
+		if err := at.Execute(); err != nil {
+			Logger.Err(fmt.Sprintf("<FraudDetector> Failed executing actions %s: %v", incident.ActionsId, err))
+		}
+	}
+}
+
+// Drops the state not needed anymore, at most once per minute. Needs to be called under lock.
+func (fd *FraudDetector) cleanup(now time.Time) {
+	if now.Sub(fd.lastCleanup) < time.Minute {
+		return
+	}
+	fd.lastCleanup = now
+	for callId, authTime := range fd.authorized {
+		if now.Sub(authTime) > time.Duration(24)*time.Hour { // Calls longer than this are not expected to still produce CDRs
+			delete(fd.authorized, callId)
+		}
+	}
+	for _, rule := range fd.rules {
+		for acntKey, acnt := range rule.accounts {
+			if now.Sub(acnt.lastSeen) > rule.cfg.TimeWindow {
+				delete(rule.accounts, acntKey)
+			}
+		}
+	}
+}
+
+// Returns a copy of the incidents recorded so far
+func (fd *FraudDetector) GetIncidents() []*FraudIncident {
+	fd.mux.Lock()
+	defer fd.mux.Unlock()
+	incidents := make([]*FraudIncident, len(fd.incidents))
+	copy(incidents, fd.incidents)
+	return incidents
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestFraudAuthorizationRules(t *testing.T) {
+	riskyDst := config.NewDefaultFraudRuleConfig("irsf")
+	riskyDst.RuleType = utils.FRAUD_RISKY_DESTINATION
+	riskyDst.DestinationPrefixes = []string{"882", "883"}
+	riskyDst.RejectCall = true
+	concurrent := config.NewDefaultFraudRuleConfig("concurrent")
+	concurrent.RuleType = utils.FRAUD_MAX_CONCURRENT_CALLS
+	concurrent.Threshold = 2
+	fd := NewFraudDetector(map[string]*config.FraudRuleConfig{"irsf": riskyDst, "concurrent": concurrent}, 2)
+	now := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	if incidents := fd.checkAuthorization("uuid1", "*out", "cgrates.org", "1001", "4986517174963", 1, now); len(incidents) != 0 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	if incidents := fd.checkAuthorization("uuid2", "*out", "cgrates.org", "1001", "88213", 0, now); len(incidents) != 1 ||
+		incidents[0].RuleId != "irsf" || !incidents[0].RejectCall {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	if incidents := fd.checkAuthorization("uuid3", "*out", "cgrates.org", "1001", "4986517174963", 2, now); len(incidents) != 1 ||
+		incidents[0].RuleId != "concurrent" || incidents[0].Value != 3 || incidents[0].RejectCall {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	// Destination already checked on authorization
+	if incidents := fd.checkCdr(&utils.StoredCdr{CgrId: "cgrid2", AccId: "uuid2", Direction: "*out", Tenant: "cgrates.org", Account: "1001",
+		Destination: "88213", MediationRunId: utils.DEFAULT_RUNID, AnswerTime: now, Cost: 1}, now); len(incidents) != 0 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	if incidents := fd.checkCdr(&utils.StoredCdr{CgrId: "cgrid4", AccId: "uuid4", Direction: "*out", Tenant: "cgrates.org", Account: "1002",
+		Destination: "88313", MediationRunId: utils.DEFAULT_RUNID, AnswerTime: now, Cost: 1}, now); len(incidents) != 1 || incidents[0].CallId != "uuid4" {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	if incidents := fd.GetIncidents(); len(incidents) != 2 || incidents[0].RuleId != "concurrent" || incidents[1].Account != "1002" {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+}
+
+func TestFraudAccountCost(t *testing.T) {
+	ub := &UserBalance{Id: "*out:cgrates.org:fraud1", BalanceMap: map[string]BalanceChain{CREDIT + OUTBOUND: BalanceChain{&Balance{Value: 10}}}}
+	if err := accountingStorage.SetUserBalance(ub); err != nil {
+		t.Fatal(err)
+	}
+	if err := accountingStorage.SetActions("FRAUD_DISABLE", Actions{&Action{Id: "FRAUD_DISABLE", ActionType: DISABLE_USER}}); err != nil {
+		t.Fatal(err)
+	}
+	hourlyCost := config.NewDefaultFraudRuleConfig("hourly_cost")
+	hourlyCost.RuleType = utils.FRAUD_MAX_ACCOUNT_COST
+	hourlyCost.Threshold = 10
+	hourlyCost.ActionsId = "FRAUD_DISABLE"
+	fd := NewFraudDetector(map[string]*config.FraudRuleConfig{"hourly_cost": hourlyCost}, 0)
+	now := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	cdr := &utils.StoredCdr{CgrId: "cgrid1", AccId: "acc1", Direction: "*out", Tenant: "cgrates.org", Account: "fraud1",
+		Destination: "1002", MediationRunId: utils.DEFAULT_RUNID, AnswerTime: now, Cost: 6}
+	if incidents := fd.checkCdr(cdr, now); len(incidents) != 0 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	cdr.MediationRunId = "extra1" // Derived runs are not counted
+	if incidents := fd.checkCdr(cdr, now); len(incidents) != 0 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	cdr.MediationRunId = utils.DEFAULT_RUNID // Re-rated CDR replaces its old cost
+	if incidents := fd.checkCdr(cdr, now); len(incidents) != 0 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	cdr2 := &utils.StoredCdr{CgrId: "cgrid2", AccId: "acc2", Direction: "*out", Tenant: "cgrates.org", Account: "fraud1",
+		Destination: "1002", MediationRunId: utils.DEFAULT_RUNID, AnswerTime: now.Add(time.Minute), Cost: 5}
+	if incidents := fd.checkCdr(cdr2, now.Add(time.Minute)); len(incidents) != 1 || incidents[0].Value != 11 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	if ub, err := accountingStorage.GetUserBalance("*out:cgrates.org:fraud1"); err != nil {
+		t.Error(err)
+	} else if !ub.Disabled {
+		t.Error("Account not disabled")
+	}
+	// Does not match twice until the cost gets back under threshold
+	cdr2.CgrId = "cgrid3"
+	if incidents := fd.checkCdr(cdr2, now.Add(time.Minute)); len(incidents) != 0 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	cdr2.CgrId, cdr2.AnswerTime = "cgrid4", now.Add(time.Duration(2)*time.Hour)
+	if incidents := fd.checkCdr(cdr2, now.Add(time.Duration(2)*time.Hour)); len(incidents) != 0 {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+}
+
+func TestFraudNewAccountCost(t *testing.T) {
+	newAcnt := config.NewDefaultFraudRuleConfig("new_account")
+	newAcnt.RuleType = utils.FRAUD_NEW_ACCOUNT_COST
+	newAcnt.Threshold = 5
+	newAcnt.TimeWindow = time.Duration(24) * time.Hour
+	fd := NewFraudDetector(map[string]*config.FraudRuleConfig{"new_account": newAcnt}, 0)
+	now := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	fd.startTime = now.Add(-time.Duration(1) * time.Hour)
+	cdr := &utils.StoredCdr{CgrId: "cgrid1", AccId: "acc1", Direction: "*out", Tenant: "cgrates.org", Account: "1001",
+		Destination: "1002", MediationRunId: utils.DEFAULT_RUNID, AnswerTime: now, Cost: 6}
+	if incidents := fd.checkCdr(cdr, now); len(incidents) != 0 { // History unknown, too close to start
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+	fd.startTime = now.Add(-time.Duration(48) * time.Hour)
+	cdr.Account = "1002"
+	if incidents := fd.checkCdr(cdr, now); len(incidents) != 1 || incidents[0].RuleId != "new_account" {
+		t.Errorf("Unexpected incidents: %+v", incidents)
+	}
+}
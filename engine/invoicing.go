@@ -0,0 +1,47 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"time"
+)
+
+// Invoice as kept in storDb, its content encoded by the invoices package. Listings leave Content and Rendered empty.
+type StoredInvoice struct {
+	Number        string
+	CycleId       string
+	Tenant        string
+	Account       string
+	PeriodStart   time.Time // Invoiced only once for the same cycle, tenant and account
+	PeriodEnd     time.Time
+	IssueTime     time.Time
+	Total         float64
+	Content       []byte  // Json encoded invoice
+	Rendered      []byte  // Invoice rendered by the template of its billing cycle
+	AdjustmentIds []int64 // Adjustments included, consumed when storing the invoice
+}
+
+// One-off charge or credit waiting for the next invoice of its account
+type InvoiceAdjustment struct {
+	Id          int64 // Issued by storDb
+	Tenant      string
+	Account     string
+	Description string
+	Amount      float64 // Negative for credits
+}
@@ -0,0 +1,64 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Result of the last run of a job scheduled periodically, eg: CDR exports or billing cycles
+type JobStatus struct {
+	Id      string
+	LastRun time.Time // Zero if the job did not run yet
+	Status  string    // <*ok|*failed>, empty if the job did not run yet
+	Error   string    // Reason of the last failure
+}
+
+// Status of the job run at runTime, failed if the run returned error
+func NewJobStatus(id string, runTime time.Time, err error) JobStatus {
+	status := JobStatus{Id: id, LastRun: runTime, Status: utils.JOB_OK}
+	if err != nil {
+		status.Status = utils.JOB_FAILED
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// Schedules the job on its timing, daily if no days are configured
+func NewJobActionTiming(tag string, timing *RITiming, run func() error) *ActionTiming {
+	if len(timing.MonthDays) == 0 && len(timing.WeekDays) == 0 {
+		timing.WeekDays = utils.WeekDays{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}
+	}
+	return NewTaskActionTiming(tag, timing, run)
+}
+
+// Interval covered by a job run at runTime, for the periods relative to the run: <*previous_day|*previous_month>
+func JobPeriod(period string, runTime time.Time) (timeStart, timeEnd time.Time) {
+	today := time.Date(runTime.Year(), runTime.Month(), runTime.Day(), 0, 0, 0, 0, runTime.Location())
+	switch period {
+	case utils.PREVIOUS_DAY:
+		timeStart, timeEnd = today.AddDate(0, 0, -1), today
+	case utils.PREVIOUS_MONTH:
+		timeEnd = today.AddDate(0, 0, 1-today.Day())
+		timeStart = timeEnd.AddDate(0, -1, 0)
+	}
+	return
+}
@@ -45,12 +45,13 @@ type CSVReader struct {
 	destinationRates  map[string]*utils.TPDestinationRate
 	ratingPlans       map[string]*RatingPlan
 	ratingProfiles    map[string]*RatingProfile
+	derivedCharging   []*utils.DerivedChargingRule
 	// file names
 	destinationsFn, ratesFn, destinationratesFn, timingsFn, destinationratetimingsFn, ratingprofilesFn,
-	actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn string
+	actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn, derivedchargingFn string
 }
 
-func NewFileCSVReader(dataStorage RatingStorage, accountingStorage AccountingStorage, sep rune, destinationsFn, timingsFn, ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn string) *CSVReader {
+func NewFileCSVReader(dataStorage RatingStorage, accountingStorage AccountingStorage, sep rune, destinationsFn, timingsFn, ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn, derivedchargingFn string) *CSVReader {
 	c := new(CSVReader)
 	c.sep = sep
 	c.dataStorage = dataStorage
@@ -65,13 +66,13 @@ func NewFileCSVReader(dataStorage RatingStorage, accountingStorage AccountingSto
 	c.ratingProfiles = make(map[string]*RatingProfile)
 	c.readerFunc = openFileCSVReader
 	c.destinationsFn, c.timingsFn, c.ratesFn, c.destinationratesFn, c.destinationratetimingsFn, c.ratingprofilesFn,
-		c.actionsFn, c.actiontimingsFn, c.actiontriggersFn, c.accountactionsFn = destinationsFn, timingsFn,
-		ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn
+		c.actionsFn, c.actiontimingsFn, c.actiontriggersFn, c.accountactionsFn, c.derivedchargingFn = destinationsFn, timingsFn,
+		ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn, derivedchargingFn
 	return c
 }
 
-func NewStringCSVReader(dataStorage RatingStorage, accountingStorage AccountingStorage, sep rune, destinationsFn, timingsFn, ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn string) *CSVReader {
-	c := NewFileCSVReader(dataStorage, accountingStorage, sep, destinationsFn, timingsFn, ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn)
+func NewStringCSVReader(dataStorage RatingStorage, accountingStorage AccountingStorage, sep rune, destinationsFn, timingsFn, ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn, derivedchargingFn string) *CSVReader {
+	c := NewFileCSVReader(dataStorage, accountingStorage, sep, destinationsFn, timingsFn, ratesFn, destinationratesFn, destinationratetimingsFn, ratingprofilesFn, actionsFn, actiontimingsFn, actiontriggersFn, accountactionsFn, derivedchargingFn)
 	c.readerFunc = openStringCSVReader
 	return c
 }
@@ -147,6 +148,8 @@ func (csvr *CSVReader) ShowStatistics() {
 	log.Print("Action plans: ", len(csvr.actionsTimings))
 	// account actions
 	log.Print("Account actions: ", len(csvr.accountActions))
+	// derived charging
+	log.Print("Derived charging rules: ", len(csvr.derivedCharging))
 }
 
 func (csvr *CSVReader) WriteToDatabase(flush, verbose bool) (err error) {
@@ -230,6 +233,18 @@ func (csvr *CSVReader) WriteToDatabase(flush, verbose bool) (err error) {
 			log.Println(ub.Id)
 		}
 	}
+	if verbose {
+		log.Print("Derived charging rules")
+	}
+	for _, dcr := range csvr.derivedCharging {
+		err = accountingStorage.SetDerivedChargingRule(dcr)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			log.Println(dcr.Id)
+		}
+	}
 	return
 }
 
@@ -646,9 +661,53 @@ func (csvr *CSVReader) LoadAll() error {
 	if err = csvr.LoadAccountActions(); err != nil {
 		return err
 	}
+	if err = csvr.LoadDerivedCharging(); err != nil {
+		return err
+	}
 	return nil
 }
 
+func (csvr *CSVReader) LoadDerivedCharging() (err error) {
+	csvReader, fp, err := csvr.readerFunc(csvr.derivedchargingFn, csvr.sep, utils.DERIVED_CHARGING_NRCOLS)
+	if err != nil {
+		log.Print("Could not load derived charging file: ", err)
+		// allow writing of the other values
+		return nil
+	}
+	if fp != nil {
+		defer fp.Close()
+	}
+	for record, err := csvReader.Read(); err == nil; record, err = csvReader.Read() {
+		for _, dcr := range csvr.derivedCharging {
+			if dcr.Id == record[0] {
+				return fmt.Errorf("Duplicate derived charging rule: %s", record[0])
+			}
+		}
+		weight, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			return fmt.Errorf("Could not parse derived charging weight: %v", err)
+		}
+		filters, err := utils.ParseDerivedChargingFilters(record[3])
+		if err != nil {
+			return err
+		}
+		skip := false
+		if len(record[4]) != 0 {
+			if skip, err = strconv.ParseBool(record[4]); err != nil {
+				return fmt.Errorf("Could not parse derived charging skip: %v", err)
+			}
+		}
+		dcr := &utils.DerivedChargingRule{Id: record[0], RunId: record[1], Weight: weight, Filters: filters, Skip: skip,
+			ReqTypeField: record[5], DirectionField: record[6], TenantField: record[7], TORField: record[8],
+			AccountField: record[9], SubjectField: record[10], DestinationField: record[11]}
+		if err := dcr.Compile(); err != nil {
+			return fmt.Errorf("Derived charging rule %s: %s", dcr.Id, err.Error())
+		}
+		csvr.derivedCharging = append(csvr.derivedCharging, dcr)
+	}
+	return
+}
+
 // Returns the identities loaded for a specific category, useful for cache reloads
 func (csvr *CSVReader) GetLoadedIds(categ string) ([]string, error) {
 	switch categ {
@@ -123,13 +123,17 @@ STANDARD_TRIGGER,*minutes,*out,*max_balance,200,GERMANY,SOME_2,10
 `
 	accountActions = `
 vdf,minitsboy,*out,MORE_MINUTES,STANDARD_TRIGGER
+`
+	derivedCharging = `
+DC_RETAIL,retail,20,tenant:^vdf$;destination:^\+49,,^prepaid,,,,~account:s/^(\d+)$/retail_${1}/,,~destination:s/^\+49(\d+)$/0${1}/
+DC_RETAIL_SKIP,retail,10,,true,,,,,,,
 `
 )
 
 var csvr *CSVReader
 
 func init() {
-	csvr = NewStringCSVReader(dataStorage, accountingStorage, ',', destinations, timings, rates, destinationRates, destinationRateTimings, ratingProfiles, actions, actionTimings, actionTriggers, accountActions)
+	csvr = NewStringCSVReader(dataStorage, accountingStorage, ',', destinations, timings, rates, destinationRates, destinationRateTimings, ratingProfiles, actions, actionTimings, actionTriggers, accountActions, derivedCharging)
 	csvr.LoadDestinations()
 	csvr.LoadTimings()
 	csvr.LoadRates()
@@ -140,6 +144,7 @@ func init() {
 	csvr.LoadActionTimings()
 	csvr.LoadActionTriggers()
 	csvr.LoadAccountActions()
+	csvr.LoadDerivedCharging()
 	csvr.WriteToDatabase(false, false)
 	dataStorage.CacheRating(nil, nil, nil)
 }
@@ -684,3 +689,23 @@ func TestLoadAccountActions(t *testing.T) {
 /*
 vdf,minitsboy,*out,MORE_MINUTES,STANDARD_TRIGGER
 */
+
+func TestLoadDerivedCharging(t *testing.T) {
+	if len(csvr.derivedCharging) != 2 {
+		t.Fatal("Failed to load derived charging rules: ", csvr.derivedCharging)
+	}
+	dcr := csvr.derivedCharging[0]
+	if dcr.Id != "DC_RETAIL" || dcr.RunId != "retail" || dcr.Weight != 20 || dcr.Skip ||
+		!reflect.DeepEqual(dcr.Filters, map[string]string{"tenant": "^vdf$", "destination": `^\+49`}) ||
+		dcr.ReqTypeField != "^prepaid" || dcr.AccountField != `~account:s/^(\d+)$/retail_${1}/` || dcr.DestinationField != `~destination:s/^\+49(\d+)$/0${1}/` {
+		t.Errorf("Unexpected rule: %+v", dcr)
+	}
+	if !csvr.derivedCharging[1].Skip {
+		t.Errorf("Unexpected rule: %+v", csvr.derivedCharging[1])
+	}
+	if dcrs, err := accountingStorage.GetDerivedChargingRules(); err != nil {
+		t.Error(err)
+	} else if len(dcrs) != 2 {
+		t.Error("Rules not written to accounting db: ", dcrs)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cgrates/cgrates/cache2go"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Source of the rating profiles, rating plans and destinations used by a CallDescriptor
+type ratingGetter interface {
+	getRatingProfile(key string) (*RatingProfile, error)
+	getRatingPlan(id string) (*RatingPlan, error)
+	getDestinationIds(prefix string) []string
+}
+
+// Rating data currently loaded in the rating db
+type storageRatingGetter struct{}
+
+func (storageRatingGetter) getRatingProfile(key string) (*RatingProfile, error) {
+	return dataStorage.GetRatingProfile(key, false)
+}
+
+func (storageRatingGetter) getRatingPlan(id string) (*RatingPlan, error) {
+	return dataStorage.GetRatingPlan(id, false)
+}
+
+func (storageRatingGetter) getDestinationIds(prefix string) []string {
+	if x, err := cache2go.GetCached(DESTINATION_PREFIX + prefix); err == nil {
+		return x.([]string)
+	}
+	return nil
+}
+
+// Rating data of one tariff plan, loaded from storDb and kept in memory apart of the one in the rating db
+type TPRatingData struct {
+	TPid           string
+	ratingProfiles map[string]*RatingProfile
+	ratingPlans    map[string]*RatingPlan
+	destinationIds map[string][]string // Destination ids indexed on prefix
+}
+
+// Loads the rating data of a tariff plan out of storDb. The tariff plan should be complete since
+// nothing is looked up in the rating db.
+func LoadTPRatingData(storDb LoadStorage, tpid string) (*TPRatingData, error) {
+	tpDb, _ := NewMapStorage() // Checked by the loader for references missing out of the tariff plan
+	dbr := NewDbReader(storDb, tpDb, nil, tpid)
+	for _, load := range []func() error{dbr.LoadDestinations, dbr.LoadTimings, dbr.LoadRates, dbr.LoadDestinationRates,
+		dbr.LoadRatingPlans, dbr.LoadRatingProfiles} {
+		if err := load(); err != nil {
+			return nil, err
+		}
+	}
+	if len(dbr.ratingProfiles) == 0 {
+		return nil, fmt.Errorf("No rating profiles in tariff plan %s", tpid)
+	}
+	return newTPRatingData(tpid, dbr.destinations, dbr.ratingPlans, dbr.ratingProfiles), nil
+}
+
+func newTPRatingData(tpid string, dsts []*Destination, rpls map[string]*RatingPlan, rpfs map[string]*RatingProfile) *TPRatingData {
+	rd := &TPRatingData{TPid: tpid, ratingProfiles: rpfs, ratingPlans: rpls, destinationIds: make(map[string][]string)}
+	for _, dst := range dsts {
+		for _, prefix := range dst.Prefixes {
+			rd.destinationIds[prefix] = append(rd.destinationIds[prefix], dst.Id)
+		}
+	}
+	return rd
+}
+
+func (rd *TPRatingData) getRatingProfile(key string) (*RatingProfile, error) {
+	if rpf, hasIt := rd.ratingProfiles[key]; hasIt {
+		return rpf, nil
+	}
+	return nil, errors.New(utils.ERR_NOT_FOUND)
+}
+
+func (rd *TPRatingData) getRatingPlan(id string) (*RatingPlan, error) {
+	if rpl, hasIt := rd.ratingPlans[id]; hasIt {
+		return rpl, nil
+	}
+	return nil, errors.New(utils.ERR_NOT_FOUND)
+}
+
+func (rd *TPRatingData) getDestinationIds(prefix string) []string {
+	return rd.destinationIds[prefix]
+}
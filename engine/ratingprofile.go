@@ -25,7 +25,6 @@ import (
 	"sort"
 	"time"
 
-	"github.com/cgrates/cgrates/cache2go"
 	"github.com/cgrates/cgrates/history"
 	"github.com/cgrates/cgrates/utils"
 )
@@ -108,7 +107,7 @@ func (ris RatingInfos) Sort() {
 func (rp *RatingProfile) GetRatingPlansForPrefix(cd *CallDescriptor) (err error) {
 	var ris RatingInfos
 	for index, rpa := range rp.RatingPlanActivations.GetActiveForCall(cd) {
-		rpl, err := dataStorage.GetRatingPlan(rpa.RatingPlanId, false)
+		rpl, err := cd.getRatingData().getRatingPlan(rpa.RatingPlanId)
 		if err != nil || rpl == nil {
 			Logger.Err(fmt.Sprintf("Error checking destination: %v", err))
 			continue
@@ -116,14 +115,11 @@ func (rp *RatingProfile) GetRatingPlansForPrefix(cd *CallDescriptor) (err error)
 		bestPrecision := 0
 		var rps RateIntervalList
 		for _, p := range utils.SplitPrefix(cd.Destination, MIN_PREFIX_MATCH) {
-			if x, err := cache2go.GetCached(DESTINATION_PREFIX + p); err == nil {
-				destIds := x.([]string)
-				for _, dId := range destIds {
-					if _, ok := rpl.DestinationRates[dId]; ok {
-						rps = rpl.RateIntervalList(dId)
-						bestPrecision = len(p)
-						break
-					}
+			for _, dId := range cd.getRatingData().getDestinationIds(p) {
+				if _, ok := rpl.DestinationRates[dId]; ok {
+					rps = rpl.RateIntervalList(dId)
+					bestPrecision = len(p)
+					break
 				}
 			}
 			if rps != nil {
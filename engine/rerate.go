@@ -0,0 +1,195 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Cost differences of one account after re-rating its CDRs
+type RerateAccountDiff struct {
+	Direction, Tenant, Account string
+	Cdrs                       int     // Number of CDRs re-rated
+	CostBefore                 float64 // Total cost of the CDRs before re-rating
+	CostAfter                  float64 // Total cost of the CDRs after re-rating
+	CostDiff                   float64 // CostAfter - CostBefore
+	BalanceDiff                float64 // Part of CostDiff coming from CDRs debiting the balance (prepaid, pseudoprepaid)
+	Adjusted                   bool    // BalanceDiff was applied on the account balance
+}
+
+func (diff *RerateAccountDiff) key() string {
+	return fmt.Sprintf("%s:%s:%s", diff.Direction, diff.Tenant, diff.Account)
+}
+
+// Result of re-rating a set of CDRs with the rating data of a tariff plan
+type RerateReport struct {
+	TPid       string
+	RunId      string // Run id the new costs were stored under, empty if the original costs were overwritten
+	Cdrs       int    // Number of CDRs re-rated
+	Errors     int    // Number of CDRs which could not be re-rated, their costs are left untouched
+	Accounts   []*RerateAccountDiff
+	AdjustErr  string // Error stopping the balance adjustments, the accounts not Adjusted were left untouched
+	diffsByKey map[string]*RerateAccountDiff
+}
+
+// Re-rates the rated CDRs with the tariff plan data and stores the new costs under runId.
+// With empty runId the costs of the original mediation runs are overwritten.
+// Same CDR can be stored only once under a new run id, so CDRs with multiple mediation runs are counted as errors then.
+// The *correlated runs are left out, their costs come out of the legs.
+func RerateCdrs(cdrDb CdrStorage, rd *TPRatingData, cdrs []*utils.StoredCdr, runId string) (*RerateReport, error) {
+	rpt := &RerateReport{TPid: rd.TPid, RunId: runId, diffsByKey: make(map[string]*RerateAccountDiff)}
+	runs := make(map[string]int) // Mediation runs selected for each cgrid
+	for _, cdr := range cdrs {
+		if isRerated(cdr, runId) {
+			runs[cdr.CgrId] += 1
+		}
+	}
+	for _, cdr := range cdrs {
+		if !isRerated(cdr, runId) {
+			continue
+		}
+		if len(runId) != 0 && runs[cdr.CgrId] > 1 {
+			Logger.Err(fmt.Sprintf("<Rerate> Could not re-rate CDR with cgrid: %s, runid: %s, error: multiple mediation runs selected", cdr.CgrId, cdr.MediationRunId))
+			rpt.Errors += 1
+			continue
+		}
+		cc, err := getRerateCost(cdr, rd)
+		if err != nil {
+			Logger.Err(fmt.Sprintf("<Rerate> Could not re-rate CDR with cgrid: %s, runid: %s, error: %s", cdr.CgrId, cdr.MediationRunId, err.Error()))
+			rpt.Errors += 1
+			continue
+		}
+		reratedCdr := *cdr
+		reratedCdr.Cost = cc.Cost
+		if len(runId) != 0 {
+			reratedCdr.MediationRunId = runId
+		}
+		if err := cdrDb.SetRatedCdr(&reratedCdr, fmt.Sprintf("rerated with tpid: %s", rd.TPid)); err != nil {
+			return nil, err
+		}
+		if cdr.Duration != 0 {
+			storageLogger.LogCallCost(reratedCdr.CgrId, MEDIATOR_SOURCE, reratedCdr.MediationRunId, cc)
+		}
+		rpt.addCdr(cdr, reratedCdr.Cost)
+	}
+	sort.Sort(rerateAccountDiffs(rpt.Accounts))
+	return rpt, nil
+}
+
+// False for the CDRs not rated, resulting out of a previous re-rate under runId or combining the legs of a call, the latter being re-mediated out of their legs
+func isRerated(cdr *utils.StoredCdr, runId string) bool {
+	return len(cdr.MediationRunId) != 0 && cdr.MediationRunId != utils.CORRELATED_RUNID && (len(runId) == 0 || cdr.MediationRunId != runId)
+}
+
+// Computes the cost of one CDR out of the tariff plan data
+func getRerateCost(cdr *utils.StoredCdr, rd *TPRatingData) (*CallCost, error) {
+	if cdr.Duration == 0 { // Failed call, nothing to rate
+		return &CallCost{}, nil
+	}
+	direction := cdr.Direction
+	if len(direction) == 0 {
+		direction = OUTBOUND
+	}
+	cd := &CallDescriptor{
+		Direction:    direction,
+		Tenant:       cdr.Tenant,
+		TOR:          cdr.TOR,
+		Subject:      cdr.Subject,
+		Account:      cdr.Account,
+		Destination:  cdr.Destination,
+		TimeStart:    cdr.AnswerTime,
+		TimeEnd:      cdr.AnswerTime.Add(cdr.Duration),
+		CallDuration: cdr.Duration,
+	}
+	cd.SetTPRatingData(rd)
+	return cd.GetCost()
+}
+
+func (rpt *RerateReport) addCdr(cdr *utils.StoredCdr, newCost float64) {
+	direction := cdr.Direction
+	if len(direction) == 0 {
+		direction = OUTBOUND
+	}
+	diff := &RerateAccountDiff{Direction: direction, Tenant: cdr.Tenant, Account: cdr.Account}
+	if existing, hasIt := rpt.diffsByKey[diff.key()]; hasIt {
+		diff = existing
+	} else {
+		rpt.diffsByKey[diff.key()] = diff
+		rpt.Accounts = append(rpt.Accounts, diff)
+	}
+	oldCost := math.Max(cdr.Cost, 0) // Cost of -1 marks a rating error
+	diff.Cdrs += 1
+	diff.CostBefore = utils.Round(diff.CostBefore+oldCost, roundingDecimals, roundingMethod)
+	diff.CostAfter = utils.Round(diff.CostAfter+newCost, roundingDecimals, roundingMethod)
+	diff.CostDiff = utils.Round(diff.CostAfter-diff.CostBefore, roundingDecimals, roundingMethod)
+	if cdr.ReqType == utils.PREPAID || cdr.ReqType == utils.PSEUDOPREPAID {
+		diff.BalanceDiff = utils.Round(diff.BalanceDiff+newCost-oldCost, roundingDecimals, roundingMethod)
+	}
+	rpt.Cdrs += 1
+}
+
+// Applies the balance differences on the accounts: debits the ones charged too little and tops-up the ones charged too much.
+// Accounts missing out of the accounting db are skipped and remain not Adjusted.
+func (rpt *RerateReport) ApplyAdjustments() error {
+	for _, diff := range rpt.Accounts {
+		if diff.BalanceDiff == 0 || diff.Adjusted {
+			continue
+		}
+		if _, err := accountingStorage.GetUserBalance(diff.key()); err != nil {
+			Logger.Warning(fmt.Sprintf("<Rerate> Not adjusting balance of account %s: %s", diff.key(), err.Error()))
+			continue
+		}
+		aType := DEBIT
+		if diff.BalanceDiff < 0 {
+			aType = TOPUP
+		}
+		at := &ActionTiming{UserBalanceIds: []string{diff.key()}}
+		at.SetActions(Actions{
+			&Action{
+				ActionType: aType,
+				BalanceId:  CREDIT,
+				Direction:  diff.Direction,
+				Balance:    &Balance{Value: math.Abs(diff.BalanceDiff)},
+			},
+		})
+		if err := at.Execute(); err != nil {
+			return fmt.Errorf("Failed adjusting balance of account %s: %s", diff.key(), err.Error())
+		}
+		diff.Adjusted = true
+	}
+	return nil
+}
+
+type rerateAccountDiffs []*RerateAccountDiff
+
+func (diffs rerateAccountDiffs) Len() int {
+	return len(diffs)
+}
+
+func (diffs rerateAccountDiffs) Swap(i, j int) {
+	diffs[i], diffs[j] = diffs[j], diffs[i]
+}
+
+func (diffs rerateAccountDiffs) Less(i, j int) bool {
+	return diffs[i].key() < diffs[j].key()
+}
@@ -0,0 +1,151 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+func getRerateTPData(t *testing.T) *TPRatingData {
+	tpDb, _ := NewMapStorage()
+	tpCsvr := NewStringCSVReader(tpDb, nil, ',',
+		`RR_NAT,0256`,
+		`RR_ALWAYS,*any,*any,*any,*any,00:00:00`,
+		`RR_R1,0,2,60s,60s,0,*middle,2`,
+		`RR_DR1,RR_NAT,RR_R1`,
+		`RR_RP1,RR_DR1,RR_ALWAYS,10`,
+		`vdf,0,*out,rerate,2013-01-01T00:00:00Z,RR_RP1,`,
+		"", "", "", "", "")
+	for _, load := range []func() error{tpCsvr.LoadDestinations, tpCsvr.LoadTimings, tpCsvr.LoadRates, tpCsvr.LoadDestinationRates,
+		tpCsvr.LoadRatingPlans, tpCsvr.LoadRatingProfiles} {
+		if err := load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return newTPRatingData("TEST_RERATE", tpCsvr.destinations, tpCsvr.ratingPlans, tpCsvr.ratingProfiles)
+}
+
+func TestTPRatingDataGetCost(t *testing.T) {
+	t1 := time.Date(2013, time.November, 7, 8, 42, 26, 0, time.UTC)
+	cd := &CallDescriptor{Direction: "*out", TOR: "0", Tenant: "vdf", Subject: "rerate", Destination: "0256", TimeStart: t1, TimeEnd: t1.Add(90 * time.Second)}
+	if cc, err := cd.GetCost(); err != nil { // Rated on the *any fallback in the rating db
+		t.Error(err)
+	} else if cc.Cost == 4 {
+		t.Error("Rated with the tariff plan data")
+	}
+	cd = &CallDescriptor{Direction: "*out", TOR: "0", Tenant: "vdf", Subject: "rerate", Destination: "0256", TimeStart: t1, TimeEnd: t1.Add(90 * time.Second)}
+	cd.SetTPRatingData(getRerateTPData(t))
+	if cc, err := cd.GetCost(); err != nil {
+		t.Error(err)
+	} else if cc.Cost != 4 {
+		t.Errorf("Unexpected cost: %v", cc.Cost)
+	}
+}
+
+func TestRerateCdrs(t *testing.T) {
+	cdrDb, _ := NewMapStorage()
+	answerTime := time.Date(2013, time.November, 7, 8, 42, 26, 0, time.UTC)
+	var cdrs []*utils.StoredCdr
+	for _, cdr := range []*utils.StoredCdr{
+		&utils.StoredCdr{AccId: "rerate1", ReqType: utils.PREPAID, Direction: "*out", Tenant: "vdf", TOR: "0", Account: "rerate_acnt1", Subject: "rerate",
+			Destination: "0256", AnswerTime: answerTime, Duration: 90 * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 1},
+		&utils.StoredCdr{AccId: "rerate2", ReqType: utils.POSTPAID, Direction: "*out", Tenant: "vdf", TOR: "0", Account: "rerate_acnt1", Subject: "rerate",
+			Destination: "0256", AnswerTime: answerTime, Duration: 30 * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 2},
+		&utils.StoredCdr{AccId: "rerate3", ReqType: utils.PREPAID, Direction: "*out", Tenant: "vdf", TOR: "0", Account: "rerate_acnt2", Subject: "unknown",
+			Destination: "0256", AnswerTime: answerTime, Duration: 30 * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 2},
+		&utils.StoredCdr{AccId: "rerate4", ReqType: utils.PREPAID, Direction: "*out", Tenant: "vdf", TOR: "0", Account: "rerate_acnt2", Subject: "rerate",
+			Destination: "0256", AnswerTime: answerTime, Duration: 30 * time.Second},
+	} {
+		cdr.CgrId = utils.FSCgrId(cdr.AccId)
+		if err := cdrDb.SetCdr(cdr); err != nil {
+			t.Fatal(err)
+		}
+		if len(cdr.MediationRunId) != 0 {
+			if err := cdrDb.SetRatedCdr(cdr, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cdrs = append(cdrs, cdr)
+	}
+	rpt, err := RerateCdrs(cdrDb, getRerateTPData(t), cdrs, "rerun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rpt.Cdrs != 2 || rpt.Errors != 1 || len(rpt.Accounts) != 1 {
+		t.Fatalf("Unexpected report: %+v", rpt)
+	}
+	eDiff := &RerateAccountDiff{Direction: "*out", Tenant: "vdf", Account: "rerate_acnt1", Cdrs: 2, CostBefore: 3, CostAfter: 6, CostDiff: 3, BalanceDiff: 3}
+	if *rpt.Accounts[0] != *eDiff {
+		t.Errorf("Expecting: %+v, received: %+v", eDiff, rpt.Accounts[0])
+	}
+	if rerated, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{MediationRunIds: []string{"rerun"}, OrderBy: utils.ACCID}); err != nil {
+		t.Error(err)
+	} else if len(rerated) != 2 || rerated[0].Cost != 4 || rerated[1].Cost != 2 {
+		t.Errorf("Unexpected rerated CDRs: %+v", rerated)
+	}
+	if orig, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{MediationRunIds: []string{utils.DEFAULT_RUNID}, OrderBy: utils.ACCID}); err != nil {
+		t.Error(err)
+	} else if len(orig) != 3 || orig[0].Cost != 1 {
+		t.Errorf("Original costs should be kept: %+v", orig)
+	}
+	// Storing again under the same run id ignores the previous results
+	if rpt, err = RerateCdrs(cdrDb, getRerateTPData(t), append(cdrs, &utils.StoredCdr{CgrId: cdrs[0].CgrId, MediationRunId: "rerun"}), "rerun"); err != nil {
+		t.Error(err)
+	} else if rpt.Cdrs != 2 {
+		t.Errorf("Unexpected report: %+v", rpt)
+	}
+	// Multiple mediation runs of the same CDR cannot be stored under one run id, the other CDRs are still re-rated
+	if rpt, err = RerateCdrs(cdrDb, getRerateTPData(t), append([]*utils.StoredCdr{&utils.StoredCdr{CgrId: cdrs[1].CgrId, MediationRunId: "other"}}, cdrs...), "rerun_multi"); err != nil {
+		t.Error(err)
+	} else if rpt.Cdrs != 1 || rpt.Errors != 3 {
+		t.Errorf("Unexpected report: %+v", rpt)
+	}
+	// Combined legs are left to the correlation, also when overwriting the original costs
+	if rpt, err = RerateCdrs(cdrDb, getRerateTPData(t), []*utils.StoredCdr{&utils.StoredCdr{CgrId: cdrs[0].CgrId, MediationRunId: utils.CORRELATED_RUNID, Cost: 1}}, ""); err != nil {
+		t.Error(err)
+	} else if rpt.Cdrs != 0 || rpt.Errors != 0 {
+		t.Errorf("Unexpected report: %+v", rpt)
+	}
+	// Overwrite the original costs
+	if rpt, err = RerateCdrs(cdrDb, getRerateTPData(t), cdrs, ""); err != nil {
+		t.Fatal(err)
+	}
+	if orig, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{MediationRunIds: []string{utils.DEFAULT_RUNID}, OrderBy: utils.ACCID}); err != nil {
+		t.Error(err)
+	} else if len(orig) != 3 || orig[0].Cost != 4 || orig[2].Cost != 2 {
+		t.Errorf("Unexpected overwritten CDRs: %+v", orig)
+	}
+	ub := &UserBalance{Id: "*out:vdf:rerate_acnt1", BalanceMap: map[string]BalanceChain{CREDIT + OUTBOUND: BalanceChain{&Balance{Value: 10}}}}
+	if err := accountingStorage.SetUserBalance(ub); err != nil {
+		t.Fatal(err)
+	}
+	if err := rpt.ApplyAdjustments(); err != nil {
+		t.Error(err)
+	} else if !rpt.Accounts[0].Adjusted {
+		t.Error("Account not adjusted")
+	}
+	if ub, err := accountingStorage.GetUserBalance("*out:vdf:rerate_acnt1"); err != nil {
+		t.Error(err)
+	} else if ub.BalanceMap[CREDIT+OUTBOUND].GetTotalValue() != 7 {
+		t.Errorf("Unexpected balance: %v", ub.BalanceMap[CREDIT+OUTBOUND].GetTotalValue())
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Populates cdrDb with CDRs and runs the GetCdrs queries on them, shared by the storages implementing CdrStorage
+func testGetCdrs(t *testing.T, cdrDb CdrStorage) {
+	answerTime := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	for idx, cdr := range []*utils.StoredCdr{
+		&utils.StoredCdr{AccId: "getcdrs1", CdrHost: "192.168.1.1", CdrSource: "test", ReqType: utils.RATED, Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1001", Subject: "1001", Destination: "+4986517174963", AnswerTime: answerTime, Duration: time.Duration(10) * time.Second,
+			ExtraFields: map[string]string{"field_extr1": "val_extr1"}},
+		&utils.StoredCdr{AccId: "getcdrs2", CdrHost: "192.168.1.1", CdrSource: "test", ReqType: utils.PREPAID, Direction: "*out", Tenant: "cgrates.org",
+			TOR: "call", Account: "1002", Subject: "1002", Destination: "+4986517174964", AnswerTime: answerTime.Add(time.Minute), Duration: time.Duration(60) * time.Second,
+			ExtraFields: map[string]string{}},
+		&utils.StoredCdr{AccId: "getcdrs3", CdrHost: "192.168.1.1", CdrSource: "other", ReqType: utils.RATED, Direction: "*out", Tenant: "itsyscom.com",
+			TOR: "call", Account: "1001", Subject: "1001", Destination: "+4012345", AnswerTime: answerTime.Add(2 * time.Minute), Duration: time.Duration(120) * time.Second,
+			ExtraFields: map[string]string{}},
+	} {
+		cdr.CgrId = utils.FSCgrId(cdr.AccId)
+		if err := cdrDb.SetCdr(cdr); err != nil {
+			t.Fatal(err)
+		}
+		if idx == 2 { // Last CDR stays unmediated
+			continue
+		}
+		ratedCdr := *cdr
+		ratedCdr.MediationRunId = utils.DEFAULT_RUNID
+		ratedCdr.Cost = float64(idx + 1)
+		if err := cdrDb.SetRatedCdr(&ratedCdr, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Extra mediation run of the first CDR
+	ratedCdr := &utils.StoredCdr{CgrId: utils.FSCgrId("getcdrs1"), MediationRunId: "run2", Subject: "1001", Cost: 5}
+	if err := cdrDb.SetRatedCdr(ratedCdr, ""); err != nil {
+		t.Fatal(err)
+	}
+	minCost := 1.5
+	for _, tc := range []struct {
+		fltr    *utils.CdrsFilter
+		eCount  int
+		eAccIds []string // Expected in order when not in count mode
+	}{
+		{&utils.CdrsFilter{Count: true}, 4, nil},
+		{&utils.CdrsFilter{Tenants: []string{"cgrates.org"}, Count: true}, 3, nil},
+		{&utils.CdrsFilter{CgrIds: []string{utils.FSCgrId("getcdrs2")}}, 1, []string{"getcdrs2"}},
+		{&utils.CdrsFilter{Accounts: []string{"1001"}, MediationRunIds: []string{utils.DEFAULT_RUNID}}, 1, []string{"getcdrs1"}},
+		{&utils.CdrsFilter{DestinationPrefixes: []string{"+49", "+40"}, OrderBy: utils.DURATION, OrderDescending: true}, 4,
+			[]string{"getcdrs3", "getcdrs2", "getcdrs1", "getcdrs1"}},
+		{&utils.CdrsFilter{DestinationPrefixes: []string{"+401"}}, 1, []string{"getcdrs3"}},
+		{&utils.CdrsFilter{ReqTypes: []string{utils.PREPAID}, CdrSources: []string{"test"}}, 1, []string{"getcdrs2"}},
+		{&utils.CdrsFilter{MinCost: &minCost, OrderBy: utils.COST}, 2, []string{"getcdrs2", "getcdrs1"}},
+		{&utils.CdrsFilter{MinDuration: time.Duration(30) * time.Second, MaxDuration: time.Duration(120) * time.Second}, 1, []string{"getcdrs2"}},
+		{&utils.CdrsFilter{AnswerTimeStart: answerTime.Add(time.Minute), OrderBy: utils.ANSWER_TIME}, 2, []string{"getcdrs2", "getcdrs3"}},
+		{&utils.CdrsFilter{Subjects: []string{"1001"}, OrderBy: utils.ANSWER_TIME, OrderDescending: true, Limit: 1, Offset: 1}, 1, []string{"getcdrs1"}},
+	} {
+		cdrs, count, err := cdrDb.GetCdrs(tc.fltr)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if count != tc.eCount {
+			t.Errorf("Filter: %+v, expected count: %d, received: %d", tc.fltr, tc.eCount, count)
+			continue
+		}
+		if tc.fltr.Count {
+			continue
+		}
+		if len(cdrs) != len(tc.eAccIds) {
+			t.Errorf("Filter: %+v, unexpected CDRs: %+v", tc.fltr, cdrs)
+			continue
+		}
+		for idx, cdr := range cdrs {
+			if cdr.AccId != tc.eAccIds[idx] {
+				t.Errorf("Filter: %+v, expected %s on position %d, received: %s", tc.fltr, tc.eAccIds[idx], idx, cdr.AccId)
+			}
+		}
+	}
+}
+
+func TestMapStorageGetCdrs(t *testing.T) {
+	ms, _ := NewMapStorage()
+	testGetCdrs(t, ms)
+}
+
+func TestMapStorageUpdateCdr(t *testing.T) {
+	ms, _ := NewMapStorage()
+	cdr := &utils.StoredCdr{CgrId: utils.FSCgrId("updatecdr1"), AccId: "updatecdr1", CdrHost: "192.168.1.1", CdrSource: "test", ReqType: utils.RATED,
+		Direction: "*out", Tenant: "cgrates.org", TOR: "call", Account: "1001", Subject: "1001", Destination: "1002",
+		AnswerTime: time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC), Duration: time.Duration(10) * time.Second, ExtraFields: map[string]string{}}
+	if err := ms.UpdateCdr(cdr); err == nil || err.Error() != utils.ERR_NOT_FOUND {
+		t.Error("Unexpected error: ", err)
+	}
+	if err := ms.SetCdr(cdr); err != nil {
+		t.Fatal(err)
+	}
+	cdr.Duration = time.Duration(20) * time.Second
+	cdr.ExtraFields["field_extr1"] = "val_extr1"
+	if err := ms.UpdateCdr(cdr); err != nil {
+		t.Fatal(err)
+	}
+	if cdrs, _, err := ms.GetCdrs(&utils.CdrsFilter{CgrIds: []string{cdr.CgrId}}); err != nil {
+		t.Fatal(err)
+	} else if len(cdrs) != 1 || cdrs[0].Duration != cdr.Duration || cdrs[0].ExtraFields["field_extr1"] != "val_extr1" {
+		t.Errorf("Unexpected CDRs: %+v", cdrs)
+	}
+}
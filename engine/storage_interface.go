@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 
 	"github.com/cgrates/cgrates/utils"
 	"github.com/ugorji/go/codec"
@@ -38,6 +39,7 @@ const (
 	ACTION_PREFIX             = "act_"
 	USER_BALANCE_PREFIX       = "ubl_"
 	DESTINATION_PREFIX        = "dst_"
+	DERIVED_CHARGING_PREFIX   = "dcr_"
 	TEMP_DESTINATION_PREFIX   = "tmp_"
 	LOG_CALL_COST_PREFIX      = "cco_"
 	LOG_ACTION_TIMMING_PREFIX = "ltm_"
@@ -45,6 +47,12 @@ const (
 	LOG_ERR                   = "ler_"
 	LOG_CDR                   = "cdr_"
 	LOG_MEDIATED_CDR          = "mcd_"
+	LOG_EXPORTED_CDR          = "cex_"
+	LOG_COST_PENDING_CDR      = "cpd_"
+	SESSION_STATE_PREFIX      = "sst_"
+	INVOICE_PREFIX            = "inv_"
+	INVOICE_SEQUENCE_PREFIX   = "isq_"
+	INVOICE_ADJUSTMENT_PREFIX = "iad_"
 	// sources
 	SESSION_MANAGER_SOURCE = "SMR"
 	MEDIATOR_SOURCE        = "MED"
@@ -55,6 +63,7 @@ const (
 	CREATE_COSTDETAILS_TABLES_SQL = "create_costdetails_tables.sql"
 	CREATE_MEDIATOR_TABLES_SQL    = "create_mediator_tables.sql"
 	CREATE_TARIFFPLAN_TABLES_SQL  = "create_tariffplan_tables.sql"
+	CREATE_INVOICES_TABLES_SQL    = "create_invoices_tables.sql"
 	TEST_SQL                      = "TEST_SQL"
 )
 
@@ -89,14 +98,28 @@ type AccountingStorage interface {
 	GetActionTimings(string) (ActionPlan, error)
 	SetActionTimings(string, ActionPlan) error
 	GetAllActionTimings() (map[string]ActionPlan, error)
+	GetDerivedChargingRules() ([]*utils.DerivedChargingRule, error)
+	SetDerivedChargingRule(*utils.DerivedChargingRule) error
+	RemDerivedChargingRule(string) error
 }
 
+// Returned by CdrStorage.SetCdr when the CDR was already stored
+var ErrDuplicateCdr = errors.New(utils.ERR_DUPLICATE)
+
 type CdrStorage interface {
 	Storage
 	SetCdr(utils.RawCDR) error
+	UpdateCdr(utils.RawCDR) error
 	SetRatedCdr(*utils.StoredCdr, string) error
 	GetStoredCdrs(time.Time, time.Time, bool, bool) ([]*utils.StoredCdr, error)
 	RemStoredCdrs([]string) error
+	GetCdrs(*utils.CdrsFilter) ([]*utils.StoredCdr, int, error)
+	SetCdrsExported(string, []*utils.StoredCdr) error
+	SetInvoice(string, *StoredInvoice, func(*StoredInvoice) error) error
+	GetInvoice(string) (*StoredInvoice, error)
+	GetInvoices(string, string, string) ([]*StoredInvoice, error)
+	SetInvoiceAdjustment(*InvoiceAdjustment) error
+	GetInvoiceAdjustments(string, string) ([]*InvoiceAdjustment, error)
 }
 
 type LogStorage interface {
@@ -107,6 +130,9 @@ type LogStorage interface {
 	LogActionTrigger(ubId, source string, at *ActionTrigger, as Actions) error
 	LogActionTiming(source string, at *ActionTiming, as Actions) error
 	GetCallCostLog(uuid, source, runid string) (*CallCost, error)
+	SetSessionState(*SessionState) error
+	RemSessionState(uuid string) error
+	GetSessionStates() ([]*SessionState, error)
 }
 
 type LoadStorage interface {
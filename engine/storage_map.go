@@ -21,6 +21,8 @@ package engine
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 
 	"github.com/cgrates/cgrates/cache2go"
 	"github.com/cgrates/cgrates/utils"
@@ -265,6 +267,34 @@ func (ms *MapStorage) GetAllActionTimings() (ats map[string]ActionPlan, err erro
 	return
 }
 
+func (ms *MapStorage) GetDerivedChargingRules() (dcrs []*utils.DerivedChargingRule, err error) {
+	for key, value := range ms.dict {
+		if !strings.HasPrefix(key, DERIVED_CHARGING_PREFIX) {
+			continue
+		}
+		dcr := new(utils.DerivedChargingRule)
+		if err = ms.ms.Unmarshal(value, dcr); err != nil {
+			return nil, err
+		}
+		dcrs = append(dcrs, dcr)
+	}
+	return
+}
+
+func (ms *MapStorage) SetDerivedChargingRule(dcr *utils.DerivedChargingRule) error {
+	result, err := ms.ms.Marshal(dcr)
+	ms.dict[DERIVED_CHARGING_PREFIX+dcr.Id] = result
+	return err
+}
+
+func (ms *MapStorage) RemDerivedChargingRule(id string) error {
+	if _, hasIt := ms.dict[DERIVED_CHARGING_PREFIX+id]; !hasIt {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	delete(ms.dict, DERIVED_CHARGING_PREFIX+id)
+	return nil
+}
+
 func (ms *MapStorage) LogCallCost(uuid, source, runid string, cc *CallCost) error {
 	result, err := ms.ms.Marshal(cc)
 	ms.dict[LOG_CALL_COST_PREFIX+source+runid+"_"+uuid] = result
@@ -310,3 +340,353 @@ func (ms *MapStorage) LogError(uuid, source, runid, errstr string) (err error) {
 	ms.dict[LOG_ERR+source+runid+"_"+uuid] = []byte(errstr)
 	return nil
 }
+
+func (ms *MapStorage) SetSessionState(ss *SessionState) error {
+	result, err := ms.ms.Marshal(ss)
+	if err != nil {
+		return err
+	}
+	ms.dict[SESSION_STATE_PREFIX+ss.Uuid] = result
+	return nil
+}
+
+func (ms *MapStorage) RemSessionState(uuid string) error {
+	delete(ms.dict, SESSION_STATE_PREFIX+uuid)
+	return nil
+}
+
+func (ms *MapStorage) GetSessionStates() (sss []*SessionState, err error) {
+	for key, value := range ms.dict {
+		if !strings.HasPrefix(key, SESSION_STATE_PREFIX) {
+			continue
+		}
+		ss := new(SessionState)
+		if err = ms.ms.Unmarshal(value, ss); err != nil {
+			return nil, err
+		}
+		sss = append(sss, ss)
+	}
+	return
+}
+
+// Stores the CDR only once, returns ErrDuplicateCdr if the same CDR was already received from the same CdrHost and CdrSource.
+// Returns utils.ERR_EXISTS if the cgrid was stored out of another CdrHost or CdrSource, CDRS stores these under utils.OriginCgrId.
+func (ms *MapStorage) SetCdr(cdr utils.RawCDR) error {
+	if values, hasKey := ms.dict[LOG_CDR+cdr.GetCgrId()]; hasKey {
+		storedCdr := new(utils.StoredCdr)
+		if err := ms.ms.Unmarshal(values, storedCdr); err != nil {
+			return err
+		}
+		if storedCdr.CdrHost != cdr.GetCdrHost() || storedCdr.CdrSource != cdr.GetCdrSource() {
+			return fmt.Errorf("%s:cgrid %s stored out of cdrhost %s, cdrsource %s", utils.ERR_EXISTS, cdr.GetCgrId(), storedCdr.CdrHost, storedCdr.CdrSource)
+		}
+		return ErrDuplicateCdr
+	}
+	return ms.putCdr(cdr)
+}
+
+// Overwrites the fields of the CDR stored under the same cgrid, returns utils.ERR_NOT_FOUND if there is none
+func (ms *MapStorage) UpdateCdr(cdr utils.RawCDR) error {
+	if _, hasKey := ms.dict[LOG_CDR+cdr.GetCgrId()]; !hasKey {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	return ms.putCdr(cdr)
+}
+
+func (ms *MapStorage) putCdr(cdr utils.RawCDR) error {
+	answerTime, _ := cdr.GetAnswerTime() // Ignore errors, we want to store the cdr no matter what
+	result, err := ms.ms.Marshal(&utils.StoredCdr{CgrId: cdr.GetCgrId(), AccId: cdr.GetAccId(), CdrHost: cdr.GetCdrHost(), CdrSource: cdr.GetCdrSource(),
+		ReqType: cdr.GetReqType(), Direction: cdr.GetDirection(), Tenant: cdr.GetTenant(), TOR: cdr.GetTOR(), Account: cdr.GetAccount(),
+		Subject: cdr.GetSubject(), Destination: cdr.GetDestination(), AnswerTime: answerTime, Duration: cdr.GetDuration(), ExtraFields: cdr.GetExtraFields()})
+	ms.dict[LOG_CDR+cdr.GetCgrId()] = result
+	return err
+}
+
+func (ms *MapStorage) SetRatedCdr(storedCdr *utils.StoredCdr, extraInfo string) error {
+	result, err := ms.ms.Marshal(storedCdr)
+	ms.dict[LOG_MEDIATED_CDR+storedCdr.MediationRunId+"_"+storedCdr.CgrId] = result
+	if extraInfo == utils.COST_PENDING {
+		ms.dict[LOG_COST_PENDING_CDR+storedCdr.MediationRunId+"_"+storedCdr.CgrId] = []byte(extraInfo)
+	} else {
+		delete(ms.dict, LOG_COST_PENDING_CDR+storedCdr.MediationRunId+"_"+storedCdr.CgrId)
+	}
+	return err
+}
+
+// Joins the stored CDRs with their mediation results, one CDR for each mediation run, not mediated ones with empty MediationRunId
+func (ms *MapStorage) getJoinedCdrs() ([]*utils.StoredCdr, error) {
+	rated := make(map[string][]*utils.StoredCdr)
+	for key, value := range ms.dict {
+		if !strings.HasPrefix(key, LOG_MEDIATED_CDR) {
+			continue
+		}
+		ratedCdr := new(utils.StoredCdr)
+		if err := ms.ms.Unmarshal(value, ratedCdr); err != nil {
+			return nil, err
+		}
+		rated[ratedCdr.CgrId] = append(rated[ratedCdr.CgrId], ratedCdr)
+	}
+	var cdrs []*utils.StoredCdr
+	for key, value := range ms.dict {
+		if !strings.HasPrefix(key, LOG_CDR) {
+			continue
+		}
+		storedCdr := new(utils.StoredCdr)
+		if err := ms.ms.Unmarshal(value, storedCdr); err != nil {
+			return nil, err
+		}
+		if _, hasKey := rated[storedCdr.CgrId]; !hasKey {
+			cdrs = append(cdrs, storedCdr)
+			continue
+		}
+		for _, ratedCdr := range rated[storedCdr.CgrId] {
+			joinedCdr := *storedCdr
+			joinedCdr.MediationRunId = ratedCdr.MediationRunId
+			joinedCdr.Cost = ratedCdr.Cost
+			if ratedCdr.MediationRunId == utils.CORRELATED_RUNID { // Combination fields kept with the run
+				joinedCdr.ExtraFields = make(map[string]string, len(storedCdr.ExtraFields)+len(utils.CorrelationFields))
+				for fld, val := range storedCdr.ExtraFields {
+					joinedCdr.ExtraFields[fld] = val
+				}
+				for _, fld := range utils.CorrelationFields {
+					joinedCdr.ExtraFields[fld] = ratedCdr.ExtraFields[fld]
+				}
+			}
+			cdrs = append(cdrs, &joinedCdr)
+		}
+	}
+	return cdrs, nil
+}
+
+func (ms *MapStorage) GetStoredCdrs(timeStart, timeEnd time.Time, ignoreErr, ignoreRated bool) ([]*utils.StoredCdr, error) {
+	joinedCdrs, err := ms.getJoinedCdrs()
+	if err != nil {
+		return nil, err
+	}
+	fltr := &utils.CdrsFilter{AnswerTimeStart: timeStart, AnswerTimeEnd: timeEnd}
+	var cdrs []*utils.StoredCdr
+	for _, cdr := range joinedCdrs {
+		if !fltr.Matches(cdr) {
+			continue
+		}
+		if (ignoreErr || ignoreRated) && (len(cdr.MediationRunId) == 0 || cdr.MediationRunId == utils.CORRELATED_RUNID) { // No cost to compare or combined out of legs
+			continue
+		}
+		if (ignoreErr && cdr.Cost <= -1) || (ignoreRated && cdr.Cost > 0) {
+			continue
+		}
+		cdrs = append(cdrs, cdr)
+	}
+	return cdrs, nil
+}
+
+func (ms *MapStorage) RemStoredCdrs(cgrIds []string) error {
+	for key := range ms.dict {
+		for _, cgrId := range cgrIds {
+			if key == LOG_CDR+cgrId || ((strings.HasPrefix(key, LOG_MEDIATED_CDR) || strings.HasPrefix(key, LOG_EXPORTED_CDR) || strings.HasPrefix(key, LOG_COST_PENDING_CDR)) && strings.HasSuffix(key, "_"+cgrId)) {
+				delete(ms.dict, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the CDRs matching the filters, one for each mediation run, or only their number in count mode
+func (ms *MapStorage) GetCdrs(fltr *utils.CdrsFilter) ([]*utils.StoredCdr, int, error) {
+	joinedCdrs, err := ms.getJoinedCdrs()
+	if err != nil {
+		return nil, 0, err
+	}
+	var cdrs []*utils.StoredCdr
+	for _, cdr := range joinedCdrs {
+		if !fltr.Matches(cdr) {
+			continue
+		}
+		if len(fltr.NotExportedBy) != 0 {
+			if _, exported := ms.dict[LOG_EXPORTED_CDR+fltr.NotExportedBy+"_"+cdr.MediationRunId+"_"+cdr.CgrId]; exported {
+				continue
+			}
+		}
+		if fltr.CostPending {
+			if _, pending := ms.dict[LOG_COST_PENDING_CDR+cdr.MediationRunId+"_"+cdr.CgrId]; !pending {
+				continue
+			}
+		}
+		cdrs = append(cdrs, cdr)
+	}
+	if fltr.Count {
+		return nil, len(cdrs), nil
+	}
+	orderBy := fltr.OrderBy
+	if len(orderBy) == 0 {
+		orderBy = utils.ANSWER_TIME
+	}
+	sort.Stable(&storedCdrsSorter{cdrs: cdrs, orderBy: orderBy, descending: fltr.OrderDescending})
+	if fltr.Offset >= len(cdrs) {
+		return []*utils.StoredCdr{}, 0, nil
+	}
+	cdrs = cdrs[fltr.Offset:]
+	if fltr.Limit > 0 && fltr.Limit < len(cdrs) {
+		cdrs = cdrs[:fltr.Limit]
+	}
+	return cdrs, len(cdrs), nil
+}
+
+// Marks the CDRs as exported by the export job, one mark for each mediation run
+func (ms *MapStorage) SetCdrsExported(exportId string, cdrs []*utils.StoredCdr) error {
+	exportTime := []byte(time.Now().Format(time.RFC3339))
+	for _, cdr := range cdrs {
+		ms.dict[LOG_EXPORTED_CDR+exportId+"_"+cdr.MediationRunId+"_"+cdr.CgrId] = exportTime
+	}
+	return nil
+}
+
+// Numbers the invoice out of the sequence of its prefix and stores it, consuming its adjustments.
+// The encode function fills the content of the invoice once numbered, nothing is stored if it fails.
+// Returns utils.ERR_EXISTS if the account was already invoiced for the same period of the cycle.
+func (ms *MapStorage) SetInvoice(numberPrefix string, inv *StoredInvoice, encode func(*StoredInvoice) error) error {
+	invs, err := ms.GetInvoices(inv.Tenant, inv.Account, inv.CycleId)
+	if err != nil {
+		return err
+	}
+	for _, stored := range invs {
+		if stored.PeriodStart.Equal(inv.PeriodStart) {
+			return fmt.Errorf("%s:%s", utils.ERR_EXISTS, stored.Number)
+		}
+	}
+	for _, adjId := range inv.AdjustmentIds {
+		if _, hasAdj := ms.dict[INVOICE_ADJUSTMENT_PREFIX+inv.Tenant+"_"+inv.Account+"_"+strconv.FormatInt(adjId, 10)]; !hasAdj {
+			return fmt.Errorf("Adjustments of %s:%s consumed meanwhile", inv.Tenant, inv.Account)
+		}
+	}
+	seq, _ := strconv.ParseInt(string(ms.dict[INVOICE_SEQUENCE_PREFIX+numberPrefix]), 10, 64)
+	seq += 1
+	inv.Number = fmt.Sprintf("%s%0*d", numberPrefix, utils.INVOICE_NUMBER_DIGITS, seq)
+	if err := encode(inv); err != nil {
+		return err
+	}
+	result, err := ms.ms.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	ms.dict[INVOICE_PREFIX+inv.Number] = result
+	ms.dict[INVOICE_SEQUENCE_PREFIX+numberPrefix] = []byte(strconv.FormatInt(seq, 10))
+	for _, adjId := range inv.AdjustmentIds {
+		delete(ms.dict, INVOICE_ADJUSTMENT_PREFIX+inv.Tenant+"_"+inv.Account+"_"+strconv.FormatInt(adjId, 10))
+	}
+	return nil
+}
+
+func (ms *MapStorage) GetInvoice(number string) (*StoredInvoice, error) {
+	values, hasKey := ms.dict[INVOICE_PREFIX+number]
+	if !hasKey {
+		return nil, fmt.Errorf("%s:%s", utils.ERR_NOT_FOUND, number)
+	}
+	inv := new(StoredInvoice)
+	if err := ms.ms.Unmarshal(values, inv); err != nil {
+		return nil, err
+	}
+	inv.AdjustmentIds = nil
+	return inv, nil
+}
+
+// Lists the invoices sorted on number, filtered on tenant, account and cycle when not empty, without their content
+func (ms *MapStorage) GetInvoices(tenant, account, cycleId string) ([]*StoredInvoice, error) {
+	invs := make([]*StoredInvoice, 0)
+	for key, values := range ms.dict {
+		if !strings.HasPrefix(key, INVOICE_PREFIX) {
+			continue
+		}
+		inv := new(StoredInvoice)
+		if err := ms.ms.Unmarshal(values, inv); err != nil {
+			return nil, err
+		}
+		if (len(tenant) != 0 && inv.Tenant != tenant) || (len(account) != 0 && inv.Account != account) || (len(cycleId) != 0 && inv.CycleId != cycleId) {
+			continue
+		}
+		inv.Content, inv.Rendered, inv.AdjustmentIds = nil, nil, nil
+		invs = append(invs, inv)
+	}
+	sort.Sort(storedInvoicesByNumber(invs))
+	return invs, nil
+}
+
+type storedInvoicesByNumber []*StoredInvoice
+
+func (invs storedInvoicesByNumber) Len() int           { return len(invs) }
+func (invs storedInvoicesByNumber) Swap(i, j int)      { invs[i], invs[j] = invs[j], invs[i] }
+func (invs storedInvoicesByNumber) Less(i, j int) bool { return invs[i].Number < invs[j].Number }
+
+// Stores the adjustment for the next invoice of its account, setting its Id
+func (ms *MapStorage) SetInvoiceAdjustment(adj *InvoiceAdjustment) error {
+	lastId, _ := strconv.ParseInt(string(ms.dict[INVOICE_ADJUSTMENT_PREFIX]), 10, 64)
+	adj.Id = lastId + 1
+	result, err := ms.ms.Marshal(adj)
+	if err != nil {
+		return err
+	}
+	ms.dict[INVOICE_ADJUSTMENT_PREFIX] = []byte(strconv.FormatInt(adj.Id, 10))
+	ms.dict[INVOICE_ADJUSTMENT_PREFIX+adj.Tenant+"_"+adj.Account+"_"+strconv.FormatInt(adj.Id, 10)] = result
+	return nil
+}
+
+// Returns the adjustments not yet invoiced for the account, in the order they were added
+func (ms *MapStorage) GetInvoiceAdjustments(tenant, account string) ([]*InvoiceAdjustment, error) {
+	adjs := make([]*InvoiceAdjustment, 0)
+	for key, values := range ms.dict {
+		if !strings.HasPrefix(key, INVOICE_ADJUSTMENT_PREFIX+tenant+"_"+account+"_") {
+			continue
+		}
+		adj := new(InvoiceAdjustment)
+		if err := ms.ms.Unmarshal(values, adj); err != nil {
+			return nil, err
+		}
+		if adj.Tenant != tenant || adj.Account != account { // Prefix shared with other accounts
+			continue
+		}
+		adjs = append(adjs, adj)
+	}
+	sort.Sort(invoiceAdjustmentsById(adjs))
+	return adjs, nil
+}
+
+type invoiceAdjustmentsById []*InvoiceAdjustment
+
+func (adjs invoiceAdjustmentsById) Len() int           { return len(adjs) }
+func (adjs invoiceAdjustmentsById) Swap(i, j int)      { adjs[i], adjs[j] = adjs[j], adjs[i] }
+func (adjs invoiceAdjustmentsById) Less(i, j int) bool { return adjs[i].Id < adjs[j].Id }
+
+// Sorts the CDRs on one of the utils.CdrsOrderFields
+type storedCdrsSorter struct {
+	cdrs       []*utils.StoredCdr
+	orderBy    string
+	descending bool
+}
+
+func (sorter *storedCdrsSorter) Len() int {
+	return len(sorter.cdrs)
+}
+
+func (sorter *storedCdrsSorter) Swap(i, j int) {
+	sorter.cdrs[i], sorter.cdrs[j] = sorter.cdrs[j], sorter.cdrs[i]
+}
+
+func (sorter *storedCdrsSorter) Less(i, j int) bool {
+	if sorter.descending {
+		i, j = j, i
+	}
+	cdrI, cdrJ := sorter.cdrs[i], sorter.cdrs[j]
+	switch sorter.orderBy {
+	case utils.ANSWER_TIME:
+		return cdrI.AnswerTime.Before(cdrJ.AnswerTime)
+	case utils.DURATION:
+		return cdrI.Duration < cdrJ.Duration
+	case utils.COST:
+		return cdrI.Cost < cdrJ.Cost
+	}
+	fldVals := func(cdr *utils.StoredCdr) map[string]string {
+		return map[string]string{utils.CGRID: cdr.CgrId, utils.ACCID: cdr.AccId, utils.CDRSOURCE: cdr.CdrSource, utils.REQTYPE: cdr.ReqType,
+			utils.TENANT: cdr.Tenant, utils.ACCOUNT: cdr.Account, utils.SUBJECT: cdr.Subject, utils.DESTINATION: cdr.Destination, utils.MEDI_RUNID: cdr.MediationRunId}
+	}
+	return fldVals(cdrI)[sorter.orderBy] < fldVals(cdrJ)[sorter.orderBy]
+}
@@ -354,6 +354,42 @@ func (rs *RedisStorage) GetAllActionTimings() (ats map[string]ActionPlan, err er
 	return
 }
 
+func (rs *RedisStorage) GetDerivedChargingRules() (dcrs []*utils.DerivedChargingRule, err error) {
+	keys, err := rs.db.Keys(DERIVED_CHARGING_PREFIX + "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		values, err := rs.db.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		dcr := new(utils.DerivedChargingRule)
+		if err = rs.ms.Unmarshal(values, dcr); err != nil {
+			return nil, err
+		}
+		dcrs = append(dcrs, dcr)
+	}
+	return
+}
+
+func (rs *RedisStorage) SetDerivedChargingRule(dcr *utils.DerivedChargingRule) (err error) {
+	result, err := rs.ms.Marshal(dcr)
+	if err != nil {
+		return err
+	}
+	return rs.db.Set(DERIVED_CHARGING_PREFIX+dcr.Id, result)
+}
+
+func (rs *RedisStorage) RemDerivedChargingRule(id string) error {
+	if removed, err := rs.db.Del(DERIVED_CHARGING_PREFIX + id); err != nil {
+		return err
+	} else if !removed {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	return nil
+}
+
 func (rs *RedisStorage) LogCallCost(uuid, source, runid string, cc *CallCost) (err error) {
 	var result []byte
 	result, err = rs.ms.Marshal(cc)
@@ -402,3 +438,36 @@ func (rs *RedisStorage) LogError(uuid, source, runid, errstr string) (err error)
 	err = rs.db.Set(LOG_ERR+source+runid+"_"+uuid, []byte(errstr))
 	return
 }
+
+func (rs *RedisStorage) SetSessionState(ss *SessionState) (err error) {
+	var result []byte
+	if result, err = rs.ms.Marshal(ss); err != nil {
+		return
+	}
+	err = rs.db.Set(SESSION_STATE_PREFIX+ss.Uuid, result)
+	return
+}
+
+func (rs *RedisStorage) RemSessionState(uuid string) (err error) {
+	_, err = rs.db.Del(SESSION_STATE_PREFIX + uuid)
+	return
+}
+
+func (rs *RedisStorage) GetSessionStates() (sss []*SessionState, err error) {
+	keys, err := rs.db.Keys(SESSION_STATE_PREFIX + "*")
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		values, err := rs.db.Get(key)
+		if err != nil {
+			continue
+		}
+		ss := new(SessionState)
+		if err = rs.ms.Unmarshal(values, ss); err != nil {
+			return nil, err
+		}
+		sss = append(sss, ss)
+	}
+	return
+}
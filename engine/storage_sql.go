@@ -22,6 +22,7 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"strings"
@@ -687,11 +688,52 @@ func (self *SQLStorage) LogActionTiming(source string, at *ActionTiming, as Acti
 }
 func (self *SQLStorage) LogError(uuid, source, runid, errstr string) (err error) { return }
 
+func (self *SQLStorage) SetSessionState(ss *SessionState) (err error) {
+	state, err := json.Marshal(ss)
+	if err != nil {
+		return err
+	}
+	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (uuid,state,update_time) VALUES (?,?,now()) ON DUPLICATE KEY UPDATE state=values(state),update_time=now()",
+		utils.TBL_SESSION_STATES), ss.Uuid, state)
+	if err != nil {
+		Logger.Err(fmt.Sprintf("failed to execute session state insert statement: %s", err.Error()))
+	}
+	return
+}
+
+func (self *SQLStorage) RemSessionState(uuid string) (err error) {
+	_, err = self.Db.Exec(fmt.Sprintf("DELETE FROM %s WHERE uuid=?", utils.TBL_SESSION_STATES), uuid)
+	return
+}
+
+func (self *SQLStorage) GetSessionStates() ([]*SessionState, error) {
+	var sss []*SessionState
+	rows, err := self.Db.Query(fmt.Sprintf("SELECT state FROM %s", utils.TBL_SESSION_STATES))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var state []byte
+		if err := rows.Scan(&state); err != nil {
+			return nil, err
+		}
+		ss := new(SessionState)
+		if err := json.Unmarshal(state, ss); err != nil {
+			return nil, err
+		}
+		sss = append(sss, ss)
+	}
+	return sss, nil
+}
+
+// Stores the CDR only once, returns ErrDuplicateCdr if the same CDR was already received from the same CdrHost and CdrSource.
+// Returns utils.ERR_EXISTS if the cgrid was stored out of another CdrHost or CdrSource, CDRS stores these under utils.OriginCgrId.
 func (self *SQLStorage) SetCdr(cdr utils.RawCDR) (err error) {
 	// map[account:1001 direction:out orig_ip:172.16.1.1 tor:call accid:accid23 answer_time:2013-02-03 19:54:00 cdrsource:freeswitch_csv destination:+4986517174963 duration:62 reqtype:prepaid subject:1001 supplier:supplier1 tenant:cgrates.org]
 	startTime, _ := cdr.GetAnswerTime() // Ignore errors, we want to store the cdr no matter what
-	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s VALUES (NULL,'%s','%s','%s','%s','%s','%s','%s','%s','%s','%s','%s','%s', %d)",
-		utils.TBL_CDRS_PRIMARY,
+	res, err := self.Db.Exec(fmt.Sprintf("INSERT INTO %s VALUES (NULL,?,?,?,?,?,?,?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE id=id",
+		utils.TBL_CDRS_PRIMARY),
 		cdr.GetCgrId(),
 		cdr.GetAccId(),
 		cdr.GetCdrHost(),
@@ -704,20 +746,32 @@ func (self *SQLStorage) SetCdr(cdr utils.RawCDR) (err error) {
 		cdr.GetSubject(),
 		cdr.GetDestination(),
 		startTime,
-		cdr.GetDuration(),
-	))
+		int64(cdr.GetDuration()),
+	)
 	if err != nil {
 		Logger.Err(fmt.Sprintf("failed to execute cdr insert statement: %v", err))
+		return err
+	}
+	if inserted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if inserted == 0 { // Same cgrid already stored
+		var cdrHost, cdrSource string
+		if err := self.Db.QueryRow(fmt.Sprintf("SELECT cdrhost,cdrsource FROM %s WHERE cgrid=?", utils.TBL_CDRS_PRIMARY), cdr.GetCgrId()).Scan(&cdrHost, &cdrSource); err != nil {
+			return err
+		}
+		if cdrHost != cdr.GetCdrHost() || cdrSource != cdr.GetCdrSource() {
+			return fmt.Errorf("%s:cgrid %s stored out of cdrhost %s, cdrsource %s", utils.ERR_EXISTS, cdr.GetCgrId(), cdrHost, cdrSource)
+		}
+		return ErrDuplicateCdr
 	}
 	extraFields, err := json.Marshal(cdr.GetExtraFields())
 	if err != nil {
 		Logger.Err(fmt.Sprintf("Error marshalling cdr extra fields to json: %v", err))
 	}
-	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s VALUES ('NULL','%s', '%s')",
-		utils.TBL_CDRS_EXTRA,
+	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s VALUES (NULL,?,?)", utils.TBL_CDRS_EXTRA),
 		cdr.GetCgrId(),
-		extraFields,
-	))
+		string(extraFields),
+	)
 	if err != nil {
 		Logger.Err(fmt.Sprintf("failed to execute cdr insert statement: %v", err))
 	}
@@ -725,14 +779,63 @@ func (self *SQLStorage) SetCdr(cdr utils.RawCDR) (err error) {
 	return
 }
 
+// Overwrites the fields of the CDR stored under the same cgrid, returns utils.ERR_NOT_FOUND if there is none
+func (self *SQLStorage) UpdateCdr(cdr utils.RawCDR) error {
+	var id int64
+	if err := self.Db.QueryRow(fmt.Sprintf("SELECT id FROM %s WHERE cgrid=?", utils.TBL_CDRS_PRIMARY), cdr.GetCgrId()).Scan(&id); err == sql.ErrNoRows {
+		return errors.New(utils.ERR_NOT_FOUND)
+	} else if err != nil {
+		return err
+	}
+	startTime, _ := cdr.GetAnswerTime()
+	if _, err := self.Db.Exec(fmt.Sprintf("UPDATE %s SET reqtype=?,direction=?,tenant=?,tor=?,account=?,subject=?,destination=?,answer_time=?,duration=? WHERE id=?",
+		utils.TBL_CDRS_PRIMARY),
+		cdr.GetReqType(),
+		cdr.GetDirection(),
+		cdr.GetTenant(),
+		cdr.GetTOR(),
+		cdr.GetAccount(),
+		cdr.GetSubject(),
+		cdr.GetDestination(),
+		startTime,
+		int64(cdr.GetDuration()),
+		id,
+	); err != nil {
+		return err
+	}
+	extraFields, err := json.Marshal(cdr.GetExtraFields())
+	if err != nil {
+		return err
+	}
+	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid,extra_fields) VALUES (?,?) ON DUPLICATE KEY UPDATE extra_fields=values(extra_fields)", utils.TBL_CDRS_EXTRA),
+		cdr.GetCgrId(),
+		string(extraFields),
+	)
+	return err
+}
+
+// The *correlated run keeps the combination fields of the legs in their own column, read back into its extra fields
 func (self *SQLStorage) SetRatedCdr(storedCdr *utils.StoredCdr, extraInfo string) (err error) {
-	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid,runid,subject,cost,mediation_time,extra_info) VALUES ('%s','%s','%s',%f,now(),'%s') ON DUPLICATE KEY UPDATE subject=values(subject),cost=values(cost),extra_info=values(extra_info)",
-		utils.TBL_RATED_CDRS,
+	var correlationFields sql.NullString
+	if storedCdr.MediationRunId == utils.CORRELATED_RUNID {
+		combinationFields := make(map[string]string, len(utils.CorrelationFields))
+		for _, fld := range utils.CorrelationFields {
+			combinationFields[fld] = storedCdr.ExtraFields[fld]
+		}
+		content, err := json.Marshal(combinationFields)
+		if err != nil {
+			return err
+		}
+		correlationFields = sql.NullString{String: string(content), Valid: true}
+	}
+	_, err = self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid,runid,subject,cost,mediation_time,extra_info,correlation_fields) VALUES (?,?,?,?,now(),?,?) ON DUPLICATE KEY UPDATE subject=values(subject),cost=values(cost),extra_info=values(extra_info),correlation_fields=values(correlation_fields)",
+		utils.TBL_RATED_CDRS),
 		storedCdr.CgrId,
 		storedCdr.MediationRunId,
 		storedCdr.Subject,
 		storedCdr.Cost,
-		extraInfo))
+		extraInfo,
+		correlationFields)
 	if err != nil {
 		Logger.Err(fmt.Sprintf("failed to execute cdr insert statement: %s", err.Error()))
 	}
@@ -741,8 +844,7 @@ func (self *SQLStorage) SetRatedCdr(storedCdr *utils.StoredCdr, extraInfo string
 
 // Return a slice of CDRs from storDb using optional filters.
 func (self *SQLStorage) GetStoredCdrs(timeStart, timeEnd time.Time, ignoreErr, ignoreRated bool) ([]*utils.StoredCdr, error) {
-	var cdrs []*utils.StoredCdr
-	q := fmt.Sprintf("SELECT %s.cgrid,accid,cdrhost,cdrsource,reqtype,direction,tenant,tor,account,%s.subject,destination,answer_time,duration,extra_fields,runid,cost FROM %s LEFT JOIN %s ON %s.cgrid=%s.cgrid LEFT JOIN %s ON %s.cgrid=%s.cgrid", utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_EXTRA, utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_EXTRA, utils.TBL_RATED_CDRS, utils.TBL_CDRS_PRIMARY, utils.TBL_RATED_CDRS)
+	q := fmt.Sprintf("SELECT %s.cgrid,accid,cdrhost,cdrsource,reqtype,direction,tenant,tor,account,%s.subject,destination,answer_time,duration,extra_fields,runid,cost,correlation_fields FROM %s LEFT JOIN %s ON %s.cgrid=%s.cgrid LEFT JOIN %s ON %s.cgrid=%s.cgrid", utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_EXTRA, utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_EXTRA, utils.TBL_RATED_CDRS, utils.TBL_CDRS_PRIMARY, utils.TBL_RATED_CDRS)
 	fltr := ""
 	if !timeStart.IsZero() {
 		if len(fltr) != 0 {
@@ -756,6 +858,12 @@ func (self *SQLStorage) GetStoredCdrs(timeStart, timeEnd time.Time, ignoreErr, i
 		}
 		fltr += fmt.Sprintf(" answer_time<'%d'", timeEnd)
 	}
+	if ignoreErr || ignoreRated { // Combined legs are re-mediated out of their legs
+		if len(fltr) != 0 {
+			fltr += " AND "
+		}
+		fltr += fmt.Sprintf("runid!='%s'", utils.CORRELATED_RUNID)
+	}
 	if ignoreErr {
 		if len(fltr) != 0 {
 			fltr += " AND "
@@ -776,6 +884,13 @@ func (self *SQLStorage) GetStoredCdrs(timeStart, timeEnd time.Time, ignoreErr, i
 		return nil, err
 	}
 	defer rows.Close()
+	return scanStoredCdrs(rows)
+}
+
+// Builds StoredCdrs out of rows selecting cgrid,accid,cdrhost,cdrsource,reqtype,direction,tenant,tor,account,subject,destination,answer_time,duration,extra_fields,runid,cost,correlation_fields.
+// The *correlated run gets its combination fields merged into the extra fields.
+func scanStoredCdrs(rows *sql.Rows) ([]*utils.StoredCdr, error) {
+	var cdrs []*utils.StoredCdr
 	for rows.Next() {
 		var cgrid, accid, cdrhost, cdrsrc, reqtype, direction, tenant, tor, account, subject, destination string
 		var extraFields []byte
@@ -783,14 +898,20 @@ func (self *SQLStorage) GetStoredCdrs(timeStart, timeEnd time.Time, ignoreErr, i
 		var duration int64
 		var runid sql.NullString // So we can export unmediated CDRs
 		var cost sql.NullFloat64 // So we can export unmediated CDRs
+		var correlationFields sql.NullString
 		var extraFieldsMp map[string]string
 		if err := rows.Scan(&cgrid, &accid, &cdrhost, &cdrsrc, &reqtype, &direction, &tenant, &tor, &account, &subject, &destination, &answerTime, &duration,
-			&extraFields, &runid, &cost); err != nil {
+			&extraFields, &runid, &cost, &correlationFields); err != nil {
 			return nil, err
 		}
 		if err := json.Unmarshal(extraFields, &extraFieldsMp); err != nil {
 			return nil, err
 		}
+		if correlationFields.Valid {
+			if err := json.Unmarshal([]byte(correlationFields.String), &extraFieldsMp); err != nil {
+				return nil, err
+			}
+		}
 		storCdr := &utils.StoredCdr{
 			CgrId: cgrid, AccId: accid, CdrHost: cdrhost, CdrSource: cdrsrc, ReqType: reqtype, Direction: direction, Tenant: tenant,
 			TOR: tor, Account: account, Subject: subject, Destination: destination, AnswerTime: answerTime, Duration: time.Duration(duration),
@@ -798,7 +919,136 @@ func (self *SQLStorage) GetStoredCdrs(timeStart, timeEnd time.Time, ignoreErr, i
 		}
 		cdrs = append(cdrs, storCdr)
 	}
-	return cdrs, nil
+	return cdrs, rows.Err()
+}
+
+// Returns the CDRs matching the filters, one for each mediation run, or only their number in count mode
+func (self *SQLStorage) GetCdrs(fltr *utils.CdrsFilter) ([]*utils.StoredCdr, int, error) {
+	var conds []string
+	var args []interface{}
+	for _, fltrIn := range []struct {
+		column string
+		vals   []string
+	}{
+		{utils.TBL_CDRS_PRIMARY + ".cgrid", fltr.CgrIds},
+		{"accid", fltr.AccIds},
+		{"tenant", fltr.Tenants},
+		{"account", fltr.Accounts},
+		{utils.TBL_CDRS_PRIMARY + ".subject", fltr.Subjects},
+		{"reqtype", fltr.ReqTypes},
+		{"runid", fltr.MediationRunIds},
+		{"cdrsource", fltr.CdrSources},
+	} {
+		if len(fltrIn.vals) == 0 {
+			continue
+		}
+		conds = append(conds, fmt.Sprintf("%s IN (%s)", fltrIn.column, strings.TrimSuffix(strings.Repeat("?,", len(fltrIn.vals)), ",")))
+		for _, val := range fltrIn.vals {
+			args = append(args, val)
+		}
+	}
+	if len(fltr.DestinationPrefixes) != 0 {
+		likes := make([]string, len(fltr.DestinationPrefixes))
+		for idx, prefix := range fltr.DestinationPrefixes {
+			likes[idx] = "destination LIKE ?"
+			args = append(args, prefix+"%")
+		}
+		conds = append(conds, "("+strings.Join(likes, " OR ")+")")
+	}
+	for _, fltrRange := range []struct {
+		cond  string
+		isSet bool
+		val   interface{}
+	}{
+		{"answer_time>=?", !fltr.AnswerTimeStart.IsZero(), fltr.AnswerTimeStart},
+		{"answer_time<?", !fltr.AnswerTimeEnd.IsZero(), fltr.AnswerTimeEnd},
+		{"duration>=?", fltr.MinDuration != 0, int64(fltr.MinDuration)},
+		{"duration<?", fltr.MaxDuration != 0, int64(fltr.MaxDuration)},
+	} {
+		if fltrRange.isSet {
+			conds = append(conds, fltrRange.cond)
+			args = append(args, fltrRange.val)
+		}
+	}
+	if fltr.MinCost != nil {
+		conds = append(conds, "cost>=?")
+		args = append(args, *fltr.MinCost)
+	}
+	if fltr.MaxCost != nil {
+		conds = append(conds, "cost<?")
+		args = append(args, *fltr.MaxCost)
+	}
+	if len(fltr.NotExportedBy) != 0 {
+		conds = append(conds, fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s.cgrid=%s.cgrid AND %s.runid=IFNULL(%s.runid,'') AND export_id=?)",
+			utils.TBL_CDRS_EXPORTED, utils.TBL_CDRS_EXPORTED, utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_EXPORTED, utils.TBL_RATED_CDRS))
+		args = append(args, fltr.NotExportedBy)
+	}
+	if fltr.CostPending {
+		conds = append(conds, "extra_info=?")
+		args = append(args, utils.COST_PENDING)
+	}
+	from := fmt.Sprintf("%s LEFT JOIN %s ON %s.cgrid=%s.cgrid LEFT JOIN %s ON %s.cgrid=%s.cgrid", utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_EXTRA,
+		utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_EXTRA, utils.TBL_RATED_CDRS, utils.TBL_CDRS_PRIMARY, utils.TBL_RATED_CDRS)
+	if len(conds) != 0 {
+		from += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if fltr.Count {
+		var cnt int
+		if err := self.Db.QueryRow("SELECT COUNT(*) FROM "+from, args...).Scan(&cnt); err != nil {
+			return nil, 0, err
+		}
+		return nil, cnt, nil
+	}
+	orderColumn := utils.ANSWER_TIME
+	switch fltr.OrderBy {
+	case "":
+	case utils.CGRID, utils.SUBJECT:
+		orderColumn = utils.TBL_CDRS_PRIMARY + "." + fltr.OrderBy
+	case utils.MEDI_RUNID:
+		orderColumn = "runid"
+	default:
+		if !utils.IsSliceMember(utils.CdrsOrderFields, fltr.OrderBy) { // Never build the query out of unchecked input
+			return nil, 0, fmt.Errorf("Unsupported OrderBy field: %s", fltr.OrderBy)
+		}
+		orderColumn = fltr.OrderBy
+	}
+	q := fmt.Sprintf("SELECT %s.cgrid,accid,cdrhost,cdrsource,reqtype,direction,tenant,tor,account,%s.subject,destination,answer_time,duration,extra_fields,runid,cost,correlation_fields FROM %s ORDER BY %s",
+		utils.TBL_CDRS_PRIMARY, utils.TBL_CDRS_PRIMARY, from, orderColumn)
+	if fltr.OrderDescending {
+		q += " DESC"
+	}
+	if fltr.Limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", fltr.Limit)
+	} else if fltr.Offset > 0 {
+		q += " LIMIT 18446744073709551615" // MySQL accepts OFFSET only together with LIMIT
+	}
+	if fltr.Offset > 0 {
+		q += fmt.Sprintf(" OFFSET %d", fltr.Offset)
+	}
+	rows, err := self.Db.Query(q, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	cdrs, err := scanStoredCdrs(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return cdrs, len(cdrs), nil
+}
+
+// Marks the CDRs as exported by the export job, one mark for each mediation run
+func (self *SQLStorage) SetCdrsExported(exportId string, cdrs []*utils.StoredCdr) error {
+	if len(cdrs) == 0 {
+		return nil
+	}
+	var args []interface{}
+	for _, cdr := range cdrs {
+		args = append(args, cdr.CgrId, cdr.MediationRunId, exportId)
+	}
+	_, err := self.Db.Exec(fmt.Sprintf("INSERT INTO %s (cgrid,runid,export_id,export_time) VALUES %s ON DUPLICATE KEY UPDATE export_time=values(export_time)",
+		utils.TBL_CDRS_EXPORTED, strings.TrimSuffix(strings.Repeat("(?,?,?,now()),", len(cdrs)), ",")), args...)
+	return err
 }
 
 // Remove CDR data out of all CDR tables based on their cgrid
@@ -810,7 +1060,8 @@ func (self *SQLStorage) RemStoredCdrs(cgrIds []string) error {
 	buffCosts := bytes.NewBufferString(fmt.Sprintf("DELETE FROM %s WHERE", utils.TBL_COST_DETAILS))
 	buffCdrExtra := bytes.NewBufferString(fmt.Sprintf("DELETE FROM %s WHERE", utils.TBL_CDRS_EXTRA))
 	buffCdrPrimary := bytes.NewBufferString(fmt.Sprintf("DELETE FROM %s WHERE", utils.TBL_CDRS_PRIMARY))
-	qryBuffers := []*bytes.Buffer{buffRated, buffCosts, buffCdrExtra, buffCdrPrimary}
+	buffCdrExported := bytes.NewBufferString(fmt.Sprintf("DELETE FROM %s WHERE", utils.TBL_CDRS_EXPORTED))
+	qryBuffers := []*bytes.Buffer{buffRated, buffCosts, buffCdrExtra, buffCdrPrimary, buffCdrExported}
 	for idx, cgrId := range cgrIds {
 		for _, buffer := range qryBuffers {
 			if idx != 0 {
@@ -827,6 +1078,140 @@ func (self *SQLStorage) RemStoredCdrs(cgrIds []string) error {
 	return nil
 }
 
+// Numbers the invoice out of the sequence of its prefix and stores it, consuming its adjustments, in one transaction.
+// The encode function fills the content of the invoice once numbered.
+// Returns utils.ERR_EXISTS if the account was already invoiced for the same period of the cycle.
+func (self *SQLStorage) SetInvoice(numberPrefix string, inv *StoredInvoice, encode func(*StoredInvoice) error) error {
+	tx, err := self.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // No effect once committed
+	var number string
+	err = tx.QueryRow(fmt.Sprintf("SELECT number FROM %s WHERE cycle_id=? AND tenant=? AND account=? AND period_start=?", utils.TBL_INVOICES),
+		inv.CycleId, inv.Tenant, inv.Account, inv.PeriodStart).Scan(&number)
+	if err == nil {
+		return fmt.Errorf("%s:%s", utils.ERR_EXISTS, number)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	// Locks the sequence until commit, so the numbers have no gaps
+	if _, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (number_prefix,last_number) VALUES (?,1) ON DUPLICATE KEY UPDATE last_number=last_number+1", utils.TBL_INVOICE_SEQUENCES),
+		numberPrefix); err != nil {
+		return err
+	}
+	var seq int64
+	if err := tx.QueryRow(fmt.Sprintf("SELECT last_number FROM %s WHERE number_prefix=?", utils.TBL_INVOICE_SEQUENCES), numberPrefix).Scan(&seq); err != nil {
+		return err
+	}
+	inv.Number = fmt.Sprintf("%s%0*d", numberPrefix, utils.INVOICE_NUMBER_DIGITS, seq)
+	if err := encode(inv); err != nil {
+		return err
+	}
+	res, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (number,cycle_id,tenant,account,period_start,period_end,issue_time,total,content,rendered) VALUES (?,?,?,?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE id=id",
+		utils.TBL_INVOICES), inv.Number, inv.CycleId, inv.Tenant, inv.Account, inv.PeriodStart, inv.PeriodEnd, inv.IssueTime, inv.Total, inv.Content, inv.Rendered)
+	if err != nil {
+		return err
+	}
+	if inserted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if inserted == 0 { // Invoiced meanwhile by another engine
+		return fmt.Errorf("%s:%s", utils.ERR_EXISTS, inv.Number)
+	}
+	if len(inv.AdjustmentIds) != 0 {
+		args := []interface{}{inv.Number, inv.Tenant, inv.Account}
+		for _, adjId := range inv.AdjustmentIds {
+			args = append(args, adjId)
+		}
+		res, err := tx.Exec(fmt.Sprintf("UPDATE %s SET invoice_number=? WHERE tenant=? AND account=? AND invoice_number IS NULL AND id IN (%s)",
+			utils.TBL_INVOICE_ADJUSTMENTS, strings.TrimSuffix(strings.Repeat("?,", len(inv.AdjustmentIds)), ",")), args...)
+		if err != nil {
+			return err
+		}
+		if consumed, err := res.RowsAffected(); err != nil {
+			return err
+		} else if consumed != int64(len(inv.AdjustmentIds)) {
+			return fmt.Errorf("Adjustments of %s:%s consumed meanwhile", inv.Tenant, inv.Account)
+		}
+	}
+	return tx.Commit()
+}
+
+func (self *SQLStorage) GetInvoice(number string) (*StoredInvoice, error) {
+	inv := &StoredInvoice{Number: number}
+	err := self.Db.QueryRow(fmt.Sprintf("SELECT cycle_id,tenant,account,period_start,period_end,issue_time,total,content,rendered FROM %s WHERE number=?", utils.TBL_INVOICES),
+		number).Scan(&inv.CycleId, &inv.Tenant, &inv.Account, &inv.PeriodStart, &inv.PeriodEnd, &inv.IssueTime, &inv.Total, &inv.Content, &inv.Rendered)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%s:%s", utils.ERR_NOT_FOUND, number)
+	} else if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// Lists the invoices sorted on number, filtered on tenant, account and cycle when not empty, without their content
+func (self *SQLStorage) GetInvoices(tenant, account, cycleId string) ([]*StoredInvoice, error) {
+	var conds []string
+	var args []interface{}
+	for _, fltr := range []struct {
+		column string
+		val    string
+	}{{"tenant", tenant}, {"account", account}, {"cycle_id", cycleId}} {
+		if len(fltr.val) != 0 {
+			conds = append(conds, fltr.column+"=?")
+			args = append(args, fltr.val)
+		}
+	}
+	q := fmt.Sprintf("SELECT number,cycle_id,tenant,account,period_start,period_end,issue_time,total FROM %s", utils.TBL_INVOICES)
+	if len(conds) != 0 {
+		q += " WHERE " + strings.Join(conds, " AND ")
+	}
+	rows, err := self.Db.Query(q+" ORDER BY number", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	invs := make([]*StoredInvoice, 0)
+	for rows.Next() {
+		inv := new(StoredInvoice)
+		if err := rows.Scan(&inv.Number, &inv.CycleId, &inv.Tenant, &inv.Account, &inv.PeriodStart, &inv.PeriodEnd, &inv.IssueTime, &inv.Total); err != nil {
+			return nil, err
+		}
+		invs = append(invs, inv)
+	}
+	return invs, rows.Err()
+}
+
+// Stores the adjustment for the next invoice of its account, setting its Id
+func (self *SQLStorage) SetInvoiceAdjustment(adj *InvoiceAdjustment) error {
+	res, err := self.Db.Exec(fmt.Sprintf("INSERT INTO %s (tenant,account,description,amount) VALUES (?,?,?,?)", utils.TBL_INVOICE_ADJUSTMENTS),
+		adj.Tenant, adj.Account, adj.Description, adj.Amount)
+	if err != nil {
+		return err
+	}
+	adj.Id, err = res.LastInsertId()
+	return err
+}
+
+// Returns the adjustments not yet invoiced for the account, in the order they were added
+func (self *SQLStorage) GetInvoiceAdjustments(tenant, account string) ([]*InvoiceAdjustment, error) {
+	rows, err := self.Db.Query(fmt.Sprintf("SELECT id,description,amount FROM %s WHERE tenant=? AND account=? AND invoice_number IS NULL ORDER BY id", utils.TBL_INVOICE_ADJUSTMENTS),
+		tenant, account)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	adjs := make([]*InvoiceAdjustment, 0)
+	for rows.Next() {
+		adj := &InvoiceAdjustment{Tenant: tenant, Account: account}
+		if err := rows.Scan(&adj.Id, &adj.Description, &adj.Amount); err != nil {
+			return nil, err
+		}
+		adjs = append(adjs, adj)
+	}
+	return adjs, rows.Err()
+}
+
 func (self *SQLStorage) GetTpDestinations(tpid, tag string) ([]*Destination, error) {
 	var dests []*Destination
 	q := fmt.Sprintf("SELECT * FROM %s WHERE tpid='%s'", utils.TBL_TP_DESTINATIONS, tpid)
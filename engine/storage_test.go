@@ -107,14 +107,40 @@ func TestCacheRefresh(t *testing.T) {
 	}
 }
 
+func TestStorageSessionStates(t *testing.T) {
+	ms, _ := NewMapStorage()
+	cd := &CallDescriptor{Direction: OUTBOUND, Tenant: "vdf", Subject: "rif", Account: "rif", Destination: "0256",
+		TimeStart: time.Date(2013, 10, 21, 18, 34, 0, 0, time.UTC), TimeEnd: time.Date(2013, 10, 21, 18, 35, 0, 0, time.UTC)}
+	cc := &CallCost{Direction: OUTBOUND, Tenant: "vdf", Subject: "rif", Account: "rif", Destination: "0256", Cost: 1}
+	ss := &SessionState{Uuid: "uuid1", CallDescriptor: cd, CallCosts: []*CallCost{cc}, LoopIndex: 2}
+	if err := ms.SetSessionState(ss); err != nil {
+		t.Fatal("Error setting session state: ", err)
+	}
+	if err := ms.SetSessionState(&SessionState{Uuid: "uuid2", CallDescriptor: cd}); err != nil {
+		t.Fatal("Error setting session state: ", err)
+	}
+	if err := ms.RemSessionState("uuid2"); err != nil {
+		t.Error("Error removing session state: ", err)
+	}
+	if sss, err := ms.GetSessionStates(); err != nil {
+		t.Error("Error getting session states: ", err)
+	} else if len(sss) != 1 || sss[0].Uuid != "uuid1" || sss[0].LoopIndex != 2 || len(sss[0].CallCosts) != 1 ||
+		!sss[0].CallDescriptor.TimeStart.Equal(cd.TimeStart) || sss[0].CallCosts[0].Cost != 1 {
+		t.Errorf("Unexpected session states: %+v", sss)
+	}
+}
+
 // Install fails to detect them and starting server will panic, these tests will fix this
 func TestStoreInterfaces(t *testing.T) {
 	rds := new(RedisStorage)
 	var _ RatingStorage = rds
 	var _ AccountingStorage = rds
+	var _ LogStorage = rds
 	sql := new(SQLStorage)
 	var _ CdrStorage = sql
 	var _ LogStorage = sql
+	ms := new(MapStorage)
+	var _ CdrStorage = ms
 }
 
 
@@ -0,0 +1,244 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package invoices
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Result of the last run of a billing cycle
+type BillingCycleStatus struct {
+	engine.JobStatus
+	Invoices  []string // Numbers of the invoices issued on the last run
+	Postponed []string // Accounts not invoiced yet because of CDRs not rated within their period, retried on the next runs
+}
+
+// Accounts left out of the invoicing of one period, invoiced on the following runs once all their CDRs are rated
+type postponedPeriod struct {
+	timeStart time.Time
+	timeEnd   time.Time
+	accounts  []string
+}
+
+// Invoices periodically the accounts of a tenant, on the timing configured for it
+type BillingCycle struct {
+	cfg         *config.InvoiceCycleConfig
+	cgrCfg      *config.CGRConfig
+	cdrDb       engine.CdrStorage
+	storage     *InvoiceStorage
+	renderer    *invoiceRenderer
+	groupFields []*utils.RSRField
+	status      *BillingCycleStatus
+	postponed   []*postponedPeriod // Kept in memory only, lost on restarts
+	mux         sync.RWMutex
+	runMux      sync.Mutex // Runs started by the scheduler and over the API one after the other
+}
+
+func NewBillingCycle(cycleCfg *config.InvoiceCycleConfig, cgrCfg *config.CGRConfig, cdrDb engine.CdrStorage, storage *InvoiceStorage) (*BillingCycle, error) {
+	bc := &BillingCycle{cfg: cycleCfg, cgrCfg: cgrCfg, cdrDb: cdrDb, storage: storage, status: &BillingCycleStatus{JobStatus: engine.JobStatus{Id: cycleCfg.Id}}}
+	var err error
+	if bc.renderer, err = newInvoiceRenderer(cycleCfg.Template, cgrCfg.RoundingDecimals); err != nil {
+		return nil, fmt.Errorf("Invalid template for billing cycle %s: %s", cycleCfg.Id, err.Error())
+	}
+	bc.groupFields = make([]*utils.RSRField, len(cycleCfg.GroupFields))
+	for idx, fldStr := range cycleCfg.GroupFields {
+		if bc.groupFields[idx], err = utils.NewRSRField(fldStr); err != nil { // Checked when loading config
+			return nil, err
+		}
+	}
+	return bc, nil
+}
+
+func (bc *BillingCycle) Id() string {
+	return bc.cfg.Id
+}
+
+// Schedules the cycle, daily if no days are configured
+func (bc *BillingCycle) ActionTiming() *engine.ActionTiming {
+	timing := &engine.RITiming{Years: bc.cfg.Years, Months: bc.cfg.Months, MonthDays: bc.cfg.MonthDays, WeekDays: bc.cfg.WeekDays,
+		StartTime: bc.cfg.StartTime}
+	return engine.NewJobActionTiming(config.INVOICE_CYCLE_PREFIX+bc.cfg.Id, timing, func() error {
+		_, err := bc.Run(time.Now(), nil)
+		return err
+	})
+}
+
+func (bc *BillingCycle) Status() *BillingCycleStatus {
+	bc.mux.RLock()
+	defer bc.mux.RUnlock()
+	status := *bc.status
+	return &status
+}
+
+// Interval of answer times invoiced by a run started at runTime
+func (bc *BillingCycle) period(runTime time.Time) (timeStart, timeEnd time.Time) {
+	return engine.JobPeriod(bc.cfg.Period, runTime)
+}
+
+// Issues the invoices of the period ending before runTime, for the accounts given or the configured ones when empty.
+// Accounts already invoiced for the period are skipped, so the cycle can be run again after a failure.
+// Accounts having CDRs not rated within the period are postponed to the next runs, which invoice them first.
+func (bc *BillingCycle) Run(runTime time.Time, accounts []string) ([]string, error) {
+	numbers, postponed, err := bc.invoice(runTime, accounts)
+	bc.mux.Lock()
+	bc.status = &BillingCycleStatus{JobStatus: engine.NewJobStatus(bc.cfg.Id, runTime, err), Invoices: numbers, Postponed: postponed}
+	bc.mux.Unlock()
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Invoices> Billing cycle %s failed: %s", bc.cfg.Id, err.Error()))
+	} else {
+		engine.Logger.Info(fmt.Sprintf("<Invoices> Billing cycle %s issued invoices: %s", bc.cfg.Id, strings.Join(numbers, ",")))
+	}
+	return numbers, err
+}
+
+// Invoices the periods postponed by the previous runs and the one of runTime, returning the numbers issued and the accounts still postponed.
+// On errors the postponed periods are left as they were, the accounts invoiced meanwhile being skipped on the next runs.
+func (bc *BillingCycle) invoice(runTime time.Time, accounts []string) (numbers, postponedAccounts []string, err error) {
+	bc.runMux.Lock()
+	defer bc.runMux.Unlock()
+	numbers = make([]string, 0)
+	var postponed []*postponedPeriod
+	for _, pp := range bc.postponed {
+		ppNumbers, ppAccounts, err := bc.invoicePeriod(runTime, pp.timeStart, pp.timeEnd, pp.accounts)
+		numbers = append(numbers, ppNumbers...)
+		if err != nil {
+			return numbers, nil, err
+		}
+		postponed = addPostponed(postponed, pp.timeStart, pp.timeEnd, ppAccounts)
+	}
+	timeStart, timeEnd := bc.period(runTime)
+	if len(accounts) == 0 {
+		accounts = bc.cfg.Accounts
+	}
+	periodNumbers, periodAccounts, err := bc.invoicePeriod(runTime, timeStart, timeEnd, accounts)
+	numbers = append(numbers, periodNumbers...)
+	if err != nil {
+		return numbers, nil, err
+	}
+	bc.postponed = addPostponed(postponed, timeStart, timeEnd, periodAccounts)
+	for _, pp := range bc.postponed {
+		for _, account := range pp.accounts {
+			if !utils.IsSliceMember(postponedAccounts, account) {
+				postponedAccounts = append(postponedAccounts, account)
+			}
+		}
+	}
+	sort.Strings(postponedAccounts)
+	return numbers, postponedAccounts, nil
+}
+
+// Adds the accounts to the ones postponed out of the period, the same period being run more than once
+func addPostponed(postponed []*postponedPeriod, timeStart, timeEnd time.Time, accounts []string) []*postponedPeriod {
+	if len(accounts) == 0 {
+		return postponed
+	}
+	for _, pp := range postponed {
+		if !pp.timeStart.Equal(timeStart) {
+			continue
+		}
+		for _, account := range accounts {
+			if !utils.IsSliceMember(pp.accounts, account) {
+				pp.accounts = append(pp.accounts, account)
+			}
+		}
+		return postponed
+	}
+	return append(postponed, &postponedPeriod{timeStart: timeStart, timeEnd: timeEnd, accounts: accounts})
+}
+
+// Invoices the accounts for the period, or the ones having CDRs within it when none are given.
+// Returns the numbers issued and the accounts postponed since some of their CDRs are not rated yet or failed rating.
+func (bc *BillingCycle) invoicePeriod(issueTime, timeStart, timeEnd time.Time, accounts []string) ([]string, []string, error) {
+	fltr := &utils.CdrsFilter{Tenants: []string{bc.cfg.Tenant}, Accounts: accounts, MediationRunIds: bc.cfg.MediationRunIds,
+		AnswerTimeStart: timeStart, AnswerTimeEnd: timeEnd}
+	cdrs, _, err := bc.cdrDb.GetCdrs(fltr)
+	if err != nil {
+		return nil, nil, err
+	}
+	acntCdrs := make(map[string][]*utils.StoredCdr)
+	for _, cdr := range cdrs {
+		acntCdrs[cdr.Account] = append(acntCdrs[cdr.Account], cdr)
+	}
+	if len(accounts) == 0 { // Invoicing the accounts with CDRs within the period
+		for account := range acntCdrs {
+			accounts = append(accounts, account)
+		}
+		sort.Strings(accounts)
+	}
+	numbers := make([]string, 0)
+	var postponed []string
+	for _, account := range accounts {
+		inv := &Invoice{CycleId: bc.cfg.Id, Tenant: bc.cfg.Tenant, Account: account, PeriodStart: timeStart, PeriodEnd: timeEnd,
+			IssueTime: issueTime, Currency: bc.cfg.Currency, GroupFields: bc.cfg.GroupFields}
+		if invoiced, err := bc.storage.IsInvoiced(inv); err != nil {
+			return numbers, postponed, err
+		} else if invoiced {
+			continue
+		}
+		if unrated := unratedCdrs(acntCdrs[account]); unrated != 0 { // Pending their cost or failed, invoicing now would leave them unbilled
+			engine.Logger.Warning(fmt.Sprintf("<Invoices> Billing cycle %s postponing account %s, CDRs not rated within period starting %s: %d",
+				bc.cfg.Id, account, timeStart, unrated))
+			postponed = append(postponed, account)
+			continue
+		}
+		adjustments, err := bc.storage.GetAdjustments(bc.cfg.Tenant, account)
+		if err != nil {
+			return numbers, postponed, err
+		}
+		inv.setAdjustments(adjustments)
+		if len(acntCdrs[account]) == 0 && len(bc.cfg.RecurringFees) == 0 && len(inv.Adjustments) == 0 {
+			continue // Nothing to charge
+		}
+		runCdrs := make(map[string][]*utils.StoredCdr)
+		for _, cdr := range acntCdrs[account] {
+			runCdrs[cdr.MediationRunId] = append(runCdrs[cdr.MediationRunId], cdr)
+		}
+		inv.Runs = make([]*InvoiceRun, len(bc.cfg.MediationRunIds))
+		for idx, runId := range bc.cfg.MediationRunIds {
+			inv.Runs[idx] = newInvoiceRun(runId, runCdrs[runId], bc.groupFields, bc.cgrCfg.RoundingDecimals)
+		}
+		inv.Fees = make([]*InvoiceFee, len(bc.cfg.RecurringFees))
+		for idx, fee := range bc.cfg.RecurringFees {
+			inv.Fees[idx] = &InvoiceFee{Description: fee.Description, Amount: fee.Amount}
+		}
+		inv.computeTotal(bc.cgrCfg.RoundingDecimals)
+		if err := bc.storage.StoreInvoice(inv, bc.renderer); err != nil {
+			return numbers, postponed, fmt.Errorf("Account %s: %s", account, err.Error())
+		}
+		numbers = append(numbers, inv.Number)
+	}
+	return numbers, postponed, nil
+}
+
+// Number of CDRs without cost, either waiting for it or failed rating
+func unratedCdrs(cdrs []*utils.StoredCdr) (unrated int) {
+	for _, cdr := range cdrs {
+		if cdr.Cost < 0 {
+			unrated += 1
+		}
+	}
+	return unrated
+}
@@ -0,0 +1,176 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package invoices
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestBillingCyclePeriod(t *testing.T) {
+	bc := &BillingCycle{cfg: config.NewDefaultInvoiceCycleConfig("test")}
+	runTime := time.Date(2014, 3, 1, 1, 30, 0, 0, time.UTC)
+	if tStart, tEnd := bc.period(runTime); !tStart.Equal(time.Date(2014, 2, 1, 0, 0, 0, 0, time.UTC)) || !tEnd.Equal(time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Unexpected period: ", tStart, tEnd)
+	}
+	bc.cfg.Period = utils.PREVIOUS_DAY
+	if tStart, tEnd := bc.period(runTime); !tStart.Equal(time.Date(2014, 2, 28, 0, 0, 0, 0, time.UTC)) || !tEnd.Equal(time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Unexpected period: ", tStart, tEnd)
+	}
+}
+
+func TestBillingCycleRun(t *testing.T) {
+	cdrDb, _ := engine.NewMapStorage()
+	answerTime := time.Date(2014, 2, 10, 10, 0, 0, 0, time.UTC)
+	for idx, cdr := range []*utils.StoredCdr{
+		&utils.StoredCdr{AccId: "inv1", Tenant: "cgrates.org", TOR: "0", Account: "1001", Subject: "1001", Destination: "+4986517174963",
+			AnswerTime: answerTime, Duration: time.Duration(60) * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 1.01},
+		&utils.StoredCdr{AccId: "inv2", Tenant: "cgrates.org", TOR: "0", Account: "1001", Subject: "1001", Destination: "+4986517174963",
+			AnswerTime: answerTime, Duration: time.Duration(30) * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 0.5},
+		&utils.StoredCdr{AccId: "inv3", Tenant: "cgrates.org", TOR: "0", Account: "1002", Subject: "1002", Destination: "+4986517174963",
+			AnswerTime: answerTime, Duration: time.Duration(10) * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 0.2},
+		&utils.StoredCdr{AccId: "inv4", Tenant: "cgrates.org", TOR: "0", Account: "1001", Subject: "1001", Destination: "+4986517174963", // Out of period
+			AnswerTime: answerTime.AddDate(0, 1, 0), Duration: time.Duration(10) * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 0.2},
+		&utils.StoredCdr{AccId: "inv5", Tenant: "itsyscom.com", TOR: "0", Account: "1001", Subject: "1001", Destination: "+4986517174963", // Other tenant
+			AnswerTime: answerTime, Duration: time.Duration(10) * time.Second, MediationRunId: utils.DEFAULT_RUNID, Cost: 0.2},
+	} {
+		cdr.CgrId = utils.FSCgrId(cdr.AccId)
+		cdr.CdrHost = "192.168.1.1"
+		cdr.ExtraFields = map[string]string{}
+		if err := cdrDb.SetCdr(cdr); err != nil {
+			t.Fatal(idx, err)
+		}
+		if err := cdrDb.SetRatedCdr(cdr, ""); err != nil {
+			t.Fatal(idx, err)
+		}
+	}
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	cycleCfg := config.NewDefaultInvoiceCycleConfig("test")
+	cycleCfg.Tenant = "cgrates.org"
+	cycleCfg.GroupFields = []string{utils.TOR}
+	cycleCfg.RecurringFees = []*config.InvoiceFeeConfig{&config.InvoiceFeeConfig{Description: "Monthly fee", Amount: 10}}
+	storage := NewInvoiceStorage(cdrDb, "INV")
+	if err := storage.AddAdjustment("cgrates.org", "1002", &InvoiceFee{Description: "Credit", Amount: -1}); err != nil {
+		t.Fatal(err)
+	}
+	bc, err := NewBillingCycle(cycleCfg, cgrCfg, cdrDb, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTime := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+	if numbers, err := bc.Run(runTime, nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual([]string{"INV000001", "INV000002"}, numbers) {
+		t.Error("Unexpected invoices: ", numbers)
+	}
+	if status := bc.Status(); status.Status != utils.JOB_OK || len(status.Invoices) != 2 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if inv, err := storage.GetInvoice("INV000001"); err != nil {
+		t.Error(err)
+	} else if inv.Account != "1001" || inv.Total != 11.51 || len(inv.Runs) != 1 || inv.Runs[0].Calls != 2 || len(inv.Runs[0].Lines) != 1 {
+		t.Errorf("Unexpected invoice: %+v", inv)
+	}
+	if inv, err := storage.GetInvoice("INV000002"); err != nil {
+		t.Error(err)
+	} else if inv.Account != "1002" || inv.Total != 9.2 || len(inv.Adjustments) != 1 {
+		t.Errorf("Unexpected invoice: %+v", inv)
+	}
+	if numbers, err := bc.Run(runTime.Add(time.Hour), nil); err != nil { // Already invoiced
+		t.Fatal(err)
+	} else if len(numbers) != 0 {
+		t.Error("Unexpected invoices: ", numbers)
+	}
+	if numbers, err := bc.Run(runTime, []string{"1003"}); err != nil { // Only the recurring fee
+		t.Fatal(err)
+	} else if !reflect.DeepEqual([]string{"INV000003"}, numbers) {
+		t.Error("Unexpected invoices: ", numbers)
+	}
+	if rendered, err := storage.GetRenderedInvoice("INV000003"); err != nil {
+		t.Error(err)
+	} else if !strings.Contains(rendered, "Account: 1003") || !strings.Contains(rendered, "Total: 10.0000") {
+		t.Error("Unexpected rendered invoice: ", rendered)
+	}
+}
+
+func TestBillingCyclePostponed(t *testing.T) {
+	cdrDb, _ := engine.NewMapStorage()
+	answerTime := time.Date(2014, 2, 10, 10, 0, 0, 0, time.UTC)
+	pendingCdr := &utils.StoredCdr{CgrId: utils.FSCgrId("inv1"), AccId: "inv1", CdrHost: "192.168.1.1", Tenant: "cgrates.org", TOR: "0", Account: "1001",
+		Subject: "1001", Destination: "+4986517174963", AnswerTime: answerTime, Duration: time.Duration(60) * time.Second,
+		MediationRunId: utils.DEFAULT_RUNID, ExtraFields: map[string]string{}, Cost: -1}
+	ratedCdr := &utils.StoredCdr{CgrId: utils.FSCgrId("inv2"), AccId: "inv2", CdrHost: "192.168.1.1", Tenant: "cgrates.org", TOR: "0", Account: "1002",
+		Subject: "1002", Destination: "+4986517174963", AnswerTime: answerTime, Duration: time.Duration(10) * time.Second,
+		MediationRunId: utils.DEFAULT_RUNID, ExtraFields: map[string]string{}, Cost: 0.2}
+	for _, cdr := range []*utils.StoredCdr{pendingCdr, ratedCdr} {
+		if err := cdrDb.SetCdr(cdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cdrDb.SetRatedCdr(pendingCdr, utils.COST_PENDING); err != nil {
+		t.Fatal(err)
+	}
+	if err := cdrDb.SetRatedCdr(ratedCdr, ""); err != nil {
+		t.Fatal(err)
+	}
+	cgrCfg, _ := config.NewDefaultCGRConfig()
+	cycleCfg := config.NewDefaultInvoiceCycleConfig("test")
+	cycleCfg.Tenant = "cgrates.org"
+	storage := NewInvoiceStorage(cdrDb, "INV")
+	bc, err := NewBillingCycle(cycleCfg, cgrCfg, cdrDb, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTime := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+	if numbers, err := bc.Run(runTime, nil); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual([]string{"INV000001"}, numbers) {
+		t.Error("Unexpected invoices: ", numbers)
+	}
+	if status := bc.Status(); !reflect.DeepEqual([]string{"1001"}, status.Postponed) {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if numbers, err := bc.Run(runTime.AddDate(0, 0, 1), nil); err != nil { // Still pending
+		t.Fatal(err)
+	} else if len(numbers) != 0 {
+		t.Error("Unexpected invoices: ", numbers)
+	}
+	pendingCdr.Cost = 1.01
+	if err := cdrDb.SetRatedCdr(pendingCdr, ""); err != nil {
+		t.Fatal(err)
+	}
+	if numbers, err := bc.Run(runTime.AddDate(0, 1, 0), nil); err != nil { // Next period invoicing the postponed one first
+		t.Fatal(err)
+	} else if !reflect.DeepEqual([]string{"INV000002"}, numbers) {
+		t.Error("Unexpected invoices: ", numbers)
+	}
+	if inv, err := storage.GetInvoice("INV000002"); err != nil {
+		t.Error(err)
+	} else if inv.Account != "1001" || !inv.PeriodStart.Equal(time.Date(2014, 2, 1, 0, 0, 0, 0, time.UTC)) || inv.Total != 1.01 {
+		t.Errorf("Unexpected invoice: %+v", inv)
+	}
+	if status := bc.Status(); len(status.Postponed) != 0 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package invoices
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+const KEY_SEP = "\x00" // Joins values into internal keys, never part of CDR fields
+
+// Amount charged on an invoice besides the rated CDRs, negative for credits
+type InvoiceFee struct {
+	Description string
+	Amount      float64
+}
+
+// Rated CDRs sharing the same values of the group fields
+type InvoiceLine struct {
+	Values   []string // Values of the group fields, in the order of the invoice GroupFields
+	Calls    int
+	Duration time.Duration
+	Cost     float64
+}
+
+// Rated CDRs of one mediation run
+type InvoiceRun struct {
+	RunId    string
+	Lines    []*InvoiceLine
+	Calls    int
+	Duration time.Duration
+	Cost     float64
+	Unrated  int // CDRs not rated or with rating errors, left out of the invoice. Accounts having such CDRs are postponed by the billing cycles
+}
+
+// Invoice of one account for one period of a billing cycle, never changed once stored
+type Invoice struct {
+	Number      string
+	CycleId     string
+	Tenant      string
+	Account     string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	IssueTime   time.Time
+	Currency    string
+	GroupFields []string // Ids of the fields grouping the lines
+	Runs        []*InvoiceRun
+	Fees        []*InvoiceFee // Recurring fees of the billing cycle
+	Adjustments []*InvoiceFee // One-off charges and credits added for the account
+	Total       float64
+
+	adjustmentIds []int64 // Consumed when storing the invoice
+}
+
+// Short form of the invoice, as listed over the API
+type InvoiceSummary struct {
+	Number      string
+	CycleId     string
+	Tenant      string
+	Account     string
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	IssueTime   time.Time
+	Total       float64
+}
+
+func (inv *Invoice) Summary() *InvoiceSummary {
+	return &InvoiceSummary{Number: inv.Number, CycleId: inv.CycleId, Tenant: inv.Tenant, Account: inv.Account, PeriodStart: inv.PeriodStart,
+		PeriodEnd: inv.PeriodEnd, IssueTime: inv.IssueTime, Total: inv.Total}
+}
+
+// Includes the adjustments waiting for the account, consumed when the invoice is stored
+func (inv *Invoice) setAdjustments(adjustments []*engine.InvoiceAdjustment) {
+	inv.Adjustments = make([]*InvoiceFee, len(adjustments))
+	inv.adjustmentIds = make([]int64, len(adjustments))
+	for idx, adj := range adjustments {
+		inv.Adjustments[idx] = &InvoiceFee{Description: adj.Description, Amount: adj.Amount}
+		inv.adjustmentIds[idx] = adj.Id
+	}
+}
+
+// Aggregates the CDRs of one run on the values of the group fields, lines sorted on their values
+func newInvoiceRun(runId string, cdrs []*utils.StoredCdr, groupFields []*utils.RSRField, roundDecimals int) *InvoiceRun {
+	run := &InvoiceRun{RunId: runId, Lines: []*InvoiceLine{}}
+	lines := make(map[string]*InvoiceLine)
+	for _, cdr := range cdrs {
+		if cdr.Cost < 0 {
+			run.Unrated += 1
+			continue
+		}
+		values := make([]string, len(groupFields))
+		for idx, fld := range groupFields {
+			values[idx] = fld.ParseValue(cdr.FieldAsString(fld.Id))
+		}
+		key := strings.Join(values, KEY_SEP)
+		line, hasLine := lines[key]
+		if !hasLine {
+			line = &InvoiceLine{Values: values}
+			lines[key] = line
+			run.Lines = append(run.Lines, line)
+		}
+		line.Calls += 1
+		line.Duration += cdr.Duration
+		line.Cost += cdr.Cost
+		run.Calls += 1
+		run.Duration += cdr.Duration
+		run.Cost += cdr.Cost
+	}
+	for _, line := range run.Lines {
+		line.Cost = utils.Round(line.Cost, roundDecimals, utils.ROUNDING_MIDDLE)
+	}
+	run.Cost = utils.Round(run.Cost, roundDecimals, utils.ROUNDING_MIDDLE)
+	sort.Sort(invoiceLinesByValues(run.Lines))
+	return run
+}
+
+type invoiceLinesByValues []*InvoiceLine
+
+func (lines invoiceLinesByValues) Len() int      { return len(lines) }
+func (lines invoiceLinesByValues) Swap(i, j int) { lines[i], lines[j] = lines[j], lines[i] }
+func (lines invoiceLinesByValues) Less(i, j int) bool {
+	for idx := range lines[i].Values {
+		if lines[i].Values[idx] != lines[j].Values[idx] {
+			return lines[i].Values[idx] < lines[j].Values[idx]
+		}
+	}
+	return false
+}
+
+// Sums up the runs, fees and adjustments into the total of the invoice
+func (inv *Invoice) computeTotal(roundDecimals int) {
+	total := 0.0
+	for _, run := range inv.Runs {
+		total += run.Cost
+	}
+	for _, fees := range [][]*InvoiceFee{inv.Fees, inv.Adjustments} {
+		for _, fee := range fees {
+			total += fee.Amount
+		}
+	}
+	inv.Total = utils.Round(total, roundDecimals, utils.ROUNDING_MIDDLE)
+}
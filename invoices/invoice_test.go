@@ -0,0 +1,53 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package invoices
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestNewInvoiceRun(t *testing.T) {
+	dstFld, _ := utils.NewRSRField(`~destination:s/^(\+49)\d+/${1}/`)
+	torFld, _ := utils.NewRSRField(utils.TOR)
+	cdrs := []*utils.StoredCdr{
+		&utils.StoredCdr{Destination: "+4986517174963", TOR: "0", Duration: time.Duration(60) * time.Second, Cost: 1.01},
+		&utils.StoredCdr{Destination: "+4986517174964", TOR: "0", Duration: time.Duration(30) * time.Second, Cost: 0.5},
+		&utils.StoredCdr{Destination: "+4986517174965", TOR: "0", Duration: time.Duration(10) * time.Second, Cost: -1},
+		&utils.StoredCdr{Destination: "+4986517174966", TOR: "1", Duration: time.Duration(1) * time.Second, Cost: 0.1},
+	}
+	eRun := &InvoiceRun{RunId: utils.DEFAULT_RUNID, Lines: []*InvoiceLine{
+		&InvoiceLine{Values: []string{"+49", "0"}, Calls: 2, Duration: time.Duration(90) * time.Second, Cost: 1.51},
+		&InvoiceLine{Values: []string{"+49", "1"}, Calls: 1, Duration: time.Duration(1) * time.Second, Cost: 0.1},
+	}, Calls: 3, Duration: time.Duration(91) * time.Second, Cost: 1.61, Unrated: 1}
+	if run := newInvoiceRun(utils.DEFAULT_RUNID, cdrs, []*utils.RSRField{dstFld, torFld}, 4); !reflect.DeepEqual(eRun, run) {
+		t.Errorf("Expecting: %+v, received: %+v", eRun, run)
+	}
+}
+
+func TestInvoiceComputeTotal(t *testing.T) {
+	inv := &Invoice{Runs: []*InvoiceRun{&InvoiceRun{Cost: 1.61}, &InvoiceRun{Cost: 0.5}},
+		Fees:        []*InvoiceFee{&InvoiceFee{Description: "Monthly fee", Amount: 10}},
+		Adjustments: []*InvoiceFee{&InvoiceFee{Description: "Credit", Amount: -2.005}}}
+	if inv.computeTotal(2); inv.Total != 10.11 {
+		t.Error("Unexpected total: ", inv.Total)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package invoices
+
+import (
+	"bytes"
+	htmltpl "html/template"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	texttpl "text/template"
+	"time"
+)
+
+const HTML_EXT = ".html" // Templates rendered as html, escaping the values
+
+// Used when the billing cycle has no template configured
+const DEFAULT_TEMPLATE = `Invoice {{.Number}}
+Account: {{.Account}}, tenant: {{.Tenant}}
+Period: {{date .PeriodStart}} - {{date .PeriodEnd}}
+Issued: {{date .IssueTime}}
+{{range .Runs}}
+{{.RunId}}: {{.Calls}} calls, {{minutes .Duration}} minutes, {{amount .Cost}}
+{{range .Lines}}  {{join .Values " / "}}: {{.Calls}} calls, {{minutes .Duration}} minutes, {{amount .Cost}}
+{{end}}{{if .Unrated}}  Not rated: {{.Unrated}} calls
+{{end}}{{end}}{{if .Fees}}
+Fees:
+{{range .Fees}}  {{.Description}}: {{amount .Amount}}
+{{end}}{{end}}{{if .Adjustments}}
+Adjustments:
+{{range .Adjustments}}  {{.Description}}: {{amount .Amount}}
+{{end}}{{end}}
+Total: {{amount .Total}} {{.Currency}}
+`
+
+// Renders the invoices out of a text/template, or an html/template for .html template files
+type invoiceRenderer struct {
+	execute func(io.Writer, interface{}) error
+}
+
+// Functions available within the templates besides the builtin ones
+func templateFuncs(roundDecimals int) map[string]interface{} {
+	return map[string]interface{}{
+		"amount":  func(amount float64) string { return strconv.FormatFloat(amount, 'f', roundDecimals, 64) },
+		"minutes": func(dur time.Duration) string { return strconv.FormatFloat(dur.Minutes(), 'f', 2, 64) },
+		"date":    func(t time.Time) string { return t.Format("2006-01-02") },
+		"join":    strings.Join,
+	}
+}
+
+func newInvoiceRenderer(tplPath string, roundDecimals int) (*invoiceRenderer, error) {
+	tplContent := DEFAULT_TEMPLATE
+	if len(tplPath) != 0 {
+		content, err := ioutil.ReadFile(tplPath)
+		if err != nil {
+			return nil, err
+		}
+		tplContent = string(content)
+	}
+	if path.Ext(tplPath) == HTML_EXT {
+		tpl, err := htmltpl.New("invoice").Funcs(htmltpl.FuncMap(templateFuncs(roundDecimals))).Parse(tplContent)
+		if err != nil {
+			return nil, err
+		}
+		return &invoiceRenderer{execute: tpl.Execute}, nil
+	}
+	tpl, err := texttpl.New("invoice").Funcs(texttpl.FuncMap(templateFuncs(roundDecimals))).Parse(tplContent)
+	if err != nil {
+		return nil, err
+	}
+	return &invoiceRenderer{execute: tpl.Execute}, nil
+}
+
+func (rdr *invoiceRenderer) render(inv *Invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := rdr.execute(&buf, inv); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
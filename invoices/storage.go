@@ -0,0 +1,111 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package invoices
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cgrates/cgrates/engine"
+)
+
+// Keeps the invoices in storDb, written once and never changed, numbered out of a sequence shared by the engines using the same prefix.
+// The rendered invoices are stored together with the json encoded ones.
+type InvoiceStorage struct {
+	cdrDb        engine.CdrStorage
+	numberPrefix string
+}
+
+func NewInvoiceStorage(cdrDb engine.CdrStorage, numberPrefix string) *InvoiceStorage {
+	return &InvoiceStorage{cdrDb: cdrDb, numberPrefix: numberPrefix}
+}
+
+// Returns true if the account was already invoiced for the period of the invoice
+func (is *InvoiceStorage) IsInvoiced(inv *Invoice) (bool, error) {
+	invs, err := is.cdrDb.GetInvoices(inv.Tenant, inv.Account, inv.CycleId)
+	if err != nil {
+		return false, err
+	}
+	for _, stored := range invs {
+		if stored.PeriodStart.Equal(inv.PeriodStart) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Numbers and stores the invoice together with its rendering, consuming the adjustments it includes in the same transaction.
+// Returns utils.ERR_EXISTS if the account was already invoiced for the same period.
+func (is *InvoiceStorage) StoreInvoice(inv *Invoice, renderer *invoiceRenderer) error {
+	stored := &engine.StoredInvoice{CycleId: inv.CycleId, Tenant: inv.Tenant, Account: inv.Account, PeriodStart: inv.PeriodStart,
+		PeriodEnd: inv.PeriodEnd, IssueTime: inv.IssueTime, Total: inv.Total, AdjustmentIds: inv.adjustmentIds}
+	return is.cdrDb.SetInvoice(is.numberPrefix, stored, func(stored *engine.StoredInvoice) (err error) {
+		inv.Number = stored.Number
+		if stored.Content, err = json.MarshalIndent(inv, "", " "); err != nil {
+			return err
+		}
+		stored.Rendered, err = renderer.render(inv)
+		return err
+	})
+}
+
+func (is *InvoiceStorage) GetInvoice(number string) (*Invoice, error) {
+	stored, err := is.cdrDb.GetInvoice(number)
+	if err != nil {
+		return nil, err
+	}
+	inv := new(Invoice)
+	if err := json.Unmarshal(stored.Content, inv); err != nil {
+		return nil, fmt.Errorf("Invalid invoice %s: %s", number, err.Error())
+	}
+	return inv, nil
+}
+
+// Returns the invoice as rendered when stored
+func (is *InvoiceStorage) GetRenderedInvoice(number string) (string, error) {
+	stored, err := is.cdrDb.GetInvoice(number)
+	if err != nil {
+		return "", err
+	}
+	return string(stored.Rendered), nil
+}
+
+// Lists the invoices sorted on number, filtered on tenant, account and cycle when not empty
+func (is *InvoiceStorage) GetInvoices(tenant, account, cycleId string) ([]*InvoiceSummary, error) {
+	invs, err := is.cdrDb.GetInvoices(tenant, account, cycleId)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]*InvoiceSummary, len(invs))
+	for idx, inv := range invs {
+		summaries[idx] = &InvoiceSummary{Number: inv.Number, CycleId: inv.CycleId, Tenant: inv.Tenant, Account: inv.Account, PeriodStart: inv.PeriodStart,
+			PeriodEnd: inv.PeriodEnd, IssueTime: inv.IssueTime, Total: inv.Total}
+	}
+	return summaries, nil
+}
+
+// Adds a one-off charge or credit to the next invoice of the account
+func (is *InvoiceStorage) AddAdjustment(tenant, account string, adjustment *InvoiceFee) error {
+	return is.cdrDb.SetInvoiceAdjustment(&engine.InvoiceAdjustment{Tenant: tenant, Account: account, Description: adjustment.Description,
+		Amount: adjustment.Amount})
+}
+
+// Returns the adjustments waiting for the next invoice of the account
+func (is *InvoiceStorage) GetAdjustments(tenant, account string) ([]*engine.InvoiceAdjustment, error) {
+	return is.cdrDb.GetInvoiceAdjustments(tenant, account)
+}
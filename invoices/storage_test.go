@@ -0,0 +1,104 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package invoices
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+)
+
+func newTestInvoice(account string) *Invoice {
+	return &Invoice{CycleId: "test", Tenant: "cgrates.org", Account: account, PeriodStart: time.Date(2014, 2, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd: time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC), IssueTime: time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC), Runs: []*InvoiceRun{},
+		Fees: []*InvoiceFee{&InvoiceFee{Description: "Monthly fee", Amount: 10}}, Total: 10}
+}
+
+func TestInvoiceStorage(t *testing.T) {
+	cdrDb, _ := engine.NewMapStorage()
+	storage := NewInvoiceStorage(cdrDb, "INV")
+	renderer, _ := newInvoiceRenderer("", 2)
+	inv := newTestInvoice("1001")
+	if err := storage.StoreInvoice(inv, renderer); err != nil {
+		t.Fatal(err)
+	} else if inv.Number != "INV000001" {
+		t.Error("Unexpected invoice number: ", inv.Number)
+	}
+	if invoiced, err := storage.IsInvoiced(newTestInvoice("1001")); err != nil {
+		t.Error(err)
+	} else if !invoiced {
+		t.Error("Invoice not indexed")
+	}
+	if err := storage.StoreInvoice(newTestInvoice("1001"), renderer); err == nil || !strings.HasPrefix(err.Error(), "EXISTS") {
+		t.Error("Expecting invoiced period error, received: ", err)
+	}
+	if rendered, err := storage.GetRenderedInvoice("INV000001"); err != nil {
+		t.Error(err)
+	} else if !strings.Contains(rendered, "Monthly fee: 10.00") || !strings.Contains(rendered, "Total: 10.00") {
+		t.Error("Unexpected rendered invoice: ", rendered)
+	}
+	if err := storage.AddAdjustment("cgrates.org", "1002", &InvoiceFee{Description: "Credit", Amount: -1}); err != nil {
+		t.Fatal(err)
+	}
+	inv2 := newTestInvoice("1002")
+	adjustments, _ := storage.GetAdjustments("cgrates.org", "1002")
+	inv2.setAdjustments(adjustments)
+	if err := storage.AddAdjustment("cgrates.org", "1002", &InvoiceFee{Description: "Setup", Amount: 5}); err != nil { // Added meanwhile, kept for the next invoice
+		t.Fatal(err)
+	}
+	if err := storage.StoreInvoice(inv2, renderer); err != nil {
+		t.Fatal(err)
+	} else if inv2.Number != "INV000002" {
+		t.Error("Unexpected invoice number: ", inv2.Number)
+	}
+	if adjustments, err := storage.GetAdjustments("cgrates.org", "1002"); err != nil {
+		t.Error(err)
+	} else if len(adjustments) != 1 || adjustments[0].Description != "Setup" {
+		t.Error("Unexpected adjustments: ", adjustments)
+	}
+	// Another engine sharing the storDb continues the sequence
+	storage = NewInvoiceStorage(cdrDb, "INV")
+	if sums, err := storage.GetInvoices("cgrates.org", "", "test"); err != nil {
+		t.Error(err)
+	} else if len(sums) != 2 || sums[0].Number != "INV000001" || sums[1].Number != "INV000002" {
+		t.Errorf("Unexpected invoices: %+v", sums)
+	}
+	if sums, err := storage.GetInvoices("", "1002", ""); err != nil {
+		t.Error(err)
+	} else if len(sums) != 1 || sums[0].Number != "INV000002" {
+		t.Errorf("Unexpected invoices: %+v", sums)
+	}
+	inv2.adjustmentIds = nil // Not stored with the invoice
+	if stored, err := storage.GetInvoice("INV000002"); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(inv2, stored) {
+		t.Errorf("Expecting: %+v, received: %+v", inv2, stored)
+	}
+	if _, err := storage.GetInvoice("INV000003"); err == nil || !strings.HasPrefix(err.Error(), "NOT_FOUND") {
+		t.Error("Expecting not found error, received: ", err)
+	}
+	inv3 := newTestInvoice("1003")
+	if err := storage.StoreInvoice(inv3, renderer); err != nil {
+		t.Fatal(err)
+	} else if inv3.Number != "INV000003" {
+		t.Error("Unexpected invoice number: ", inv3.Number)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package mediator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+// Rated legs of one call, waiting for the others before being combined
+type correlatedCall struct {
+	aLeg  *utils.StoredCdr            // Customer run of the leg with the accid of the correlation id
+	bLegs map[string]*utils.StoredCdr // Supplier runs of the other legs, indexed on cgrid
+}
+
+// Combines the legs of the calls sharing the same correlation id into one rated CDR with customer cost, supplier cost and margin.
+// The legs are collected in memory only: a restart within the wait interval drops the combination, which is rebuilt by re-mediating one of the legs.
+type legCorrelator struct {
+	customerRunId string
+	supplierRunId string
+	wait          time.Duration
+	roundDecimals int // Decimals of the costs in the combined CDRs
+	cdrDb         engine.CdrStorage
+	mux           sync.Mutex
+	calls         map[string]*correlatedCall
+}
+
+func newLegCorrelator(customerRunId, supplierRunId string, wait time.Duration, roundDecimals int, cdrDb engine.CdrStorage) *legCorrelator {
+	return &legCorrelator{customerRunId: customerRunId, supplierRunId: supplierRunId, wait: wait, roundDecimals: roundDecimals, cdrDb: cdrDb,
+		calls: make(map[string]*correlatedCall)}
+}
+
+// Collects the rated leg if its run provides one of the costs, the legs of a call are combined once the wait interval since the first one passed
+func (lc *legCorrelator) addLeg(correlationId string, cdr *utils.StoredCdr) {
+	isALeg := cdr.AccId == correlationId
+	if (isALeg && cdr.MediationRunId != lc.customerRunId) || (!isALeg && cdr.MediationRunId != lc.supplierRunId) {
+		return
+	}
+	lc.mux.Lock()
+	defer lc.mux.Unlock()
+	call, hasCall := lc.calls[correlationId]
+	if !hasCall {
+		call = &correlatedCall{bLegs: make(map[string]*utils.StoredCdr)}
+		lc.calls[correlationId] = call
+		time.AfterFunc(lc.wait, func() { lc.combine(correlationId) })
+	}
+	if isALeg {
+		call.aLeg = cdr
+	} else {
+		call.bLegs[cdr.CgrId] = cdr
+	}
+}
+
+// Stores the combined CDR of the call as one more mediation run of the A-leg, replacing the one out of a previous mediation.
+// The storage keeps the combination fields with the run, merging them into the extra fields of the A-leg when reading it back.
+func (lc *legCorrelator) combine(correlationId string) {
+	lc.mux.Lock()
+	call := lc.calls[correlationId]
+	delete(lc.calls, correlationId)
+	lc.mux.Unlock()
+	if err := lc.loadMissingLegs(correlationId, call); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Mediator> Could not load the legs of correlation id: %s, error: %s", correlationId, err.Error()))
+		return
+	}
+	combined, err := combineLegs(correlationId, call, lc.roundDecimals)
+	if err != nil {
+		engine.Logger.Warning(fmt.Sprintf("<Mediator> Cannot combine the legs of correlation id: %s, error: %s", correlationId, err.Error()))
+		return
+	}
+	if err := lc.cdrDb.SetRatedCdr(combined, ""); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Mediator> Could not store the combined CDR of correlation id: %s, error: %s", correlationId, err.Error()))
+	}
+}
+
+// Completes the call with the legs rated by previous mediations, so re-rating one leg updates the combined CDR.
+// The A-leg is found on its accid, the B-legs on the cgrids kept in the previous combined CDR.
+func (lc *legCorrelator) loadMissingLegs(correlationId string, call *correlatedCall) error {
+	if call.aLeg == nil {
+		cdrs, _, err := lc.cdrDb.GetCdrs(&utils.CdrsFilter{AccIds: []string{correlationId}, MediationRunIds: []string{lc.customerRunId}})
+		if err != nil {
+			return err
+		}
+		if len(cdrs) != 0 {
+			call.aLeg = cdrs[0]
+		}
+	}
+	if len(call.bLegs) != 0 || call.aLeg == nil {
+		return nil
+	}
+	prevCdrs, _, err := lc.cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: []string{call.aLeg.CgrId}, MediationRunIds: []string{utils.CORRELATED_RUNID}})
+	if err != nil || len(prevCdrs) == 0 || prevCdrs[0].ExtraFields[utils.BLEG_CGRIDS] == "" {
+		return err
+	}
+	cdrs, _, err := lc.cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: strings.Split(prevCdrs[0].ExtraFields[utils.BLEG_CGRIDS], ","), MediationRunIds: []string{lc.supplierRunId}})
+	if err != nil {
+		return err
+	}
+	for _, cdr := range cdrs {
+		call.bLegs[cdr.CgrId] = cdr
+	}
+	return nil
+}
+
+// Builds the combined CDR out of the A-leg, keeping its customer cost, with the supplier cost summed up over the B-legs.
+// The margin goes only in the extra fields, as a cost it could be negative and taken for a rating error.
+func combineLegs(correlationId string, call *correlatedCall, roundDecimals int) (*utils.StoredCdr, error) {
+	if call.aLeg == nil {
+		return nil, fmt.Errorf("%s:A-leg", utils.ERR_NOT_FOUND)
+	}
+	if len(call.bLegs) == 0 {
+		return nil, fmt.Errorf("%s:B-leg", utils.ERR_NOT_FOUND)
+	}
+	if call.aLeg.Cost < 0 {
+		return nil, fmt.Errorf("No cost for A-leg with cgrid: %s", call.aLeg.CgrId)
+	}
+	bLegCgrIds := make([]string, 0, len(call.bLegs))
+	supplierCost := 0.0
+	for cgrId, bLeg := range call.bLegs {
+		if bLeg.Cost < 0 {
+			return nil, fmt.Errorf("No cost for B-leg with cgrid: %s", cgrId)
+		}
+		bLegCgrIds = append(bLegCgrIds, cgrId)
+		supplierCost += bLeg.Cost
+	}
+	sort.Strings(bLegCgrIds)
+	combined := *call.aLeg
+	combined.MediationRunId = utils.CORRELATED_RUNID
+	combined.ExtraFields = make(map[string]string, len(call.aLeg.ExtraFields)+len(utils.CorrelationFields))
+	for fld, val := range call.aLeg.ExtraFields {
+		combined.ExtraFields[fld] = val
+	}
+	combined.ExtraFields[utils.CORRELATION_ID] = correlationId
+	combined.ExtraFields[utils.BLEG_CGRIDS] = strings.Join(bLegCgrIds, ",")
+	combined.ExtraFields[utils.CUSTOMER_COST] = strconv.FormatFloat(call.aLeg.Cost, 'f', roundDecimals, 64)
+	combined.ExtraFields[utils.SUPPLIER_COST] = strconv.FormatFloat(supplierCost, 'f', roundDecimals, 64)
+	combined.ExtraFields[utils.MARGIN] = strconv.FormatFloat(call.aLeg.Cost-supplierCost, 'f', roundDecimals, 64)
+	return &combined, nil
+}
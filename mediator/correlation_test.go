@@ -0,0 +1,130 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+package mediator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+func TestCombineLegs(t *testing.T) {
+	aLeg := &utils.StoredCdr{CgrId: utils.FSCgrId("aleg1"), AccId: "aleg1", CdrSource: "freeswitch_json", MediationRunId: utils.DEFAULT_RUNID,
+		ExtraFields: map[string]string{"bridge_id": "aleg1"}, Cost: 1.5}
+	bLeg := &utils.StoredCdr{CgrId: utils.FSCgrId("bleg1"), AccId: "bleg1", MediationRunId: "supplier", Cost: 1.2}
+	call := &correlatedCall{bLegs: map[string]*utils.StoredCdr{}}
+	if _, err := combineLegs("aleg1", call, 4); err == nil {
+		t.Error("Expecting error on missing A-leg")
+	}
+	call.aLeg = aLeg
+	if _, err := combineLegs("aleg1", call, 4); err == nil {
+		t.Error("Expecting error on missing B-leg")
+	}
+	call.bLegs[bLeg.CgrId] = &utils.StoredCdr{CgrId: bLeg.CgrId, Cost: -1}
+	if _, err := combineLegs("aleg1", call, 4); err == nil {
+		t.Error("Expecting error on B-leg without cost")
+	}
+	call.bLegs[bLeg.CgrId] = bLeg
+	combined, err := combineLegs("aleg1", call, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if combined.CgrId != aLeg.CgrId || combined.MediationRunId != utils.CORRELATED_RUNID || combined.Cost != 1.5 {
+		t.Errorf("Unexpected combined CDR: %+v", combined)
+	}
+	for fld, eVal := range map[string]string{"bridge_id": "aleg1", utils.CORRELATION_ID: "aleg1",
+		utils.BLEG_CGRIDS: bLeg.CgrId, utils.CUSTOMER_COST: "1.5000", utils.SUPPLIER_COST: "1.2000", utils.MARGIN: "0.3000"} {
+		if combined.ExtraFields[fld] != eVal {
+			t.Errorf("Expecting %s: %s, received: %s", fld, eVal, combined.ExtraFields[fld])
+		}
+	}
+	if _, hasFld := aLeg.ExtraFields[utils.MARGIN]; hasFld {
+		t.Error("A-leg modified when combining")
+	}
+	// Supplier costing more than charged to the customer
+	call.bLegs[bLeg.CgrId] = &utils.StoredCdr{CgrId: bLeg.CgrId, Cost: 2.7}
+	if combined, err := combineLegs("aleg1", call, 4); err != nil {
+		t.Error(err)
+	} else if combined.Cost != 1.5 || combined.ExtraFields[utils.SUPPLIER_COST] != "2.7000" || combined.ExtraFields[utils.MARGIN] != "-1.2000" {
+		t.Errorf("Unexpected combined CDR with negative margin: %+v", combined)
+	}
+}
+
+// Combines the legs of the correlation id without waiting, returning the combined CDR stored
+func combinedCdr(t *testing.T, m *Mediator, correlationId string) *utils.StoredCdr {
+	if _, hasCall := m.correlator.calls[correlationId]; !hasCall {
+		t.Fatalf("No legs collected for correlation id: %s", correlationId)
+	}
+	m.correlator.combine(correlationId)
+	cdrs, _, err := m.cdrDb.GetCdrs(&utils.CdrsFilter{AccIds: []string{correlationId}, MediationRunIds: []string{utils.CORRELATED_RUNID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cdrs) != 1 {
+		t.Fatalf("Unexpected combined CDRs for correlation id %s: %+v", correlationId, cdrs)
+	}
+	return cdrs[0]
+}
+
+func TestLegCorrelation(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	cfg.MediatorCorrelationField = "bridge_id"
+	cfg.MediatorCorrelationWait = time.Duration(1) * time.Hour // Combined on demand
+	cdrDb, _ := engine.NewMapStorage()
+	m, err := NewMediator(new(engine.Responder), cdrDb, cdrDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cgrCdr := range []utils.CgrCdr{
+		utils.CgrCdr{utils.ACCID: "call1", utils.CDRHOST: "192.168.1.1", utils.REQTYPE: utils.RATED, utils.TENANT: "cgrates.org", utils.ACCOUNT: "1001",
+			utils.SUBJECT: "1001", utils.DESTINATION: "1002", utils.ANSWER_TIME: "2013-11-07T08:42:26Z", utils.DURATION: "0", "bridge_id": "call1"},
+		utils.CgrCdr{utils.ACCID: "call1_b", utils.CDRHOST: "192.168.1.1", utils.REQTYPE: utils.RATED, utils.TENANT: "cgrates.org", utils.ACCOUNT: "supplier1",
+			utils.SUBJECT: "supplier1", utils.DESTINATION: "1002", utils.ANSWER_TIME: "2013-11-07T08:42:26Z", utils.DURATION: "0", "bridge_id": "call1"},
+	} {
+		if err := cdrDb.SetCdr(cgrCdr); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.RateCdr(cgrCdr, false); err != nil {
+			t.Error(err)
+		}
+	}
+	combined := combinedCdr(t, m, "call1")
+	if combined.MediationRunId != utils.CORRELATED_RUNID || combined.ExtraFields[utils.MARGIN] != "0.0000" || combined.Account != "1001" || combined.ExtraFields[utils.BLEG_CGRIDS] != utils.FSCgrId("call1_b") {
+		t.Errorf("Unexpected combined CDR: %+v", combined)
+	}
+	if combined.CgrId != utils.FSCgrId("call1") || combined.CdrSource != "" || combined.Cost != 0 {
+		t.Errorf("Combined CDR not stored with the A-leg: %+v", combined)
+	}
+	// Only the runs of the two legs and the combined one, no CDR of its own
+	if cdrs, _, _ := cdrDb.GetCdrs(&utils.CdrsFilter{}); len(cdrs) != 3 {
+		t.Error("Unexpected CDRs stored: ", cdrs)
+	}
+	if cdrs, _ := cdrDb.GetStoredCdrs(time.Time{}, time.Time{}, true, false); len(cdrs) != 2 {
+		t.Error("Combined CDR listed for re-mediation: ", cdrs)
+	}
+	// Re-rating the A-leg alone updates the combined CDR out of the B-leg already stored
+	aLeg := &utils.StoredCdr{CgrId: utils.FSCgrId("call1"), AccId: "call1", CdrHost: "192.168.1.1", Tenant: "cgrates.org", Account: "1001",
+		MediationRunId: utils.DEFAULT_RUNID, Cost: 2}
+	m.storeRatedCdr(aLeg, "", "call1")
+	if combined := combinedCdr(t, m, "call1"); combined.ExtraFields[utils.MARGIN] != "2.0000" || combined.Cost != 2 {
+		t.Errorf("Combined CDR not updated: %+v", combined)
+	}
+}
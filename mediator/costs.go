@@ -0,0 +1,161 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mediator
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	PENDING_RETENTION     = 24 * time.Hour   // CDRs still waiting for their session cost are forgotten after this interval
+	PENDING_POLL_INTERVAL = 60 * time.Second // Pending CDRs are checked for costs logged without notification once per interval
+)
+
+var ErrCostPending = errors.New(utils.COST_PENDING)
+
+// Rated CDR kept aside until its session cost arrives
+type pendingCdr struct {
+	cdr           *utils.StoredCdr
+	correlationId string // Correlates the CDR with the other legs of the call once rated
+	since         time.Time
+}
+
+// Keeps the CDR aside until its session cost is handed over, so the worker moves on to the next CDRs.
+// Once cost_timeout passes without the cost, the CDR is stored without cost and remains pending.
+func (self *Mediator) waitCost(cdr *utils.StoredCdr, correlationId string) {
+	wCdr := &pendingCdr{cdr: cdr, correlationId: correlationId, since: time.Now()}
+	self.costsMux.Lock()
+	self.waitingCdrs[cdr.CgrId] = append(self.waitingCdrs[cdr.CgrId], wCdr)
+	self.costsMux.Unlock()
+	// Cost logged after the first query but before the CDR was set aside
+	if cc, _ := self.logDb.GetCallCostLog(cdr.CgrId, engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID); cc != nil {
+		self.NotifyCallCost(cdr.CgrId, cc)
+		return
+	}
+	time.AfterFunc(self.cgrCfg.MediatorCostTimeout, func() { self.costTimeout(wCdr) })
+}
+
+// Stores the CDR still waiting for its cost as pending, under lock so a cost arriving meanwhile is not overwritten
+func (self *Mediator) costTimeout(wCdr *pendingCdr) {
+	self.costsMux.Lock()
+	defer self.costsMux.Unlock()
+	waiting := self.waitingCdrs[wCdr.cdr.CgrId]
+	idx := indexOfPendingCdr(waiting, wCdr)
+	if idx == -1 { // Cost arrived meanwhile
+		return
+	}
+	if waiting = append(waiting[:idx], waiting[idx+1:]...); len(waiting) == 0 {
+		delete(self.waitingCdrs, wCdr.cdr.CgrId)
+	} else {
+		self.waitingCdrs[wCdr.cdr.CgrId] = waiting
+	}
+	engine.Logger.Warning(fmt.Sprintf("<Mediator> No session cost received for cgrid: %s, runid: %s, marked for re-mediation", wCdr.cdr.CgrId, wCdr.cdr.MediationRunId))
+	wCdr.cdr.Cost = -1
+	if err := self.cdrDb.SetRatedCdr(wCdr.cdr, utils.COST_PENDING); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Mediator> Could not record pending cost for cgrid: <%s>, err: <%s>", wCdr.cdr.CgrId, err.Error()))
+	}
+	self.addPendingCdr(wCdr)
+}
+
+// Remembers the CDR stored without cost so it gets rated once the cost is handed over, to be called under costsMux
+func (self *Mediator) addPendingCdr(pCdr *pendingCdr) {
+	now := time.Now()
+	for cgrid, pending := range self.pendingCdrs {
+		if now.Sub(pending[0].since) > PENDING_RETENTION {
+			delete(self.pendingCdrs, cgrid)
+		}
+	}
+	self.pendingCdrs[pCdr.cdr.CgrId] = append(self.pendingCdrs[pCdr.cdr.CgrId], pCdr)
+}
+
+// Rebuilds the list of CDRs stored without cost out of storDb, so they still get rated by costs arriving after a restart
+func (self *Mediator) LoadPendingCdrs() error {
+	cdrs, _, err := self.cdrDb.GetCdrs(&utils.CdrsFilter{CostPending: true})
+	if err != nil {
+		return err
+	}
+	self.costsMux.Lock()
+	defer self.costsMux.Unlock()
+	for _, cdr := range cdrs {
+		correlationId := ""
+		if self.correlator != nil {
+			correlationId = cdr.ExtraFields[self.cgrCfg.MediatorCorrelationField]
+		}
+		self.addPendingCdr(&pendingCdr{cdr: cdr, correlationId: correlationId, since: time.Now()})
+	}
+	if len(cdrs) != 0 {
+		engine.Logger.Info(fmt.Sprintf("<Mediator> Reloaded %d CDRs pending their session cost", len(cdrs)))
+	}
+	return nil
+}
+
+// Hands over the final cost of a session, called by the session managers once they logged it.
+// Rates the CDRs waiting for it and the ones which were marked as pending because of it.
+func (self *Mediator) NotifyCallCost(cgrid string, cc *engine.CallCost) {
+	self.costsMux.Lock()
+	waiting := self.waitingCdrs[cgrid]
+	delete(self.waitingCdrs, cgrid)
+	pending := self.pendingCdrs[cgrid]
+	delete(self.pendingCdrs, cgrid)
+	self.costsMux.Unlock()
+	for _, wCdr := range waiting {
+		wCdr.cdr.Cost = cc.Cost
+		self.storeRatedCdr(wCdr.cdr, "", wCdr.correlationId)
+	}
+	for _, pCdr := range pending {
+		pCdr.cdr.Cost = cc.Cost
+		engine.Logger.Info(fmt.Sprintf("<Mediator> Late cost received for cgrid: %s, runid: %s, waited: %v", cgrid, pCdr.cdr.MediationRunId, time.Since(pCdr.since)))
+		self.storeRatedCdr(pCdr.cdr, "", pCdr.correlationId)
+	}
+}
+
+// Looks up periodically in logDb the costs of the pending CDRs, logged by session managers or agents which do not notify the mediator
+func (self *Mediator) pollPendingCosts() {
+	for _ = range time.Tick(PENDING_POLL_INTERVAL) {
+		self.checkPendingCosts()
+	}
+}
+
+func (self *Mediator) checkPendingCosts() {
+	self.costsMux.Lock()
+	cgrIds := make([]string, 0, len(self.pendingCdrs))
+	for cgrId := range self.pendingCdrs {
+		cgrIds = append(cgrIds, cgrId)
+	}
+	self.costsMux.Unlock()
+	for _, cgrId := range cgrIds {
+		if cc, _ := self.logDb.GetCallCostLog(cgrId, engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID); cc != nil {
+			self.NotifyCallCost(cgrId, cc)
+		}
+	}
+}
+
+func indexOfPendingCdr(pCdrs []*pendingCdr, pCdr *pendingCdr) int {
+	for idx, p := range pCdrs {
+		if p == pCdr {
+			return idx
+		}
+	}
+	return -1
+}
@@ -21,6 +21,7 @@ package mediator
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/cgrates/cgrates/config"
@@ -30,23 +31,70 @@ import (
 
 func NewMediator(connector engine.Connector, logDb engine.LogStorage, cdrDb engine.CdrStorage, cfg *config.CGRConfig) (m *Mediator, err error) {
 	m = &Mediator{
-		connector: connector,
-		logDb:     logDb,
-		cdrDb:     cdrDb,
-		cgrCfg:    cfg,
+		connector:   connector,
+		logDb:       logDb,
+		cdrDb:       cdrDb,
+		cgrCfg:      cfg,
+		jobs:        make(map[string]*MediationJob),
+		waitingCdrs: make(map[string][]*pendingCdr),
+		pendingCdrs: make(map[string][]*pendingCdr),
 	}
 	// Parse config
 	if err := m.parseConfig(); err != nil {
 		return nil, err
 	}
+	if cfg.MediatorCorrelationField != "" {
+		m.correlator = newLegCorrelator(cfg.MediatorCustomerRunId, cfg.MediatorSupplierRunId, cfg.MediatorCorrelationWait, cfg.RoundingDecimals, cdrDb)
+	}
+	m.startWorkers()
+	go m.pollPendingCosts()
 	return m, nil
 }
 
 type Mediator struct {
-	connector engine.Connector
-	logDb     engine.LogStorage
-	cdrDb     engine.CdrStorage
-	cgrCfg    *config.CGRConfig
+	connector     engine.Connector
+	logDb         engine.LogStorage
+	cdrDb         engine.CdrStorage
+	cgrCfg        *config.CGRConfig
+	cdrStats      *engine.CdrStats      // Fed with the rated CDRs when enabled
+	fraudDetector *engine.FraudDetector // Checks the rated CDRs when enabled
+	correlator    *legCorrelator        // Combines the legs of one call when enabled
+	dcRules       utils.DerivedChargingRules
+	dcMux         sync.RWMutex
+	queues        []chan *mediationTask // One queue per worker
+	jobs          map[string]*MediationJob
+	jobsMux       sync.RWMutex
+	waitingCdrs   map[string][]*pendingCdr // CDRs waiting for the session costs, not stored yet, indexed on cgrid
+	pendingCdrs   map[string][]*pendingCdr // CDRs stored without cost, indexed on cgrid
+	costsMux      sync.Mutex
+}
+
+// Replaces the derived charging rules applied on the CDRs
+func (self *Mediator) SetDerivedChargingRules(rules []*utils.DerivedChargingRule) error {
+	dcRules, err := utils.NewDerivedChargingRules(rules)
+	if err != nil {
+		return err
+	}
+	self.dcMux.Lock()
+	self.dcRules = dcRules
+	self.dcMux.Unlock()
+	return nil
+}
+
+func (self *Mediator) getDerivedChargingRules() utils.DerivedChargingRules {
+	self.dcMux.RLock()
+	defer self.dcMux.RUnlock()
+	return self.dcRules
+}
+
+// Enables fraud checks on the rated CDRs
+func (self *Mediator) SetFraudDetector(fd *engine.FraudDetector) {
+	self.fraudDetector = fd
+}
+
+// Enables feeding the rated CDRs into stats queues
+func (self *Mediator) SetCdrStats(cdrStats *engine.CdrStats) {
+	self.cdrStats = cdrStats
 }
 
 func (self *Mediator) parseConfig() error {
@@ -61,24 +109,23 @@ func (self *Mediator) parseConfig() error {
 			return errors.New("Inconsistent lenght of mediator fields.")
 		}
 	}
+	if !utils.IsSliceMember([]string{utils.DUPLICATE_SKIP, utils.DUPLICATE_RERATE}, self.cgrCfg.MediatorDuplicateCdrs) {
+		return fmt.Errorf("Unsupported duplicate_cdrs policy: %s", self.cgrCfg.MediatorDuplicateCdrs)
+	}
 
 	return nil
 }
 
-// Retrive the cost from logging database
-func (self *Mediator) getCostsFromDB(cgrid string) (cc *engine.CallCost, err error) {
-	for i := 0; i < 3; i++ { // Mechanism to avoid concurrency between SessionManager writing the costs and mediator picking them up
-		cc, err = self.logDb.GetCallCostLog(cgrid, engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID) //ToDo: What are we getting when there is no log?
-		if cc != nil {                                                                                 // There were no errors, chances are that we got what we are looking for
-			break
-		}
-		time.Sleep(time.Duration(i) * time.Second)
+// Retrive the cost from logging database, ErrCostPending if the session manager did not log it yet
+func (self *Mediator) getCostsFromDB(cgrid string) (*engine.CallCost, error) {
+	if cc, _ := self.logDb.GetCallCostLog(cgrid, engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID); cc != nil {
+		return cc, nil
 	}
-	return
+	return nil, ErrCostPending
 }
 
-// Retrive the cost from engine
-func (self *Mediator) getCostsFromRater(cdr *utils.StoredCdr) (*engine.CallCost, error) {
+// Retrive the cost from engine, skipDebit used when re-rating CDRs already debited
+func (self *Mediator) getCostsFromRater(cdr *utils.StoredCdr, skipDebit bool) (*engine.CallCost, error) {
 	cc := &engine.CallCost{}
 	var err error
 	if cdr.Duration == time.Duration(0) { // failed call,  returning empty callcost, no error
@@ -96,7 +143,7 @@ func (self *Mediator) getCostsFromRater(cdr *utils.StoredCdr) (*engine.CallCost,
 		LoopIndex:    0,
 		CallDuration: cdr.Duration,
 	}
-	if cdr.ReqType == utils.PSEUDOPREPAID {
+	if cdr.ReqType == utils.PSEUDOPREPAID && !skipDebit {
 		err = self.connector.Debit(cd, cc)
 	} else {
 		err = self.connector.GetCost(cd, cc)
@@ -110,14 +157,14 @@ func (self *Mediator) getCostsFromRater(cdr *utils.StoredCdr) (*engine.CallCost,
 	return cc, err
 }
 
-func (self *Mediator) rateCDR(cdr *utils.StoredCdr) error {
+func (self *Mediator) rateCDR(cdr *utils.StoredCdr, skipDebit bool) error {
 	var qryCC *engine.CallCost
 	var errCost error
 	if cdr.ReqType == utils.PREPAID || cdr.ReqType == utils.POSTPAID {
 		// Should be previously calculated and stored in DB
 		qryCC, errCost = self.getCostsFromDB(cdr.CgrId)
 	} else {
-		qryCC, errCost = self.getCostsFromRater(cdr)
+		qryCC, errCost = self.getCostsFromRater(cdr, skipDebit)
 	}
 	if errCost != nil {
 		return errCost
@@ -128,16 +175,37 @@ func (self *Mediator) rateCDR(cdr *utils.StoredCdr) error {
 	return nil
 }
 
-// Forks original CDR based on original request plus runIds for extra mediation
-func (self *Mediator) RateCdr(dbcdr utils.RawCDR) error {
+// Forks original CDR based on original request plus runIds for extra mediation.
+// Derived charging rules matching the CDR decide the fork of their runs, skipping or populating its fields out of templates.
+// Duplicates of CDRs already received are skipped or re-rated without debiting, based on the duplicate_cdrs policy.
+func (self *Mediator) RateCdr(dbcdr utils.RawCDR, duplicate bool) error {
+	if duplicate && self.cgrCfg.MediatorDuplicateCdrs != utils.DUPLICATE_RERATE {
+		return nil
+	}
 	//engine.Logger.Debug(fmt.Sprintf("Mediating rawCdr: %v, duration: %d",dbcdr, dbcdr.GetDuration()))
 	rtCdr, err := utils.NewStoredCdrFromRawCDR(dbcdr)
 	if err != nil {
 		return err
 	}
 	//engine.Logger.Debug(fmt.Sprintf("Have converted raw into rated: %v", rtCdr))
-	cdrs := []*utils.StoredCdr{rtCdr} // Start with initial dbcdr, will add here all to be mediated
+	correlationId := ""
+	if self.correlator != nil {
+		correlationId = rtCdr.ExtraFields[self.cgrCfg.MediatorCorrelationField]
+	}
+	dcRules := self.getDerivedChargingRules()
+	var cdrs []*utils.StoredCdr // Will add here all to be mediated
+	if dcr := dcRules.RuleForRun(utils.DEFAULT_RUNID, rtCdr); dcr == nil {
+		cdrs = append(cdrs, rtCdr)
+	} else if !dcr.Skip {
+		cdrs = append(cdrs, dcr.ForkCdr(rtCdr))
+	}
 	for runIdx, runId := range self.cgrCfg.MediatorRunIds {
+		if dcr := dcRules.RuleForRun(runId, rtCdr); dcr != nil { // Rules take precedence over the static fields in config
+			if !dcr.Skip {
+				cdrs = append(cdrs, dcr.ForkCdr(rtCdr))
+			}
+			continue
+		}
 		forkedCdr, err := dbcdr.AsStoredCdr(self.cgrCfg.MediatorRunIds[runIdx], self.cgrCfg.MediatorReqTypeFields[runIdx], self.cgrCfg.MediatorDirectionFields[runIdx],
 			self.cgrCfg.MediatorTenantFields[runIdx], self.cgrCfg.MediatorTORFields[runIdx], self.cgrCfg.MediatorAccountFields[runIdx],
 			self.cgrCfg.MediatorSubjectFields[runIdx], self.cgrCfg.MediatorDestFields[runIdx], self.cgrCfg.MediatorAnswerTimeFields[runIdx],
@@ -148,28 +216,57 @@ func (self *Mediator) RateCdr(dbcdr utils.RawCDR) error {
 		}
 		cdrs = append(cdrs, forkedCdr)
 	}
+	for _, runId := range dcRules.RunIds() { // Runs defined by rules only
+		if runId == utils.DEFAULT_RUNID || utils.IsSliceMember(self.cgrCfg.MediatorRunIds, runId) {
+			continue
+		}
+		if dcr := dcRules.RuleForRun(runId, rtCdr); dcr != nil && !dcr.Skip {
+			cdrs = append(cdrs, dcr.ForkCdr(rtCdr))
+		}
+	}
 	for _, cdr := range cdrs {
 		extraInfo := ""
-		if err = self.rateCDR(cdr); err != nil {
+		if err = self.rateCDR(cdr, duplicate); err == ErrCostPending { // Session cost not arrived yet
+			self.waitCost(cdr, correlationId)
+			continue
+		} else if err != nil {
 			extraInfo = err.Error()
 		}
-		if err := self.cdrDb.SetRatedCdr(cdr, extraInfo); err != nil {
-			engine.Logger.Err(fmt.Sprintf("<Mediator> Could not record cost for cgrid: <%s>, err: <%s>, cost: %f, extraInfo: %s",
-				cdr.CgrId, err.Error(), cdr.Cost, extraInfo))
-		}
+		self.storeRatedCdr(cdr, extraInfo, correlationId)
 	}
 	return nil
 }
 
-func (self *Mediator) RateCdrs(timeStart, timeEnd time.Time, rerateErrors, rerateRated bool) error {
+// Stores the rated CDR, feeding it afterwards into stats, fraud checks and the correlation of the legs
+func (self *Mediator) storeRatedCdr(cdr *utils.StoredCdr, extraInfo, correlationId string) {
+	if err := self.cdrDb.SetRatedCdr(cdr, extraInfo); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<Mediator> Could not record cost for cgrid: <%s>, err: <%s>, cost: %f, extraInfo: %s",
+			cdr.CgrId, err.Error(), cdr.Cost, extraInfo))
+	}
+	if self.cdrStats != nil {
+		self.cdrStats.AppendCdr(cdr)
+	}
+	if self.fraudDetector != nil {
+		self.fraudDetector.CheckCdr(cdr)
+	}
+	if self.correlator != nil && correlationId != "" {
+		self.correlator.addLeg(correlationId, cdr)
+	}
+}
+
+// Mediates the stored CDRs in the background, errors are collected on the returned job instead of aborting it
+func (self *Mediator) RateCdrs(timeStart, timeEnd time.Time, rerateErrors, rerateRated bool) (*MediationJob, error) {
 	cdrs, err := self.cdrDb.GetStoredCdrs(timeStart, timeEnd, !rerateErrors, !rerateRated)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for _, cdr := range cdrs {
-		if err := self.RateCdr(cdr); err != nil {
-			return err
+	job := newMediationJob(len(cdrs))
+	self.addJob(job)
+	engine.Logger.Info(fmt.Sprintf("<Mediator> Job %s started, mediating %d CDRs", job.id, len(cdrs)))
+	go func() {
+		for _, cdr := range cdrs {
+			self.queueTask(&mediationTask{cdr: cdr, job: job})
 		}
-	}
-	return nil
+	}()
+	return job, nil
 }
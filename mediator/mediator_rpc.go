@@ -19,16 +19,17 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package mediator
 
 import (
+	"errors"
 	"fmt"
-	"time"
 	"github.com/cgrates/cgrates/utils"
+	"time"
 )
 
 type MediatorV1 struct {
 	Medi *Mediator
 }
 
-// Remotely start mediation with specific runid, runs asynchronously, it's status will be displayed in syslog
+// Remotely start mediation with specific runid, runs asynchronously, replying with the job id to poll the status with
 func (self *MediatorV1) RateCdrs(attrs utils.AttrRateCdrs, reply *string) error {
 	if self.Medi == nil {
 		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, "MediatorNotRunning")
@@ -45,8 +46,23 @@ func (self *MediatorV1) RateCdrs(attrs utils.AttrRateCdrs, reply *string) error
 			return err
 		}
 	}
-	if err := self.Medi.RateCdrs(tStart, tEnd, attrs.RerateErrors, attrs.RerateRated); err != nil {
+	job, err := self.Medi.RateCdrs(tStart, tEnd, attrs.RerateErrors, attrs.RerateRated)
+	if err != nil {
 		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, err.Error())
 	}
+	*reply = job.Id()
+	return nil
+}
+
+// Returns the progress of a mediation job started with RateCdrs
+func (self *MediatorV1) GetJobStatus(jobId string, reply *MediationJobStatus) error {
+	if self.Medi == nil {
+		return fmt.Errorf("%s:%s", utils.ERR_SERVER_ERROR, "MediatorNotRunning")
+	}
+	job := self.Medi.GetJob(jobId)
+	if job == nil {
+		return errors.New(utils.ERR_NOT_FOUND)
+	}
+	*reply = *job.Status()
 	return nil
 }
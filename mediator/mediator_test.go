@@ -1,8 +1,12 @@
 package mediator
 
 import (
+	"errors"
 	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
 	"testing"
+	"time"
 )
 
 func TestParseConfig(t *testing.T) {
@@ -29,3 +33,218 @@ func TestParseConfig(t *testing.T) {
 	cfg.MediatorAnswerTimeFields = []string{"answerTimeFieldName1", "answerTimeFieldName1"}
 	cfg.MediatorDurationFields = []string{"durFieldName1", "durFieldName2"}
 }
+
+func TestDuplicateCdrsPolicy(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	m := &Mediator{cgrCfg: cfg}
+	cfg.MediatorDuplicateCdrs = "*ignore"
+	if err := m.parseConfig(); err == nil {
+		t.Error("Failed to detect unsupported duplicate_cdrs policy")
+	}
+	cfg.MediatorDuplicateCdrs = utils.DUPLICATE_SKIP
+	if err := m.parseConfig(); err != nil {
+		t.Error(err)
+	}
+	// Skipped duplicates do not reach storage or rater
+	if err := m.RateCdr(utils.CgrCdr{utils.ACCID: "dsafdsaf", utils.CDRHOST: "192.168.1.1"}, true); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDerivedChargingRules(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	cfg.MediatorRunIds = []string{"run1"}
+	cfg.MediatorReqTypeFields, cfg.MediatorDirectionFields, cfg.MediatorTenantFields = []string{"^rated"}, []string{"^*out"}, []string{utils.TENANT}
+	cfg.MediatorTORFields, cfg.MediatorAccountFields, cfg.MediatorSubjectFields = []string{utils.TOR}, []string{utils.ACCOUNT}, []string{utils.SUBJECT}
+	cfg.MediatorDestFields, cfg.MediatorAnswerTimeFields, cfg.MediatorDurationFields = []string{utils.DESTINATION}, []string{utils.ANSWER_TIME}, []string{utils.DURATION}
+	cdrDb, _ := engine.NewMapStorage()
+	m, err := NewMediator(new(engine.Responder), cdrDb, cdrDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDerivedChargingRules([]*utils.DerivedChargingRule{
+		&utils.DerivedChargingRule{Id: "SKIP_RUN1_DE", RunId: "run1", Filters: map[string]string{utils.DESTINATION: `^\+49`}, Skip: true},
+		&utils.DerivedChargingRule{Id: "RETAIL", RunId: "retail", AccountField: `~account:s/^(\d+)$/retail_${1}/`},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, cgrCdr := range []utils.CgrCdr{
+		utils.CgrCdr{utils.ACCID: "dcr1", utils.CDRHOST: "192.168.1.1", utils.REQTYPE: utils.RATED, utils.DIRECTION: "*out", utils.TENANT: "cgrates.org",
+			utils.TOR: "call", utils.ACCOUNT: "1001", utils.SUBJECT: "1001", utils.DESTINATION: "+4986517174963", utils.ANSWER_TIME: "2013-11-07T08:42:26Z", utils.DURATION: "0"},
+		utils.CgrCdr{utils.ACCID: "dcr2", utils.CDRHOST: "192.168.1.1", utils.REQTYPE: utils.RATED, utils.DIRECTION: "*out", utils.TENANT: "cgrates.org",
+			utils.TOR: "call", utils.ACCOUNT: "1002", utils.SUBJECT: "1002", utils.DESTINATION: "+3312345", utils.ANSWER_TIME: "2013-11-07T08:42:26Z", utils.DURATION: "0"},
+	} {
+		if err := cdrDb.SetCdr(cgrCdr); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.RateCdr(cgrCdr, false); err != nil {
+			t.Error(err)
+		}
+	}
+	eRuns := map[string][]string{utils.FSCgrId("dcr1"): []string{"default", "retail"}, utils.FSCgrId("dcr2"): []string{"default", "retail", "run1"}}
+	for cgrId, runIds := range eRuns {
+		cdrs, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: []string{cgrId}, OrderBy: utils.MEDI_RUNID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cdrs) != len(runIds) {
+			t.Fatalf("Unexpected CDRs for %s: %+v", cgrId, cdrs)
+		}
+		for idx, cdr := range cdrs {
+			if cdr.MediationRunId != runIds[idx] {
+				t.Errorf("Expecting run %s, received: %s", runIds[idx], cdr.MediationRunId)
+			}
+		}
+	}
+}
+
+func TestQueueCdrPerAccount(t *testing.T) {
+	m := &Mediator{queues: make([]chan *mediationTask, 4)}
+	for idx := range m.queues {
+		m.queues[idx] = make(chan *mediationTask, 10)
+	}
+	for _, accId := range []string{"q1", "q2", "q3"} {
+		m.QueueCdr(utils.CgrCdr{utils.ACCID: accId, utils.TENANT: "cgrates.org", utils.ACCOUNT: "1001"}, false)
+	}
+	for _, queue := range m.queues {
+		if len(queue) != 0 && len(queue) != 3 {
+			t.Error("CDRs of the same account queued on different workers")
+		}
+	}
+}
+
+func TestMediationJob(t *testing.T) {
+	job := newMediationJob(3)
+	job.addResult(utils.CgrCdr{utils.ACCID: "job1"}, nil)
+	job.addResult(utils.CgrCdr{utils.ACCID: "job2"}, errors.New("TEST_ERROR"))
+	if status := job.Status(); status.Status != JOB_RUNNING || status.Processed != 2 || status.Errors != 1 || len(status.ErrorMessages) != 1 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	job.addResult(utils.CgrCdr{utils.ACCID: "job3"}, nil)
+	job.Wait()
+	if status := job.Status(); status.Status != JOB_FINISHED || status.Processed != 3 || status.EndTime.IsZero() {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if job := newMediationJob(0); job.Status().Status != JOB_FINISHED {
+		t.Error("Empty job should be finished")
+	}
+}
+
+func TestRateCdrsJob(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	cdrDb, _ := engine.NewMapStorage()
+	m, err := NewMediator(new(engine.Responder), cdrDb, cdrDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, accId := range []string{"job1", "job2", "job3"} {
+		if err := cdrDb.SetCdr(utils.CgrCdr{utils.ACCID: accId, utils.CDRHOST: "192.168.1.1", utils.REQTYPE: utils.RATED, utils.TENANT: "cgrates.org",
+			utils.ACCOUNT: "1001", utils.SUBJECT: "1001", utils.DESTINATION: "1002", utils.ANSWER_TIME: "2013-11-07T08:42:26Z", utils.DURATION: "0"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := m.RateCdrs(time.Time{}, time.Time{}, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Wait()
+	if m.GetJob(job.Id()) != job {
+		t.Error("Job not registered")
+	}
+	if status := job.Status(); status.Total != 3 || status.Processed != 3 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	if cdrs, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{MediationRunIds: []string{utils.DEFAULT_RUNID}}); err != nil {
+		t.Error(err)
+	} else if len(cdrs) != 3 {
+		t.Error("CDRs not mediated: ", cdrs)
+	}
+}
+
+func TestCostHandoff(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	cfg.MediatorCostTimeout = time.Duration(5) * time.Second
+	cdrDb, _ := engine.NewMapStorage()
+	m, err := NewMediator(new(engine.Responder), cdrDb, cdrDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdr := utils.CgrCdr{utils.ACCID: "handoff1", utils.CDRHOST: "192.168.1.1", utils.REQTYPE: utils.PREPAID, utils.TENANT: "cgrates.org",
+		utils.ACCOUNT: "1001", utils.SUBJECT: "1001", utils.DESTINATION: "1002", utils.ANSWER_TIME: "2013-11-07T08:42:26Z", utils.DURATION: "10"}
+	if err := cdrDb.SetCdr(cdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RateCdr(cdr, false); err != nil { // Returns without waiting for the cost
+		t.Fatal(err)
+	}
+	if cdrs, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: []string{cdr.GetCgrId()}}); err != nil {
+		t.Error(err)
+	} else if len(cdrs) != 1 || len(cdrs[0].MediationRunId) != 0 {
+		t.Error("CDR stored before its cost arrived: ", cdrs)
+	}
+	m.NotifyCallCost(cdr.GetCgrId(), &engine.CallCost{Cost: 1.5})
+	if cdrs, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: []string{cdr.GetCgrId()}}); err != nil {
+		t.Error(err)
+	} else if len(cdrs) != 1 || cdrs[0].Cost != 1.5 {
+		t.Error("Unexpected CDRs: ", cdrs)
+	}
+	if len(m.waitingCdrs) != 0 || len(m.pendingCdrs) != 0 {
+		t.Error("Waiting CDRs not removed: ", m.waitingCdrs, m.pendingCdrs)
+	}
+}
+
+func TestCostPending(t *testing.T) {
+	cfg, _ := config.NewDefaultCGRConfig()
+	cfg.MediatorCostTimeout = time.Duration(10) * time.Millisecond
+	cdrDb, _ := engine.NewMapStorage()
+	m, err := NewMediator(new(engine.Responder), cdrDb, cdrDb, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cdr := utils.CgrCdr{utils.ACCID: "pending1", utils.CDRHOST: "192.168.1.1", utils.REQTYPE: utils.POSTPAID, utils.TENANT: "cgrates.org",
+		utils.ACCOUNT: "1001", utils.SUBJECT: "1001", utils.DESTINATION: "1002", utils.ANSWER_TIME: "2013-11-07T08:42:26Z", utils.DURATION: "10"}
+	if err := cdrDb.SetCdr(cdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RateCdr(cdr, false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Duration(50) * time.Millisecond) // Wait for the cost timeout
+	m.costsMux.Lock()
+	if len(m.waitingCdrs) != 0 || len(m.pendingCdrs) != 1 {
+		t.Error("CDR not pending: ", m.waitingCdrs, m.pendingCdrs)
+	}
+	m.costsMux.Unlock()
+	if cdrs, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: []string{cdr.GetCgrId()}}); err != nil {
+		t.Error(err)
+	} else if len(cdrs) != 1 || cdrs[0].Cost != -1 {
+		t.Error("CDR not marked as pending: ", cdrs)
+	}
+	if cdrs, err := cdrDb.GetStoredCdrs(time.Time{}, time.Time{}, false, true); err != nil {
+		t.Error(err)
+	} else if len(cdrs) != 1 {
+		t.Error("Pending CDR not available for re-mediation: ", cdrs)
+	}
+	// Pending CDRs survive a restart
+	if m, err = NewMediator(new(engine.Responder), cdrDb, cdrDb, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadPendingCdrs(); err != nil {
+		t.Fatal(err)
+	} else if len(m.pendingCdrs[cdr.GetCgrId()]) != 1 {
+		t.Error("Pending CDRs not reloaded: ", m.pendingCdrs)
+	}
+	// Cost logged by a session manager not notifying the mediator
+	if err := cdrDb.LogCallCost(cdr.GetCgrId(), engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID, &engine.CallCost{Cost: 2.5}); err != nil {
+		t.Fatal(err)
+	}
+	m.checkPendingCosts()
+	if cdrs, _, err := cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: []string{cdr.GetCgrId()}}); err != nil {
+		t.Error(err)
+	} else if len(cdrs) != 1 || cdrs[0].Cost != 2.5 {
+		t.Error("Late cost not applied: ", cdrs)
+	}
+	if len(m.pendingCdrs) != 0 {
+		t.Error("Pending CDRs not removed: ", m.pendingCdrs)
+	}
+}
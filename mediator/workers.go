@@ -0,0 +1,183 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mediator
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
+)
+
+const (
+	JOB_RUNNING      = "*running"
+	JOB_FINISHED     = "*finished"
+	MAX_JOB_ERRORS   = 100            // Error messages kept on a job, the others are only counted
+	JOB_RETENTION    = 24 * time.Hour // Finished jobs are removed after this interval
+	PROGRESS_PERCENT = 10             // Long jobs log their progress each time this percentage is completed
+)
+
+// One CDR waiting for mediation
+type mediationTask struct {
+	cdr       utils.RawCDR
+	duplicate bool
+	job       *MediationJob // Job the task belongs to, nil for CDRs received by CDRS
+}
+
+// Mediation of stored CDRs started by RateCdrs, tracked until all of them are processed
+type MediationJob struct {
+	id            string
+	startTime     time.Time
+	endTime       time.Time
+	total         int
+	processed     int
+	errors        int
+	errorMessages []string
+	mux           sync.RWMutex
+	done          chan struct{}
+}
+
+// Snapshot of a mediation job, as returned by MediatorV1.GetJobStatus
+type MediationJobStatus struct {
+	Id            string
+	Status        string // <*running|*finished>
+	StartTime     time.Time
+	EndTime       time.Time // Zero while running
+	Total         int       // Number of CDRs to mediate
+	Processed     int       // Number of CDRs mediated so far, with or without errors
+	Errors        int       // Number of CDRs which could not be mediated
+	ErrorMessages []string  // First errors met, maximum MAX_JOB_ERRORS
+}
+
+func newMediationJob(total int) *MediationJob {
+	job := &MediationJob{id: utils.GenUUID(), startTime: time.Now(), total: total, done: make(chan struct{})}
+	if total == 0 {
+		job.finish()
+	}
+	return job
+}
+
+func (job *MediationJob) Id() string {
+	return job.id
+}
+
+// Blocks until all the CDRs of the job are processed
+func (job *MediationJob) Wait() {
+	<-job.done
+}
+
+func (job *MediationJob) Status() *MediationJobStatus {
+	job.mux.RLock()
+	defer job.mux.RUnlock()
+	status := &MediationJobStatus{Id: job.id, Status: JOB_RUNNING, StartTime: job.startTime, EndTime: job.endTime, Total: job.total,
+		Processed: job.processed, Errors: job.errors, ErrorMessages: make([]string, len(job.errorMessages))}
+	copy(status.ErrorMessages, job.errorMessages)
+	if !job.endTime.IsZero() {
+		status.Status = JOB_FINISHED
+	}
+	return status
+}
+
+func (job *MediationJob) finish() {
+	job.endTime = time.Now()
+	close(job.done)
+}
+
+// Records the result of mediating one CDR of the job
+func (job *MediationJob) addResult(cdr utils.RawCDR, err error) {
+	job.mux.Lock()
+	defer job.mux.Unlock()
+	job.processed += 1
+	if err != nil {
+		job.errors += 1
+		if len(job.errorMessages) < MAX_JOB_ERRORS {
+			job.errorMessages = append(job.errorMessages, fmt.Sprintf("cgrid: %s, error: %s", cdr.GetCgrId(), err.Error()))
+		}
+	}
+	if job.processed == job.total {
+		job.finish()
+		engine.Logger.Info(fmt.Sprintf("<Mediator> Job %s finished, mediated %d CDRs with %d errors in %v", job.id, job.total, job.errors, job.endTime.Sub(job.startTime)))
+	} else if job.processed*100/job.total/PROGRESS_PERCENT != (job.processed-1)*100/job.total/PROGRESS_PERCENT {
+		engine.Logger.Info(fmt.Sprintf("<Mediator> Job %s progress: %d/%d CDRs, %d errors", job.id, job.processed, job.total, job.errors))
+	}
+}
+
+func (job *MediationJob) expired(now time.Time) bool {
+	job.mux.RLock()
+	defer job.mux.RUnlock()
+	return !job.endTime.IsZero() && now.Sub(job.endTime) > JOB_RETENTION
+}
+
+// Starts the workers, each one mediating sequentially the CDRs queued for it
+func (self *Mediator) startWorkers() {
+	workers := self.cgrCfg.MediatorWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	self.queues = make([]chan *mediationTask, workers)
+	for idx := range self.queues {
+		self.queues[idx] = make(chan *mediationTask, self.cgrCfg.MediatorQueueLength)
+		go self.work(self.queues[idx])
+	}
+}
+
+func (self *Mediator) work(queue chan *mediationTask) {
+	for task := range queue {
+		err := self.RateCdr(task.cdr, task.duplicate)
+		if task.job != nil {
+			task.job.addResult(task.cdr, err)
+		} else if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<Mediator> Could not run mediation on CDR with cgrid: %s, error: %s", task.cdr.GetCgrId(), err.Error()))
+		}
+	}
+}
+
+// CDRs of the same account always go to the same worker, keeping their debits in order
+func (self *Mediator) queueTask(task *mediationTask) {
+	hash := fnv.New32a()
+	hash.Write([]byte(task.cdr.GetTenant() + ":" + task.cdr.GetAccount()))
+	self.queues[hash.Sum32()%uint32(len(self.queues))] <- task
+}
+
+// Queues the CDR for mediation, blocking while the queue of its worker is full
+func (self *Mediator) QueueCdr(cdr utils.RawCDR, duplicate bool) {
+	self.queueTask(&mediationTask{cdr: cdr, duplicate: duplicate})
+}
+
+func (self *Mediator) addJob(job *MediationJob) {
+	self.jobsMux.Lock()
+	defer self.jobsMux.Unlock()
+	now := time.Now()
+	for jobId, oldJob := range self.jobs {
+		if oldJob.expired(now) {
+			delete(self.jobs, jobId)
+		}
+	}
+	self.jobs[job.id] = job
+}
+
+// Returns the job with the given id, nil if not found
+func (self *Mediator) GetJob(jobId string) *MediationJob {
+	self.jobsMux.RLock()
+	defer self.jobsMux.RUnlock()
+	return self.jobs[jobId]
+}
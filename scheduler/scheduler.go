@@ -29,6 +29,7 @@ import (
 
 type Scheduler struct {
 	queue       engine.ActionTimingPriotityList
+	tasks       []*engine.ActionTiming // Scheduled independently of the action timings in storage, kept over reloads
 	timer       *time.Timer
 	restartLoop chan bool
 	sync.Mutex
@@ -100,10 +101,18 @@ func (s *Scheduler) LoadActionTimings(storage engine.AccountingStorage) {
 			})
 		}
 	}
+	s.queue = append(s.queue, s.tasks...)
 	sort.Sort(s.queue)
 	s.Unlock()
 }
 
+// Registers a task built with engine.NewTaskActionTiming, queued on the next LoadActionTimings
+func (s *Scheduler) AddTask(at *engine.ActionTiming) {
+	s.Lock()
+	s.tasks = append(s.tasks, at)
+	s.Unlock()
+}
+
 func (s *Scheduler) Restart() {
 	s.restartLoop <- true
 	if s.timer != nil {
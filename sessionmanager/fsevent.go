@@ -55,6 +55,8 @@ const (
 	MISSING_PARAMETER  = "-MISSING_PARAMETER"
 	SYSTEM_ERROR       = "-SYSTEM_ERROR"
 	MANAGER_REQUEST    = "+MANAGER_REQUEST"
+	ADMIN_DISCONNECT   = "-ADMIN_DISCONNECT"
+	FRAUD_DETECTED     = "-FRAUD_DETECTED"
 	USERNAME           = "Caller-Username"
 )
 
@@ -25,6 +25,7 @@ import (
 	"log/syslog"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cgrates/cgrates/config"
@@ -38,16 +39,23 @@ var cfg *config.CGRConfig // Share the configuration with the rest of the packag
 // The freeswitch session manager type holding a buffer for the network connection
 // and the active sessions
 type FSSessionManager struct {
-	conn        net.Conn
-	buf         *bufio.Reader
-	sessions    []*Session
-	connector   engine.Connector
-	debitPeriod time.Duration
-	loggerDB    engine.LogStorage
+	conn            net.Conn
+	buf             *bufio.Reader
+	sessions        []*Session
+	authorizedCalls map[string]*authorizedCall // Calls authorized but not answered yet, key is the uuid
+	sessionsMux     sync.RWMutex               // Protects sessions list and authorized calls, accessed also by APIs
+	connector       engine.Connector
+	debitPeriod     time.Duration
+	debitMargin     time.Duration // Debit this much before the debited time is consumed
+	loggerDB        engine.LogStorage
+	cdrDb           engine.CdrStorage // Source of the hangup times of the calls ended while the engine was down
+	lowBalanceHooks []LowBalanceHook  // Called on low balance warnings, in addition to the announcement
+	fraudDetector   *engine.FraudDetector
+	costNotifier    CostNotifier // Receives the final session costs, the mediator waiting on them
 }
 
-func NewFSSessionManager(storage engine.LogStorage, connector engine.Connector, debitPeriod time.Duration) *FSSessionManager {
-	return &FSSessionManager{loggerDB: storage, connector: connector, debitPeriod: debitPeriod}
+func NewFSSessionManager(storage engine.LogStorage, connector engine.Connector, debitPeriod, debitMargin time.Duration) *FSSessionManager {
+	return &FSSessionManager{loggerDB: storage, connector: connector, debitPeriod: debitPeriod, debitMargin: debitMargin}
 }
 
 // Connects to the freeswitch mod_event_socket server and starts
@@ -60,10 +68,71 @@ func (sm *FSSessionManager) Connect(cgrCfg *config.CGRConfig) (err error) {
 	} else if !fsock.FS.Connected() {
 		return errors.New("Cannot connect to FreeSWITCH")
 	}
+	sm.recoverSessions()
 	fsock.FS.ReadEvents()
 	return errors.New("stopped reading events")
 }
 
+// Reloads the sessions persisted before a restart, re-attaching to the calls still up in freeswitch
+// and finalizing the ones which ended in the meantime
+func (sm *FSSessionManager) recoverSessions() {
+	if sm.loggerDB == nil {
+		return
+	}
+	sss, err := sm.loggerDB.GetSessionStates()
+	if err != nil {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not load session states: %v", err))
+		return
+	}
+	for _, ss := range sss {
+		s := &Session{uuid: ss.Uuid,
+			callDescriptor: ss.CallDescriptor,
+			sessionManager: sm,
+			stopDebit:      make(chan bool, 2),
+			CallCosts:      ss.CallCosts,
+			loopIndex:      ss.LoopIndex,
+			clock:          realClock{}}
+		if len(s.CallCosts) == 0 {
+			s.removeState()
+			continue
+		}
+		if reply, err := fsock.FS.SendApiCmd(fmt.Sprintf("uuid_exists %s\n\n", s.uuid)); err != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not check session %s: %v", s.uuid, err))
+			continue // keep the state for the next restart
+		} else if strings.HasPrefix(strings.TrimSpace(reply), "true") {
+			engine.Logger.Info(fmt.Sprintf("<SessionManager> Resuming session %s", s.uuid))
+			sm.sessionsMux.Lock()
+			sm.sessions = append(sm.sessions, s)
+			sm.sessionsMux.Unlock()
+			go s.startDebitLoop()
+			continue
+		}
+		// Call ended while we were down, refund whatever was debited past its hangup
+		engine.Logger.Info(fmt.Sprintf("<SessionManager> Finalizing session %s", s.uuid))
+		sm.refundSessionCosts(s, sm.recoveredHangupTime(s))
+		s.SaveOperations()
+		s.removeState()
+	}
+}
+
+// Hangup time of a call which ended while the engine was down, out of the CDR posted by the switch if already received.
+// Otherwise the time we found out, capped at the end of the last debit.
+func (sm *FSSessionManager) recoveredHangupTime(s *Session) time.Time {
+	if sm.cdrDb != nil {
+		cdrs, _, err := sm.cdrDb.GetCdrs(&utils.CdrsFilter{CgrIds: []string{utils.FSCgrId(s.uuid)}})
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not query the CDR of session %s: %v", s.uuid, err))
+		} else if len(cdrs) != 0 && !cdrs[0].AnswerTime.IsZero() {
+			return cdrs[0].AnswerTime.Add(cdrs[0].Duration)
+		}
+	}
+	hangupTime := time.Now()
+	if lastEnd := s.CallCosts[len(s.CallCosts)-1].GetEndTime(); lastEnd.Before(hangupTime) {
+		hangupTime = lastEnd
+	}
+	return hangupTime
+}
+
 func (sm *FSSessionManager) createHandlers() (handlers map[string][]func(string)) {
 	hb := func(body string) {
 		ev := new(FSEvent).New(body)
@@ -91,6 +160,8 @@ func (sm *FSSessionManager) createHandlers() (handlers map[string][]func(string)
 
 // Searches and return the session with the specifed uuid
 func (sm *FSSessionManager) GetSession(uuid string) *Session {
+	sm.sessionsMux.RLock()
+	defer sm.sessionsMux.RUnlock()
 	for _, s := range sm.sessions {
 		if s.uuid == uuid {
 			return s
@@ -99,6 +170,60 @@ func (sm *FSSessionManager) GetSession(uuid string) *Session {
 	return nil
 }
 
+// Returns a copy of the active sessions list
+func (sm *FSSessionManager) GetSessions() []*Session {
+	sm.sessionsMux.RLock()
+	defer sm.sessionsMux.RUnlock()
+	sessions := make([]*Session, len(sm.sessions))
+	copy(sessions, sm.sessions)
+	return sessions
+}
+
+// Call authorized on park, counted with the sessions of its account until answered or hung up
+type authorizedCall struct {
+	direction string
+	tenant    string
+	account   string
+	expires   time.Time // Dropped afterwards in case its hangup was missed
+}
+
+func (sm *FSSessionManager) addAuthorizedCall(uuid, direction, tenant, account string, expires time.Time) {
+	sm.sessionsMux.Lock()
+	defer sm.sessionsMux.Unlock()
+	if sm.authorizedCalls == nil {
+		sm.authorizedCalls = make(map[string]*authorizedCall)
+	}
+	sm.authorizedCalls[uuid] = &authorizedCall{direction: direction, tenant: tenant, account: account, expires: expires}
+}
+
+func (sm *FSSessionManager) removeAuthorizedCall(uuid string) {
+	sm.sessionsMux.Lock()
+	defer sm.sessionsMux.Unlock()
+	delete(sm.authorizedCalls, uuid)
+}
+
+// Returns the number of calls in progress for the account, the ones authorized and not answered yet included
+func (sm *FSSessionManager) countAccountSessions(direction, tenant, account string) (cnt int) {
+	sm.sessionsMux.Lock()
+	defer sm.sessionsMux.Unlock()
+	now := time.Now()
+	for uuid, call := range sm.authorizedCalls {
+		if now.After(call.expires) {
+			delete(sm.authorizedCalls, uuid)
+			continue
+		}
+		if call.direction == direction && call.tenant == tenant && call.account == account {
+			cnt++
+		}
+	}
+	for _, s := range sm.sessions {
+		if s.callDescriptor.Direction == direction && s.callDescriptor.Tenant == tenant && s.callDescriptor.Account == account {
+			cnt++
+		}
+	}
+	return
+}
+
 // Disconnects a session by sending hangup command to freeswitch
 func (sm *FSSessionManager) DisconnectSession(s *Session, notify string) {
 	// engine.Logger.Debug(fmt.Sprintf("Session: %+v", s.uuid))
@@ -115,6 +240,8 @@ func (sm *FSSessionManager) DisconnectSession(s *Session, notify string) {
 
 // Remove session from sessin list
 func (sm *FSSessionManager) RemoveSession(s *Session) {
+	sm.sessionsMux.Lock()
+	defer sm.sessionsMux.Unlock()
 	for i, ss := range sm.sessions {
 		if ss == s {
 			sm.sessions = append(sm.sessions[:i], sm.sessions[i+1:]...)
@@ -165,6 +292,15 @@ func (sm *FSSessionManager) OnChannelPark(ev Event) {
 		engine.Logger.Err(fmt.Sprintf("Missing parameter for %s", ev.GetUUID()))
 		return
 	}
+	if sm.fraudDetector != nil {
+		for _, incident := range sm.fraudDetector.CheckAuthorization(ev.GetUUID(), ev.GetDirection(), ev.GetTenant(), ev.GetAccount(), ev.GetDestination(),
+			sm.countAccountSessions(ev.GetDirection(), ev.GetTenant(), ev.GetAccount())) {
+			if incident.RejectCall {
+				sm.unparkCall(ev.GetUUID(), ev.GetCallDestNr(), FRAUD_DETECTED)
+				return
+			}
+		}
+	}
 	cd := engine.CallDescriptor{
 		Direction:   ev.GetDirection(),
 		Tenant:      ev.GetTenant(),
@@ -190,6 +326,7 @@ func (sm *FSSessionManager) OnChannelPark(ev Event) {
 		return
 	}
 	sm.setMaxCallDuration(ev.GetUUID(), remainingDuration)
+	sm.addAuthorizedCall(ev.GetUUID(), ev.GetDirection(), ev.GetTenant(), ev.GetAccount(), time.Now().Add(cfg.SMMaxCallDuration))
 	sm.unparkCall(ev.GetUUID(), ev.GetCallDestNr(), AUTH_OK)
 }
 
@@ -200,13 +337,17 @@ func (sm *FSSessionManager) OnChannelAnswer(ev Event) {
 		engine.Logger.Err(fmt.Sprintf("Error on attempting to overwrite cgr_type in chan variables: %v", err))
 	}
 	s := NewSession(ev, sm)
+	sm.sessionsMux.Lock()
+	delete(sm.authorizedCalls, ev.GetUUID()) // Counted as session from now on
 	if s != nil {
 		sm.sessions = append(sm.sessions, s)
 	}
+	sm.sessionsMux.Unlock()
 }
 
 func (sm *FSSessionManager) OnChannelHangupComplete(ev Event) {
 	//engine.Logger.Info("<SessionManager> FreeSWITCH hangup.")
+	sm.removeAuthorizedCall(ev.GetUUID()) // Not answered
 	s := sm.GetSession(ev.GetUUID())
 	if s == nil { // Not handled by us
 		return
@@ -241,14 +382,13 @@ func (sm *FSSessionManager) OnChannelHangupComplete(ev Event) {
 			engine.Logger.Err(fmt.Sprintf("Error making the general debit for postpaid call: %v", ev.GetUUID()))
 			return
 		}
-		s.CallCosts = append(s.CallCosts, cc)
+		s.addCallCost(cc, cd.LoopIndex)
 		return
 	}
 
-	if s == nil || len(s.CallCosts) == 0 {
+	if s == nil || s.lastCallCost() == nil {
 		return // why would we have 0 callcosts
 	}
-	lastCC := s.CallCosts[len(s.CallCosts)-1]
 	// put credit back
 	var hangupTime time.Time
 	var err error
@@ -256,57 +396,14 @@ func (sm *FSSessionManager) OnChannelHangupComplete(ev Event) {
 		engine.Logger.Err("Error parsing answer event hangup time, using time.Now!")
 		hangupTime = time.Now()
 	}
-	end := lastCC.Timespans[len(lastCC.Timespans)-1].TimeEnd
-	refundDuration := end.Sub(hangupTime)
-	//initialRefundDuration := refundDuration
-	var refundIncrements engine.Increments
-	for i := len(lastCC.Timespans) - 1; i >= 0; i-- {
-		ts := lastCC.Timespans[i]
-		tsDuration := ts.GetDuration()
-		if refundDuration <= tsDuration {
-			lastRefundedIncrementIndex := 0
-			for j := len(ts.Increments) - 1; j >= 0; j-- {
-				increment := ts.Increments[j]
-				if increment.Duration <= refundDuration {
-					refundIncrements = append(refundIncrements, increment)
-					refundDuration -= increment.Duration
-					lastRefundedIncrementIndex = j
-				}
-			}
-			ts.SplitByIncrement(lastRefundedIncrementIndex)
-			break // do not go to other timespans
-		} else {
-			refundIncrements = append(refundIncrements, ts.Increments...)
-			// remove the timespan entirely
-			lastCC.Timespans[i] = nil
-			lastCC.Timespans = lastCC.Timespans[:i]
-			// continue to the next timespan with what is left to refund
-			refundDuration -= tsDuration
-		}
-	}
-	// show only what was actualy refunded (stopped in timespan)
-	// engine.Logger.Info(fmt.Sprintf("Refund duration: %v", initialRefundDuration-refundDuration))
-	if len(refundIncrements) > 0 {
-		cd := &engine.CallDescriptor{
-			Direction:   lastCC.Direction,
-			Tenant:      lastCC.Tenant,
-			TOR:         lastCC.TOR,
-			Subject:     lastCC.Subject,
-			Account:     lastCC.Account,
-			Destination: lastCC.Destination,
-			Increments:  refundIncrements,
-			// FallbackSubject: lastCC.FallbackSubject, // TODO: check how to best add it
-		}
-		var response float64
-		err := sm.connector.RefundIncrements(*cd, &response)
-		if err != nil {
-			engine.Logger.Err(fmt.Sprintf("Debit cents failed: %v", err))
-		}
-	}
-	cost := refundIncrements.GetTotalCost()
-	lastCC.Cost -= cost
-	// engine.Logger.Info(fmt.Sprintf("Rambursed %v cents", cost))
+	sm.refundSessionCosts(s, hangupTime)
+}
 
+// Refunds the increments debited for the session past the hangup time
+func (sm *FSSessionManager) refundSessionCosts(s *Session, hangupTime time.Time) {
+	lastCC := s.lastCallCost()
+	end := lastCC.Timespans[len(lastCC.Timespans)-1].TimeEnd
+	RefundCallCost(sm.connector, lastCC, end.Sub(hangupTime))
 }
 
 func (sm *FSSessionManager) LoopAction(s *Session, cd *engine.CallDescriptor) (cc *engine.CallCost) {
@@ -323,18 +420,81 @@ func (sm *FSSessionManager) LoopAction(s *Session, cd *engine.CallDescriptor) (c
 		sm.DisconnectSession(s, INSUFFICIENT_FUNDS)
 		return
 	}
-	s.CallCosts = append(s.CallCosts, cc)
+	s.addCallCost(cc, cd.LoopIndex)
+	s.saveState()
+	if s.hasPendingLowBalanceWarnings() {
+		sm.checkLowBalance(s, cd, cc)
+	}
 	return
 }
 
+// Queries the time left to the session after the debited period and warns if it crossed a threshold
+func (sm *FSSessionManager) checkLowBalance(s *Session, cd *engine.CallDescriptor, cc *engine.CallCost) {
+	remCd := *cd
+	remCd.TimeStart = cc.GetEndTime()
+	remCd.TimeEnd = remCd.TimeStart.Add(cfg.SMMaxCallDuration)
+	var maxSessionTime float64
+	if err := sm.connector.GetMaxSessionTime(remCd, &maxSessionTime); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not get remaining time for %s: %v", s.uuid, err))
+		return
+	}
+	if warning := s.lowBalanceWarning(cc.GetDuration() + time.Duration(maxSessionTime)); warning != nil {
+		sm.WarnLowBalance(warning)
+	}
+}
+
+// Enables fraud checks on authorizations
+func (sm *FSSessionManager) SetFraudDetector(fd *engine.FraudDetector) {
+	sm.fraudDetector = fd
+}
+
+// Looks up the hangup times of the calls ended while the engine was down in the CDRs posted by the switch
+func (sm *FSSessionManager) SetCdrDb(cdrDb engine.CdrStorage) {
+	sm.cdrDb = cdrDb
+}
+
+// Hands over the final session costs directly instead of leaving them only in logDb
+func (sm *FSSessionManager) SetCostNotifier(notifier CostNotifier) {
+	sm.costNotifier = notifier
+}
+
+// Registers a function to be called on low balance warnings
+func (sm *FSSessionManager) AddLowBalanceHook(hook LowBalanceHook) {
+	sm.lowBalanceHooks = append(sm.lowBalanceHooks, hook)
+}
+
+// Plays the low balance announcement into the call and passes the warning to the registered hooks
+func (sm *FSSessionManager) WarnLowBalance(warning *LowBalanceWarning) {
+	engine.Logger.Info(fmt.Sprintf("<SessionManager> Low balance on session %s, remaining duration: %v", warning.Uuid, warning.RemainingDuration))
+	if cfg.FreeswitchLowBalanceAnn != "" {
+		if _, err := fsock.FS.SendApiCmd(fmt.Sprintf("uuid_broadcast %s %s aleg\n\n", warning.Uuid, cfg.FreeswitchLowBalanceAnn)); err != nil {
+			engine.Logger.Err(fmt.Sprintf("could not send low balance announcement to freeswitch: %v", err))
+		}
+	}
+	for _, hook := range sm.lowBalanceHooks {
+		hook(warning)
+	}
+}
+
 func (sm *FSSessionManager) GetDebitPeriod() time.Duration {
 	return sm.debitPeriod
 }
 
+func (sm *FSSessionManager) GetDebitMargin() time.Duration {
+	return sm.debitMargin
+}
+
 func (sm *FSSessionManager) GetDbLogger() engine.LogStorage {
 	return sm.loggerDB
 }
 
+// Passes the cost logged at the end of the session to the notifier, if one is set
+func (sm *FSSessionManager) NotifyCallCost(uuid string, cc *engine.CallCost) {
+	if sm.costNotifier != nil {
+		sm.costNotifier(utils.FSCgrId(uuid), cc)
+	}
+}
+
 func (sm *FSSessionManager) Shutdown() (err error) {
 	if fsock.FS == nil || !fsock.FS.Connected() {
 		return errors.New("Cannot shutdown sessions, fsock not connected")
@@ -348,9 +508,9 @@ func (sm *FSSessionManager) Shutdown() (err error) {
 			return
 		}
 	}
-	for guard := 0; len(sm.sessions) > 0 && guard < 20; guard++ {
+	for guard := 0; len(sm.GetSessions()) > 0 && guard < 20; guard++ {
 		time.Sleep(100 * time.Millisecond) // wait for the hungup event to be fired
-		engine.Logger.Info(fmt.Sprintf("<SessionManager> Shutdown waiting on sessions: %v", sm.sessions))
+		engine.Logger.Info(fmt.Sprintf("<SessionManager> Shutdown waiting on sessions: %v", sm.GetSessions()))
 	}
 	return
 }
@@ -19,8 +19,11 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package sessionmanager
 
 import (
-// "github.com/cgrates/cgrates/timespans"
-// "testing"
+	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/engine"
+	"github.com/cgrates/cgrates/utils"
 )
 
 /*func TestConnect(t *testing.T) {
@@ -34,3 +37,49 @@ import (
 	//log.Print(ev)
 	//}
 }*/
+
+func TestRecoveredHangupTime(t *testing.T) {
+	answerTime := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	cc := &engine.CallCost{Timespans: engine.TimeSpans{&engine.TimeSpan{TimeStart: answerTime, TimeEnd: answerTime.Add(time.Minute)}}}
+	s := &Session{uuid: "recovered1", CallCosts: []*engine.CallCost{cc}}
+	cdrDb, _ := engine.NewMapStorage()
+	sm := &FSSessionManager{}
+	if hangupTime := sm.recoveredHangupTime(s); !hangupTime.Equal(answerTime.Add(time.Minute)) { // No CDR storage, end of the last debit
+		t.Error("Unexpected hangup time: ", hangupTime)
+	}
+	sm.SetCdrDb(cdrDb)
+	if hangupTime := sm.recoveredHangupTime(s); !hangupTime.Equal(answerTime.Add(time.Minute)) { // CDR not received yet
+		t.Error("Unexpected hangup time: ", hangupTime)
+	}
+	cdr := &utils.StoredCdr{CgrId: utils.FSCgrId("recovered1"), AccId: "recovered1", CdrHost: "192.168.1.1", AnswerTime: answerTime,
+		Duration: time.Duration(25) * time.Second, ExtraFields: map[string]string{}}
+	if err := cdrDb.SetCdr(cdr); err != nil {
+		t.Fatal(err)
+	}
+	if hangupTime := sm.recoveredHangupTime(s); !hangupTime.Equal(answerTime.Add(time.Duration(25) * time.Second)) {
+		t.Error("Unexpected hangup time: ", hangupTime)
+	}
+}
+
+func TestCountAccountSessions(t *testing.T) {
+	sm := &FSSessionManager{}
+	expires := time.Now().Add(time.Hour)
+	sm.addAuthorizedCall("uuid1", "*out", "cgrates.org", "1001", expires)
+	sm.addAuthorizedCall("uuid2", "*in", "cgrates.org", "1001", expires)                       // Other direction
+	sm.addAuthorizedCall("uuid3", "*out", "cgrates.org", "1001", time.Now().Add(-time.Second)) // Hangup missed
+	sm.sessions = []*Session{&Session{uuid: "uuid4", callDescriptor: &engine.CallDescriptor{Direction: "*out", Tenant: "cgrates.org", Account: "1001"}},
+		&Session{uuid: "uuid5", callDescriptor: &engine.CallDescriptor{Direction: "*out", Tenant: "cgrates.org", Account: "1002"}}}
+	if cnt := sm.countAccountSessions("*out", "cgrates.org", "1001"); cnt != 2 {
+		t.Error("Unexpected sessions: ", cnt)
+	}
+	if _, hasIt := sm.authorizedCalls["uuid3"]; hasIt {
+		t.Error("Expired authorization not removed")
+	}
+	sm.removeAuthorizedCall("uuid1") // Hung up before answer
+	if cnt := sm.countAccountSessions("*out", "cgrates.org", "1001"); cnt != 1 {
+		t.Error("Unexpected sessions: ", cnt)
+	}
+	if cnt := sm.countAccountSessions("*in", "cgrates.org", "1001"); cnt != 1 {
+		t.Error("Unexpected sessions: ", cnt)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package sessionmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
+)
+
+// Warning raised when the time left to a prepaid session drops under one of the configured thresholds.
+// Switch independent, passed to the low balance hooks of the SessionManager.
+type LowBalanceWarning struct {
+	Uuid              string
+	Direction         string
+	Tenant            string
+	Account           string
+	Subject           string
+	Destination       string
+	Threshold         time.Duration // Threshold crossed
+	RemainingDuration time.Duration // Time left to the session, including the period already debited
+}
+
+// Function to be called on low balance warnings
+type LowBalanceHook func(*LowBalanceWarning)
+
+// Hook posting the warnings as JSON to the url in the background, so agents and switches other than FreeSWITCH can act on them
+func NewLowBalancePoster(url string) LowBalanceHook {
+	httpClient := &http.Client{Timeout: time.Duration(10) * time.Second}
+	return func(warning *LowBalanceWarning) {
+		body, err := json.Marshal(warning)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not encode low balance warning for %s: %v", warning.Uuid, err))
+			return
+		}
+		go func() {
+			resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(body))
+			if err != nil {
+				engine.Logger.Err(fmt.Sprintf("<SessionManager> Could not post low balance warning for %s to %s: %v", warning.Uuid, url, err))
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+}
+
+// Returns the low balance thresholds applying to the session, account ones having priority over subject ones
+func (s *Session) lowBalanceThresholds() []time.Duration {
+	if cfg == nil {
+		return nil
+	}
+	if thresholds, hasIt := cfg.SMLowBalanceAccounts[config.LowBalanceKey(s.callDescriptor.Tenant, s.callDescriptor.Account)]; hasIt {
+		return thresholds
+	}
+	if thresholds, hasIt := cfg.SMLowBalanceSubjects[config.LowBalanceKey(s.callDescriptor.Tenant, s.callDescriptor.Subject)]; hasIt {
+		return thresholds
+	}
+	return cfg.SMLowBalanceWarnings
+}
+
+// Checks if there are thresholds the session was not yet warned about
+func (s *Session) hasPendingLowBalanceWarnings() bool {
+	for _, threshold := range s.lowBalanceThresholds() {
+		if s.lowBalanceWarned == 0 || threshold < s.lowBalanceWarned {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the lowest threshold crossed by the remaining duration and not yet warned about, 0 if none
+func (s *Session) crossedLowBalanceThreshold(remaining time.Duration) (crossed time.Duration) {
+	for _, threshold := range s.lowBalanceThresholds() {
+		if remaining >= threshold || (s.lowBalanceWarned != 0 && threshold >= s.lowBalanceWarned) {
+			continue
+		}
+		if crossed == 0 || threshold < crossed {
+			crossed = threshold
+		}
+	}
+	return
+}
+
+// Builds the warning to be passed to hooks for the remaining duration, nil if no new threshold was crossed
+func (s *Session) lowBalanceWarning(remaining time.Duration) *LowBalanceWarning {
+	threshold := s.crossedLowBalanceThreshold(remaining)
+	if threshold == 0 {
+		return nil
+	}
+	s.lowBalanceWarned = threshold
+	return &LowBalanceWarning{
+		Uuid:              s.uuid,
+		Direction:         s.callDescriptor.Direction,
+		Tenant:            s.callDescriptor.Tenant,
+		Account:           s.callDescriptor.Account,
+		Subject:           s.callDescriptor.Subject,
+		Destination:       s.callDescriptor.Destination,
+		Threshold:         threshold,
+		RemainingDuration: remaining,
+	}
+}
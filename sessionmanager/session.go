@@ -20,6 +20,7 @@ package sessionmanager
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/cgrates/cgrates/engine"
@@ -29,11 +30,36 @@ import (
 // Session type holding the call information fields, a session delegate for specific
 // actions and a channel to signal end of the debit loop.
 type Session struct {
-	uuid           string
-	callDescriptor *engine.CallDescriptor
-	sessionManager SessionManager
-	stopDebit      chan bool
-	CallCosts      []*engine.CallCost
+	uuid             string
+	callDescriptor   *engine.CallDescriptor
+	sessionManager   SessionManager
+	stopDebit        chan bool
+	CallCosts        []*engine.CallCost
+	loopIndex        float64       // Index of the last debit loop, used when resuming the session
+	lowBalanceWarned time.Duration // Last low balance threshold the user was warned about
+	clock            Clock         // Time source for the debit loop
+	debitLags        int           // Number of debits the lag was measured on
+	lastDebitLag     time.Duration // Lag of the last debit behind the call, negative when debited ahead
+	maxDebitLag      time.Duration // Biggest lag measured on the session
+	lateDebits       int           // Debits completed after the call entered the period they were covering
+	debitLagMux      sync.RWMutex  // Protects the lag metrics, read also by APIs
+	callCostsMux     sync.RWMutex  // Protects CallCosts and loopIndex, appended by the debit loop while read by APIs
+}
+
+// Snapshot of a running session, used to expose it outside of the SessionManager
+type ActiveSession struct {
+	Uuid         string
+	Direction    string
+	Tenant       string
+	Account      string
+	Subject      string
+	Destination  string
+	TimeStart    time.Time
+	Debited      float64       // Sum of the costs debited so far
+	CallCosts    int           // Number of debits performed so far
+	LastDebitLag time.Duration // How far the last debit lagged behind the call, negative when debited ahead of time
+	MaxDebitLag  time.Duration // Biggest lag of a debit behind the call
+	LateDebits   int           // Debits completed after the call started consuming the period they were covering
 }
 
 // Creates a new session and starts the debit loop
@@ -58,7 +84,8 @@ func NewSession(ev Event, sm SessionManager) (s *Session) {
 		TimeStart:   startTime}
 	s = &Session{uuid: ev.GetUUID(),
 		callDescriptor: cd,
-		stopDebit:      make(chan bool, 2)} //buffer it for multiple close signals
+		stopDebit:      make(chan bool, 2), //buffer it for multiple close signals
+		clock:          realClock{}}
 	s.sessionManager = sm
 	if ev.MissingParameter() {
 		sm.DisconnectSession(s, MISSING_PARAMETER)
@@ -74,10 +101,21 @@ func NewSession(ev Event, sm SessionManager) (s *Session) {
 }
 
 // the debit loop method (to be stoped by sending somenthing on stopDebit channel)
+// Each debit is scheduled on the wall clock, debitMargin before the already debited time is consumed.
+// Deadlines are computed out of the debited time ends, relative to the session start, so the debit latencies do not add up.
 func (s *Session) startDebitLoop() {
 	nextCd := *s.callDescriptor
 	index := 0.0
 	debitPeriod := s.sessionManager.GetDebitPeriod()
+	debitMargin := s.sessionManager.GetDebitMargin()
+	if debitMargin >= debitPeriod { // would debit continuously
+		debitMargin = debitPeriod / 2
+	}
+	if len(s.CallCosts) != 0 { // Resuming a recovered session, continue from the last debit
+		index = s.loopIndex + 1
+		nextCd.TimeEnd = s.CallCosts[len(s.CallCosts)-1].GetEndTime()
+		nextCd.CallDuration = nextCd.TimeEnd.Sub(nextCd.TimeStart)
+	}
 	for {
 		select {
 		case <-s.stopDebit:
@@ -85,29 +123,61 @@ func (s *Session) startDebitLoop() {
 		default:
 		}
 		if index > 0 { // first time use the session start time
+			select { // wait for the debited time to be consumed, up to the margin
+			case <-s.stopDebit:
+				return
+			case <-s.clock.After(nextCd.TimeEnd.Add(-debitMargin).Sub(s.clock.Now())):
+			}
 			nextCd.TimeStart = nextCd.TimeEnd
 		}
 		nextCd.TimeEnd = nextCd.TimeStart.Add(debitPeriod)
 		nextCd.LoopIndex = index
 		nextCd.CallDuration += debitPeriod // first presumed duration
 		cc := s.sessionManager.LoopAction(s, &nextCd)
-		nextCd.TimeEnd = cc.GetEndTime() // set debited timeEnd
+		s.addDebitLag(s.clock.Now().Sub(nextCd.TimeStart), index)
+		if len(cc.Timespans) == 0 { // nothing debited, the period ends now
+			nextCd.TimeEnd = s.clock.Now()
+		} else {
+			nextCd.TimeEnd = cc.GetEndTime() // set debited timeEnd
+		}
 		// update call duration with real debited duration
 		nextCd.CallDuration -= debitPeriod
 		nextCd.CallDuration += nextCd.GetDuration()
-		time.Sleep(cc.GetDuration())
 		index++
 	}
 }
 
-// Returns the session duration till the specified time
-func (s *Session) getSessionDurationFrom(now time.Time) (d time.Duration) {
-	seconds := now.Sub(s.callDescriptor.TimeStart).Seconds()
-	d, err := time.ParseDuration(fmt.Sprintf("%ds", int(seconds)))
-	if err != nil {
-		engine.Logger.Err(fmt.Sprintf("Cannot parse session duration %v", seconds))
+// Updates the lag metrics with the time passed between the start of the debited period and the debit completion
+func (s *Session) addDebitLag(lag time.Duration, index float64) {
+	s.debitLagMux.Lock()
+	defer s.debitLagMux.Unlock()
+	if s.debitLags == 0 || lag > s.maxDebitLag {
+		s.maxDebitLag = lag
+	}
+	s.lastDebitLag = lag
+	s.debitLags++
+	if lag > 0 && index > 0 { // the first debit always follows the answer
+		s.lateDebits++
+		engine.Logger.Warning(fmt.Sprintf("<SessionManager> Debit for session %s lagging %v behind the call", s.uuid, lag))
 	}
-	return
+}
+
+// Appends the cost of one debit
+func (s *Session) addCallCost(cc *engine.CallCost, loopIndex float64) {
+	s.callCostsMux.Lock()
+	defer s.callCostsMux.Unlock()
+	s.CallCosts = append(s.CallCosts, cc)
+	s.loopIndex = loopIndex
+}
+
+// Returns the cost of the last debit, nil if nothing was debited
+func (s *Session) lastCallCost() *engine.CallCost {
+	s.callCostsMux.RLock()
+	defer s.callCostsMux.RUnlock()
+	if len(s.CallCosts) == 0 {
+		return nil
+	}
+	return s.CallCosts[len(s.CallCosts)-1]
 }
 
 // Stops the debit loop
@@ -125,6 +195,53 @@ func (s *Session) Close(ev Event) {
 	}
 	s.SaveOperations()
 	s.sessionManager.RemoveSession(s)
+	s.removeState()
+}
+
+// Removes the persisted state of the session, not needed once the costs are saved
+func (s *Session) removeState() {
+	if s.sessionManager.GetDbLogger() == nil {
+		return
+	}
+	if err := s.sessionManager.GetDbLogger().RemSessionState(s.uuid); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Error removing state of session %s: %v", s.uuid, err))
+	}
+}
+
+// Persists the state of the session so it can be recovered after restarts
+func (s *Session) saveState() {
+	if s.sessionManager.GetDbLogger() == nil {
+		return
+	}
+	s.callCostsMux.RLock()
+	defer s.callCostsMux.RUnlock()
+	ss := &engine.SessionState{Uuid: s.uuid, CallDescriptor: s.callDescriptor, CallCosts: s.CallCosts, LoopIndex: s.loopIndex}
+	if err := s.sessionManager.GetDbLogger().SetSessionState(ss); err != nil {
+		engine.Logger.Err(fmt.Sprintf("<SessionManager> Error saving state of session %s: %v", s.uuid, err))
+	}
+}
+
+// Returns the snapshot of the session as seen at the time of the call
+func (s *Session) AsActiveSession() *ActiveSession {
+	actvSession := &ActiveSession{
+		Uuid:        s.uuid,
+		Direction:   s.callDescriptor.Direction,
+		Tenant:      s.callDescriptor.Tenant,
+		Account:     s.callDescriptor.Account,
+		Subject:     s.callDescriptor.Subject,
+		Destination: s.callDescriptor.Destination,
+		TimeStart:   s.callDescriptor.TimeStart,
+	}
+	s.callCostsMux.RLock()
+	actvSession.CallCosts = len(s.CallCosts)
+	for _, cc := range s.CallCosts {
+		actvSession.Debited += cc.Cost
+	}
+	s.callCostsMux.RUnlock()
+	s.debitLagMux.RLock()
+	actvSession.LastDebitLag, actvSession.MaxDebitLag, actvSession.LateDebits = s.lastDebitLag, s.maxDebitLag, s.lateDebits
+	s.debitLagMux.RUnlock()
+	return actvSession
 }
 
 // Nice print for session
@@ -132,20 +249,37 @@ func (s *Session) String() string {
 	return fmt.Sprintf("%v: %s(%s) -> %s", s.callDescriptor.TimeStart, s.callDescriptor.Subject, s.callDescriptor.Account, s.callDescriptor.Destination)
 }
 
-//
+// Copies the call cost down to its timespans, the ones modified by merging
+func copyCallCost(cc *engine.CallCost) *engine.CallCost {
+	ccCopy := *cc
+	ccCopy.Timespans = make(engine.TimeSpans, len(cc.Timespans))
+	for idx, ts := range cc.Timespans {
+		tsCopy := *ts
+		ccCopy.Timespans[idx] = &tsCopy
+	}
+	return &ccCopy
+}
+
 func (s *Session) SaveOperations() {
 	go func() {
-		if s == nil || len(s.CallCosts) == 0 {
+		if s == nil {
+			return
+		}
+		s.callCostsMux.RLock()
+		if len(s.CallCosts) == 0 {
+			s.callCostsMux.RUnlock()
 			return
 		}
-		firstCC := s.CallCosts[0]
+		firstCC := copyCallCost(s.CallCosts[0]) // Merged into a copy, the session costs stay as debited for the active sessions API
 		for _, cc := range s.CallCosts[1:] {
-			firstCC.Merge(cc)
+			firstCC.Merge(copyCallCost(cc))
 		}
+		s.callCostsMux.RUnlock()
 		if s.sessionManager.GetDbLogger() == nil {
 			engine.Logger.Err("<SessionManager> Error: no connection to logger database, cannot save costs")
 		}
 		s.sessionManager.GetDbLogger().LogCallCost(s.uuid, engine.SESSION_MANAGER_SOURCE, utils.DEFAULT_RUNID, firstCC)
+		s.sessionManager.NotifyCallCost(s.uuid, firstCC)
 		// engine.Logger.Debug(fmt.Sprintf("<SessionManager> End of call, having costs: %v", firstCC.String()))
 	}()
 }
@@ -19,8 +19,15 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package sessionmanager
 
 import (
-	"github.com/cgrates/cgrates/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/cgrates/cgrates/config"
+	"github.com/cgrates/cgrates/engine"
 )
 
 var (
@@ -58,17 +65,6 @@ default_reqtype=
 `)
 )
 
-/*func TestSessionDurationSingle(t *testing.T) {
-	newEvent := new(FSEvent).New(newEventBody)
-	sm := &FSSessionManager{}
-	s := NewSession(newEvent, sm)
-	defer s.Close()
-	twoSeconds, _ := time.ParseDuration("2s")
-	if d := s.getSessionDurationFrom(s.callDescriptor.TimeStart.Add(twoSeconds)); d.Seconds() < 2 || d.Seconds() > 3 {
-		t.Errorf("Wrong session duration %v", d)
-	}
-}*/
-
 func TestSessionNilSession(t *testing.T) {
 	var errCfg error
 	cfg, errCfg = config.NewCGRConfigBytes(conf_data) // Needed here to avoid nil on cfg variable
@@ -82,3 +78,281 @@ func TestSessionNilSession(t *testing.T) {
 		t.Error("no account and it still created session.")
 	}
 }
+
+func TestSessionAsActiveSession(t *testing.T) {
+	tStart := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	s := &Session{uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad",
+		callDescriptor: &engine.CallDescriptor{Direction: "*out", Tenant: "cgrates.org", TOR: "call", Subject: "1001", Account: "1001",
+			Destination: "1002", TimeStart: tStart},
+		CallCosts: []*engine.CallCost{&engine.CallCost{Cost: 0.5}, &engine.CallCost{Cost: 0.25}}}
+	eActvSession := &ActiveSession{Uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad", Direction: "*out", Tenant: "cgrates.org", Account: "1001",
+		Subject: "1001", Destination: "1002", TimeStart: tStart, Debited: 0.75, CallCosts: 2}
+	if actvSession := s.AsActiveSession(); !reflect.DeepEqual(eActvSession, actvSession) {
+		t.Errorf("Expecting: %+v, received: %+v", eActvSession, actvSession)
+	}
+}
+
+func TestSessionLowBalanceWarning(t *testing.T) {
+	var errCfg error
+	if cfg, errCfg = config.NewCGRConfigBytes(conf_data); errCfg != nil {
+		t.Fatalf("Cannot get configuration %v", errCfg)
+	}
+	cfg.SMLowBalanceWarnings = []time.Duration{time.Duration(30) * time.Second, time.Duration(60) * time.Second}
+	cfg.SMLowBalanceAccounts = map[string][]time.Duration{"cgrates.org:1001": []time.Duration{time.Duration(120) * time.Second},
+		"itsyscom.com:rif": []time.Duration{time.Duration(300) * time.Second}}
+	cfg.SMLowBalanceSubjects = map[string][]time.Duration{"cgrates.org:1001": []time.Duration{time.Duration(240) * time.Second},
+		"cgrates.org:premium": []time.Duration{}}
+	s := &Session{uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad",
+		callDescriptor: &engine.CallDescriptor{Direction: "*out", Tenant: "cgrates.org", TOR: "call", Subject: "rif", Account: "rif", Destination: "1002"}}
+	if warning := s.lowBalanceWarning(time.Duration(90) * time.Second); warning != nil {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+	if warning := s.lowBalanceWarning(time.Duration(45) * time.Second); warning == nil || warning.Threshold != time.Duration(60)*time.Second {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+	if warning := s.lowBalanceWarning(time.Duration(40) * time.Second); warning != nil {
+		t.Errorf("Warning twice on the same threshold: %+v", warning)
+	}
+	if !s.hasPendingLowBalanceWarnings() {
+		t.Error("Should have pending warnings")
+	}
+	if warning := s.lowBalanceWarning(time.Duration(10) * time.Second); warning == nil || warning.Threshold != time.Duration(30)*time.Second ||
+		warning.RemainingDuration != time.Duration(10)*time.Second || warning.Account != "rif" {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+	if s.hasPendingLowBalanceWarnings() {
+		t.Error("Should not have pending warnings")
+	}
+	s.callDescriptor.Account = "1001" // Account profile overwrites defaults, and the profile of the subject with the same name
+	s.callDescriptor.Subject = "1001"
+	s.lowBalanceWarned = 0
+	if warning := s.lowBalanceWarning(time.Duration(100) * time.Second); warning == nil || warning.Threshold != time.Duration(120)*time.Second {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+	s.callDescriptor.Account = "1002" // Subject profile applies when the account has none
+	s.lowBalanceWarned = 0
+	if warning := s.lowBalanceWarning(time.Duration(200) * time.Second); warning == nil || warning.Threshold != time.Duration(240)*time.Second {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+	s.callDescriptor.Subject = "premium" // Warnings disabled by the subject profile
+	s.lowBalanceWarned = 0
+	if s.hasPendingLowBalanceWarnings() {
+		t.Error("Should not have pending warnings")
+	}
+	s.callDescriptor.Account, s.callDescriptor.Subject = "rif", "rif" // Profile of the same account in another tenant not applied
+	s.lowBalanceWarned = 0
+	if warning := s.lowBalanceWarning(time.Duration(100) * time.Second); warning != nil {
+		t.Errorf("Unexpected warning: %+v", warning)
+	}
+}
+
+func TestLowBalancePoster(t *testing.T) {
+	posted := make(chan *LowBalanceWarning, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var warning LowBalanceWarning
+		if err := json.NewDecoder(r.Body).Decode(&warning); err != nil {
+			t.Error(err)
+		}
+		posted <- &warning
+	}))
+	defer srv.Close()
+	warning := &LowBalanceWarning{Uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad", Direction: "*out", Tenant: "cgrates.org", Account: "1001",
+		Subject: "1001", Destination: "1002", Threshold: time.Duration(60) * time.Second, RemainingDuration: time.Duration(45) * time.Second}
+	NewLowBalancePoster(srv.URL)(warning)
+	select {
+	case received := <-posted:
+		if !reflect.DeepEqual(warning, received) {
+			t.Errorf("Expecting: %+v, received: %+v", warning, received)
+		}
+	case <-time.After(time.Second):
+		t.Error("Warning not posted")
+	}
+}
+
+// Clock advancing instantly on waits, recording them
+type testClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (clk *testClock) Now() time.Time {
+	return clk.now
+}
+
+func (clk *testClock) After(d time.Duration) <-chan time.Time {
+	clk.waits = append(clk.waits, d)
+	if d > 0 {
+		clk.now = clk.now.Add(d)
+	}
+	ch := make(chan time.Time, 1)
+	ch <- clk.now
+	return ch
+}
+
+// SessionManager debiting the requested periods with a fixed latency
+type testSessionManager struct {
+	clock       *testClock
+	latencies   []time.Duration // Latency of each debit, the session is stopped once all are consumed
+	debitPeriod time.Duration
+	debitMargin time.Duration
+	debits      []*engine.CallDescriptor
+	debitTimes  []time.Time // Wall clock time when each debit was issued
+	noCredit    bool        // Debits return empty costs
+	loggerDb    engine.LogStorage
+	costs       chan *engine.CallCost // Receives the final costs if not nil
+}
+
+func (sm *testSessionManager) Connect(*config.CGRConfig) error    { return nil }
+func (sm *testSessionManager) GetSessions() []*Session            { return nil }
+func (sm *testSessionManager) DisconnectSession(*Session, string) {}
+func (sm *testSessionManager) RemoveSession(*Session)             {}
+func (sm *testSessionManager) WarnLowBalance(*LowBalanceWarning)  {}
+func (sm *testSessionManager) GetDebitPeriod() time.Duration      { return sm.debitPeriod }
+func (sm *testSessionManager) GetDebitMargin() time.Duration      { return sm.debitMargin }
+func (sm *testSessionManager) GetDbLogger() engine.LogStorage     { return sm.loggerDb }
+func (sm *testSessionManager) Shutdown() error                    { return nil }
+
+func (sm *testSessionManager) NotifyCallCost(uuid string, cc *engine.CallCost) {
+	if sm.costs != nil {
+		sm.costs <- cc
+	}
+}
+
+func (sm *testSessionManager) LoopAction(s *Session, cd *engine.CallDescriptor) *engine.CallCost {
+	cdCopy := *cd
+	sm.debits = append(sm.debits, &cdCopy)
+	sm.debitTimes = append(sm.debitTimes, sm.clock.now)
+	sm.clock.now = sm.clock.now.Add(sm.latencies[len(sm.debits)-1])
+	if len(sm.debits) == len(sm.latencies) {
+		s.stopDebit <- true
+	}
+	if sm.noCredit {
+		return &engine.CallCost{}
+	}
+	cc := &engine.CallCost{Timespans: engine.TimeSpans{&engine.TimeSpan{TimeStart: cd.TimeStart, TimeEnd: cd.TimeEnd}}}
+	s.addCallCost(cc, cd.LoopIndex)
+	return cc
+}
+
+func TestSessionSaveOperations(t *testing.T) {
+	logDb, _ := engine.NewMapStorage()
+	sm := &testSessionManager{loggerDb: logDb, costs: make(chan *engine.CallCost, 1)}
+	tStart := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	s := &Session{uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad", sessionManager: sm,
+		callDescriptor: &engine.CallDescriptor{Direction: "*out", Tenant: "cgrates.org", Account: "1001", TimeStart: tStart},
+		CallCosts: []*engine.CallCost{
+			&engine.CallCost{Cost: 0.5, Timespans: engine.TimeSpans{&engine.TimeSpan{TimeStart: tStart, TimeEnd: tStart.Add(time.Duration(10) * time.Second)}}},
+			&engine.CallCost{Cost: 0.25, Timespans: engine.TimeSpans{&engine.TimeSpan{TimeStart: tStart.Add(time.Duration(10) * time.Second),
+				TimeEnd: tStart.Add(time.Duration(20) * time.Second)}}}}}
+	s.SaveOperations()
+	select {
+	case cc := <-sm.costs:
+		if cc.Cost != 0.75 || cc.GetDuration() != time.Duration(20)*time.Second {
+			t.Errorf("Unexpected cost: %+v", cc)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Cost not saved")
+	}
+	// Saving leaves the costs of the session as they were debited
+	if actvSession := s.AsActiveSession(); actvSession.Debited != 0.75 || actvSession.CallCosts != 2 {
+		t.Errorf("Unexpected active session: %+v", actvSession)
+	}
+	if s.CallCosts[0].Cost != 0.5 || s.CallCosts[0].GetDuration() != time.Duration(10)*time.Second {
+		t.Errorf("First cost modified: %+v", s.CallCosts[0])
+	}
+}
+
+func TestSessionDebitLoopDeadlines(t *testing.T) {
+	tStart := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	clock := &testClock{now: tStart}
+	latency := time.Duration(300) * time.Millisecond
+	sm := &testSessionManager{clock: clock, latencies: []time.Duration{latency, latency, latency, latency},
+		debitPeriod: time.Duration(10) * time.Second, debitMargin: time.Duration(1) * time.Second}
+	s := &Session{uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad", callDescriptor: &engine.CallDescriptor{TimeStart: tStart},
+		sessionManager: sm, stopDebit: make(chan bool, 2), clock: clock}
+	s.startDebitLoop()
+	if len(sm.debits) != 4 {
+		t.Fatalf("Unexpected debits: %+v", sm.debits)
+	}
+	// Debits issued one margin before the debited time ends, without accumulating the latency
+	eDebitTimes := []time.Time{tStart, tStart.Add(time.Duration(9) * time.Second), tStart.Add(time.Duration(19) * time.Second),
+		tStart.Add(time.Duration(29) * time.Second)}
+	if !reflect.DeepEqual(eDebitTimes, sm.debitTimes) {
+		t.Errorf("Expecting: %+v, received: %+v", eDebitTimes, sm.debitTimes)
+	}
+	for i, cd := range sm.debits {
+		if eStart := tStart.Add(time.Duration(i*10) * time.Second); !cd.TimeStart.Equal(eStart) || cd.GetDuration() != sm.debitPeriod {
+			t.Errorf("Debit %d, unexpected period: %v - %v", i, cd.TimeStart, cd.TimeEnd)
+		}
+		if cd.CallDuration != time.Duration((i+1)*10)*time.Second {
+			t.Errorf("Debit %d, unexpected call duration: %v", i, cd.CallDuration)
+		}
+	}
+	actvSession := s.AsActiveSession()
+	if actvSession.LastDebitLag != latency-sm.debitMargin || actvSession.MaxDebitLag != latency || actvSession.LateDebits != 0 {
+		t.Errorf("Unexpected lag metrics: %+v", actvSession)
+	}
+}
+
+func TestSessionDebitLoopLateDebits(t *testing.T) {
+	tStart := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	clock := &testClock{now: tStart}
+	sm := &testSessionManager{clock: clock, latencies: []time.Duration{time.Duration(100) * time.Millisecond, time.Duration(1500) * time.Millisecond,
+		time.Duration(200) * time.Millisecond}, debitPeriod: time.Duration(10) * time.Second, debitMargin: time.Duration(1) * time.Second}
+	s := &Session{uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad", callDescriptor: &engine.CallDescriptor{TimeStart: tStart},
+		sessionManager: sm, stopDebit: make(chan bool, 2), clock: clock}
+	s.startDebitLoop()
+	// A slow debit does not delay the following ones
+	if eDebitTime := tStart.Add(time.Duration(19) * time.Second); !sm.debitTimes[2].Equal(eDebitTime) {
+		t.Errorf("Expecting: %v, received: %v", eDebitTime, sm.debitTimes[2])
+	}
+	actvSession := s.AsActiveSession()
+	if actvSession.MaxDebitLag != time.Duration(500)*time.Millisecond || actvSession.LateDebits != 1 {
+		t.Errorf("Unexpected lag metrics: %+v", actvSession)
+	}
+}
+
+func TestSessionDebitLoopEmptyDebits(t *testing.T) {
+	tStart := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	clock := &testClock{now: tStart}
+	latency := time.Duration(300) * time.Millisecond
+	sm := &testSessionManager{clock: clock, latencies: []time.Duration{latency, latency}, noCredit: true,
+		debitPeriod: time.Duration(10) * time.Second, debitMargin: time.Duration(1) * time.Second}
+	s := &Session{uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad", callDescriptor: &engine.CallDescriptor{TimeStart: tStart},
+		sessionManager: sm, stopDebit: make(chan bool, 2), clock: clock}
+	s.startDebitLoop()
+	if len(sm.debits) != 2 {
+		t.Fatalf("Unexpected debits: %+v", sm.debits)
+	}
+	// Nothing debited, the next period starts at the time of the failed debit
+	if eStart := tStart.Add(latency); !sm.debits[1].TimeStart.Equal(eStart) || sm.debits[1].CallDuration != latency+sm.debitPeriod {
+		t.Errorf("Unexpected period: %v, call duration: %v", sm.debits[1].TimeStart, sm.debits[1].CallDuration)
+	}
+}
+
+func TestSessionAsActiveSessionWhileDebiting(t *testing.T) {
+	tStart := time.Date(2013, 12, 7, 8, 42, 24, 0, time.UTC)
+	clock := &testClock{now: tStart}
+	latencies := make([]time.Duration, 100)
+	sm := &testSessionManager{clock: clock, latencies: latencies, debitPeriod: time.Duration(10) * time.Second, debitMargin: time.Duration(1) * time.Second}
+	s := &Session{uuid: "e3133bf7-dcde-4daf-9663-9a79ffcef5ad", callDescriptor: &engine.CallDescriptor{TimeStart: tStart},
+		sessionManager: sm, stopDebit: make(chan bool, 2), clock: clock}
+	loopDone := make(chan struct{})
+	go func() {
+		s.startDebitLoop()
+		close(loopDone)
+	}()
+	for debiting := true; debiting; {
+		select {
+		case <-loopDone:
+			debiting = false
+		default:
+		}
+		if actvSession := s.AsActiveSession(); actvSession.CallCosts > len(latencies) {
+			t.Fatalf("Unexpected session: %+v", actvSession)
+		}
+	}
+	if actvSession := s.AsActiveSession(); actvSession.CallCosts != len(latencies) {
+		t.Errorf("Unexpected session: %+v", actvSession)
+	}
+}
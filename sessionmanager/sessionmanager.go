@@ -19,6 +19,7 @@ along with this program.  If not, see <http://www.gnu.org/licenses/>
 package sessionmanager
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/cgrates/cgrates/config"
@@ -27,10 +28,70 @@ import (
 
 type SessionManager interface {
 	Connect(*config.CGRConfig) error
+	GetSessions() []*Session
 	DisconnectSession(*Session, string)
 	RemoveSession(*Session)
 	LoopAction(*Session, *engine.CallDescriptor) *engine.CallCost
+	WarnLowBalance(*LowBalanceWarning)
 	GetDebitPeriod() time.Duration
+	GetDebitMargin() time.Duration
 	GetDbLogger() engine.LogStorage
+	NotifyCallCost(string, *engine.CallCost)
 	Shutdown() error
 }
+
+// Function receiving the final cost of a session, indexed on the cgrid of its CDR
+type CostNotifier func(string, *engine.CallCost)
+
+// Refunds the last refundDuration out of the debited cost, shared by the session managers and agents
+func RefundCallCost(connector engine.Connector, lastCC *engine.CallCost, refundDuration time.Duration) {
+	//initialRefundDuration := refundDuration
+	var refundIncrements engine.Increments
+	for i := len(lastCC.Timespans) - 1; i >= 0; i-- {
+		ts := lastCC.Timespans[i]
+		tsDuration := ts.GetDuration()
+		if refundDuration <= tsDuration {
+			lastRefundedIncrementIndex := 0
+			for j := len(ts.Increments) - 1; j >= 0; j-- {
+				increment := ts.Increments[j]
+				if increment.Duration <= refundDuration {
+					refundIncrements = append(refundIncrements, increment)
+					refundDuration -= increment.Duration
+					lastRefundedIncrementIndex = j
+				}
+			}
+			ts.SplitByIncrement(lastRefundedIncrementIndex)
+			break // do not go to other timespans
+		} else {
+			refundIncrements = append(refundIncrements, ts.Increments...)
+			// remove the timespan entirely
+			lastCC.Timespans[i] = nil
+			lastCC.Timespans = lastCC.Timespans[:i]
+			// continue to the next timespan with what is left to refund
+			refundDuration -= tsDuration
+		}
+	}
+	// show only what was actualy refunded (stopped in timespan)
+	// engine.Logger.Info(fmt.Sprintf("Refund duration: %v", initialRefundDuration-refundDuration))
+	if len(refundIncrements) > 0 {
+		cd := &engine.CallDescriptor{
+			Direction:   lastCC.Direction,
+			Tenant:      lastCC.Tenant,
+			TOR:         lastCC.TOR,
+			Subject:     lastCC.Subject,
+			Account:     lastCC.Account,
+			Destination: lastCC.Destination,
+			Increments:  refundIncrements,
+			// FallbackSubject: lastCC.FallbackSubject, // TODO: check how to best add it
+		}
+		var response float64
+		err := connector.RefundIncrements(*cd, &response)
+		if err != nil {
+			engine.Logger.Err(fmt.Sprintf("Debit cents failed: %v", err))
+		}
+	}
+	cost := refundIncrements.GetTotalCost()
+	lastCC.Cost -= cost
+	// engine.Logger.Info(fmt.Sprintf("Rambursed %v cents", cost))
+
+}
@@ -307,6 +307,7 @@ type CachedItemAge struct {
 
 type AttrExpFileCdrs struct {
 	CdrFormat    string // Cdr output file format <utils.CdreCdrFormats>
+	ExportTemplate string // Export template defined in config, overwrites CdrFormat
 	TimeStart    string // If provided, will represent the starting of the CDRs interval (>=)
 	TimeEnd      string // If provided, will represent the end of the CDRs interval (<)
 	SkipErrors bool   // Do not export errored CDRs
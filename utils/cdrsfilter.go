@@ -0,0 +1,166 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Fields the CDRs can be ordered on when queried
+var CdrsOrderFields = []string{CGRID, ACCID, CDRSOURCE, REQTYPE, TENANT, ACCOUNT, SUBJECT, DESTINATION, ANSWER_TIME, DURATION, MEDI_RUNID, COST}
+
+// Filters applied when querying stored CDRs, empty filters are not considered
+type CdrsFilter struct {
+	CgrIds              []string
+	AccIds              []string
+	Tenants             []string
+	Accounts            []string
+	Subjects            []string
+	DestinationPrefixes []string
+	ReqTypes            []string
+	MediationRunIds     []string
+	CdrSources          []string
+	AnswerTimeStart     time.Time     // Answer time start (>=), zero to ignore
+	AnswerTimeEnd       time.Time     // Answer time end (<), zero to ignore
+	MinCost             *float64      // Cost range start (>=), nil to ignore. Cost filters match only rated CDRs
+	MaxCost             *float64      // Cost range end (<), nil to ignore
+	MinDuration         time.Duration // Duration range start (>=), zero to ignore
+	MaxDuration         time.Duration // Duration range end (<), zero to ignore
+	NotExportedBy       string        // Skip the CDRs marked as exported by this export job, empty to ignore
+	CostPending         bool          // Only the rated CDRs stored without cost, waiting for their session cost
+	OrderBy             string        // One of the CdrsOrderFields, answer_time if empty
+	OrderDescending     bool
+	Limit               int  // Maximum number of CDRs returned, 0 for unlimited
+	Offset              int  // Number of matching CDRs skipped
+	Count               bool // Return only the number of matching CDRs
+}
+
+// Checks one CDR against the filters, CDRs not mediated should have empty MediationRunId
+func (fltr *CdrsFilter) Matches(cdr *StoredCdr) bool {
+	for _, fltrVals := range []struct {
+		vals []string
+		val  string
+	}{
+		{fltr.CgrIds, cdr.CgrId},
+		{fltr.AccIds, cdr.AccId},
+		{fltr.Tenants, cdr.Tenant},
+		{fltr.Accounts, cdr.Account},
+		{fltr.Subjects, cdr.Subject},
+		{fltr.ReqTypes, cdr.ReqType},
+		{fltr.MediationRunIds, cdr.MediationRunId},
+		{fltr.CdrSources, cdr.CdrSource},
+	} {
+		if len(fltrVals.vals) != 0 && !IsSliceMember(fltrVals.vals, fltrVals.val) {
+			return false
+		}
+	}
+	if len(fltr.DestinationPrefixes) != 0 {
+		matched := false
+		for _, prefix := range fltr.DestinationPrefixes {
+			if strings.HasPrefix(cdr.Destination, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if (!fltr.AnswerTimeStart.IsZero() && cdr.AnswerTime.Before(fltr.AnswerTimeStart)) ||
+		(!fltr.AnswerTimeEnd.IsZero() && !cdr.AnswerTime.Before(fltr.AnswerTimeEnd)) {
+		return false
+	}
+	if (fltr.MinCost != nil || fltr.MaxCost != nil) && len(cdr.MediationRunId) == 0 {
+		return false
+	}
+	if (fltr.MinCost != nil && cdr.Cost < *fltr.MinCost) || (fltr.MaxCost != nil && cdr.Cost >= *fltr.MaxCost) {
+		return false
+	}
+	if (fltr.MinDuration != 0 && cdr.Duration < fltr.MinDuration) || (fltr.MaxDuration != 0 && cdr.Duration >= fltr.MaxDuration) {
+		return false
+	}
+	return true
+}
+
+// Parameters of ApierV1.GetCdrs, times and durations as strings so they can be passed from console
+type AttrGetCdrs struct {
+	CgrIds              []string
+	AccIds              []string
+	Tenants             []string
+	Accounts            []string
+	Subjects            []string
+	DestinationPrefixes []string
+	ReqTypes            []string
+	MediationRunIds     []string
+	CdrSources          []string
+	AnswerTimeStart     string   // Answer time start (>=), empty to ignore
+	AnswerTimeEnd       string   // Answer time end (<), empty to ignore
+	MinCost             *float64 // Cost range start (>=), nil to ignore
+	MaxCost             *float64 // Cost range end (<), nil to ignore
+	MinDuration         string   // Duration range start (>=), eg: 60s, empty to ignore
+	MaxDuration         string   // Duration range end (<), empty to ignore
+	OrderBy             string   // One of the CdrsOrderFields, answer_time if empty
+	OrderDescending     bool
+	Limit               int  // Maximum number of CDRs returned, 0 for unlimited
+	Offset              int  // Number of matching CDRs skipped
+	Count               bool // Return only the number of matching CDRs
+}
+
+func (attr *AttrGetCdrs) AsCdrsFilter() (*CdrsFilter, error) {
+	fltr := &CdrsFilter{CgrIds: attr.CgrIds, AccIds: attr.AccIds, Tenants: attr.Tenants, Accounts: attr.Accounts, Subjects: attr.Subjects,
+		DestinationPrefixes: attr.DestinationPrefixes, ReqTypes: attr.ReqTypes, MediationRunIds: attr.MediationRunIds, CdrSources: attr.CdrSources,
+		MinCost: attr.MinCost, MaxCost: attr.MaxCost, OrderBy: attr.OrderBy, OrderDescending: attr.OrderDescending, Limit: attr.Limit, Offset: attr.Offset,
+		Count: attr.Count}
+	var err error
+	if len(attr.AnswerTimeStart) != 0 {
+		if fltr.AnswerTimeStart, err = ParseTimeDetectLayout(attr.AnswerTimeStart); err != nil {
+			return nil, err
+		}
+	}
+	if len(attr.AnswerTimeEnd) != 0 {
+		if fltr.AnswerTimeEnd, err = ParseTimeDetectLayout(attr.AnswerTimeEnd); err != nil {
+			return nil, err
+		}
+	}
+	if len(attr.MinDuration) != 0 {
+		if fltr.MinDuration, err = ParseDurationWithSecs(attr.MinDuration); err != nil {
+			return nil, err
+		}
+	}
+	if len(attr.MaxDuration) != 0 {
+		if fltr.MaxDuration, err = ParseDurationWithSecs(attr.MaxDuration); err != nil {
+			return nil, err
+		}
+	}
+	if len(fltr.OrderBy) != 0 && !IsSliceMember(CdrsOrderFields, fltr.OrderBy) {
+		return nil, fmt.Errorf("Unsupported OrderBy field: %s", fltr.OrderBy)
+	}
+	if fltr.Limit < 0 || fltr.Offset < 0 {
+		return nil, fmt.Errorf("Negative Limit or Offset")
+	}
+	return fltr, nil
+}
+
+// Reply of ApierV1.GetCdrs
+type GetCdrsReply struct {
+	Count int          // Number of CDRs matching the filters, without considering Limit and Offset in count mode
+	Cdrs  []*StoredCdr // CDRs matching the filters, empty in count mode
+}
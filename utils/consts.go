@@ -20,6 +20,11 @@ const (
 	ERR_MANDATORY_IE_MISSING   = "MANDATORY_IE_MISSING"
 	ERR_EXISTS                 = "EXISTS"
 	ERR_BROKEN_REFERENCE       = "BROKEN_REFERENCE"
+	ERR_DUPLICATE              = "DUPLICATE"
+	ERR_INVALID_CDR            = "INVALID_CDR"
+	ERR_JOB_RUNNING            = "JOB_RUNNING"
+	HTTP_CGR                   = "http_cgr"
+	HTTP_JSON                  = "http_json"
 	TBL_TP_TIMINGS             = "tp_timings"
 	TBL_TP_DESTINATIONS        = "tp_destinations"
 	TBL_TP_RATES               = "tp_rates"
@@ -34,6 +39,12 @@ const (
 	TBL_CDRS_EXTRA             = "cdrs_extra"
 	TBL_COST_DETAILS           = "cost_details"
 	TBL_RATED_CDRS             = "rated_cdrs"
+	TBL_CDRS_EXPORTED          = "cdrs_exported"
+	TBL_SESSION_STATES         = "session_states"
+	TBL_INVOICES               = "invoices"
+	TBL_INVOICE_SEQUENCES      = "invoice_sequences"
+	TBL_INVOICE_ADJUSTMENTS    = "invoice_adjustments"
+	INVOICE_NUMBER_DIGITS      = 6 // Sequence digits of the invoice numbers, zero padded after their prefix
 	TIMINGS_CSV                = "Timings.csv"
 	DESTINATIONS_CSV           = "Destinations.csv"
 	RATES_CSV                  = "Rates.csv"
@@ -44,6 +55,7 @@ const (
 	ACTION_PLANS_CSV           = "ActionPlans.csv"
 	ACTION_TRIGGERS_CSV        = "ActionTriggers.csv"
 	ACCOUNT_ACTIONS_CSV        = "AccountActions.csv"
+	DERIVED_CHARGING_CSV       = "DerivedCharging.csv"
 	TIMINGS_NRCOLS             = 6
 	DESTINATIONS_NRCOLS        = 2
 	RATES_NRCOLS               = 8
@@ -54,6 +66,7 @@ const (
 	ACTION_PLANS_NRCOLS        = 4
 	ACTION_TRIGGERS_NRCOLS     = 8
 	ACCOUNT_ACTIONS_NRCOLS     = 5
+	DERIVED_CHARGING_NRCOLS    = 12
 	ROUNDING_UP                = "*up"
 	ROUNDING_MIDDLE            = "*middle"
 	ROUNDING_DOWN              = "*down"
@@ -61,6 +74,7 @@ const (
 	COMMENT_CHAR               = '#'
 	CSV_SEP                    = ','
 	FALLBACK_SEP               = ';'
+	INFIELD_SEP                = ";"
 	JSON                       = "json"
 	MSGPACK                    = "msgpack"
 	CSV_LOAD                   = "CSVLOAD"
@@ -77,14 +91,61 @@ const (
 	DESTINATION                = "destination"
 	ANSWER_TIME                = "answer_time"
 	DURATION                   = "duration"
+	MEDI_RUNID                 = "mediation_runid"
+	COST                       = "cost"
 	DEFAULT_RUNID              = "default"
 	STATIC_VALUE_PREFIX        = "^"
+	REGEXP_PREFIX              = "~"
 	CDRE_CSV                   = "csv"
 	CDRE_DRYRUN                = "dry_run"
+	CDRE_FIXED_WIDTH           = "fixed_width"
+	CDRE_JSON_LINES            = "json_lines"
+	CDRE_CDRS_NUMBER           = "*cdrs_number"    // Number of exported CDRs, usable in export templates
+	CDRE_TOTAL_COST            = "*total_cost"     // Sum of the exported costs
+	CDRE_TOTAL_DURATION        = "*total_duration" // Sum of the exported durations
+	CDRE_FIRST_CDR_TIME        = "*first_cdr_time" // Earliest answer time of the exported CDRs
+	CDRE_LAST_CDR_TIME         = "*last_cdr_time"  // Latest answer time of the exported CDRs
+	CDRE_EXPORT_TIME           = "*export_time"    // Time the export file was generated
+	CDRE_JOB_ID                = "*job_id"         // Id of the export job, usable in export file names
+	CDRE_TIME_START            = "*time_start"     // Start of the period exported by the job
+	CDRE_TIME_END              = "*time_end"       // End of the period exported by the job
+	PREVIOUS_DAY               = "*previous_day"   // Period of the scheduled jobs covering the day before their run
+	PREVIOUS_MONTH             = "*previous_month" // Period of the scheduled jobs covering the month before their run
+	JOB_OK                     = "*ok"             // Status of the scheduled jobs after their last run
+	JOB_FAILED                 = "*failed"
+	PADDING_LEFT               = "left"
+	PADDING_RIGHT              = "right"
+	PADDING_ZEROLEFT           = "zeroleft"
 	INTERNAL                   = "internal"
 	ZERO_RATING_SUBJECT_PREFIX = "*zero"
+	STATS_ASR                  = "ASR" // Answer seizure ratio, percentage of answered calls
+	STATS_ACD                  = "ACD" // Average call duration, in seconds
+	STATS_ACC                  = "ACC" // Average call cost
+	STATS_TCC                  = "TCC" // Total call cost
+	STATS_TCD                  = "TCD" // Total call duration, in seconds
+	STATS_MIN                  = "*min"
+	STATS_MAX                  = "*max"
+	FRAUD_MAX_ACCOUNT_COST     = "*max_account_cost"     // Account cost within the time window over threshold
+	FRAUD_NEW_ACCOUNT_COST     = "*new_account_cost"     // Cost of an account with no previous traffic within the time window over threshold
+	FRAUD_RISKY_DESTINATION    = "*risky_destination"    // Calls towards the configured destination prefixes
+	FRAUD_MAX_CONCURRENT_CALLS = "*max_concurrent_calls" // Simultaneous calls of an account over threshold
+	STATS_DDC                  = "DDC"                   // Duplicate CDRs received within the time window, since start if no time window
+	DUPLICATE_SKIP             = "*skip"                 // Do not mediate duplicate CDRs
+	DUPLICATE_RERATE           = "*rerate"               // Mediate duplicate CDRs again, without debiting
+	COST_PENDING               = "*cost_pending"         // Rated CDRs whose session cost did not arrive in time, kept for re-mediation
+	CORRELATED_RUNID           = "*correlated"           // Mediation run of the A-leg combining the legs of one call
+	CORRELATION_ID             = "correlation_id"        // Extra fields of the combined CDRs
+	BLEG_CGRIDS                = "bleg_cgrids"
+	CUSTOMER_COST              = "customer_cost"
+	SUPPLIER_COST              = "supplier_cost"
+	MARGIN                     = "margin"
 )
 
 var (
-	CdreCdrFormats = []string{CDRE_CSV, CDRE_DRYRUN}
+	CdreCdrFormats    = []string{CDRE_CSV, CDRE_DRYRUN}
+	CdreTplFormats    = []string{CDRE_CSV, CDRE_FIXED_WIDTH, CDRE_JSON_LINES}
+	CdreVariables     = []string{CDRE_CDRS_NUMBER, CDRE_TOTAL_COST, CDRE_TOTAL_DURATION, CDRE_FIRST_CDR_TIME, CDRE_LAST_CDR_TIME, CDRE_EXPORT_TIME}
+	CdrStatsMetrics   = []string{STATS_ASR, STATS_ACD, STATS_ACC, STATS_TCC, STATS_TCD, STATS_DDC}
+	FraudRuleTypes    = []string{FRAUD_MAX_ACCOUNT_COST, FRAUD_NEW_ACCOUNT_COST, FRAUD_RISKY_DESTINATION, FRAUD_MAX_CONCURRENT_CALLS}
+	CorrelationFields = []string{CORRELATION_ID, BLEG_CGRIDS, CUSTOMER_COST, SUPPLIER_COST, MARGIN} // Extra fields kept with the *correlated run
 )
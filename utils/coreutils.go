@@ -51,6 +51,11 @@ func FSCgrId(uuid string) string {
 	return SHA1(uuid)
 }
 
+// Cgrid of a CDR reusing the accid of one received before from another CdrHost or CdrSource
+func OriginCgrId(accId, cdrHost, cdrSource string) string {
+	return SHA1(accId + INFIELD_SEP + cdrHost + INFIELD_SEP + cdrSource)
+}
+
 func NewTPid() string {
 	return SHA1(GenUUID())
 }
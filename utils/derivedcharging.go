@@ -0,0 +1,225 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Template building a CDR field value out of another one: ^static_value, field_name or ~field_name:s/regexp/replacement/
+type RSRField struct {
+	Id              string // Name of the CDR field the value is taken from, empty for static values
+	staticValue     string
+	searchRegexp    *regexp.Regexp
+	replaceTemplate string
+}
+
+func NewRSRField(fldStr string) (*RSRField, error) {
+	if len(fldStr) == 0 {
+		return nil, nil
+	}
+	if strings.HasPrefix(fldStr, STATIC_VALUE_PREFIX) {
+		return &RSRField{staticValue: fldStr[len(STATIC_VALUE_PREFIX):]}, nil
+	}
+	if !strings.HasPrefix(fldStr, REGEXP_PREFIX) {
+		return &RSRField{Id: fldStr}, nil
+	}
+	sepIdx := strings.Index(fldStr, ":s/")
+	if sepIdx == -1 || !strings.HasSuffix(fldStr, "/") {
+		return nil, fmt.Errorf("Invalid search&replace field: %s", fldStr)
+	}
+	rules := fldStr[sepIdx+3 : len(fldStr)-1]
+	replaceIdx := strings.LastIndex(rules, "/")
+	if replaceIdx == -1 {
+		return nil, fmt.Errorf("Invalid search&replace field: %s", fldStr)
+	}
+	searchRegexp, err := regexp.Compile(rules[:replaceIdx])
+	if err != nil {
+		return nil, err
+	}
+	return &RSRField{Id: fldStr[len(REGEXP_PREFIX):sepIdx], searchRegexp: searchRegexp, replaceTemplate: rules[replaceIdx+1:]}, nil
+}
+
+// Builds the value out of the one of the source field. Values not matching the search regexp are returned unchanged.
+func (rsrf *RSRField) ParseValue(value string) string {
+	if len(rsrf.Id) == 0 {
+		return rsrf.staticValue
+	}
+	if rsrf.searchRegexp == nil {
+		return value
+	}
+	match := rsrf.searchRegexp.FindStringSubmatchIndex(value)
+	if match == nil {
+		return value
+	}
+	return string(rsrf.searchRegexp.ExpandString(nil, rsrf.replaceTemplate, value, match))
+}
+
+// Rule deciding whether and how the mediator forks a CDR into one of its runs
+type DerivedChargingRule struct {
+	Id               string            // Unique identifier of the rule
+	RunId            string            // Mediation run the matching CDRs are forked into
+	Weight           float64           // Rules of the same run are checked in descending order of weight, the first matching one is applied
+	Filters          map[string]string // Regexps on CDR fields, primary or extra, all need to match. No filters match all CDRs
+	Skip             bool              // Do not fork matching CDRs into this run
+	ReqTypeField     string            // Field templates, empty to keep the value of the original CDR
+	DirectionField   string
+	TenantField      string
+	TORField         string
+	AccountField     string
+	SubjectField     string
+	DestinationField string
+	filters          map[string]*regexp.Regexp
+	fields           map[string]*RSRField // Templates indexed on the CDR field they populate
+}
+
+// Parses the filters and field templates, needs to be called before using the rule on CDRs
+func (dcr *DerivedChargingRule) Compile() (err error) {
+	if len(dcr.Id) == 0 || len(dcr.RunId) == 0 {
+		return fmt.Errorf("%s:Id or RunId", ERR_MANDATORY_IE_MISSING)
+	}
+	filters := make(map[string]*regexp.Regexp, len(dcr.Filters))
+	for fldName, fltr := range dcr.Filters {
+		if filters[fldName], err = regexp.Compile(fltr); err != nil {
+			return fmt.Errorf("Invalid filter on field %s: %s", fldName, err.Error())
+		}
+	}
+	fields := make(map[string]*RSRField)
+	for fldName, fldTpl := range map[string]string{REQTYPE: dcr.ReqTypeField, DIRECTION: dcr.DirectionField, TENANT: dcr.TenantField,
+		TOR: dcr.TORField, ACCOUNT: dcr.AccountField, SUBJECT: dcr.SubjectField, DESTINATION: dcr.DestinationField} {
+		if rsrf, err := NewRSRField(fldTpl); err != nil {
+			return fmt.Errorf("Invalid %s template: %s", fldName, err.Error())
+		} else if rsrf != nil {
+			fields[fldName] = rsrf
+		}
+	}
+	dcr.filters, dcr.fields = filters, fields
+	return nil
+}
+
+// Checks the CDR against the rule filters
+func (dcr *DerivedChargingRule) Matches(cdr *StoredCdr) bool {
+	for fldName, fltr := range dcr.filters {
+		if !fltr.MatchString(cdr.FieldAsString(fldName)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Forks the CDR into the rule run, populating the fields out of templates
+func (dcr *DerivedChargingRule) ForkCdr(cdr *StoredCdr) *StoredCdr {
+	forkedCdr := *cdr
+	forkedCdr.MediationRunId = dcr.RunId
+	forkedCdr.Cost = -1
+	forkedCdr.ExtraFields = make(map[string]string, len(cdr.ExtraFields))
+	for fldName, fldVal := range cdr.ExtraFields {
+		forkedCdr.ExtraFields[fldName] = fldVal
+	}
+	for fldName, rsrf := range dcr.fields {
+		fldVal := rsrf.ParseValue(cdr.FieldAsString(rsrf.Id))
+		switch fldName {
+		case REQTYPE:
+			forkedCdr.ReqType = fldVal
+		case DIRECTION:
+			forkedCdr.Direction = fldVal
+		case TENANT:
+			forkedCdr.Tenant = fldVal
+		case TOR:
+			forkedCdr.TOR = fldVal
+		case ACCOUNT:
+			forkedCdr.Account = fldVal
+		case SUBJECT:
+			forkedCdr.Subject = fldVal
+		case DESTINATION:
+			forkedCdr.Destination = fldVal
+		}
+	}
+	return &forkedCdr
+}
+
+// Parses filters in the field1:regexp1;field2:regexp2 format used in tariff plan files
+func ParseDerivedChargingFilters(fltrsStr string) (map[string]string, error) {
+	fltrs := make(map[string]string)
+	if len(fltrsStr) == 0 {
+		return fltrs, nil
+	}
+	for _, fltrStr := range strings.Split(fltrsStr, INFIELD_SEP) {
+		sepIdx := strings.Index(fltrStr, ":")
+		if sepIdx < 1 {
+			return nil, fmt.Errorf("Invalid filter: %s", fltrStr)
+		}
+		fltrs[fltrStr[:sepIdx]] = fltrStr[sepIdx+1:]
+	}
+	return fltrs, nil
+}
+
+// Compiled rules, ordered on run id and weight
+type DerivedChargingRules []*DerivedChargingRule
+
+func NewDerivedChargingRules(rules []*DerivedChargingRule) (DerivedChargingRules, error) {
+	dcrs := make(DerivedChargingRules, len(rules))
+	for idx, dcr := range rules {
+		if err := dcr.Compile(); err != nil {
+			return nil, fmt.Errorf("Rule %s: %s", dcr.Id, err.Error())
+		}
+		dcrs[idx] = dcr
+	}
+	sort.Sort(dcrs)
+	return dcrs, nil
+}
+
+func (dcrs DerivedChargingRules) Len() int {
+	return len(dcrs)
+}
+
+func (dcrs DerivedChargingRules) Swap(i, j int) {
+	dcrs[i], dcrs[j] = dcrs[j], dcrs[i]
+}
+
+func (dcrs DerivedChargingRules) Less(i, j int) bool {
+	if dcrs[i].RunId != dcrs[j].RunId {
+		return dcrs[i].RunId < dcrs[j].RunId
+	}
+	return dcrs[i].Weight > dcrs[j].Weight
+}
+
+// Run ids the rules are defined for
+func (dcrs DerivedChargingRules) RunIds() []string {
+	runIds := make([]string, 0)
+	for _, dcr := range dcrs {
+		if len(runIds) == 0 || runIds[len(runIds)-1] != dcr.RunId {
+			runIds = append(runIds, dcr.RunId)
+		}
+	}
+	return runIds
+}
+
+// Returns the first rule of the run matching the CDR, nil if none matches
+func (dcrs DerivedChargingRules) RuleForRun(runId string, cdr *StoredCdr) *DerivedChargingRule {
+	for _, dcr := range dcrs {
+		if dcr.RunId == runId && dcr.Matches(cdr) {
+			return dcr
+		}
+	}
+	return nil
+}
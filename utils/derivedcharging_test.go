@@ -0,0 +1,103 @@
+/*
+Rating system designed to be used in VoIP Carriers World
+Copyright (C) 2013 ITsysCOM
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package utils
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRSRField(t *testing.T) {
+	if rsrf, err := NewRSRField(""); err != nil || rsrf != nil {
+		t.Error("Unexpected field out of empty template: ", rsrf, err)
+	}
+	if rsrf, err := NewRSRField("^static"); err != nil {
+		t.Error(err)
+	} else if rsrf.ParseValue("any") != "static" {
+		t.Error("Unexpected static value: ", rsrf.ParseValue("any"))
+	}
+	if rsrf, err := NewRSRField("subject"); err != nil {
+		t.Error(err)
+	} else if rsrf.Id != "subject" || rsrf.ParseValue("1001") != "1001" {
+		t.Errorf("Unexpected field: %+v", rsrf)
+	}
+	if rsrf, err := NewRSRField(`~destination:s/^\+49(\d+)$/0${1}/`); err != nil {
+		t.Error(err)
+	} else if rsrf.Id != DESTINATION {
+		t.Errorf("Unexpected field: %+v", rsrf)
+	} else if parsed := rsrf.ParseValue("+4986517174963"); parsed != "086517174963" {
+		t.Error("Unexpected value: ", parsed)
+	} else if parsed := rsrf.ParseValue("+3312345"); parsed != "+3312345" {
+		t.Error("Not matching values should be returned unchanged: ", parsed)
+	}
+	for _, invalid := range []string{`~destination`, `~destination:s/^\+49/`, `~destination:s/^(\+49/0/`} {
+		if _, err := NewRSRField(invalid); err == nil {
+			t.Error("Invalid template accepted: ", invalid)
+		}
+	}
+}
+
+func TestParseDerivedChargingFilters(t *testing.T) {
+	if fltrs, err := ParseDerivedChargingFilters(`tenant:^cgrates\.org$;destination:^\+49`); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(fltrs, map[string]string{TENANT: `^cgrates\.org$`, DESTINATION: `^\+49`}) {
+		t.Error("Unexpected filters: ", fltrs)
+	}
+	if _, err := ParseDerivedChargingFilters(`:^\+49`); err == nil {
+		t.Error("Filter without field accepted")
+	}
+}
+
+func TestDerivedChargingRules(t *testing.T) {
+	cdr := &StoredCdr{CgrId: FSCgrId("dcr1"), AccId: "dcr1", ReqType: RATED, Direction: "*out", Tenant: "cgrates.org", TOR: "call", Account: "1001",
+		Subject: "1001", Destination: "+4986517174963", AnswerTime: time.Date(2013, 11, 7, 8, 42, 26, 0, time.UTC), Duration: 10 * time.Second,
+		ExtraFields: map[string]string{"route": "premium"}, MediationRunId: DEFAULT_RUNID, Cost: -1}
+	if _, err := NewDerivedChargingRules([]*DerivedChargingRule{&DerivedChargingRule{Id: "INVALID", RunId: "run1", Filters: map[string]string{"route": "("}}}); err == nil {
+		t.Error("Invalid filter accepted")
+	}
+	dcrs, err := NewDerivedChargingRules([]*DerivedChargingRule{
+		&DerivedChargingRule{Id: "RETAIL_DE", RunId: "retail", Weight: 20, Filters: map[string]string{DESTINATION: `^\+49`, "route": "^premium$"},
+			ReqTypeField: "^" + PREPAID, AccountField: `~account:s/^(\d+)$/retail_${1}/`, DestinationField: `~destination:s/^\+49(\d+)$/0${1}/`},
+		&DerivedChargingRule{Id: "RETAIL_SKIP", RunId: "retail", Weight: 10, Skip: true},
+		&DerivedChargingRule{Id: "WHOLESALE", RunId: "wholesale", Filters: map[string]string{TENANT: "^itsyscom.com$"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runIds := dcrs.RunIds(); !reflect.DeepEqual(runIds, []string{"retail", "wholesale"}) {
+		t.Error("Unexpected run ids: ", runIds)
+	}
+	if dcr := dcrs.RuleForRun("wholesale", cdr); dcr != nil {
+		t.Error("Unexpected matching rule: ", dcr.Id)
+	}
+	dcr := dcrs.RuleForRun("retail", cdr)
+	if dcr == nil || dcr.Id != "RETAIL_DE" {
+		t.Fatal("Unexpected rule: ", dcr)
+	}
+	eCdr := *cdr
+	eCdr.MediationRunId, eCdr.ReqType, eCdr.Account, eCdr.Destination = "retail", PREPAID, "retail_1001", "086517174963"
+	if forked := dcr.ForkCdr(cdr); !reflect.DeepEqual(forked, &eCdr) {
+		t.Errorf("Expecting: %+v, received: %+v", eCdr, forked)
+	}
+	cdr.ExtraFields["route"] = "standard"
+	if dcr := dcrs.RuleForRun("retail", cdr); dcr == nil || !dcr.Skip {
+		t.Error("Unexpected rule: ", dcr)
+	}
+}
@@ -130,6 +130,42 @@ func (storedCdr *StoredCdr) AsStoredCdr(runId, reqTypeFld, directionFld, tenantF
 	return storedCdr, nil
 }
 
+// Returns the value of a primary or extra field, empty string if the field is not present
+func (storedCdr *StoredCdr) FieldAsString(fieldName string) string {
+	switch fieldName {
+	case CGRID:
+		return storedCdr.CgrId
+	case ACCID:
+		return storedCdr.AccId
+	case CDRHOST:
+		return storedCdr.CdrHost
+	case CDRSOURCE:
+		return storedCdr.CdrSource
+	case REQTYPE:
+		return storedCdr.ReqType
+	case DIRECTION:
+		return storedCdr.Direction
+	case TENANT:
+		return storedCdr.Tenant
+	case TOR:
+		return storedCdr.TOR
+	case ACCOUNT:
+		return storedCdr.Account
+	case SUBJECT:
+		return storedCdr.Subject
+	case DESTINATION:
+		return storedCdr.Destination
+	case ANSWER_TIME:
+		return storedCdr.AnswerTime.String()
+	case DURATION:
+		return strconv.FormatFloat(storedCdr.Duration.Seconds(), 'f', -1, 64)
+	case MEDI_RUNID:
+		return storedCdr.MediationRunId
+	default:
+		return storedCdr.ExtraFields[fieldName]
+	}
+}
+
 // Converts part of the rated Cdr as httpForm used to post remotely to CDRS
 func (storedCdr *StoredCdr) AsRawCdrHttpForm() url.Values {
 	v := url.Values{}
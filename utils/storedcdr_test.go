@@ -148,3 +148,14 @@ func TestAsRawCdrHttpForm(t *testing.T) {
 		t.Errorf("Expected: %s, received: %s", ratedCdr.ExtraFields["fieldextr2"], cdrForm.Get("fieldextr2"))
 	}
 }
+
+func TestStoredCdrFieldAsString(t *testing.T) {
+	cdr := StoredCdr{CgrId: FSCgrId("dsafdsaf"), AccId: "dsafdsaf", ReqType: RATED, Tenant: "cgrates.org", Account: "1001", Destination: "1002",
+		Duration: 10 * time.Second, ExtraFields: map[string]string{"field_extr1": "val_extr1"}, MediationRunId: DEFAULT_RUNID}
+	for fldName, eVal := range map[string]string{ACCID: "dsafdsaf", REQTYPE: RATED, TENANT: "cgrates.org", ACCOUNT: "1001", DESTINATION: "1002",
+		DURATION: "10", MEDI_RUNID: DEFAULT_RUNID, "field_extr1": "val_extr1", "missing": ""} {
+		if val := cdr.FieldAsString(fldName); val != eVal {
+			t.Errorf("Field %s, expecting: %s, received: %s", fldName, eVal, val)
+		}
+	}
+}